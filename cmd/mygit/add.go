@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stagedFile is a working tree file whose content has been stored as a
+// blob.
+type stagedFile struct {
+	path string
+	hash string
+	mode uint32
+}
+
+// readWorktreeBlob returns the blob content and mode git records for the
+// working tree file at path: a symlink's target, or a regular file's
+// content as its attributes have it stored.
+func readWorktreeBlob(path string) ([]byte, uint32, error) {
+	info, err := os.Lstat(filepath.FromSlash(path))
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(filepath.FromSlash(path))
+		if err != nil {
+			return nil, 0, err
+		}
+		return []byte(filepath.ToSlash(target)), 0o120000, nil
+	case info.Mode().IsRegular():
+		content, err := os.ReadFile(filepath.FromSlash(path))
+		if err != nil {
+			return nil, 0, err
+		}
+		mode := uint32(0o100644)
+		if info.Mode()&0o111 != 0 {
+			mode = 0o100755
+		}
+		if content, err = toRepoBlob(path, content); err != nil {
+			return nil, 0, err
+		}
+		return content, mode, nil
+	}
+	return nil, 0, fmt.Errorf("%s: unsupported file type", path)
+}
+
+// hashWorktreeFile stores path as a blob and returns it with the mode
+// git records for it.
+func hashWorktreeFile(path string) (stagedFile, error) {
+	f := stagedFile{path: path}
+	content, mode, err := readWorktreeBlob(path)
+	if err != nil {
+		return f, err
+	}
+	f.mode = mode
+	if f.hash, err = writeObject(BlobObject, content); err != nil {
+		return f, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// hashWorktreeFiles stores a blob for each of paths using up to
+// GOMAXPROCS workers. Results are returned in the order of paths, so
+// trees built from them do not depend on scheduling. Every file that
+// fails is reported, not just the first.
+func hashWorktreeFiles(paths []string) ([]stagedFile, error) {
+	// Settings are cached on first use; read them before the workers
+	// start so they are not loaded concurrently.
+	repoFormat()
+	autocrlf()
+
+	files := make([]stagedFile, len(paths))
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := min(runtime.GOMAXPROCS(0), len(paths)); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				files[i], errs[i] = hashWorktreeFile(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listWorktreeFiles returns the slash-separated paths of the files under
+// dir, sorted, leaving out the .git directory.
+func listWorktreeFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			// The repository, or in a linked working tree the file
+			// pointing at it.
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		paths = append(paths, filepath.ToSlash(filepath.Clean(path)))
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// writeTree implements `mygit write-tree [--prefix=<dir>/]`: the tree of
+// the index is stored and the name of its root returned, or with a
+// prefix, the name of the subtree at that directory, of which only the
+// entries below it are written. A repository that has never had an
+// index, as mygit's first commands left it, has every file in the
+// working tree stored instead.
+func writeTree(prefix string) (string, error) {
+	var files map[string]TreeEntry
+	if _, err := os.Stat(indexPath()); err == nil {
+		idx, err := readIndex()
+		if err != nil {
+			return "", err
+		}
+		if files, err = indexTreeFiles(idx.entries); err != nil {
+			return "", err
+		}
+	} else {
+		paths, err := listWorktreeFiles(".")
+		if err != nil {
+			return "", err
+		}
+		staged, err := hashWorktreeFiles(paths)
+		if err != nil {
+			return "", err
+		}
+		files = make(map[string]TreeEntry, len(staged))
+		for _, f := range staged {
+			files[f.path] = TreeEntry{Mode: formatMode(f.mode), Hash: f.hash}
+		}
+	}
+	if dir := strings.TrimSuffix(prefix, "/"); dir != "" {
+		under := map[string]TreeEntry{}
+		for path, te := range files {
+			if rest, ok := strings.CutPrefix(path, dir+"/"); ok {
+				under[rest] = te
+			}
+		}
+		if len(under) == 0 {
+			return "", fmt.Errorf("git-write-tree: prefix %s not found", prefix)
+		}
+		files = under
+	}
+	return writeTreeFiles(files)
+}
+
+// add implements `mygit add <path>...`. Directories are staged
+// recursively, and tracked files that no longer exist under a named
+// path are removed from the index.
+func add(pathspecs []string) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, spec := range pathspecs {
+		spec = filepath.ToSlash(filepath.Clean(spec))
+		if spec != "." && !worktreeRelative(spec) {
+			return fmt.Errorf("'%s' is outside repository", spec)
+		}
+		found, err := listWorktreeFiles(spec)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// Files sparse checkout left out are missing, not deleted.
+		keep := found
+		for _, e := range idx.entries {
+			if e.skipWorktree() {
+				keep = append(keep, e.path)
+			}
+		}
+		removed := idx.removeUnder(spec, keep)
+		if len(found) == 0 && !removed {
+			return fmt.Errorf("pathspec '%s' did not match any files", spec)
+		}
+		paths = append(paths, found...)
+	}
+
+	staged, err := hashWorktreeFiles(paths)
+	if err != nil {
+		return err
+	}
+	for _, f := range staged {
+		e, err := newIndexEntry(f.path, f.hash, f.mode)
+		if err != nil {
+			return err
+		}
+		idx.add(e)
+	}
+	return idx.write()
+}
+
+// removeUnder drops the entries at or below spec that are not in keep,
+// reporting whether any were dropped.
+func (idx *index) removeUnder(spec string, keep []string) bool {
+	present := make(map[string]bool, len(keep))
+	for _, p := range keep {
+		present[p] = true
+	}
+	kept := idx.entries[:0]
+	for _, e := range idx.entries {
+		under := spec == "." || e.path == spec || strings.HasPrefix(e.path, spec+"/")
+		if under && !present[e.path] {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(kept) != len(idx.entries)
+	idx.entries = kept
+	return removed
+}
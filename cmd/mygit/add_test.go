@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// enterTempRepo creates an empty repository in a temporary directory and
+// makes it the current one for the rest of the test, as an in-process
+// command would find it.
+func enterTempRepo(tb testing.TB) string {
+	tb.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dir := tb.TempDir()
+	if err := initRepo(initOptions{dir: dir}); err != nil {
+		tb.Fatal(err)
+	}
+	if err := enterWorktree(dir); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := enterWorktree(wd); err != nil {
+			tb.Fatal(err)
+		}
+	})
+	return dir
+}
+
+// writeBenchFiles writes n files of size bytes each, spread over
+// directories of a hundred, and returns their paths.
+func writeBenchFiles(tb testing.TB, n, size int) []string {
+	tb.Helper()
+	paths := make([]string, n)
+	content := []byte(strings.Repeat("0123456789abcdef", size/16))
+	for i := range paths {
+		paths[i] = fmt.Sprintf("d%02d/f%04d", i/100, i)
+		if err := os.MkdirAll(filepath.Dir(paths[i]), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		// Each file differs, so each is a blob of its own.
+		if err := os.WriteFile(paths[i], append(content, fmt.Sprint(i)...), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestHashWorktreeFilesMatchesSerial(t *testing.T) {
+	// More than one worker, even on one CPU, so that go test -race sees
+	// the settings and caches the workers share.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	enterTempRepo(t)
+	paths := writeBenchFiles(t, 300, 1024)
+	// Every worker reads, and caches, the attributes files on the way
+	// to its files.
+	for _, file := range []string{".gitattributes", "d00/.gitattributes", "d01/.gitattributes"} {
+		if err := os.WriteFile(file, []byte("f00* text\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, file)
+	}
+	files, err := hashWorktreeFiles(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range paths {
+		want, err := hashWorktreeFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[i] != want {
+			t.Errorf("%s: hashed as %+v in parallel, %+v serially", path, files[i], want)
+		}
+	}
+}
+
+func TestHashWorktreeFilesReportsEveryError(t *testing.T) {
+	enterTempRepo(t)
+	paths := writeBenchFiles(t, 10, 64)
+	paths = append(paths, "missing-1", "missing-2")
+	_, err := hashWorktreeFiles(paths)
+	if err == nil {
+		t.Fatal("hashing missing files succeeded")
+	}
+	for _, path := range []string{"missing-1", "missing-2"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("error %q does not name %s", err, path)
+		}
+	}
+}
+
+// BenchmarkHashWorktreeFiles stages 2000 16KiB files with one worker and
+// with GOMAXPROCS of them.
+func BenchmarkHashWorktreeFiles(b *testing.B) {
+	enterTempRepo(b)
+	paths := writeBenchFiles(b, 2000, 16<<10)
+	counts := []int{1}
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		counts = append(counts, procs)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(workers))
+			for i := 0; i < b.N; i++ {
+				if _, err := hashWorktreeFiles(paths); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// patchHunk is one @@ section of a unified diff. oldLines and newLines
+// keep their terminators, so a "\ No newline at end of file" marker
+// shows up as a final line without one.
+type patchHunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	oldLines, newLines []string
+}
+
+// patchFile is the set of hunks for one file. A path of "" means the
+// file does not exist on that side (/dev/null). The two paths differ
+// only for a git patch that renames or copies the file; renamed tells
+// which.
+type patchFile struct {
+	oldPath, newPath string
+	renamed          bool
+	hunks            []patchHunk
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// patchPath extracts the file name from a ---/+++ line, dropping any
+// trailing timestamp and the leading a/ or b/ component.
+func patchPath(s string) string {
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if _, rest, ok := strings.Cut(s, "/"); ok {
+		return rest
+	}
+	return s
+}
+
+// traditionalName is the one file a patch that is not git's, such as
+// `diff -u f.orig f` makes, changes, named on its ---/+++ lines by old
+// and new. As in git's apply, that is old when it is a shorter prefix
+// of new, as for "f" and "f.new", and otherwise new.
+func traditionalName(old, new string) string {
+	if len(old) < len(new) && strings.HasPrefix(new, old) {
+		return old
+	}
+	return new
+}
+
+// parsePatch reads the files and hunks of a unified diff. Of the text
+// between files, only the "diff --git" line that starts a git patch and
+// its "rename from" line are heeded.
+func parsePatch(r io.Reader) ([]*patchFile, error) {
+	var files []*patchFile
+	var cur *patchFile
+	var hunk *patchHunk
+	var last byte // prefix of the previous hunk line
+	// Whether the file to come is in a git patch, and renamed by it.
+	gitPatch, renaming := false, false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		inHunk := hunk != nil && (len(hunk.oldLines) < hunk.oldCount || len(hunk.newLines) < hunk.newCount)
+		switch {
+		case inHunk && line != "" && strings.ContainsRune(" -+", rune(line[0])),
+			inHunk && line == "":
+			content := ""
+			prefix := byte(' ')
+			if line != "" {
+				prefix, content = line[0], line[1:]
+			}
+			content += "\n"
+			if prefix != '+' {
+				hunk.oldLines = append(hunk.oldLines, content)
+			}
+			if prefix != '-' {
+				hunk.newLines = append(hunk.newLines, content)
+			}
+			last = prefix
+		case strings.HasPrefix(line, `\`) && hunk != nil:
+			// "\ No newline at end of file" applies to the line before it.
+			if last != '+' {
+				trimLastNewline(hunk.oldLines)
+			}
+			if last != '-' {
+				trimLastNewline(hunk.newLines)
+			}
+		case strings.HasPrefix(line, "diff --git "):
+			gitPatch, renaming = true, false
+			hunk = nil
+		case strings.HasPrefix(line, "rename from ") && gitPatch:
+			renaming = true
+		case strings.HasPrefix(line, "--- "):
+			cur = &patchFile{oldPath: patchPath(line[4:]), renamed: renaming}
+			hunk = nil
+		case strings.HasPrefix(line, "+++ ") && cur != nil && cur.newPath == "" && len(cur.hunks) == 0:
+			cur.newPath = patchPath(line[4:])
+			if !gitPatch && cur.oldPath != "" && cur.newPath != "" {
+				cur.oldPath = traditionalName(cur.oldPath, cur.newPath)
+				cur.newPath = cur.oldPath
+			}
+			gitPatch, renaming = false, false
+			files = append(files, cur)
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil || cur == nil {
+				return nil, fmt.Errorf("corrupt patch at line %d", lineno)
+			}
+			count := func(s string) int {
+				if s == "" {
+					return 1
+				}
+				n, _ := strconv.Atoi(s)
+				return n
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			newStart, _ := strconv.Atoi(m[3])
+			cur.hunks = append(cur.hunks, patchHunk{
+				oldStart: oldStart, oldCount: count(m[2]),
+				newStart: newStart, newCount: count(m[4]),
+			})
+			hunk = &cur.hunks[len(cur.hunks)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		for _, h := range f.hunks {
+			if len(h.oldLines) != h.oldCount || len(h.newLines) != h.newCount {
+				return nil, fmt.Errorf("corrupt patch: truncated hunk for %s", f.name())
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("No valid patches in input")
+	}
+	return files, nil
+}
+
+func trimLastNewline(lines []string) {
+	if n := len(lines); n > 0 {
+		lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+	}
+}
+
+// name is the path the patch is reported under.
+func (f *patchFile) name() string {
+	if f.newPath != "" {
+		return f.newPath
+	}
+	return f.oldPath
+}
+
+// reverse swaps the two sides of the patch.
+func (f *patchFile) reverse() {
+	f.oldPath, f.newPath = f.newPath, f.oldPath
+	for i := range f.hunks {
+		h := &f.hunks[i]
+		h.oldStart, h.newStart = h.newStart, h.oldStart
+		h.oldCount, h.newCount = h.newCount, h.oldCount
+		h.oldLines, h.newLines = h.newLines, h.oldLines
+	}
+}
+
+// applyTo applies the hunks of f to content. Each hunk's old lines
+// must match exactly; only their position may have shifted, as happens
+// when the file was edited elsewhere.
+func (f *patchFile) applyTo(content []byte) ([]byte, error) {
+	lines := splitLines(content)
+	var out []string
+	pos := 0    // next unconsumed line of the original
+	offset := 0 // how far hunks have been found from their stated lines
+	for i, h := range f.hunks {
+		want := h.oldStart - 1 + offset
+		if h.oldCount == 0 {
+			want++ // a pure insertion names the line it follows
+		}
+		at := findHunk(lines, h.oldLines, want, pos)
+		if at < 0 {
+			return nil, fmt.Errorf("patch failed: %s:%d (hunk #%d does not match)", f.name(), h.oldStart, i+1)
+		}
+		offset += at - want
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.newLines...)
+		pos = at + len(h.oldLines)
+	}
+	out = append(out, lines[pos:]...)
+	return []byte(strings.Join(out, "")), nil
+}
+
+// findHunk returns the position nearest to want, and not before from,
+// at which old appears in lines, or -1.
+func findHunk(lines, old []string, want, from int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(old) > len(lines) {
+			return false
+		}
+		for i, l := range old {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; want-d >= from || want+d <= len(lines); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if matches(want + d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// applyPatch implements `mygit apply [--check] [--reverse] <patch>`.
+// Every file is checked before any is written, so a failing hunk leaves
+// the working tree untouched.
+func applyPatch(patch string, check, reverse bool) error {
+	f, err := os.Open(patch)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	files, err := parsePatch(f)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		file    *patchFile
+		content []byte
+		perm    os.FileMode
+	}
+	var results []result
+	for _, pf := range files {
+		if reverse {
+			pf.reverse()
+		}
+		if pf.oldPath != "" && !worktreeRelative(pf.oldPath) || pf.newPath != "" && !worktreeRelative(pf.newPath) {
+			return fmt.Errorf("invalid path '%s'", pf.name())
+		}
+		// As in git, the paths of a patch applied in a subdirectory are
+		// taken from there.
+		if pf.oldPath != "" {
+			pf.oldPath = repoPrefix + pf.oldPath
+		}
+		if pf.newPath != "" {
+			pf.newPath = repoPrefix + pf.newPath
+		}
+		var old []byte
+		perm := os.FileMode(0o644)
+		if pf.oldPath == "" {
+			if _, err := os.Lstat(pf.newPath); err == nil {
+				return fmt.Errorf("%s: already exists in working directory", pf.newPath)
+			}
+		} else {
+			info, err := os.Stat(pf.oldPath)
+			if err != nil {
+				return fmt.Errorf("%s: No such file or directory", pf.oldPath)
+			}
+			perm = info.Mode().Perm()
+			if old, err = os.ReadFile(pf.oldPath); err != nil {
+				return err
+			}
+		}
+		content, err := pf.applyTo(old)
+		if err != nil {
+			return fmt.Errorf("%s\n%s: patch does not apply", err, pf.name())
+		}
+		if pf.newPath == "" && len(content) > 0 {
+			return fmt.Errorf("%s: removal patch leaves file contents", pf.oldPath)
+		}
+		results = append(results, result{pf, content, perm})
+	}
+	if check {
+		return nil
+	}
+
+	for _, r := range results {
+		if r.file.newPath == "" {
+			if err := removeWorktreeFile(r.file.oldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if r.file.renamed && r.file.oldPath != r.file.newPath {
+			if err := removeWorktreeFile(r.file.oldPath); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAll(r.file.newPath, r.content, r.perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAll writes a file, creating its parent directories.
+func writeFileAll(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
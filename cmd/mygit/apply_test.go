@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestApplyTraditionalPatch applies a patch made by `diff -u f.orig f`,
+// forward and reversed, and checks that, as with git apply, only f
+// changes and f.orig is left alone, while a git patch that renames a
+// file still removes the old name.
+func TestApplyTraditionalPatch(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff is not installed")
+	}
+	r := newGoldenRepo(t)
+	old, changed := "one\ntwo\nthree\n", "one\n2\nthree\n"
+	r.write("f.orig", old, 0o644)
+	r.write("f", changed, 0o644)
+	cmd := exec.Command("diff", "-u", "f.orig", "f")
+	cmd.Dir = r.dir
+	patch, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	r.write("p.diff", string(patch), 0o644)
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(r.dir, name))
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	}
+	for _, tool := range []func(args ...string) string{r.git, r.mygit} {
+		r.write("f", old, 0o644)
+		tool("apply", "p.diff")
+		if got, orig := read("f"), read("f.orig"); got != changed || orig != old {
+			t.Errorf("apply: f is %q and f.orig %q", got, orig)
+		}
+		tool("apply", "-R", "p.diff")
+		if got, orig := read("f"), read("f.orig"); got != old || orig != old {
+			t.Errorf("apply -R: f is %q and f.orig %q", got, orig)
+		}
+	}
+
+	r.git("add", "f")
+	r.git("commit", "-q", "-m", "f")
+	r.git("mv", "f", "g")
+	r.write("g", changed, 0o644)
+	r.git("add", "g")
+	r.write("rename.diff", r.git("diff", "--cached", "-M"), 0o644)
+	r.git("reset", "-q", "--hard")
+	r.mygit("apply", "rename.diff")
+	if _, err := os.Stat(filepath.Join(r.dir, "f")); !os.IsNotExist(err) {
+		t.Errorf("apply of a rename kept f: %v", err)
+	}
+	if got := read("g"); got != changed {
+		t.Errorf("apply of a rename: g is %q", got)
+	}
+	if !strings.Contains(read("rename.diff"), "rename from f") {
+		t.Errorf("git diff -M made no rename:\n%s", read("rename.diff"))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// archive implements `mygit archive [--format=tar] [--prefix=<dir>/]
+// <tree-ish>`, writing the tree as a tar stream. As with git, entries
+// are owned by root with the commit's time, and a commit's name is
+// stored in a pax global header so `git get-tar-commit-id` can read it.
+func archive(w io.Writer, treeish, format, prefix string) error {
+	if format != "tar" {
+		return fmt.Errorf("Unknown archive format '%s'", format)
+	}
+	hash, err := resolveObjectName(treeish)
+	if err != nil {
+		return fmt.Errorf("not a valid object name: %s", treeish)
+	}
+	tree, commit, err := peelToTree(hash)
+	if err != nil {
+		return err
+	}
+	mtime := time.Now()
+	tw := tar.NewWriter(w)
+	if commit != "" {
+		c, err := readCommit(commit)
+		if err != nil {
+			return err
+		}
+		mtime = c.Committer.When
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			Name:       "pax_global_header",
+			PAXRecords: map[string]string{"comment": commit},
+		}); err != nil {
+			return err
+		}
+	}
+	header := func(typ byte, name string, mode int64) *tar.Header {
+		return &tar.Header{
+			Typeflag: typ,
+			Name:     name,
+			Mode:     mode,
+			ModTime:  mtime,
+			Uname:    "root",
+			Gname:    "root",
+		}
+	}
+	if strings.HasSuffix(prefix, "/") {
+		if err := tw.WriteHeader(header(tar.TypeDir, prefix, 0o775)); err != nil {
+			return err
+		}
+	}
+
+	err = walkTree(tree, func(path string, e TreeEntry) error {
+		name := prefix + path
+		switch e.Mode {
+		case "40000", "160000":
+			// Submodule contents are not archived, only their directory.
+			return tw.WriteHeader(header(tar.TypeDir, name+"/", 0o775))
+		}
+		_, body, err := readObject(e.Hash)
+		if err != nil {
+			return err
+		}
+		var h *tar.Header
+		switch e.Mode {
+		case "120000":
+			h = header(tar.TypeSymlink, name, 0o777)
+			h.Linkname = string(body)
+			body = nil
+		case "100755":
+			h = header(tar.TypeReg, name, 0o775)
+		default:
+			h = header(tar.TypeReg, name, 0o664)
+		}
+		if h.Typeflag == tar.TypeReg {
+			body = toWorktreeText(path, body)
+			h.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err = tw.Write(body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// tarListing describes every entry of a tar stream: its type, name,
+// mode, owner, link target and content, the pax records of global
+// headers and, if times is set, its modification time.
+func tarListing(t *testing.T, stream string, times bool) string {
+	t.Helper()
+	var b strings.Builder
+	tr := tar.NewReader(strings.NewReader(stream))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return b.String()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "%c %s %o %s/%s %q %q %v", h.Typeflag, h.Name, h.Mode, h.Uname, h.Gname,
+			h.Linkname, body, h.PAXRecords)
+		if times {
+			fmt.Fprintf(&b, " %d", h.ModTime.Unix())
+		}
+		b.WriteString("\n")
+	}
+}
+
+// TestArchive reads the tar streams archive writes for a commit, an
+// annotated tag, a tree, a subdirectory and with a prefix, and checks
+// that they hold the entries git's do, with their modes, symlink
+// targets, times and the commit's name in a pax header.
+func TestArchive(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.write("long/"+strings.Repeat("d", 120)+"/file.txt", "long path\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("tag", "-a", "-m", "release", "v1")
+	for _, args := range [][]string{
+		{"archive", "HEAD"},
+		{"archive", "--format=tar", "v1"},
+		{"archive", "HEAD:src"},
+		{"archive", "--prefix=project/", "HEAD"},
+		{"archive", "HEAD^{tree}"},
+	} {
+		// A tree has no time of its own, so its entries get the time
+		// the archive is made.
+		rev := args[len(args)-1]
+		times := !strings.Contains(rev, ":") && !strings.HasSuffix(rev, "{tree}")
+		want, got := tarListing(t, r.git(args...), times), tarListing(t, r.mygit(args...), times)
+		if got != want {
+			t.Errorf("%s:\ngit:\n%s\nmygit:\n%s", strings.Join(args, " "), want, got)
+		}
+	}
+	if got := r.mygitFails("archive", "--format=zip", "HEAD"); got != "fatal: Unknown archive format 'zip'\n" {
+		t.Errorf("archive --format=zip printed %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Attribute states other than a value given with "attr=value".
+const (
+	attrSet   = "set"   // "attr"
+	attrUnset = "unset" // "-attr"
+)
+
+// attrRule is a line of a .gitattributes file: a pattern and the
+// attributes it gives matching paths. An empty value leaves the
+// attribute unspecified, as "!attr" does.
+type attrRule struct {
+	pattern pathPattern
+	attrs   [][2]string // name, state
+}
+
+// attrMacros are the built-in attribute macros.
+var attrMacros = map[string][][2]string{
+	"binary": {{"binary", attrSet}, {"diff", attrUnset}, {"merge", attrUnset}, {"text", attrUnset}},
+}
+
+// attrFiles caches parsed attribute files by path for this run. It is
+// guarded by attrFilesMu, as files staged in parallel look up their
+// attributes at once.
+var (
+	attrFiles   = map[string][]attrRule{}
+	attrFilesMu sync.Mutex
+)
+
+// readAttrFile parses the attributes file at file, whose patterns are
+// relative to the directory base. A missing file has no rules.
+func readAttrFile(file, base string) []attrRule {
+	attrFilesMu.Lock()
+	rules, ok := attrFiles[file]
+	attrFilesMu.Unlock()
+	if ok {
+		return rules
+	}
+	data, _ := os.ReadFile(file)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		p, ok := parsePathPattern(fields[0], base)
+		if !ok || p.negate {
+			continue // git does not allow negative attribute patterns
+		}
+		rule := attrRule{pattern: p}
+		for _, field := range fields[1:] {
+			switch {
+			case field[0] == '-':
+				rule.attrs = append(rule.attrs, [2]string{field[1:], attrUnset})
+			case field[0] == '!':
+				rule.attrs = append(rule.attrs, [2]string{field[1:], ""})
+			case strings.Contains(field, "="):
+				name, value, _ := strings.Cut(field, "=")
+				rule.attrs = append(rule.attrs, [2]string{name, value})
+			case attrMacros[field] != nil:
+				rule.attrs = append(rule.attrs, attrMacros[field]...)
+			default:
+				rule.attrs = append(rule.attrs, [2]string{field, attrSet})
+			}
+		}
+		rules = append(rules, rule)
+	}
+	attrFilesMu.Lock()
+	attrFiles[file] = rules
+	attrFilesMu.Unlock()
+	return rules
+}
+
+// forgetAttrFile drops the cached rules of the attributes file at file,
+// so that they are read again.
+func forgetAttrFile(file string) {
+	attrFilesMu.Lock()
+	delete(attrFiles, file)
+	attrFilesMu.Unlock()
+}
+
+// resetAttrFiles drops the cached rules of every attributes file.
+func resetAttrFiles() {
+	attrFilesMu.Lock()
+	attrFiles = map[string][]attrRule{}
+	attrFilesMu.Unlock()
+}
+
+// pathAttributes returns the attributes of the file at the slash-
+// separated path, read from the .gitattributes files of the working tree
+// from the top down to the file's directory and then from
+// .git/info/attributes. Later lines, deeper files and info/attributes
+// take precedence. Unspecified attributes are absent.
+func pathAttributes(name string) map[string]string {
+	files := [][2]string{{".gitattributes", ""}}
+	dir := path.Dir(name)
+	if dir != "." {
+		parts := strings.Split(dir, "/")
+		for i := range parts {
+			base := strings.Join(parts[:i+1], "/")
+			files = append(files, [2]string{filepath.Join(filepath.FromSlash(base), ".gitattributes"), base})
+		}
+	}
+	files = append(files, [2]string{gitPath("info/attributes"), ""})
+
+	attrs := map[string]string{}
+	for _, f := range files {
+		for _, rule := range readAttrFile(f[0], f[1]) {
+			if !rule.pattern.match(name, false) {
+				continue
+			}
+			for _, a := range rule.attrs {
+				if a[1] == "" {
+					delete(attrs, a[0])
+				} else {
+					attrs[a[0]] = a[1]
+				}
+			}
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAttributes gives files text, binary and eol attributes, some
+// from a nested .gitattributes and info/attributes, and checks that
+// hash-object normalizes their line endings, diff treats them as text or
+// binary, and checkout writes their line endings as git does.
+func TestAttributes(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write(".gitattributes", "*.txt text\n*.crlf text eol=crlf\n*.dat binary\nraw.txt -text\n", 0o644)
+	r.write("sub/.gitattributes", "*.txt -text\nauto* text=auto\n", 0o644)
+	r.write(".git/info/attributes", "info.md text\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "attributes")
+	files := map[string]string{
+		"a.txt":       "one\r\ntwo\r\n",
+		"raw.txt":     "one\r\ntwo\r\n",
+		"b.crlf":      "one\ntwo\n",
+		"c.dat":       "plain text\n",
+		"info.md":     "one\r\n",
+		"plain.md":    "one\r\n",
+		"sub/d.txt":   "one\r\n",
+		"sub/auto.x":  "one\r\n",
+		"sub/autobin": "one\r\n\x00\n",
+	}
+	for name, content := range files {
+		r.write(name, content, 0o644)
+		r.same("hash-object", name)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "files")
+	for name, content := range files {
+		r.write(name, content+"more\n", 0o644)
+	}
+	r.same("diff")
+
+	read := func() map[string]string {
+		got := map[string]string{}
+		for name := range files {
+			data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[name] = string(data)
+		}
+		return got
+	}
+	r.git("reset", "-q", "--hard")
+	r.git("checkout", "-q", "HEAD~1")
+	r.git("checkout", "-q", "main")
+	want := read()
+	if want["b.crlf"] != "one\r\ntwo\r\n" {
+		t.Fatalf("git checked out b.crlf as %q", want["b.crlf"])
+	}
+	r.git("checkout", "-q", "HEAD~1")
+	r.mygit("checkout", "main")
+	for name, content := range read() {
+		if content != want[name] {
+			t.Errorf("%s checked out as %q, git writes %q", name, content, want[name])
+		}
+	}
+}
+
+// TestUnfilteredObjects checks against git that hash-object
+// --no-filters hashes a file's bytes as they are where plain
+// hash-object and --path convert them, and that cat-file -p writes a
+// blob as stored where --filters converts it for its path, with
+// core.autocrlf on and text and eol attributes set.
+func TestUnfilteredObjects(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.git("config", "core.autocrlf", "true")
+	r.write(".gitattributes", "*.txt text eol=crlf\n*.bin -text\n", 0o644)
+	r.write("a.txt", "one\r\ntwo\r\n", 0o644)
+	r.write("b.bin", "one\r\n", 0o644)
+	r.write("c.md", "one\r\n", 0o644)
+	for _, args := range [][]string{
+		{"hash-object", "a.txt"},
+		{"hash-object", "--no-filters", "a.txt"},
+		{"hash-object", "--path=b.bin", "a.txt"},
+		{"hash-object", "--path=a.txt", "c.md"},
+		{"hash-object", "--no-filters", "c.md"},
+		{"hash-object", "c.md"},
+	} {
+		r.same(args...)
+	}
+	if got := r.same("hash-object", "--no-filters", "a.txt"); got == r.git("hash-object", "a.txt") {
+		t.Errorf("hash-object --no-filters converted a.txt")
+	}
+	r.sameIn("one\r\n", "hash-object", "--stdin", "--path=c.md")
+	if got := r.mygitFails("hash-object", "--no-filters", "--path=a.txt", "c.md"); !strings.HasPrefix(got, "error: Can't use --path with --no-filters\n") {
+		t.Errorf("hash-object --no-filters --path printed %q", got)
+	}
+
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "files")
+	for _, args := range [][]string{
+		{"cat-file", "-p", "HEAD:a.txt"},
+		{"cat-file", "--filters", "HEAD:a.txt"},
+		{"cat-file", "--filters", "--path=c.md", "HEAD:a.txt"},
+		{"cat-file", "--filters", "HEAD:b.bin"},
+		{"cat-file", "--filters", "HEAD:c.md"},
+	} {
+		r.same(args...)
+	}
+	for _, args := range [][]string{
+		{"--filters", "-p", "HEAD:a.txt"},
+		{"-p", "--path=a.txt", "HEAD:a.txt"},
+		{"--filters", "HEAD"},
+	} {
+		r.sameCatFileFailure(args...)
+	}
+}
+
+// TestHashObjectPath compares hash-object --path with git's: content
+// hashed for another path goes through that path's clean filter, given
+// the path quoted for the shell, its line ending conversion and ident
+// collapsing, and a failing clean command is reported and skipped unless
+// the driver is required.
+func TestHashObjectPath(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.git("config", "filter.upper.clean", "echo %f >&2; tr a-z A-Z")
+	r.git("config", "filter.broken.clean", "exit 3")
+	r.git("config", "filter.needed.clean", "exit 3")
+	r.git("config", "filter.needed.required", "true")
+	r.write(".gitattributes", "*.up filter=upper\n*.id ident text\n*.bad filter=broken\n*.req filter=needed\n", 0o644)
+	r.write("content", "$Id: 0123 $\r\nsome text\r\n", 0o644)
+	for _, path := range []string{"a.up", "it's here.up", "b.id", "c.bad", "plain"} {
+		args := []string{"hash-object", "--path=" + path, "content"}
+		r.same(args...)
+		want, _ := r.stderrOf("git", args...)
+		if got, _ := r.stderrOf("", args...); got != want {
+			t.Errorf("%s printed:\ngit:   %q\nmygit: %q", strings.Join(args, " "), want, got)
+		}
+	}
+	r.sameIn("$Id: stdin $\nfrom stdin\n", "hash-object", "--stdin", "--path=b.id")
+	r.sameFailure("hash-object", "--path=d.req", "content")
+}
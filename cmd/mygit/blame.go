@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// blameLine records which commit introduced a line of the blamed file,
+// the line's index in that commit's version of the file and the parent
+// the commit changed it from, if the file was there.
+type blameLine struct {
+	commit   string
+	boundary bool
+	orig     int
+	previous string
+}
+
+// blameOptions are the flags of `mygit blame`.
+type blameOptions struct {
+	lineRange string // -L <start>,<end>; "" for the whole file
+	porcelain bool
+}
+
+// blame attributes each line of path at rev to the commit that last
+// changed it, following the first-parent chain. Renames are not followed.
+// With a line range only those lines are followed back through history,
+// so blaming a few lines of a long-lived file stays cheap.
+func blame(ctx *cmdContext, w io.Writer, rev, path string, opts blameOptions) error {
+	start, err := resolveObjectName(rev)
+	if err != nil {
+		return err
+	}
+	commits := map[string]*Commit{}
+	getCommit := func(hash string) (*Commit, error) {
+		if c, ok := commits[hash]; ok {
+			return c, nil
+		}
+		c, err := ctx.getCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		commits[hash] = c
+		return c, nil
+	}
+
+	c, err := getCommit(start)
+	if err != nil {
+		return err
+	}
+	entry, ok, err := lookupPath(c.Tree, path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no such path %s in %s", path, rev)
+	}
+	_, content, err := readObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+	finalLines := splitLines(content)
+	first, last := 0, len(finalLines)
+	if opts.lineRange != "" {
+		if first, last, err = parseLineRange(opts.lineRange, path, len(finalLines)); err != nil {
+			return err
+		}
+	}
+	result := make([]blameLine, len(finalLines))
+
+	// pending maps line indexes in the current version to final lines.
+	type pendingLine struct{ cur, final int }
+	var pending []pendingLine
+	for i := first; i < last; i++ {
+		pending = append(pending, pendingLine{i, i})
+	}
+	curLines, curBlob := finalLines, entry.Hash
+
+	for len(pending) > 0 {
+		if len(c.Parents) == 0 {
+			for _, p := range pending {
+				result[p.final] = blameLine{commit: c.Hash, boundary: true, orig: p.cur}
+			}
+			break
+		}
+		parent, err := getCommit(c.Parents[0])
+		if err != nil {
+			return err
+		}
+		pe, ok, err := lookupPath(parent.Tree, path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			for _, p := range pending {
+				result[p.final] = blameLine{commit: c.Hash, orig: p.cur}
+			}
+			break
+		}
+		if pe.Hash == curBlob {
+			c = parent
+			continue
+		}
+
+		_, parentContent, err := readObject(pe.Hash)
+		if err != nil {
+			return err
+		}
+		parentLines := splitLines(parentContent)
+		fromParent := map[int]int{}
+		for _, op := range diffLines(parentLines, curLines) {
+			if op.kind == diffEqual {
+				fromParent[op.newLine] = op.oldLine
+			}
+		}
+		var next []pendingLine
+		for _, p := range pending {
+			if old, ok := fromParent[p.cur]; ok {
+				next = append(next, pendingLine{old, p.final})
+			} else {
+				result[p.final] = blameLine{commit: c.Hash, orig: p.cur, previous: parent.Hash}
+			}
+		}
+		pending, curLines, curBlob, c = next, parentLines, pe.Hash, parent
+	}
+
+	mm := readMailmap()
+	if opts.porcelain {
+		writeBlamePorcelain(w, mm, commits, path, finalLines, result[first:last], first)
+		return nil
+	}
+	authors := map[string]string{}
+	authorWidth := 0
+	for _, r := range result[first:last] {
+		if _, ok := authors[r.commit]; !ok {
+			authors[r.commit] = mm.lookup(commits[r.commit].Author).Name
+		}
+		if n := len(authors[r.commit]); n > authorWidth {
+			authorWidth = n
+		}
+	}
+	numWidth := len(strconv.Itoa(last))
+	for i := first; i < last; i++ {
+		r := result[i]
+		commit := commits[r.commit]
+		id := r.commit[:8]
+		if r.boundary {
+			id = "^" + r.commit[:7]
+		}
+		fmt.Fprintf(w, "%s (%-*s %s %*d) %s\n", id, authorWidth, authors[r.commit],
+			commit.Author.When.Format("2006-01-02 15:04:05 -0700"), numWidth, i+1,
+			strings.TrimSuffix(finalLines[i], "\n"))
+	}
+	return nil
+}
+
+// writeBlamePorcelain writes blamed lines in git's porcelain format,
+// the first of them being line first of the file. Each run of lines
+// that came from consecutive lines of one commit starts with a header
+// giving the line's number in that commit, its final number and the
+// run's length; the first run of each commit also describes it. Every
+// line then follows its own header, indented by a tab.
+func writeBlamePorcelain(w io.Writer, mm *mailmap, commits map[string]*Commit, path string, lines []string, result []blameLine, first int) {
+	described := map[string]bool{}
+	for i := 0; i < len(result); {
+		r := result[i]
+		n := 1
+		for i+n < len(result) && result[i+n].commit == r.commit && result[i+n].orig == r.orig+n {
+			n++
+		}
+		fmt.Fprintf(w, "%s %d %d %d\n", r.commit, r.orig+1, first+i+1, n)
+		if !described[r.commit] {
+			described[r.commit] = true
+			c := commits[r.commit]
+			for _, who := range []struct {
+				role string
+				sig  Signature
+			}{{"author", mm.lookup(c.Author)}, {"committer", mm.lookup(c.Committer)}} {
+				fmt.Fprintf(w, "%s %s\n", who.role, who.sig.Name)
+				fmt.Fprintf(w, "%s-mail <%s>\n", who.role, who.sig.Email)
+				fmt.Fprintf(w, "%s-time %d\n", who.role, who.sig.When.Unix())
+				fmt.Fprintf(w, "%s-tz %s\n", who.role, who.sig.When.Format("-0700"))
+			}
+			// Unlike Subject, git's summary is only the message's first line.
+			summary, _, _ := strings.Cut(strings.TrimLeft(c.Message, "\n"), "\n")
+			fmt.Fprintf(w, "summary %s\n", summary)
+			if r.boundary {
+				fmt.Fprintln(w, "boundary")
+			}
+			if r.previous != "" {
+				fmt.Fprintf(w, "previous %s %s\n", r.previous, quotePath(path))
+			}
+			fmt.Fprintf(w, "filename %s\n", quotePath(path))
+		}
+		for j := 0; j < n; j++ {
+			if j > 0 {
+				fmt.Fprintf(w, "%s %d %d\n", r.commit, r.orig+j+1, first+i+j+1)
+			}
+			fmt.Fprintf(w, "\t%s\n", strings.TrimSuffix(lines[first+i+j], "\n"))
+		}
+		i += n
+	}
+}
+
+// parseLineRange turns blame's -L argument into the half-open range of
+// line indexes it names in a file of the given number of lines. As in
+// git the range is <start>,<end>, <start>,+<count> or <start>,-<count>
+// counting back from start, a missing start is the first line and a
+// missing end the last, the ends may come in either order and an end
+// past the last line stops there.
+func parseLineRange(spec, path string, lines int) (first, last int, err error) {
+	number := func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("-L invalid line number: %s", s)
+		}
+		return n, nil
+	}
+	startSpec, endSpec, hasEnd := strings.Cut(spec, ",")
+	start, end := 1, lines
+	if startSpec != "" {
+		if start, err = number(startSpec); err != nil {
+			return 0, 0, err
+		}
+	}
+	if start > lines {
+		return 0, 0, fmt.Errorf("file %s has only %d lines", path, lines)
+	}
+	if hasEnd && endSpec != "" {
+		count, err := number(strings.TrimLeft(endSpec, "+-"))
+		if err != nil {
+			return 0, 0, err
+		}
+		switch endSpec[0] {
+		case '+':
+			end = start + count - 1
+		case '-':
+			start, end = max(start-count+1, 1), start
+		default:
+			end = count
+		}
+	}
+	if end < start {
+		start, end = end, start
+	}
+	return start - 1, min(end, lines), nil
+}
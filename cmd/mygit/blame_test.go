@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBlame compares blame, in the default and the porcelain format,
+// with git's for a file whose lines come from several commits by two
+// authors, over the whole file, at an older revision and over each form
+// of -L range, and checks that ranges outside the file fail as in git.
+func TestBlame(t *testing.T) {
+	r := newGoldenRepo(t)
+	lines := []string{"one", "two", "three", "four", "five", "six"}
+	r.write("f", strings.Join(lines, "\n")+"\n", 0o644)
+	r.git("add", "f")
+	r.git("commit", "-q", "-m", "root")
+	lines[1], lines[4] = "TWO", "FIVE"
+	r.write("f", strings.Join(lines, "\n")+"\n", 0o644)
+	r.run("", "git", []string{"commit", "-q", "-am", "shout"},
+		"GIT_AUTHOR_NAME=Other Author", "GIT_AUTHOR_EMAIL=other@example.com", "GIT_AUTHOR_DATE=1600000000 +0200")
+	lines = append(lines[:3], append([]string{"inserted"}, lines[3:]...)...)
+	r.write("f", strings.Join(lines, "\n")+"\n", 0o644)
+	r.git("commit", "-q", "-am", "insert")
+
+	for _, args := range [][]string{
+		{"blame", "f"},
+		{"blame", "--porcelain", "f"},
+		{"blame", "HEAD~1", "f"},
+		{"blame", "-L", "2,4", "f"},
+		{"blame", "-L2,4", "--porcelain", "f"},
+		{"blame", "-L", "3", "f"},
+		{"blame", "-L", ",3", "f"},
+		{"blame", "-L", "2,+3", "f"},
+		{"blame", "-L", "5,-2", "f"},
+		{"blame", "-L", "4,2", "f"},
+		{"blame", "-L", "6,100", "f"},
+	} {
+		r.same(args...)
+	}
+	r.sameFailure("blame", "-L", "100", "f")
+	r.sameFailure("blame", "-L", "0,2", "f")
+}
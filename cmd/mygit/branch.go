@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkBranchName returns the full ref name of the branch name, or an
+// error if git would not accept it.
+func checkBranchName(name string) (string, error) {
+	ref := "refs/heads/" + name
+	if strings.HasPrefix(name, "-") || name == "HEAD" {
+		return "", fmt.Errorf("'%s' is not a valid branch name", name)
+	}
+	if err := validShortRefName("refs/heads/", name); err != nil {
+		return "", fmt.Errorf("'%s' is not a valid branch name: %s", name, err)
+	}
+	return ref, nil
+}
+
+// listBranches implements `mygit branch`, marking the current branch
+// with "*" and those checked out in other working trees with "+", and
+// showing where a symbolic ref among them points.
+func listBranches(w io.Writer) error {
+	names, _, err := listRefs("refs/heads/")
+	if err != nil {
+		return err
+	}
+	here, err := filepath.Abs(gitDir())
+	if err != nil {
+		return err
+	}
+	trees, err := listWorktrees()
+	if err != nil {
+		return err
+	}
+	elsewhere := map[string]bool{}
+	for _, t := range trees {
+		if ref, _, err := t.head(); err == nil && t.gitDir != here {
+			elsewhere[ref] = true
+		}
+	}
+	current, err := headRef()
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		if head, err := resolveRef("HEAD"); err == nil {
+			label := detachedLabel(head)
+			if !strings.HasPrefix(label, "HEAD ") {
+				label = "no branch"
+			}
+			fmt.Fprintf(w, "* (%s)\n", label)
+		}
+	}
+	for _, name := range names {
+		mark := " "
+		switch {
+		case name == current:
+			mark = "*"
+		case elsewhere[name]:
+			mark = "+"
+		}
+		// A symbolic ref is listed with the branch it points at.
+		target := ""
+		if data, err := os.ReadFile(gitPath(name)); err == nil {
+			if ref, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: "); ok {
+				target = " -> " + strings.TrimPrefix(ref, "refs/heads/")
+			}
+		}
+		fmt.Fprintf(w, "%s %s%s\n", mark, strings.TrimPrefix(name, "refs/heads/"), target)
+	}
+	return nil
+}
+
+// createBranch implements `mygit branch <name> [<start-point>]`.
+func createBranch(name, start string) error {
+	ref, err := checkBranchName(name)
+	if err != nil {
+		return err
+	}
+	if hasRef(ref) {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	hash, err := resolveObjectName(start)
+	if err != nil {
+		return fmt.Errorf("not a valid object name: '%s'", start)
+	}
+	if hash, err = peelToCommit(hash); err != nil {
+		return err
+	}
+	return updateRef(ref, hash, "branch: Created from "+start)
+}
+
+// renameBranch implements `mygit branch (-m | -M) [<old>] <new>`; old
+// is "" for the current branch. The ref, its reflog, its config section
+// and HEAD, if it points at the branch, all move to the new name. force
+// allows replacing an existing branch.
+func renameBranch(oldName, newName string, force bool) error {
+	head, err := headRef()
+	if err != nil {
+		return err
+	}
+	oldRef := "refs/heads/" + oldName
+	if oldName == "" {
+		if head == "" {
+			return errors.New("cannot rename the current branch while not on any")
+		}
+		oldRef, oldName = head, strings.TrimPrefix(head, "refs/heads/")
+	}
+	newRef, err := checkBranchName(newName)
+	if err != nil {
+		return err
+	}
+	for _, ref := range []string{oldRef, newRef} {
+		if err := materializeRef(ref); err != nil {
+			return err
+		}
+	}
+	if hasRef(newRef) && newRef != oldRef {
+		if !force {
+			return fmt.Errorf("a branch named '%s' already exists", newName)
+		}
+		if newRef == head {
+			top, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("cannot force update the branch '%s' checked out at '%s'", newName, top)
+		}
+	}
+
+	hash, err := readRef(oldRef)
+	if errors.Is(err, os.ErrNotExist) {
+		if oldRef != head {
+			return fmt.Errorf("No branch named '%s'.", oldName)
+		}
+		// An unborn branch is only a name in HEAD.
+		return setHeadRef(newRef)
+	}
+	if err != nil {
+		return err
+	}
+
+	if newRef != oldRef {
+		if err := deleteRef(newRef); err != nil {
+			return err
+		}
+		if err := os.Remove(reflogPath(newRef)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if _, err := os.Stat(reflogPath(oldRef)); err == nil {
+			if err := os.MkdirAll(filepath.Dir(reflogPath(newRef)), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(reflogPath(oldRef), reflogPath(newRef)); err != nil {
+				return err
+			}
+		}
+		if err := deleteRef(oldRef); err != nil {
+			return err
+		}
+		if err := writeLooseRef(newRef, hash); err != nil {
+			return err
+		}
+	}
+	message := fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef)
+	if err := appendReflog(newRef, hash, hash, message); err != nil {
+		return err
+	}
+	if head == oldRef {
+		if err := setHeadRef(newRef); err != nil {
+			return err
+		}
+		// As in git, HEAD's reflog sees the branch deleted and created
+		// again, or, renamed to itself, updated in place.
+		zero := strings.Repeat("0", repoFormat().hexLen())
+		created := zero
+		if newRef == oldRef {
+			created = hash
+		}
+		if err := appendReflog("HEAD", hash, zero, message); err != nil {
+			return err
+		}
+		if err := appendReflog("HEAD", created, hash, message); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.renameSection("branch", oldName, newName) {
+		return cfg.write()
+	}
+	return nil
+}
+
+// materializeRef turns a ref that only exists in packed-refs into a
+// loose one, so it can be moved or rewritten like any other.
+func materializeRef(name string) error {
+	if _, err := os.Lstat(gitPath(name)); err == nil {
+		return nil
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	hash, ok := packed[name]
+	if !ok {
+		return nil
+	}
+	if err := writeLooseRef(name, hash); err != nil {
+		return err
+	}
+	return removePackedRef(name)
+}
+
+// setUpstream implements `mygit branch --set-upstream-to=<upstream>
+// [<branch>]`, recording in branch.<name>.remote and .merge that the
+// branch tracks a remote-tracking branch such as origin/main, or another
+// local branch. branch is "" for the current branch.
+func setUpstream(w io.Writer, branch, upstream string) error {
+	if branch == "" {
+		head, err := headRef()
+		if err != nil {
+			return err
+		}
+		if head == "" {
+			return errors.New("could not set upstream of HEAD when it does not point to any branch")
+		}
+		branch = strings.TrimPrefix(head, "refs/heads/")
+	}
+	if !hasRef("refs/heads/" + branch) {
+		return fmt.Errorf("branch '%s' does not exist", branch)
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	remote, merge := "", ""
+	upstream = strings.TrimPrefix(upstream, "refs/remotes/")
+	if hasRef("refs/remotes/" + upstream) {
+		// Find the remote whose fetch refspec maps a remote branch here.
+		for _, s := range cfg.sections {
+			if s.name != "remote" || remote != "" {
+				continue
+			}
+			for _, raw := range cfg.getAll("remote", s.subsection, "fetch") {
+				spec, err := parseRefspec(raw, s.subsection)
+				if err != nil {
+					continue
+				}
+				if src, ok := spec.reverse("refs/remotes/" + upstream); ok {
+					remote, merge = s.subsection, src
+					break
+				}
+			}
+		}
+	}
+	if remote == "" && hasRef("refs/heads/"+strings.TrimPrefix(upstream, "refs/heads/")) {
+		remote, merge = ".", "refs/heads/"+strings.TrimPrefix(upstream, "refs/heads/")
+	}
+	if remote == "" {
+		return fmt.Errorf("the requested upstream branch '%s' does not exist\n"+
+			"hint: \n"+
+			"hint: If you are planning on basing your work on an upstream\n"+
+			"hint: branch that already exists at the remote, you may need to\n"+
+			"hint: run \"git fetch\" to retrieve it.\n"+
+			"hint: \n"+
+			"hint: If you are planning to push out a new local branch that\n"+
+			"hint: will track its remote counterpart, you may want to use\n"+
+			"hint: \"git push -u\" to set the upstream config as you push.\n"+
+			"hint: Disable this message with \"git config advice.setUpstreamFailure false\"", upstream)
+	}
+	cfg.set("branch", branch, "remote", remote)
+	cfg.set("branch", branch, "merge", merge)
+	if err := cfg.write(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "branch '%s' set up to track '%s'.\n", branch, upstream)
+	return nil
+}
+
+// branchUpstream returns the local ref that holds the upstream of
+// branch (a full ref name), and the short name status shows for it. ok
+// is false when no upstream is configured.
+func branchUpstream(branch string) (ref, name string, ok bool, err error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return "", "", false, err
+	}
+	short := strings.TrimPrefix(branch, "refs/heads/")
+	remote, hasRemote := cfg.get("branch", short, "remote")
+	merge, hasMerge := cfg.get("branch", short, "merge")
+	if !hasRemote || !hasMerge {
+		return "", "", false, nil
+	}
+	if remote == "." {
+		return merge, strings.TrimPrefix(merge, "refs/heads/"), true, nil
+	}
+	for _, raw := range cfg.getAll("remote", remote, "fetch") {
+		spec, err := parseRefspec(raw, remote)
+		if err != nil {
+			continue
+		}
+		if local, ok := spec.match(merge); ok {
+			return local, strings.TrimPrefix(local, "refs/remotes/"), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// resolveUpstream resolves "<branch>@{upstream}", spec being the whole
+// name, to the commit the branch's upstream holds. An empty branch, or
+// HEAD, is the branch HEAD is on.
+func resolveUpstream(branch, spec string) (string, error) {
+	ref := "refs/heads/" + branch
+	if branch == "" || branch == "HEAD" {
+		head, err := headRef()
+		if err != nil {
+			return "", err
+		}
+		if head == "" {
+			return "", errors.New("HEAD does not point to a branch")
+		}
+		ref = head
+	} else if !hasRef(ref) {
+		return "", fmt.Errorf("no such branch: '%s'", branch)
+	}
+	upstream, _, ok, err := branchUpstream(ref)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		cfg, err := readConfig()
+		if err != nil {
+			return "", err
+		}
+		short := strings.TrimPrefix(ref, "refs/heads/")
+		if merge, ok := cfg.get("branch", short, "merge"); ok {
+			return "", fmt.Errorf("upstream branch '%s' not stored as a remote-tracking branch", merge)
+		}
+		return "", fmt.Errorf("no upstream configured for branch '%s'", short)
+	}
+	hash, err := readRef(upstream)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", unknownRevision(spec)
+	}
+	return hash, err
+}
+
+// aheadBehind counts the commits reachable from local but not upstream,
+// and from upstream but not local.
+func (ctx *cmdContext) aheadBehind(local, upstream string) (ahead, behind int, err error) {
+	inOurs := map[string]bool{}
+	err = ctx.walkTopology([]string{local}, func(c *Commit) error {
+		inOurs[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	err = ctx.walkTopology([]string{upstream}, func(c *Commit) error {
+		if inOurs[c.Hash] {
+			delete(inOurs, c.Hash)
+		} else {
+			behind++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(inOurs), behind, nil
+}
+
+// trackingStatus writes the paragraph status shows about how branch
+// compares to its upstream, if it has one.
+func (ctx *cmdContext) trackingStatus(w io.Writer, branch, head string) error {
+	ref, name, ok, err := branchUpstream(branch)
+	if err != nil || !ok {
+		return err
+	}
+	upstream, err := readRef(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(w, "Your branch is based on '%s', but the upstream is gone.\n", name)
+		fmt.Fprintf(w, "  (use \"git branch --unset-upstream\" to fixup)\n\n")
+		return nil
+	}
+	if err != nil || head == "" {
+		return err
+	}
+	ahead, behind, err := ctx.aheadBehind(head, upstream)
+	if err != nil {
+		return err
+	}
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 commit"
+		}
+		return fmt.Sprintf("%d commits", n)
+	}
+	switch {
+	case ahead == 0 && behind == 0:
+		fmt.Fprintf(w, "Your branch is up to date with '%s'.\n", name)
+	case behind == 0:
+		fmt.Fprintf(w, "Your branch is ahead of '%s' by %s.\n", name, plural(ahead))
+		fmt.Fprintf(w, "  (use \"git push\" to publish your local commits)\n")
+	case ahead == 0:
+		fmt.Fprintf(w, "Your branch is behind '%s' by %s, and can be fast-forwarded.\n", name, plural(behind))
+		fmt.Fprintf(w, "  (use \"git pull\" to update your local branch)\n")
+	default:
+		fmt.Fprintf(w, "Your branch and '%s' have diverged,\n", name)
+		fmt.Fprintf(w, "and have %d and %d different commits each, respectively.\n", ahead, behind)
+		fmt.Fprintf(w, "  (use \"git pull\" to merge the remote branch into yours)\n")
+	}
+	fmt.Fprintln(w)
+	return nil
+}
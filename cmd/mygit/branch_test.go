@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRenameBranch compares branch -m and -M with git: the current
+// branch and another, one whose config section and reflog move with it,
+// a packed one, to itself, and refusals of a name taken, of forcing over
+// the branch checked out, of a bad name and of a branch that does not
+// exist.
+func TestRenameBranch(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("branch", "--set-upstream-to=main", "topic")
+	r.git("branch", "packed", "main~1")
+	r.git("pack-refs", "--all")
+	start := filepath.Join(t.TempDir(), "start")
+	r.run("", "cp", []string{"-a", filepath.Join(r.dir, ".git"), start})
+
+	state := func() string {
+		config, _ := os.ReadFile(filepath.Join(r.dir, ".git", "config"))
+		return r.git("for-each-ref") + r.git("rev-parse", "--symbolic-full-name", "HEAD") +
+			r.git("reflog", "show", "--all") + string(config)
+	}
+	// Going back puts the repository as it was at the start.
+	back := func() {
+		if err := os.RemoveAll(filepath.Join(r.dir, ".git")); err != nil {
+			t.Fatal(err)
+		}
+		r.run("", "cp", []string{"-a", start, filepath.Join(r.dir, ".git")})
+	}
+	for _, args := range [][]string{
+		{"branch", "-m", "renamed"},
+		{"branch", "-m", "topic", "feature/topic"},
+		{"branch", "-m", "packed", "unpacked"},
+		{"branch", "-m", "topic", "main"},
+		{"branch", "-M", "topic", "main"},
+		{"branch", "-M", "main", "topic"},
+		{"branch", "-m", "missing", "other"},
+		{"branch", "-m", "main", "main"},
+	} {
+		r.sameRun(back, state, args...)
+		back()
+	}
+	// Unlike git's, the message names the rule the name breaks.
+	if got := r.mygitFails("branch", "-m", "topic", "bad..name"); got != "fatal: 'bad..name' is not a valid branch name: ref name cannot contain '..'\n" {
+		t.Errorf("branch -m to a bad name printed %q", got)
+	}
+}
+
+// TestTrackingStatus sets upstreams with branch -u and
+// --set-upstream-to, and compares what they print and record, and what
+// status says of a branch up to date with, ahead of, behind and diverged
+// from its upstream and of one whose upstream is gone, with git.
+func TestTrackingStatus(t *testing.T) {
+	r := mergeRepo(t, true)
+	clone := *r
+	clone.dir = filepath.Join(t.TempDir(), "clone")
+	r.git("clone", "-q", r.dir, clone.dir)
+
+	clone.same("status")
+	clone.write("a", "ahead\n", 0o644)
+	clone.git("commit", "-q", "-am", "ahead")
+	clone.same("status")
+	clone.git("fetch", "-q", "origin", "topic:refs/remotes/origin/topic")
+	clone.same("branch", "-u", "origin/topic")
+	if got := clone.git("config", "--get-regexp", "^branch\\."); got != "branch.main.remote origin\nbranch.main.merge refs/heads/topic\n" {
+		t.Errorf("branch -u recorded %q", got)
+	}
+	clone.same("status")
+	clone.git("reset", "-q", "--hard", "HEAD~1")
+	clone.same("status")
+	clone.git("reset", "-q", "--hard", "origin/topic~1")
+	clone.same("status")
+
+	clone.git("branch", "side")
+	clone.same("branch", "--set-upstream-to=origin/main", "side")
+	if got := clone.git("config", "--get-regexp", "^branch\\.side\\."); got != "branch.side.remote origin\nbranch.side.merge refs/heads/main\n" {
+		t.Errorf("branch --set-upstream-to recorded %q", got)
+	}
+	clone.git("checkout", "-q", "side")
+	clone.same("status")
+	clone.git("update-ref", "-d", "refs/remotes/origin/main")
+	clone.same("status")
+	clone.sameFailure("branch", "-u", "origin/missing")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bundle is the header of a git bundle: the refs it carries and the
+// commits a repository must already have to use it.
+type bundle struct {
+	format        objectFormat
+	prerequisites []string // object names
+	names         []string
+	refs          map[string]string
+}
+
+// bundleRevs splits rev-list style arguments into the refs a bundle
+// should carry, their tips, and the commits to leave out. "--all" takes
+// HEAD and every ref, "^rev" excludes rev and "a..b" is "^a b".
+func bundleRevs(args []string) (refs []string, include, exclude []string, err error) {
+	for _, arg := range args {
+		if arg == "--all" {
+			names, _, err := listRefs("refs/")
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			args = append(args, names...)
+			args = append(args, "HEAD")
+		}
+	}
+	for _, arg := range args {
+		if arg == "--all" {
+			continue
+		}
+		if from, to, ok := strings.Cut(arg, ".."); ok {
+			hash, err := resolveObjectName(from)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			exclude = append(exclude, hash)
+			arg = to
+		}
+		if negative, ok := strings.CutPrefix(arg, "^"); ok {
+			hash, err := resolveObjectName(negative)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+		ref := arg
+		if arg != "HEAD" {
+			var ok bool
+			if ref, ok = expandRef(arg); !ok {
+				return nil, nil, nil, fmt.Errorf("unknown revision %q", arg)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	for _, ref := range refs {
+		hash, err := readRef(ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		include = append(include, hash)
+	}
+	return refs, include, exclude, nil
+}
+
+// createBundle implements `mygit bundle create <file> <rev>...`: a v2
+// bundle header naming the refs and prerequisite commits, followed by a
+// pack of everything reachable from the refs but not the exclusions.
+func createBundle(ctx *cmdContext, path string, args []string) error {
+	refs, include, exclude, err := bundleRevs(args)
+	if err != nil {
+		return err
+	}
+	excluded, err := ctx.reachableObjects(exclude, nil)
+	if err != nil {
+		return err
+	}
+	skip := make(map[string]bool, len(excluded))
+	for _, hash := range excluded {
+		skip[hash] = true
+	}
+
+	// Tips may be tags; the commits below them are what history is
+	// walked from. Refs whose commits are excluded carry nothing.
+	var names, hashes, tips, tags []string
+	seenRef := map[string]bool{}
+	for i, hash := range include {
+		if seenRef[refs[i]] {
+			continue
+		}
+		seenRef[refs[i]] = true
+		commit, err := peelToCommit(hash)
+		if err != nil {
+			return err
+		}
+		if skip[commit] {
+			fmt.Fprintf(os.Stderr, "warning: ref '%s' is excluded by the rev-list options\n", refs[i])
+			continue
+		}
+		if commit != hash {
+			tags = append(tags, hash)
+		}
+		names, hashes, tips = append(names, refs[i]), append(hashes, hash), append(tips, commit)
+	}
+	if len(names) == 0 {
+		return errors.New("Refusing to create empty bundle.")
+	}
+	objects, err := ctx.reachableObjects(tips, skip)
+	if err != nil {
+		return err
+	}
+
+	// The excluded commits right below included ones are the
+	// prerequisites a receiving repository must have. reachableObjects
+	// loaded every included commit through ctx, so the cache tells
+	// commits apart from trees and blobs.
+	var prerequisites []string
+	isPrereq := map[string]bool{}
+	for _, hash := range objects {
+		c, ok := ctx.commits[hash]
+		if !ok {
+			continue
+		}
+		for _, parent := range c.Parents {
+			if skip[parent] && !isPrereq[parent] {
+				isPrereq[parent] = true
+				prerequisites = append(prerequisites, parent)
+			}
+		}
+	}
+	objects = append(objects, tags...)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if repoFormat().name == "sha1" {
+		fmt.Fprintf(w, "# v2 git bundle\n")
+	} else {
+		fmt.Fprintf(w, "# v3 git bundle\n@object-format=%s\n", repoFormat().name)
+	}
+	for _, hash := range prerequisites {
+		c, err := ctx.getCommit(hash)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		fmt.Fprintf(w, "-%s %s\n", hash, c.Subject())
+	}
+	for i, name := range names {
+		fmt.Fprintf(w, "%s %s\n", hashes[i], name)
+	}
+	fmt.Fprintf(w, "\n")
+	err = writePack(w, objects)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// readBundleHeader parses a v2 or v3 bundle header, leaving r at the
+// start of the pack.
+func readBundleHeader(r *bufio.Reader) (*bundle, error) {
+	b := &bundle{format: sha1Format, refs: map[string]string{}}
+	signature, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.New("not a bundle")
+	}
+	switch signature {
+	case "# v2 git bundle\n", "# v3 git bundle\n":
+	default:
+		return nil, errors.New("not a bundle")
+	}
+	v3 := signature == "# v3 git bundle\n"
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("truncated bundle header")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return b, nil
+		case v3 && strings.HasPrefix(line, "@"):
+			key, value, _ := strings.Cut(line[1:], "=")
+			if key == "object-format" {
+				if b.format, err = parseObjectFormat(value); err != nil {
+					return nil, err
+				}
+			}
+		case strings.HasPrefix(line, "-"):
+			hash, _, _ := strings.Cut(line[1:], " ")
+			b.prerequisites = append(b.prerequisites, hash)
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok || len(hash) != b.format.hexLen() || !isHex(hash) {
+				return nil, fmt.Errorf("unrecognized bundle header line %q", line)
+			}
+			b.names = append(b.names, name)
+			b.refs[name] = hash
+		}
+	}
+}
+
+// verifyBundle implements `mygit bundle verify <file>`. The bundle must
+// be usable here: every prerequisite commit is present, the pack's
+// checksum matches and every object in it can be resolved, and each ref
+// it carries names an object that would then exist.
+func verifyBundle(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open '%s'", path)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	b, err := readBundleHeader(r)
+	if err != nil {
+		return fmt.Errorf("'%s' does not look like a v2 or v3 bundle file", path)
+	}
+	if b.format.name != repoFormat().name {
+		return fmt.Errorf("bundle uses %s, but this repository uses %s", b.format.name, repoFormat().name)
+	}
+	var missing []string
+	for _, hash := range b.prerequisites {
+		if !hasObject(hash) {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Repository lacks these prerequisite commits:\nerror: %s ", strings.Join(missing, " \nerror: "))
+	}
+
+	entries, _, err := parsePack(r, io.Discard, nil)
+	if err != nil {
+		return err
+	}
+	if err := resolvePackEntries(entries, nil); err != nil {
+		return err
+	}
+	inPack := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		inPack[e.hash] = true
+	}
+	for _, name := range b.names {
+		if hash := b.refs[name]; !inPack[hash] && !hasObject(hash) {
+			return fmt.Errorf("bundle ref %s points at %s, which is not in the bundle", name, hash)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is okay\n", path)
+	refPlural := func(n int, what string) string {
+		if n == 1 {
+			return "The bundle " + what + " this ref:"
+		}
+		return fmt.Sprintf("The bundle %s these %d refs:", what, n)
+	}
+	fmt.Fprintln(w, refPlural(len(b.names), "contains"))
+	for _, name := range b.names {
+		fmt.Fprintf(w, "%s %s\n", b.refs[name], name)
+	}
+	if len(b.prerequisites) == 0 {
+		fmt.Fprintln(w, "The bundle records a complete history.")
+	} else {
+		fmt.Fprintln(w, refPlural(len(b.prerequisites), "requires"))
+		for _, hash := range b.prerequisites {
+			fmt.Fprintf(w, "%s \n", hash)
+		}
+	}
+	fmt.Fprintf(w, "The bundle uses this hash algorithm: %s\n", b.format.name)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBundle creates bundles of the whole history and of its last
+// commit with git and mygit and checks that their headers match, that
+// git can verify and clone from mygit's, and that mygit verifies git's
+// as git does, refusing one whose prerequisites are missing.
+func TestBundle(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "changed\n", 0o644)
+	r.git("commit", "-q", "-am", "change README")
+	r.git("tag", "-a", "-m", "release", "v1")
+
+	header := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(r.dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, _, _ := strings.Cut(string(data), "\n\n")
+		return head
+	}
+	for i, revs := range [][]string{{"main", "v1"}, {"main~1..main"}} {
+		name := []string{"full", "last"}[i]
+		r.git(append([]string{"bundle", "create", "-q", name + ".git"}, revs...)...)
+		r.mygit(append([]string{"bundle", "create", name + ".mygit"}, revs...)...)
+		if want, got := header(name+".git"), header(name+".mygit"); got != want {
+			t.Errorf("bundle create %s:\ngit:   %q\nmygit: %q", strings.Join(revs, " "), want, got)
+		}
+		r.git("bundle", "verify", "-q", name+".mygit")
+		r.same("bundle", "verify", name+".git")
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	r.git("clone", "-q", filepath.Join(r.dir, "full.mygit"), clone)
+	r.git("-C", clone, "fsck", "--strict")
+	if want, got := r.git("rev-parse", "v1"), r.git("-C", clone, "rev-parse", "v1"); got != want {
+		t.Errorf("clone of mygit's bundle has v1 at %q, want %q", got, want)
+	}
+
+	empty := newGoldenRepo(t)
+	empty.sameFailure("bundle", "verify", filepath.Join(r.dir, "last.git"))
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// catFile implements `mygit cat-file (-t | -s | -p) [--allow-unknown-type] <object>`,
+// `mygit cat-file <type> <object>`, `mygit cat-file (--filters |
+// --textconv) --path=<path> <blob>` and `mygit cat-file (--batch |
+// --batch-check | --batch-command) [--buffer]`.
+// <object> may be "<rev>:<path>", and with --follow-symlinks symlinks on
+// the way to path are followed within the tree. git allows that only in
+// batch mode; mygit allows it everywhere. <type> is the type wanted, to
+// which a tag or commit is peeled, as git does.
+// --allow-unknown-type lets -t and -s report the header of an object
+// whose type is not one of the four git knows; -p still rejects it.
+// --object-format=<name> reads names of that format, which need not be
+// the repository's.
+// -p writes a blob exactly as it is stored, whatever core.autocrlf and
+// the attributes say; --filters converts it as checking it out at path
+// would, and --textconv runs the textconv command of path's diff driver.
+func catFile(r io.Reader, w io.Writer, args []string) error {
+	if batch, follow, buffer, ok := batchFlags(args); ok {
+		if batch == "--batch-command" {
+			return batchCommand(r, w, follow, buffer)
+		}
+		return batchNames(r, w, batch == "--batch", follow, buffer)
+	}
+	var mode, name, path, wantType string
+	allowUnknown, filters, textconv, follow := false, false, false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--follow-symlinks":
+			follow = true
+		case mode == "" && name == "" && wantType == "" && objectTypeName(arg):
+			wantType = arg
+		case arg == "-t" || arg == "-s" || arg == "-p":
+			mode = arg
+		case arg == "--allow-unknown-type":
+			allowUnknown = true
+		case arg == "--filters":
+			filters = true
+		case arg == "--textconv":
+			textconv = true
+		case strings.HasPrefix(arg, "--path="):
+			path = strings.TrimPrefix(arg, "--path=")
+		case strings.HasPrefix(arg, "--object-format="):
+			if err := overrideObjectFormat(strings.TrimPrefix(arg, "--object-format="), false); err != nil {
+				return err
+			}
+		default:
+			name = arg
+		}
+	}
+	if _, p, ok := cutTreePath(name); ok && path == "" && (filters || textconv) {
+		path = p // "<rev>:<path>" says which path to convert for
+	}
+	switch {
+	case filters && textconv:
+		return errors.New("option `filters' is incompatible with --textconv")
+	case filters && mode != "":
+		return fmt.Errorf("switch `%s' is incompatible with --filters", mode[1:])
+	case textconv && mode != "":
+		return fmt.Errorf("option `textconv' is incompatible with %s", mode)
+	case path != "" && !filters && !textconv:
+		return errors.New("'--path=<path|tree-ish>' needs '--filters' or '--textconv'")
+	case (filters || textconv) && name != "" && path == "":
+		return fmt.Errorf("<object>:<path> required, only <object> '%s' given", name)
+	case filters && name != "":
+		return catFiltered(w, name, path)
+	case textconv && name != "":
+		return catTextconv(r, w, name, path)
+	}
+	if wantType != "" && mode == "" && name == "" {
+		// A lone type name is an object name after all.
+		name, wantType = wantType, ""
+	}
+	if (mode == "") == (wantType == "") || name == "" {
+		return errors.New("usage: mygit cat-file (-t | -s | -p) [--allow-unknown-type] [--follow-symlinks] <object>\n" +
+			"   or: mygit cat-file [--follow-symlinks] <type> <object>\n" +
+			"   or: mygit cat-file (--filters | --textconv) --path=<path> <blob>\n" +
+			"   or: mygit cat-file (--batch | --batch-check | --batch-command) [--buffer] [--follow-symlinks]")
+	}
+	if allowUnknown && mode != "-t" && mode != "-s" {
+		return errors.New("--allow-unknown-type only applies to -t and -s")
+	}
+	hash, err := catObjectName(name, follow)
+	if err != nil {
+		return err
+	}
+	if wantType != "" {
+		return catTyped(w, wantType, hash, name)
+	}
+
+	if mode != "-p" && !allowUnknown {
+		// The header says all -t and -s need to know.
+		objType, size, err := statObject(hash)
+		if err != nil {
+			return err
+		}
+		if mode == "-t" {
+			fmt.Fprintln(w, objType)
+		} else {
+			fmt.Fprintln(w, size)
+		}
+		return nil
+	}
+	var typeName string
+	var body []byte
+	if allowUnknown {
+		typeName, body, err = readUnknownObject(hash)
+	} else {
+		var objType ObjectType
+		objType, body, err = readObject(hash)
+		typeName = objType.String()
+	}
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "-t":
+		fmt.Fprintln(w, typeName)
+	case "-s":
+		fmt.Fprintln(w, len(body))
+	case "-p":
+		if typeName == TreeObject.String() {
+			return lsTree(w, hash, lsTreeOptions{}) // trees print as ls-tree lists them
+		}
+		w.Write(body)
+	}
+	return nil
+}
+
+// objectTypeName reports whether name is one of the four object types.
+func objectTypeName(name string) bool {
+	_, err := ParseObjectType(name)
+	return err == nil
+}
+
+// catObjectName resolves the object name cat-file was given. With
+// follow, a "<rev>:<path>" follows symlinks within the tree.
+func catObjectName(name string, follow bool) (string, error) {
+	if _, _, ok := cutTreePath(name); ok {
+		hash, err := resolveTreePath(name, follow)
+		var linkErr *symlinkError
+		if errors.As(err, &linkErr) {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return hash, err
+	}
+	hash, err := resolveObjectName(name)
+	if err != nil {
+		return "", fmt.Errorf("Not a valid object name %s", name)
+	}
+	return hash, nil
+}
+
+// catTyped writes the body of the object hash as objType, peeling tags
+// and, for a tree, commits on the way, as `cat-file <type>` does.
+func catTyped(w io.Writer, objType, hash, name string) error {
+	for depth := 0; depth < 10; depth++ {
+		t, body, err := readObject(hash)
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.String() == objType:
+			_, err = w.Write(body)
+			return err
+		case t == TagObject:
+			tag, err := parseTag(hash, body)
+			if err != nil {
+				return err
+			}
+			hash = tag.Object
+		case t == CommitObject && objType == TreeObject.String():
+			c, err := parseCommit(hash, body)
+			if err != nil {
+				return err
+			}
+			hash = c.Tree
+		default:
+			return fmt.Errorf("git cat-file %s: bad file", name)
+		}
+	}
+	return fmt.Errorf("git cat-file %s: bad file", name)
+}
+
+// catFiltered writes the blob name as it would be checked out at path,
+// with the line endings core.autocrlf and path's attributes give it.
+func catFiltered(w io.Writer, name, path string) error {
+	hash, err := resolveObjectName(name)
+	if err != nil {
+		return fmt.Errorf("Not a valid object name %s", name)
+	}
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return err
+	}
+	if objType != BlobObject {
+		// git writes any other object as it is.
+		_, err = w.Write(body)
+		return err
+	}
+	_, err = w.Write(toWorktreeText(filepath.ToSlash(path), body))
+	return err
+}
+
+// catTextconv writes the blob name as diff would show it at path: the
+// output of the command diff.<driver>.textconv names, for the driver
+// path's diff attribute picks, given the blob in a temporary file. Like
+// git, it falls back to what -p writes when there is no such command.
+func catTextconv(r io.Reader, w io.Writer, name, path string) error {
+	hash, err := resolveObjectName(name)
+	if err != nil {
+		return fmt.Errorf("Not a valid object name %s", name)
+	}
+	command := ""
+	switch driver := pathAttributes(filepath.ToSlash(path))["diff"]; driver {
+	case "", attrSet, attrUnset:
+	default:
+		if cfg, err := readConfig(); err == nil {
+			command, _ = cfg.get("diff", driver, "textconv")
+		}
+	}
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return err
+	}
+	if command == "" || objType != BlobObject {
+		return catFile(r, w, []string{"-p", hash})
+	}
+
+	tmp, err := os.CreateTemp("", "*_"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// As in git, the command is run by the shell with the file as its
+	// argument, so it may carry options of its own.
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, tmp.Name())
+	cmd.Stdout, cmd.Stderr = w, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("unable to read files to diff")
+	}
+	return nil
+}
+
+// batchFlags recognizes the arguments of the batch modes: one of
+// --batch, --batch-check and --batch-command, with --follow-symlinks
+// and --buffer.
+func batchFlags(args []string) (batch string, follow, buffer, ok bool) {
+	for _, arg := range args {
+		switch arg {
+		case "--batch", "--batch-check", "--batch-command":
+			if batch != "" {
+				return "", false, false, false
+			}
+			batch = arg
+		case "--follow-symlinks":
+			follow = true
+		case "--buffer":
+			buffer = true
+		default:
+			return "", false, false, false
+		}
+	}
+	return batch, follow, buffer, batch != ""
+}
+
+// batchBufferSize is how much output --buffer holds back at most before
+// writing it, so memory stays bounded however many objects are asked
+// for.
+const batchBufferSize = 64 << 10
+
+// newBatchWriter returns the writer the batch modes answer through. Only
+// with buffer does output wait for it to fill; otherwise the caller
+// flushes it after every answer, so that a process driving cat-file
+// through a pipe sees each one at once.
+func newBatchWriter(w io.Writer, buffer bool) *bufio.Writer {
+	if buffer {
+		return bufio.NewWriterSize(w, batchBufferSize)
+	}
+	return bufio.NewWriter(w)
+}
+
+// batchNames implements `mygit cat-file (--batch | --batch-check)
+// [--buffer] [--follow-symlinks]`: for each object named on a line of r
+// it writes "<hash> <type> <size>", followed with contents by the
+// object's content and a newline, or "<name> missing" when there is no
+// such object. Without contents only headers are read. With follow, a
+// "<rev>:<path>" that cannot be followed through the tree's symlinks is
+// reported as git does: the kind of failure and a length, then the
+// name, or for a link out of the tree where it leads. Each answer is
+// written as soon as it is known unless buffer is set.
+func batchNames(r io.Reader, w io.Writer, contents, follow, buffer bool) (err error) {
+	out := newBatchWriter(w, buffer)
+	// Answers given before a failure are still written, however much
+	// --buffer was holding back.
+	defer func() {
+		if ferr := out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := batchObject(out, scanner.Text(), follow, contents); err != nil {
+			return err
+		}
+		if !buffer {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// batchCommand implements `mygit cat-file --batch-command [--buffer]
+// [--follow-symlinks]`: each line of r is "info <object>", answered as
+// --batch-check answers a name, "contents <object>", answered as
+// --batch does, or "flush". Output is written after each command, or
+// with buffer only on flush, when the buffer fills and at the end of
+// the input. Unlike git, which stops at the first bad command, an
+// unknown or empty command is reported and the rest still run.
+func batchCommand(r io.Reader, w io.Writer, follow, buffer bool) (err error) {
+	out := newBatchWriter(w, buffer)
+	// As for batchNames, what was answered before a failure is written.
+	defer func() {
+		if ferr := out.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		command, name, _ := strings.Cut(scanner.Text(), " ")
+		switch command {
+		case "info", "contents":
+			if err := batchObject(out, name, follow, command == "contents"); err != nil {
+				return err
+			}
+		case "flush":
+			if !buffer {
+				return errors.New("flush is only for --buffer mode")
+			}
+		case "":
+			fmt.Fprintln(os.Stderr, "error: empty command in input")
+		default:
+			fmt.Fprintf(os.Stderr, "error: unknown command: '%s'\n", command)
+		}
+		if !buffer || command == "flush" {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// batchObject answers one object name for the batch modes, with its
+// content too when contents is set.
+func batchObject(w io.Writer, name string, follow, contents bool) error {
+	var hash string
+	var err error
+	if _, _, ok := cutTreePath(name); ok {
+		hash, err = resolveTreePath(name, follow)
+	} else {
+		hash, err = resolveObjectName(name)
+	}
+	var linkErr *symlinkError
+	if errors.As(err, &linkErr) {
+		what := name
+		if linkErr.kind == "symlink" {
+			what = linkErr.target
+		}
+		fmt.Fprintf(w, "%s %d\n%s\n", linkErr.kind, len(what), what)
+		return nil
+	}
+	if err != nil || !hasObject(hash) {
+		fmt.Fprintf(w, "%s missing\n", name)
+		return nil
+	}
+	if !contents {
+		objType, size, err := statObject(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %s %d\n", hash, objType, size)
+		return nil
+	}
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s %s %d\n", hash, objType, len(body))
+	w.Write(body)
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCatFileTextconv compares cat-file --textconv with git's for a blob
+// whose diff driver has a textconv command, one whose driver has none,
+// one with no driver, and a path given with --path rather than in the
+// object name.
+func TestCatFileTextconv(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.git("config", "diff.upper.textconv", "tr a-z A-Z <")
+	r.write(".gitattributes", "*.up diff=upper\n*.none diff=none\n", 0o644)
+	r.write("a.up", "shout\n", 0o644)
+	r.write("b.none", "quiet\n", 0o644)
+	r.write("c.txt", "plain\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "files")
+	for _, args := range [][]string{
+		{"cat-file", "--textconv", "HEAD:a.up"},
+		{"cat-file", "--textconv", "HEAD:b.none"},
+		{"cat-file", "--textconv", "HEAD:c.txt"},
+		{"cat-file", "--textconv", "--path=a.up", "HEAD:c.txt"},
+		{"cat-file", "-p", "HEAD:a.up"},
+	} {
+		r.same(args...)
+	}
+	if got := r.same("cat-file", "--textconv", "HEAD:a.up"); got != "SHOUT\n" {
+		t.Errorf("cat-file --textconv ran no textconv: %q", got)
+	}
+}
+
+// TestFollowSymlinks compares cat-file --batch --follow-symlinks with
+// git's for <rev>:<path> names through in-tree symlinks: to a file in
+// another directory, through a symlinked directory, chained, looping,
+// dangling and leading out of the tree.
+func TestFollowSymlinks(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("real/file", "real\n", 0o644)
+	for link, target := range map[string]string{
+		"src/link":     "../real/file",
+		"src/dir":      "../real",
+		"src/chain":    "link",
+		"loop/a":       "b",
+		"loop/b":       "a",
+		"dangling":     "nowhere",
+		"outside":      "../outside",
+		"src/absolute": "/etc/passwd",
+	} {
+		os.MkdirAll(filepath.Join(r.dir, filepath.Dir(link)), 0o755)
+		if err := os.Symlink(target, filepath.Join(r.dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "links")
+	names := []string{"src/link", "src/dir/file", "src/chain", "loop/a", "dangling", "outside", "src/absolute", "real/file", "missing"}
+	var stdin strings.Builder
+	for _, name := range names {
+		stdin.WriteString("HEAD:" + name + "\n")
+	}
+	for _, batch := range []string{"--batch", "--batch-check"} {
+		r.sameIn(stdin.String(), "cat-file", batch, "--follow-symlinks")
+		r.sameIn(stdin.String(), "cat-file", batch)
+	}
+}
+
+// TestObjectNames compares rev-parse and cat-file with git's for the
+// extended object names: ancestors and parents, <rev>:<path>, peeling
+// with ^{<type>} and ^{}, and names that do not resolve.
+func TestObjectNames(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("merge", "-q", "--no-edit", "topic")
+	r.git("tag", "-a", "-m", "release", "v1", "HEAD~1")
+	names := []string{
+		"HEAD", "HEAD~", "HEAD~2", "HEAD^", "HEAD^2", "HEAD^^", "HEAD~1^1",
+		"HEAD:a", "HEAD^2:new.txt", "HEAD:", "main~1:c",
+		"HEAD^{tree}", "HEAD^{commit}", "v1^{}", "v1^{commit}", "v1^{tree}", "v1^{tag}",
+	}
+	r.same(append([]string{"rev-parse"}, names...)...)
+	for _, name := range []string{"HEAD:a", "HEAD^{tree}", "v1^{}", "v1", "HEAD^2"} {
+		r.same("cat-file", "-p", name)
+		r.same("cat-file", "-t", name)
+	}
+	for _, name := range []string{"HEAD~3", "HEAD^3", "HEAD:missing", "nothing"} {
+		r.sameFailure("rev-parse", name)
+		r.sameCatFileFailure("-t", name)
+	}
+	for _, name := range []string{"HEAD^{blob}", "v1^{blob}"} {
+		if got := r.mygitFails("rev-parse", name); !strings.Contains(got, name+": expected blob type, but the object dereferences to tree type") {
+			t.Errorf("rev-parse %s printed %q", name, got)
+		}
+	}
+}
+
+// sameCatFileFailure runs cat-file with args with git and mygit, which
+// must both fail with the same message. cat-file prints its errors
+// without git's "error: " or "fatal: " in front, so only the first line
+// of git's is compared, after its prefix.
+func (r *goldenRepo) sameCatFileFailure(args ...string) {
+	r.t.Helper()
+	args = append([]string{"cat-file"}, args...)
+	want, _ := r.stderrOf("git", args...)
+	got := r.mygitFails(args...)
+	wantLine, _, _ := strings.Cut(want, "\n")
+	if gotLine, _, _ := strings.Cut(got, "\n"); gotLine == "" || !strings.HasSuffix(wantLine, ": "+gotLine) {
+		r.t.Errorf("%s:\ngit:   %q\nmygit: %q", strings.Join(args, " "), want, got)
+	}
+}
+
+// TestBatchCommand compares cat-file --batch-command with git's for info
+// and contents of each kind of object, missing ones and <rev>:<path>
+// names, with and without --buffer and flush, and checks that where git
+// stops at an unknown or empty command mygit reports it and goes on.
+func TestBatchCommand(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("tag", "-a", "-m", "release", "v1")
+	var commands strings.Builder
+	for _, name := range []string{"HEAD", "HEAD^{tree}", "HEAD:README", "HEAD:data.bin", "v1", "HEAD:missing", "nothing"} {
+		commands.WriteString("info " + name + "\ncontents " + name + "\n")
+	}
+	r.sameIn(commands.String(), "cat-file", "--batch-command")
+	r.sameIn(commands.String()+"flush\n", "cat-file", "--batch-command", "--buffer")
+	r.sameIn("info HEAD\nflush\ncontents HEAD:README\n", "cat-file", "--batch-command", "--buffer")
+	r.same("cat-file", "--batch-command", "--buffer")
+
+	want := r.run("info HEAD\ninfo HEAD:README\n", "git", []string{"cat-file", "--batch-command"})
+	cmd := exec.Command(os.Args[0], "cat-file", "--batch-command")
+	cmd.Dir, cmd.Env = r.dir, append(append([]string{}, r.env...), "MYGIT_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader("info HEAD\nfrobnicate HEAD\n\ninfo HEAD:README\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	got, err := cmd.Output()
+	if err != nil || string(got) != want {
+		t.Errorf("batch-command past bad commands printed %q, %v; want %q", got, err, want)
+	}
+	if stderr.String() != "error: unknown command: 'frobnicate'\nerror: empty command in input\n" {
+		t.Errorf("batch-command reported %q", stderr.String())
+	}
+}
+
+// TestBatchBuffer checks that --batch and --batch-check print what git
+// does with and without --buffer, and that without it each answer is
+// written as soon as its name is read, so that a caller can wait for it
+// before asking the next, while with it nothing is written before the
+// input ends.
+func TestBatchBuffer(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	names := "HEAD\nHEAD:README\nHEAD^{tree}\nnothing\n"
+	for _, batch := range []string{"--batch", "--batch-check"} {
+		r.sameIn(names, "cat-file", batch)
+		r.sameIn(names, "cat-file", batch, "--buffer")
+	}
+
+	want := r.run("HEAD\n", "git", []string{"cat-file", "--batch-check"})
+	for _, buffer := range []bool{false, true} {
+		args := []string{"cat-file", "--batch-check"}
+		if buffer {
+			args = append(args, "--buffer")
+		}
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Dir, cmd.Env = r.dir, append(append([]string{}, r.env...), "MYGIT_TEST_MAIN=1")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(stdin, "HEAD\n")
+		answer := make(chan string, 1)
+		go func() {
+			line, _ := bufio.NewReader(stdout).ReadString('\n')
+			answer <- line
+		}()
+		select {
+		case line := <-answer:
+			if buffer {
+				t.Errorf("--buffer answered %q before the input ended", line)
+			} else if line != want {
+				t.Errorf("unbuffered answer = %q, want %q", line, want)
+			}
+			stdin.Close()
+		case <-time.After(500 * time.Millisecond):
+			stdin.Close()
+			line := <-answer
+			if !buffer {
+				t.Error("no answer without --buffer before the input ended")
+			} else if line != want {
+				t.Errorf("buffered answer = %q, want %q", line, want)
+			}
+		}
+		cmd.Wait()
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkoutTarget is where a checkout moves HEAD: a branch, or a commit
+// to detach at when branch is "".
+type checkoutTarget struct {
+	name   string // as given on the command line
+	branch string // full ref name
+	commit string
+	create string // when set, branch is created with this reflog message
+}
+
+// resolveCheckoutTarget prefers a branch of the given name and otherwise
+// detaches at the commit name refers to.
+func resolveCheckoutTarget(name string) (*checkoutTarget, error) {
+	t := &checkoutTarget{name: name}
+	if hasRef("refs/heads/" + name) {
+		t.branch = "refs/heads/" + name
+	}
+	hash, err := resolveObjectName(name)
+	if err != nil {
+		return nil, fmt.Errorf("pathspec '%s' did not match any file(s) known to git", name)
+	}
+	if t.commit, err = peelToCommit(hash); err != nil {
+		return nil, fmt.Errorf("reference is not a tree: %s", name)
+	}
+	return t, nil
+}
+
+// checkoutConflicts returns the paths that moving from the status's HEAD
+// to the files of another commit would overwrite while they hold local
+// changes, and the untracked files in the way of paths it would create.
+// A changed path that already matches the target is not a conflict.
+func (s *worktreeStatus) checkoutConflicts(to map[string]TreeEntry) (changed, untracked []string) {
+	for _, e := range s.entries {
+		target, inTarget := to[e.path]
+		if e.staged == '?' {
+			if inTarget {
+				untracked = append(untracked, e.path)
+			}
+			continue
+		}
+		if cur, ok := s.files[e.path]; ok == inTarget && cur.Hash == target.Hash && cur.Mode == target.Mode {
+			continue // the checkout leaves this path alone
+		}
+		if ie := s.idx.entry(e.path); e.unstaged == ' ' && ie != nil && inTarget &&
+			ie.hash == target.Hash && ie.mode == parseMode(target.Mode) {
+			continue
+		}
+		changed = append(changed, e.path)
+	}
+	return changed, untracked
+}
+
+// checkoutFiles moves the working tree and index from the status's HEAD
+// to the files of another commit. Paths the two commits agree on keep
+// their local changes. With force, local changes are discarded and
+// every file is written afresh.
+func (s *worktreeStatus) checkoutFiles(to map[string]TreeEntry, force bool) error {
+	if force {
+		for path := range s.files {
+			if _, ok := to[path]; !ok {
+				if err := removeWorktreeFile(path); err != nil {
+					return err
+				}
+			}
+		}
+		for _, e := range s.idx.entries {
+			if _, ok := to[e.path]; !ok {
+				if err := removeWorktreeFile(e.path); err != nil {
+					return err
+				}
+			}
+		}
+		if err := updateWorktree(map[string]TreeEntry{}, to); err != nil {
+			return err
+		}
+		tree, err := writeTreeFiles(to)
+		if err != nil {
+			return err
+		}
+		idx, err := indexFromTree(tree, &index{})
+		if err != nil {
+			return err
+		}
+		if err := idx.applySparseCheckout(os.Stderr); err != nil {
+			return err
+		}
+		return idx.write()
+	}
+
+	if err := updateWorktree(s.files, to); err != nil {
+		return err
+	}
+	for path := range s.files {
+		if _, ok := to[path]; !ok {
+			s.idx.removeUnder(path, nil)
+		}
+	}
+	for _, path := range sortedPaths(to) {
+		e := to[path]
+		if old, ok := s.files[path]; ok && old == e {
+			continue
+		}
+		ie, err := newIndexEntry(path, e.Hash, parseMode(e.Mode))
+		if err != nil {
+			return err
+		}
+		s.idx.add(ie)
+	}
+	if err := s.idx.applySparseCheckout(os.Stderr); err != nil {
+		return err
+	}
+	return s.idx.write()
+}
+
+// overwriteError describes the paths a checkout or, when op is "merge",
+// a merge refused to touch, in git's words.
+func overwriteError(op string, changed, untracked []string) error {
+	before := "switch branches"
+	if op == "merge" {
+		before = "merge"
+	}
+	var b strings.Builder
+	if len(changed) > 0 {
+		fmt.Fprintf(&b, "Your local changes to the following files would be overwritten by %s:\n", op)
+		for _, path := range changed {
+			fmt.Fprintf(&b, "\t%s\n", path)
+		}
+		fmt.Fprintf(&b, "Please commit your changes or stash them before you %s.\n", before)
+	}
+	if len(untracked) > 0 {
+		fmt.Fprintf(&b, "The following untracked working tree files would be overwritten by %s:\n", op)
+		for _, path := range untracked {
+			fmt.Fprintf(&b, "\t%s\n", path)
+		}
+		fmt.Fprintf(&b, "Please move or remove them before you %s.\n", before)
+	}
+	b.WriteString("Aborting")
+	return errors.New(b.String())
+}
+
+// checkout implements `mygit checkout [-f | --force] <branch | commit>`.
+// It refuses to overwrite local changes unless force is set.
+func checkout(w io.Writer, name string, force bool) error {
+	t, err := resolveCheckoutTarget(name)
+	if err != nil {
+		return err
+	}
+	return switchTo(w, t, force)
+}
+
+// switchOptions are the flags of `mygit switch`.
+type switchOptions struct {
+	create string // -c: a branch to create at the target and switch to
+	detach bool
+	force  bool
+}
+
+// switchBranch implements `mygit switch`. Unlike checkout it only moves
+// to branches: a commit is refused unless detach is set, and -c creates
+// the branch before switching to it. As with merge, the error carries
+// git's "fatal:" or, for local changes in the way, "error:" prefix.
+func switchBranch(w io.Writer, name string, opts switchOptions) error {
+	t, err := switchTarget(name, opts)
+	if err != nil {
+		return fmt.Errorf("fatal: %w", err)
+	}
+	if err := switchTo(w, t, opts.force); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
+// switchTarget resolves what switchBranch is asked to switch to.
+func switchTarget(name string, opts switchOptions) (*checkoutTarget, error) {
+	if opts.create != "" || opts.detach {
+		start := name
+		if start == "" {
+			start = "HEAD"
+		}
+		hash, err := resolveObjectName(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference: %s", start)
+		}
+		if hash, err = peelToCommit(hash); err != nil {
+			return nil, fmt.Errorf("reference is not a tree: %s", start)
+		}
+		if opts.detach {
+			return &checkoutTarget{name: start, commit: hash}, nil
+		}
+		ref, err := checkBranchName(opts.create)
+		if err != nil {
+			return nil, err
+		}
+		if hasRef(ref) {
+			return nil, fmt.Errorf("a branch named '%s' already exists", opts.create)
+		}
+		return &checkoutTarget{
+			name:   opts.create,
+			branch: ref,
+			commit: hash,
+			create: "branch: Created from " + start,
+		}, nil
+	}
+
+	if !hasRef("refs/heads/" + name) {
+		if _, err := resolveObjectName(name); err == nil {
+			return nil, fmt.Errorf("a branch is expected, got commit '%s'\n"+
+				"hint: If you want to detach HEAD at the commit, try again with the --detach option.", name)
+		}
+		return nil, fmt.Errorf("invalid reference: %s", name)
+	}
+	return resolveCheckoutTarget(name)
+}
+
+// switchTo checks out the files of t and moves HEAD to it, creating
+// t's branch first if asked to.
+func switchTo(w io.Writer, t *checkoutTarget, force bool) error {
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	to, err := commitFiles(t.commit)
+	if err != nil {
+		return err
+	}
+	if !force {
+		if changed, untracked := s.checkoutConflicts(to); len(changed)+len(untracked) > 0 {
+			return overwriteError("checkout", changed, untracked)
+		}
+	}
+	if err := s.checkoutFiles(to, force); err != nil {
+		return err
+	}
+	if t.create != "" {
+		if err := updateRef(t.branch, t.commit, t.create); err != nil {
+			return err
+		}
+	}
+	return moveHead(w, s.head, t)
+}
+
+// moveHead points HEAD at the target after its files are checked out,
+// recording the move in HEAD's reflog and reporting it as git does.
+func moveHead(w io.Writer, old string, t *checkoutTarget) error {
+	ref, err := headRef()
+	if err != nil {
+		return err
+	}
+	from := strings.TrimPrefix(ref, "refs/heads/")
+	if ref == "" {
+		from = old
+	}
+	message := fmt.Sprintf("checkout: moving from %s to %s", from, t.name)
+
+	if t.branch == "" {
+		if err := updateRef("HEAD", t.commit, message); err != nil {
+			return err
+		}
+		c, err := readCommit(t.commit)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "HEAD is now at %s %s\n", t.commit[:7], c.Subject())
+		return nil
+	}
+
+	branch := strings.TrimPrefix(t.branch, "refs/heads/")
+	if ref == t.branch {
+		fmt.Fprintf(w, "Already on '%s'\n", branch)
+		return nil
+	}
+	if ref == "" && old != "" && old != t.commit {
+		if c, err := readCommit(old); err == nil {
+			fmt.Fprintf(w, "Previous HEAD position was %s %s\n", old[:7], c.Subject())
+		}
+	}
+	if err := setHeadRef(t.branch); err != nil {
+		return err
+	}
+	if err := appendReflog("HEAD", old, t.commit, message); err != nil {
+		return err
+	}
+	if t.create != "" {
+		fmt.Fprintf(w, "Switched to a new branch '%s'\n", branch)
+	} else {
+		fmt.Fprintf(w, "Switched to branch '%s'\n", branch)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckoutKeepsLocalChanges checks that checkout refuses, with git's
+// message, to overwrite a modified file or an untracked one the target
+// branch has, carries a change to a file both branches share across as
+// git does, and that -f throws the local changes away.
+func TestCheckoutKeepsLocalChanges(t *testing.T) {
+	r := mergeRepo(t, false)
+	r.write("a", "local\n", 0o644)
+	r.sameFailure("checkout", "topic")
+	r.git("checkout", "a")
+	r.write("new.txt", "local\n", 0o644)
+	r.sameFailure("checkout", "topic")
+	if err := os.Remove(filepath.Join(r.dir, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.write("b", "local\n", 0o644)
+	r.mygit("checkout", "topic")
+	if got := r.git("status", "--porcelain", "-b"); got != "## topic\n M b\n" {
+		t.Errorf("status after checkout = %q, want b still modified", got)
+	}
+
+	r.write("a", "local\n", 0o644)
+	r.mygit("checkout", "-f", "main")
+	if got := r.git("status", "--porcelain", "-b"); got != "## main\n" {
+		t.Errorf("status after checkout -f = %q, want clean", got)
+	}
+}
+
+// TestSwitch compares switch with git: to a branch, to a new one with
+// -c, detached with --detach, and its refusals of a commit without
+// --detach, of a branch that exists, of -c with --detach and of a local
+// change in the way. The messages, HEAD and its reflog must match.
+func TestSwitch(t *testing.T) {
+	r := mergeRepo(t, true)
+	state := func() string {
+		return r.git("rev-parse", "--symbolic-full-name", "HEAD") + r.git("rev-parse", "HEAD") + r.git("status", "--porcelain") +
+			r.git("for-each-ref") + r.git("reflog", "-1", "--format=%gs", "HEAD")
+	}
+	// Going back leaves HEAD's reflog alone, so that only the switch
+	// under test shows in it.
+	back := func() {
+		r.git("symbolic-ref", "HEAD", "refs/heads/main")
+		r.git("read-tree", "-u", "--reset", "main")
+		r.stderrOf("git", "branch", "-q", "-D", "new")
+	}
+	for _, args := range [][]string{
+		{"switch", "topic"},
+		{"switch", "-c", "new", "topic"},
+		{"switch", "--create", "new"},
+		{"switch", "--detach", "topic"},
+		{"switch", "-d"},
+		{"switch", "topic~0"},
+		{"switch", "-c", "topic"},
+		{"switch", "-c", "new", "--detach"},
+		{"switch", "missing"},
+	} {
+		r.sameRun(back, state, args...)
+		back()
+	}
+	r.write("a", "local\n", 0o644)
+	r.sameFailure("switch", "topic")
+	r.sameFailure("checkout", "-b", "new", "topic")
+}
+
+// TestCheckoutIndex compares checkout-index with git's by the files it
+// leaves: everything with -a, named paths, into another directory with
+// --prefix, over files already there with and without -f, each with its
+// executable bit or as a symlink, and a path the index does not have.
+func TestCheckoutIndex(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	for _, name := range []string{"README", "run.sh", "link", "src/main.go"} {
+		if err := os.Remove(filepath.Join(r.dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.write("data.bin", "local\n", 0o644)
+
+	state := func() string {
+		var b strings.Builder
+		filepath.Walk(r.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			rel, _ := filepath.Rel(r.dir, path)
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				target, _ := os.Readlink(path)
+				fmt.Fprintf(&b, "%s -> %s\n", rel, target)
+			case !info.IsDir():
+				data, _ := os.ReadFile(path)
+				fmt.Fprintf(&b, "%s %v %q\n", rel, info.Mode(), data)
+			}
+			return nil
+		})
+		return b.String()
+	}
+	undo := r.snapshot()
+	for _, args := range [][]string{
+		{"checkout-index", "-a"},
+		{"checkout-index", "-a", "-f"},
+		{"checkout-index", "README", "link", "src/main.go"},
+		{"checkout-index", "data.bin"},
+		{"checkout-index", "-f", "data.bin", "run.sh"},
+		{"checkout-index", "--prefix=out/", "-a"},
+		{"checkout-index", "--prefix=copy-", "README", "run.sh"},
+		{"checkout-index", "missing"},
+	} {
+		r.sameRun(undo, state, args...)
+		undo()
+	}
+}
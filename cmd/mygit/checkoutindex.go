@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkoutIndexOptions are the flags of `mygit checkout-index`.
+type checkoutIndexOptions struct {
+	all    bool   // -a: every entry rather than the named paths
+	force  bool   // -f: overwrite files that are already there
+	prefix string // --prefix=<string>: put before each path written
+}
+
+// checkoutIndex implements `mygit checkout-index [-a] [-f]
+// [--prefix=<string>] [<path>...]`: the index entries for the paths,
+// or with all every entry, are written to the working tree with their
+// modes, symlinks as symlinks. A file already there is left alone and
+// reported unless force is set, or unless it already holds what its
+// entry stages and there is no prefix. As in git the prefix is put
+// before each path as it is, so "out/" writes into a directory and
+// "out-" beside the files. Conflicted and sparse entries are not
+// written. Problems with paths are reported to w as git words them and
+// the rest still written; it reports whether there were none.
+func checkoutIndex(w io.Writer, paths []string, opts checkoutIndexOptions) (bool, error) {
+	if opts.all && len(paths) > 0 {
+		return false, errors.New("git checkout-index: don't mix '--all' and explicit filenames")
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return false, err
+	}
+	ok := true
+	checkout := func(e *indexEntry) error {
+		target := filepath.FromSlash(opts.prefix + e.path)
+		if _, err := os.Lstat(target); err == nil && !opts.force {
+			if opts.prefix == "" {
+				if same, err := idx.worktreeUpToDate(e); err != nil || same {
+					return err
+				}
+			}
+			fmt.Fprintf(w, "%s already exists, no checkout\n", opts.prefix+e.path)
+			ok = false
+			return nil
+		}
+		if e.mode == 0o160000 {
+			// Submodules are not cloned; leave an empty directory like git.
+			return os.MkdirAll(target, 0o755)
+		}
+		return writeWorktreeFile(target, TreeEntry{Mode: formatMode(e.mode), Hash: e.hash})
+	}
+
+	if opts.all {
+		for _, e := range idx.entries {
+			if e.stage() == 0 && !e.skipWorktree() {
+				if err := checkout(e); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+	for _, path := range paths {
+		e := idx.entry(path)
+		switch {
+		case e == nil:
+			fmt.Fprintf(w, "git checkout-index: %s is not in the cache\n", path)
+		case e.stage() != 0:
+			fmt.Fprintf(w, "git checkout-index: %s is unmerged\n", path)
+		case e.skipWorktree():
+			fmt.Fprintf(w, "git checkout-index: %s has skip-worktree enabled; use '--ignore-skip-worktree-bits' to checkout\n", path)
+		default:
+			if err := checkout(e); err != nil {
+				return false, err
+			}
+			continue
+		}
+		ok = false
+	}
+	return ok, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// initOptions selects the layout of a new repository.
+type initOptions struct {
+	dir    string // where to create it; "" is the current directory
+	bare   bool   // put the repository in dir itself rather than dir/.git
+	branch string // initial branch; "" means main
+}
+
+// initRepo creates an empty repository, or fills in whatever is missing
+// from an existing one. An existing config or HEAD is left alone.
+func initRepo(opts initOptions) error {
+	branch := opts.branch
+	if branch == "" {
+		branch = "main"
+	}
+	if _, err := checkBranchName(branch); err != nil {
+		return fmt.Errorf("invalid initial branch name: '%s'", branch)
+	}
+	gitDir := filepath.Join(opts.dir, ".git")
+	if opts.bare {
+		gitDir = filepath.Clean(opts.dir)
+	}
+	for _, dir := range []string{gitDir, filepath.Join(gitDir, "objects"), filepath.Join(gitDir, "refs")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Error creating directory: %s", err)
+		}
+	}
+	headPath := filepath.Join(gitDir, "HEAD")
+	if _, err := os.Stat(headPath); errors.Is(err, os.ErrNotExist) {
+		headFileContents := []byte("ref: refs/heads/" + branch + "\n")
+		if err := os.WriteFile(headPath, headFileContents, 0o644); err != nil {
+			return fmt.Errorf("Error writing file: %s", err)
+		}
+	}
+
+	cfgPath := filepath.Join(gitDir, "config")
+	if _, err := os.Stat(cfgPath); err == nil {
+		return nil
+	}
+	cfg := &config{}
+	cfg.set("core", "", "repositoryformatversion", "0")
+	cfg.set("core", "", "filemode", "true")
+	cfg.set("core", "", "bare", strconv.FormatBool(opts.bare))
+	if !opts.bare {
+		cfg.set("core", "", "logallrefupdates", "true")
+	}
+	return cfg.writeFile(cfgPath)
+}
+
+// clone implements `mygit clone [--depth <n>] [--filter=<spec>] [--quiet]
+// <url> [<dir>]`. The remote's branches become remote-tracking branches
+// and, unless the clone is shallow, its tags are copied as they are into
+// packed-refs, each annotated one with its peeled line. The url may be a file:// URL or a path of a repository
+// on this machine, which is fetched from by reading its files; a path is
+// recorded as the remote's URL made absolute. As in git, a path clone
+// ignores --depth and --filter, and a file:// one --filter. A clone that
+// fails leaves nothing behind: the directory is removed if it made it,
+// and emptied again if it was there.
+func clone(ctx *cmdContext, url, dir string, opts fetchOptions) (err error) {
+	url = strings.TrimSuffix(url, "/")
+	if dir == "" {
+		dir = strings.TrimSuffix(path.Base(url), ".git")
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", dir)
+	}
+	localPath, fileURL, local := localRemotePath(url)
+	if local {
+		if _, err := openLocalRemote(url); err != nil {
+			return err
+		}
+		if !fileURL {
+			abs, err := filepath.Abs(localPath)
+			if err != nil {
+				return err
+			}
+			url = abs
+		}
+	}
+	if !opts.quiet {
+		fmt.Fprintf(os.Stderr, "Cloning into '%s'...\n", dir)
+	}
+	if local && !fileURL && opts.depth > 0 {
+		fmt.Fprintln(os.Stderr, "warning: --depth is ignored in local clones; use file:// instead.")
+		opts.depth = 0
+	}
+	if local && opts.filter != "" {
+		if fileURL {
+			fmt.Fprintln(os.Stderr, "warning: filtering not recognized by server, ignoring")
+		} else {
+			fmt.Fprintln(os.Stderr, "warning: --filter is ignored in local clones; use file:// instead.")
+		}
+		opts.filter = ""
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	_, statErr := os.Stat(abs)
+	existed := statErr == nil
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			removeFailedClone(abs, existed)
+		}
+	}()
+	if err := enterWorktree(abs); err != nil {
+		return err
+	}
+	if err := initRepo(initOptions{}); err != nil {
+		return err
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.set("remote", "origin", "url", url)
+	cfg.set("remote", "origin", "fetch", defaultFetchRefspec("origin"))
+	if opts.filter != "" {
+		cfg.set("core", "", "repositoryformatversion", "1")
+		cfg.set("extensions", "", "partialclone", "origin")
+		cfg.set("remote", "origin", "promisor", "true")
+		cfg.set("remote", "origin", "partialclonefilter", opts.filter)
+	}
+	if err := cfg.write(); err != nil {
+		return err
+	}
+
+	spec, _ := parseRefspec(defaultFetchRefspec("origin"), "origin")
+	specs := []refspec{spec}
+	if opts.depth == 0 {
+		// As in git, a shallow clone takes no tags.
+		specs = append(specs, refspec{src: "refs/tags/*", dst: "refs/tags/*"})
+	}
+	opts.clone = true
+	adv, updates, err := fetchObjects(ctx, url, specs, opts)
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 {
+		fmt.Fprintln(os.Stderr, "warning: You appear to have cloned an empty repository.")
+	}
+	if local && !fileURL && !opts.quiet {
+		fmt.Fprintln(os.Stderr, "done.")
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	message := "clone: from " + url
+	tags := false
+	for _, u := range updates {
+		if strings.HasPrefix(u.local, "refs/tags/") {
+			// Tags have no reflog, and go to packed-refs below.
+			if err := writeLooseRef(u.local, u.hash); err != nil {
+				return err
+			}
+			tags = true
+		} else if err := updateRef(u.local, u.hash, message); err != nil {
+			return err
+		}
+	}
+	if tags {
+		if err := packRefs(false, false); err != nil {
+			return err
+		}
+	}
+
+	branch := remoteHeadBranch(adv)
+	if branch == "" {
+		return errors.New("remote HEAD does not point at a branch")
+	}
+	head := adv.refs["refs/heads/"+branch]
+	if err := updateRef("refs/heads/"+branch, head, message); err != nil {
+		return err
+	}
+	if err := os.WriteFile(gitPath("HEAD"), []byte("ref: refs/heads/"+branch+"\n"), 0o644); err != nil {
+		return err
+	}
+	if err := appendReflog("HEAD", "", head, message); err != nil {
+		return err
+	}
+	if cfg, err = readConfig(); err != nil {
+		return err
+	}
+	cfg.set("branch", branch, "remote", "origin")
+	cfg.set("branch", branch, "merge", "refs/heads/"+branch)
+	if err := cfg.write(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(gitPath("refs/remotes/origin/HEAD"), []byte("ref: refs/remotes/origin/"+branch+"\n"), 0o644); err != nil {
+		return err
+	}
+	if err := appendReflog("refs/remotes/origin/HEAD", "", head, message); err != nil {
+		return err
+	}
+
+	c, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+	if opts.filter != "" {
+		if err := fetchMissingBlobs(c.Tree); err != nil {
+			return err
+		}
+	}
+	files, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	if err := updateWorktree(map[string]TreeEntry{}, files); err != nil {
+		return err
+	}
+	idx, err := indexFromTree(c.Tree, &index{})
+	if err != nil {
+		return err
+	}
+	return idx.write()
+}
+
+// removeFailedClone undoes a clone into dir that failed: dir is removed
+// unless it existed before, in which case it is emptied, as it was.
+func removeFailedClone(dir string, existed bool) {
+	// Step out of the directory first; it is the working directory now.
+	os.Chdir(filepath.Dir(dir))
+	if !existed {
+		os.RemoveAll(dir)
+		return
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		os.RemoveAll(filepath.Join(dir, e.Name()))
+	}
+}
+
+// remoteHeadBranch returns the branch the remote HEAD points at, using
+// the symref capability when present and matching hashes otherwise.
+func remoteHeadBranch(adv *remoteRefs) string {
+	if target, ok := strings.CutPrefix(adv.caps["symref"], "HEAD:refs/heads/"); ok {
+		return target
+	}
+	head, ok := adv.refs["HEAD"]
+	if !ok {
+		return ""
+	}
+	for _, name := range adv.names {
+		if branch, ok := strings.CutPrefix(name, "refs/heads/"); ok && adv.refs[name] == head {
+			return branch
+		}
+	}
+	return ""
+}
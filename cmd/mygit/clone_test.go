@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFailedCloneCleansUp clones from a server that has no repository
+// and from a path that does not exist, and checks that neither leaves a
+// directory behind, and that a directory that was already there empty
+// is left empty.
+func TestFailedCloneCleansUp(t *testing.T) {
+	r := newGoldenRepo(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	for _, url := range []string{srv.URL + "/missing.git", filepath.Join(r.dir, "missing")} {
+		r.mygitFails("clone", url, "target")
+		if _, err := os.Stat(filepath.Join(r.dir, "target")); !os.IsNotExist(err) {
+			t.Errorf("clone %s left target behind: %v", url, err)
+		}
+	}
+
+	empty := filepath.Join(r.dir, "empty")
+	if err := os.Mkdir(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.mygitFails("clone", srv.URL+"/missing.git", "empty")
+	if entries, err := os.ReadDir(empty); err != nil || len(entries) > 0 {
+		t.Errorf("clone into an empty directory left %v, %v", entries, err)
+	}
+}
+
+// TestCloneTags clones a repository with a lightweight tag, annotated
+// ones, a tag of a tag and a tag on a commit no branch has, from a path,
+// a file:// URL and over HTTP, and checks that each clone holds the tags
+// git's does, packed with their peeled lines, and that a shallow clone,
+// as in git, has none.
+func TestCloneTags(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("README", "hello\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("tag", "light")
+	r.git("tag", "-a", "-m", "release", "v1")
+	r.git("tag", "-a", "-m", "tag of a tag", "outer", "v1")
+	r.git("commit", "-q", "--allow-empty", "-m", "second")
+	r.git("tag", "-a", "-m", "second release", "v2")
+	orphan := strings.TrimSpace(r.git("commit-tree", "-m", "orphan", "HEAD^{tree}"))
+	r.git("tag", "-a", "-m", "off every branch", "orphan", orphan)
+
+	tags := func(dir string) string {
+		data, err := os.ReadFile(filepath.Join(dir, ".git", "packed-refs"))
+		if err != nil {
+			return err.Error()
+		}
+		var b strings.Builder
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			if strings.Contains(line, " refs/tags/") || strings.HasPrefix(line, "^") {
+				b.WriteString(line)
+			}
+		}
+		return b.String() + r.git("-C", dir, "for-each-ref", "refs/tags")
+	}
+	git := t.TempDir()
+	r.git("clone", "-q", r.dir, git)
+	want := tags(git)
+	if !strings.Contains(want, "refs/tags/orphan") {
+		t.Fatalf("git's clone has no orphan tag:\n%s", want)
+	}
+
+	srv := httptest.NewServer(&cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(r.git("--exec-path")), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(r.dir), "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	defer srv.Close()
+	for _, url := range []string{r.dir, "file://" + r.dir, srv.URL + "/" + filepath.Base(r.dir) + "/.git"} {
+		dir := t.TempDir()
+		r.mygit("clone", "-q", url, dir)
+		if got := tags(dir); got != want {
+			t.Errorf("clone %s tags:\ngit:   %q\nmygit: %q", url, want, got)
+		}
+		r.git("-C", dir, "fsck", "--no-dangling")
+	}
+
+	dir := t.TempDir()
+	r.mygit("clone", "-q", "--depth", "1", "file://"+r.dir, dir)
+	if got := r.git("-C", dir, "for-each-ref", "refs/tags"); got != "" {
+		t.Errorf("shallow clone has tags:\n%s", got)
+	}
+}
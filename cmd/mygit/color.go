@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ANSI escapes for the colors git uses by default.
+const (
+	colorReset  = "\x1b[m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorRedBg  = "\x1b[41m"
+)
+
+// parseColorWhen checks the value of --color, where a bare --color
+// means "always".
+func parseColorWhen(value string) (string, error) {
+	switch value {
+	case "always", "never", "auto":
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid --color value %q", value)
+}
+
+// colorFlag reads arg as one of --color, --color=<when> and --no-color
+// into when, reporting whether it was one.
+func colorFlag(arg string, when *string) (bool, error) {
+	switch {
+	case arg == "--color":
+		*when = "always"
+	case arg == "--no-color":
+		*when = "never"
+	case strings.HasPrefix(arg, "--color="):
+		w, err := parseColorWhen(strings.TrimPrefix(arg, "--color="))
+		if err != nil {
+			return true, err
+		}
+		*when = w
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// useColor decides whether output to w is colored for --color=<when>.
+// "auto", the default, colors only a terminal, or a pager showing on
+// one, and honors NO_COLOR.
+func useColor(when string, w io.Writer) bool {
+	switch when {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if _, ok := w.(*pager); ok {
+		return true
+	}
+	return isTerminal(w)
+}
+
+// isTerminal reports whether w is a file open on a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminalFile(f)
+}
+
+// paint wraps s in color when on is set.
+func paint(on bool, color, s string) string {
+	if !on {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is an author, committer or tagger line.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// Commit is a parsed commit object.
+type Commit struct {
+	Hash      string
+	Tree      string
+	Parents   []string
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+// parseSignature parses "Name <email> <epoch> <tz>".
+func parseSignature(s string) (Signature, error) {
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 0 || gt < lt {
+		return Signature{}, fmt.Errorf("malformed signature %q", s)
+	}
+	sig := Signature{
+		Name:  strings.TrimSpace(s[:lt]),
+		Email: s[lt+1 : gt],
+	}
+	fields := strings.Fields(s[gt+1:])
+	if len(fields) != 2 {
+		return Signature{}, fmt.Errorf("malformed signature %q", s)
+	}
+	epoch, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed signature time %q", fields[0])
+	}
+	zone, ok := parseTimezone(fields[1])
+	if !ok {
+		return Signature{}, fmt.Errorf("malformed signature timezone %q", fields[1])
+	}
+	sig.When = time.Unix(epoch, 0).In(zone)
+	return sig, nil
+}
+
+// parseTimezone parses a "+hhmm" or "-hhmm" offset as stored in objects.
+func parseTimezone(tz string) (*time.Location, bool) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return nil, false
+	}
+	hours, err1 := strconv.Atoi(tz[1:3])
+	minutes, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(tz, offset), true
+}
+
+// String formats the signature the way it is stored in objects.
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+// parseCommit parses the body of a commit object.
+func parseCommit(hash string, body []byte) (*Commit, error) {
+	c := &Commit{Hash: hash}
+	text := string(body)
+	headers, message, _ := strings.Cut(text, "\n\n")
+	c.Message = message
+	for _, line := range strings.Split(headers, "\n") {
+		if strings.HasPrefix(line, " ") {
+			continue // continuation of a multi-line header such as gpgsig
+		}
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "tree":
+			c.Tree = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			c.Author, err = parseSignature(value)
+		case "committer":
+			c.Committer, err = parseSignature(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", hash, err)
+		}
+	}
+	if c.Tree == "" {
+		return nil, fmt.Errorf("commit %s: missing tree", hash)
+	}
+	return c, nil
+}
+
+// readCommit reads and parses the commit named by hash.
+func readCommit(hash string) (*Commit, error) {
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != CommitObject {
+		return nil, fmt.Errorf("%s is a %s, not a commit", hash, objType)
+	}
+	c, err := parseCommit(hash, body)
+	if err != nil {
+		return nil, err
+	}
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	if shallow[hash] {
+		// History is cut off here; the parents were never fetched.
+		c.Parents = nil
+	}
+	return c, nil
+}
+
+// isAncestor reports whether ancestor is reachable from descendant.
+// Generation numbers from the commit-graph cut the search short: no
+// commit whose generation is not above ancestor's can lead to it.
+func (ctx *cmdContext) isAncestor(ancestor, descendant string) (bool, error) {
+	floor, err := ctx.generation(ancestor)
+	if err != nil {
+		return false, err
+	}
+	seen := map[string]bool{descendant: true}
+	stack := []string{descendant}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if hash == ancestor {
+			return true, nil
+		}
+		if floor != 0 {
+			gen, err := ctx.generation(hash)
+			if err != nil {
+				return false, err
+			}
+			if gen != 0 && gen <= floor {
+				continue
+			}
+		}
+		c, err := ctx.getCommitNode(hash)
+		if err != nil {
+			return false, err
+		}
+		for _, parent := range c.Parents {
+			if !seen[parent] {
+				seen[parent] = true
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return false, nil
+}
+
+// Subject returns the first paragraph of the message joined onto one
+// line, as git's %s does.
+func (c *Commit) Subject() string {
+	para, _, _ := strings.Cut(strings.TrimLeft(c.Message, "\n"), "\n\n")
+	return strings.Join(strings.Fields(strings.ReplaceAll(para, "\n", " ")), " ")
+}
+
+// gitDateLayouts are the RFC 2822 and ISO 8601 forms parseGitDate
+// accepts. Those without a zone are local time.
+var gitDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// parseGitDate parses a GIT_AUTHOR_DATE or GIT_COMMITTER_DATE value:
+// git's internal "<epoch> <tz>" form, optionally with a leading "@" and
+// with the zone optional after one, or an RFC 2822 or ISO 8601 date.
+func parseGitDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	epochText, tz, hasZone := strings.Cut(strings.TrimPrefix(value, "@"), " ")
+	if epoch, err := strconv.ParseInt(epochText, 10, 64); err == nil && (hasZone || value[0] == '@') {
+		zone := time.UTC
+		if hasZone {
+			var ok bool
+			if zone, ok = parseTimezone(tz); !ok {
+				return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+			}
+		}
+		return time.Unix(epoch, 0).In(zone), nil
+	}
+	for _, layout := range gitDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date format: %s", value)
+}
+
+// currentSignature builds the author or committer identity for a new
+// commit from the GIT_<ROLE>_NAME/EMAIL/DATE variables, falling back to
+// user.name/email and the current time. role is "AUTHOR" or
+// "COMMITTER".
+func currentSignature(role string) (Signature, error) {
+	cfg, err := readUserConfig()
+	if err != nil {
+		return Signature{}, err
+	}
+	sig := Signature{
+		Name:  os.Getenv("GIT_" + role + "_NAME"),
+		Email: os.Getenv("GIT_" + role + "_EMAIL"),
+		When:  time.Now(),
+	}
+	if date := os.Getenv("GIT_" + role + "_DATE"); date != "" {
+		if sig.When, err = parseGitDate(date); err != nil {
+			return Signature{}, err
+		}
+	}
+	if sig.Name == "" {
+		sig.Name, _ = cfg.get("user", "", "name")
+	}
+	if sig.Email == "" {
+		sig.Email, _ = cfg.get("user", "", "email")
+	}
+	if sig.Name == "" || sig.Email == "" {
+		return Signature{}, fmt.Errorf("unable to auto-detect %s identity; set user.name and user.email", strings.ToLower(role))
+	}
+	return sig, nil
+}
+
+// writeCommit creates a commit object with the current identity, signed
+// unless sign is nil, and returns its name.
+func writeCommit(tree string, parents []string, message string, sign *signer) (string, error) {
+	author, err := currentSignature("AUTHOR")
+	if err != nil {
+		return "", err
+	}
+	committer, err := currentSignature("COMMITTER")
+	if err != nil {
+		return "", err
+	}
+	return writeCommitObject(&Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+	}, sign)
+}
+
+// writePickedCommit is writeCommit with the author of the commit picked.
+func writePickedCommit(tree string, parents []string, message, picked string, sign *signer) (string, error) {
+	c, err := readCommit(picked)
+	if err != nil {
+		return "", err
+	}
+	committer, err := currentSignature("COMMITTER")
+	if err != nil {
+		return "", err
+	}
+	return writeCommitObject(&Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    c.Author,
+		Committer: committer,
+		Message:   message,
+	}, sign)
+}
+
+// commitTree implements `mygit commit-tree <tree> [-p <parent>]... [-S]`,
+// writing a commit of tree with the given parents and message and
+// returning its name. Unlike commit it touches no refs.
+func commitTree(treeish string, parentRevs []string, message string, sign *signer) (string, error) {
+	hash, err := resolveObjectName(treeish)
+	if err != nil {
+		return "", fmt.Errorf("not a valid object name %s", treeish)
+	}
+	tree, _, err := peelToTree(hash)
+	if err != nil {
+		return "", err
+	}
+	var parents []string
+	for _, rev := range parentRevs {
+		hash, err := resolveObjectName(rev)
+		if err != nil {
+			return "", fmt.Errorf("not a valid object name %s", rev)
+		}
+		commit, err := peelToCommit(hash)
+		if err != nil {
+			return "", err
+		}
+		parents = append(parents, commit)
+	}
+	return writeCommit(tree, parents, message, sign)
+}
+
+// writeCommitObject serializes c and stores it, signed by sign unless it
+// is nil.
+func writeCommitObject(c *Commit, sign *signer) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	fmt.Fprintf(&b, "author %s\n", c.Author)
+	fmt.Fprintf(&b, "committer %s\n", c.Committer)
+	b.WriteString("\n" + c.Message)
+	body := []byte(b.String())
+	if sign != nil {
+		var err error
+		if body, err = sign.signCommitBody(body); err != nil {
+			return "", err
+		}
+	}
+	return writeObject(CommitObject, body)
+}
+
+// mergeBase returns the best common ancestor of a and b, or "" if their
+// histories are unrelated. Walking b's history newest first, the first
+// commit also reachable from a is not an ancestor of any other common
+// ancestor, barring clock skew.
+func (ctx *cmdContext) mergeBase(a, b string) (string, error) {
+	reachable := map[string]bool{}
+	err := ctx.walkTopology([]string{a}, func(c *Commit) error {
+		reachable[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	base := ""
+	err = ctx.walkTopology([]string{b}, func(c *Commit) error {
+		if reachable[c.Hash] {
+			base = c.Hash
+			return errStopWalk
+		}
+		return nil
+	})
+	return base, err
+}
+
+// cleanupMessage tidies a commit message as git's default cleanup mode
+// does: trailing whitespace and leading and trailing blank lines are
+// removed, runs of blank lines are collapsed, and the message ends in a
+// newline. An empty result means there was no message.
+func cleanupMessage(message string) string {
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// errCommitUnmerged refuses a commit while the index holds conflicts.
+var errCommitUnmerged = unmergedError("Committing")
+
+// commitOptions are the flags of `mygit commit`.
+type commitOptions struct {
+	allowEmpty bool
+	amend      bool    // replace HEAD instead of building on it
+	noVerify   bool    // skip the pre-commit and commit-msg hooks
+	sign       *signer // -S: sign the commit
+}
+
+// commitIndex implements `mygit commit -m <msg>`: the index is written
+// as a tree and committed on top of HEAD. HEAD's branch is advanced, or
+// HEAD itself when it is detached. With amend the new commit takes
+// HEAD's place instead, keeping its parents and author. During a merge
+// the commit concludes it, taking the merged commits as further parents
+// and MERGE_MSG as the message when none is given; the index must have
+// no conflicts left. So does a stopped cherry-pick or revert, a
+// cherry-pick keeping the picked commit's author. The pre-commit hook may refuse the commit, and the
+// commit-msg hook may edit or refuse the message, which it is given in
+// COMMIT_EDITMSG.
+func commitIndex(w io.Writer, message string, opts commitOptions) error {
+	if !opts.noVerify {
+		if err := runHook("pre-commit"); err != nil {
+			return err
+		}
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if unmerged := idx.unmerged(); len(unmerged) > 0 {
+		for _, path := range unmerged {
+			fmt.Fprintf(w, "U\t%s\n", path)
+		}
+		return errCommitUnmerged
+	}
+	merging, err := mergeHeads()
+	if err != nil {
+		return err
+	}
+	if merging != nil && opts.amend {
+		return errors.New("You are in the middle of a merge -- cannot amend.")
+	}
+	picking, picked, err := pickInProgress()
+	if err != nil {
+		return err
+	}
+	if (merging != nil || picking != "") && message == "" {
+		data, err := os.ReadFile(gitPath(mergeMsgFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// As from git's editor, the message loses its comment lines.
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		message = strings.Join(lines, "\n")
+	}
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	msgPath := gitPath("COMMIT_EDITMSG")
+	if err := os.WriteFile(msgPath, []byte(message), 0o644); err != nil {
+		return err
+	}
+	if !opts.noVerify {
+		if err := runHook("commit-msg", msgPath); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(msgPath)
+		if err != nil {
+			return err
+		}
+		message = string(data)
+	}
+	if message = cleanupMessage(message); message == "" {
+		return errors.New("Aborting commit due to empty commit message.")
+	}
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		head, err = "", nil
+	}
+	if err != nil {
+		return err
+	}
+	tree, err := writeIndexTree(idx.entries)
+	if err != nil {
+		return err
+	}
+	if opts.amend {
+		return amendHead(w, head, tree, message, opts.allowEmpty, opts.sign)
+	}
+	var parents []string
+	if head == "" && len(idx.entries) == 0 && !opts.allowEmpty {
+		return errNothingToCommit
+	}
+	if head != "" {
+		parent, err := readCommit(head)
+		if err != nil {
+			return err
+		}
+		if parent.Tree == tree && !opts.allowEmpty && merging == nil {
+			return errNothingToCommit
+		}
+		parents = append([]string{head}, merging...)
+	}
+	var hash string
+	if picking == "cherry-pick" {
+		// A stopped cherry-pick is committed as the picked commit's author.
+		hash, err = writePickedCommit(tree, parents, message, picked, opts.sign)
+	} else {
+		hash, err = writeCommit(tree, parents, message, opts.sign)
+	}
+	if err != nil {
+		return err
+	}
+	subject := (&Commit{Message: message}).Subject()
+	action, root := "commit", ""
+	switch {
+	case head == "":
+		action, root = "commit (initial)", " (root-commit)"
+	case merging != nil:
+		action = "commit (merge)"
+	case picking == "cherry-pick":
+		action = "commit (cherry-pick)"
+	}
+	if err := updateHead(hash, action+": "+subject); err != nil {
+		return err
+	}
+	if err := clearMergeState(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "[%s%s %s] %s\n", currentBranchName(), root, hash[:7], subject)
+	return nil
+}
+
+// amendHead replaces the commit head with one of tree and message on
+// the same parents. The original author and date are kept; only the
+// committer is new. Unless allowEmpty is set, the new commit must
+// change something relative to its first parent.
+func amendHead(w io.Writer, head, tree, message string, allowEmpty bool, sign *signer) error {
+	if head == "" {
+		return errors.New("You have nothing to amend.")
+	}
+	old, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+	if len(old.Parents) > 0 && !allowEmpty {
+		parent, err := readCommit(old.Parents[0])
+		if err != nil {
+			return err
+		}
+		if parent.Tree == tree {
+			return errAmendEmpty
+		}
+	}
+	committer, err := currentSignature("COMMITTER")
+	if err != nil {
+		return err
+	}
+	hash, err := writeCommitObject(&Commit{
+		Tree:      tree,
+		Parents:   old.Parents,
+		Author:    old.Author,
+		Committer: committer,
+		Message:   message,
+	}, sign)
+	if err != nil {
+		return err
+	}
+	subject := (&Commit{Message: message}).Subject()
+	if err := updateHead(hash, "commit (amend): "+subject); err != nil {
+		return err
+	}
+	root := ""
+	if len(old.Parents) == 0 {
+		root = " (root-commit)"
+	}
+	fmt.Fprintf(w, "[%s%s %s] %s\n", currentBranchName(), root, hash[:7], subject)
+	fmt.Fprintf(w, " Date: %s\n", formatDate(old.Author.When, "default"))
+	return nil
+}
+
+var errNothingToCommit = errors.New("nothing to commit, working tree clean")
+
+var errAmendEmpty = errors.New(`You asked to amend the most recent commit, but doing so would make
+it empty. You can repeat your command with --allow-empty, or you can
+remove the commit entirely with "git reset HEAD^".`)
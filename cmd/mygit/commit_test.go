@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCommitDetachedHead commits on a detached HEAD: HEAD itself moves
+// to the new commit, the branch it was detached from stays, and status,
+// log and rev-parse follow HEAD as git does.
+func TestCommitDetachedHead(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	root := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	r.git("checkout", "-q", "--detach")
+
+	r.write("README", "detached\n", 0o644)
+	r.mygit("add", "README")
+	r.mygit("commit", "-m", "on detached HEAD")
+
+	head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	if head == root {
+		t.Fatal("HEAD did not move")
+	}
+	if data, err := os.ReadFile(filepath.Join(r.dir, ".git", "HEAD")); err != nil || string(data) != head+"\n" {
+		t.Errorf("HEAD file holds %q, %v, want the new commit", data, err)
+	}
+	if got := strings.TrimSpace(r.git("cat-file", "-p", "HEAD")); !strings.Contains(got, "parent "+root+"\n") {
+		t.Errorf("new commit does not have the old HEAD as its parent:\n%s", got)
+	}
+	if got := strings.TrimSpace(r.git("rev-parse", "main")); got != root {
+		t.Errorf("main moved to %s", got)
+	}
+	r.git("fsck", "--strict", "--no-dangling")
+	r.same("rev-parse", "HEAD")
+	r.same("log")
+	r.same("status")
+}
+
+// TestUnbornBranch runs log, status and rev-parse in a repository with
+// no commits yet, where they report it as git does, and checks that the
+// first commit creates the branch HEAD names.
+func TestUnbornBranch(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.sameFailure("log")
+	r.same("status")
+	r.sameFailure("rev-parse", "HEAD")
+	r.fill()
+	r.git("add", "-A")
+	r.same("status")
+
+	r.mygit("commit", "-m", "root")
+	want := r.git("rev-parse", "HEAD")
+	if got := r.git("rev-parse", "refs/heads/main"); got != want {
+		t.Errorf("main is %q after the first commit, HEAD %q", got, want)
+	}
+	if got := r.git("rev-list", "--parents", "HEAD"); got != want {
+		t.Errorf("first commit has parents: %q", got)
+	}
+}
+
+// TestCommitAmend amends a commit by another author and checks that the
+// new commit, which keeps the author and parents, and the reflog entry
+// are git's, and that amending with nothing to amend or into a commit
+// that changes nothing fails as in git.
+func TestCommitAmend(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.sameFailure("commit", "--amend", "-m", "nothing")
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "second\n", 0o644)
+	r.run("", "git", []string{"commit", "-q", "-am", "second"},
+		"GIT_AUTHOR_NAME=Other Author", "GIT_AUTHOR_EMAIL=other@example.com", "GIT_AUTHOR_DATE=1600000000 +0200")
+	old := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+
+	r.write("README", "amended\n", 0o644)
+	r.git("add", "README")
+	state := func() string {
+		return r.git("cat-file", "-p", "HEAD") + r.git("log", "-g", "-1", "--format=%gs") + r.git("status", "--porcelain")
+	}
+	undo := func() { r.git("reset", "-q", "--soft", old) }
+	r.sameRun(undo, state, "commit", "--amend", "-m", "amended")
+
+	r.git("checkout", "-q", "HEAD~1", "--", "README")
+	r.sameFailure("commit", "--amend", "-m", "empty")
+}
+
+// TestCommitAllowEmpty checks that, as in git, a commit whose tree is
+// its parent's is refused with the status printed, and that with
+// --allow-empty it is made, as is an amend that leaves nothing changed.
+func TestCommitAllowEmpty(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+
+	stdout := func(name string, args ...string) (string, bool) {
+		cmd := exec.Command(name, args...)
+		cmd.Dir, cmd.Env = r.dir, append(append([]string{}, r.env...), "MYGIT_TEST_MAIN=1")
+		out, err := cmd.Output()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			t.Fatal(err)
+		}
+		return string(out), err == nil
+	}
+	want, wantOK := stdout("git", "commit", "-m", "empty")
+	got, gotOK := stdout(os.Args[0], "commit", "-m", "empty")
+	if wantOK || gotOK || got != want {
+		t.Errorf("commit with nothing to commit:\ngit:   %q %v\nmygit: %q %v", want, wantOK, got, gotOK)
+	}
+
+	state := func() string { return r.git("cat-file", "-p", "HEAD") }
+	undo := func() { r.git("reset", "-q", "--soft", head) }
+	r.sameRun(undo, state, "commit", "--allow-empty", "-m", "empty")
+	head = strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	r.sameFailure("commit", "--amend", "-m", "empty amend")
+	r.sameRun(undo, state, "commit", "--amend", "--allow-empty", "-m", "empty amend")
+}
+
+// TestCommitHooks checks, against git, that a failing pre-commit hook
+// refuses the commit and --no-verify skips it, that commit-msg may edit
+// the message in the file it is given or refuse it, and that a hook that
+// is not executable is skipped with a hint.
+func TestCommitHooks(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	r.write("README", "second\n", 0o644)
+	r.git("add", "README")
+
+	state := func() string { return r.git("log", "-1", "--format=%B") + r.git("status", "--porcelain") }
+	undo := func() { r.git("reset", "-q", "--soft", head) }
+	r.write(".git/hooks/pre-commit", "#!/bin/sh\necho refused in $(basename \"$PWD\") >&2\nexit 1\n", 0o755)
+	r.sameFailure("commit", "-m", "refused")
+	r.sameRun(undo, state, "commit", "--no-verify", "-m", "not verified")
+	undo()
+
+	r.write(".git/hooks/pre-commit", "#!/bin/sh\ntest -f \"$GIT_INDEX_FILE\"\n", 0o755)
+	r.write(".git/hooks/commit-msg", "#!/bin/sh\nsed -i 's/^/[hooked] /' \"$1\"\n", 0o755)
+	r.sameRun(undo, state, "commit", "-m", "edited")
+	undo()
+	r.sameRun(undo, state, "commit", "-n", "-m", "not edited")
+	undo()
+
+	r.write(".git/hooks/commit-msg", "#!/bin/sh\necho bad message: $(cat \"$1\") >&2\nexit 1\n", 0o755)
+	r.sameFailure("commit", "-m", "refused")
+	if err := os.Chmod(filepath.Join(r.dir, ".git", "hooks", "commit-msg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.sameRun(undo, state, "commit", "-m", "hook ignored")
+}
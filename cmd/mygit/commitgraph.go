@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Chunk IDs of a commit-graph file.
+const (
+	graphChunkFanout  = 0x4f494446 // "OIDF"
+	graphChunkLookup  = 0x4f49444c // "OIDL"
+	graphChunkData    = 0x43444154 // "CDAT"
+	graphChunkExtEdge = 0x45444745 // "EDGE"
+)
+
+// graphNoParent marks a missing parent in the commit data, and
+// graphExtraEdges a second parent field that indexes the EDGE chunk
+// because the commit has more than two parents.
+const (
+	graphNoParent   = 0x70000000
+	graphExtraEdges = 0x80000000
+)
+
+// commitGraph is a parsed objects/info/commit-graph file, which lists
+// commits with their trees, parents, generation numbers and commit
+// times so history can be walked without inflating each commit. A graph
+// with no commits stands for a missing one.
+type commitGraph struct {
+	path     string
+	hashSize int
+	fanout   [256]uint32
+	oids     []byte // sorted raw names, hashSize bytes each
+	data     []byte // hashSize+16 bytes for each commit
+	edges    []byte // the third and later parents of octopus merges
+}
+
+// graphCommit is what a commit-graph records about a commit.
+type graphCommit struct {
+	tree       string
+	parents    []string
+	generation uint32 // 0 if the graph was written without them
+	time       int64  // committer time, in seconds since the epoch
+}
+
+// repoCommitGraph caches the repository's commit-graph; nil means
+// unread.
+var repoCommitGraph *commitGraph
+
+// openCommitGraph returns the repository's commit-graph, or an empty
+// one when there is none, when core.commitGraph is false, or when the
+// file cannot be used; commits are then read from the object store.
+func openCommitGraph() *commitGraph {
+	if repoCommitGraph != nil {
+		return repoCommitGraph
+	}
+	repoCommitGraph = &commitGraph{}
+	if cfg, err := readConfig(); err == nil {
+		if v, ok := cfg.get("core", "", "commitgraph"); ok {
+			switch strings.ToLower(v) {
+			case "false", "no", "off", "0":
+				return repoCommitGraph
+			}
+		}
+	}
+	g, err := readCommitGraph(filepath.Join(objectsDir(), "info", "commit-graph"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		}
+		return repoCommitGraph
+	}
+	repoCommitGraph = g
+	return g
+}
+
+// readCommitGraph parses the version 1 commit-graph file at path. Split
+// graphs, which chain onto others, are not supported.
+func readCommitGraph(path string) (*commitGraph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || !bytes.Equal(data[:4], []byte("CGPH")) {
+		return nil, fmt.Errorf("%s: not a commit-graph file", path)
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("%s: unsupported commit-graph version %d", path, data[4])
+	}
+	g := &commitGraph{path: path}
+	switch format := repoFormat(); {
+	case data[5] == 1 && format.name == "sha1", data[5] == 2 && format.name == "sha256":
+		g.hashSize = format.size
+	default:
+		return nil, fmt.Errorf("%s: commit-graph hash version %d does not match the repository", path, data[5])
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("%s: split commit-graphs are not supported", path)
+	}
+
+	// The table of contents lists each chunk's ID and offset, then an
+	// entry with ID 0 whose offset ends the last chunk.
+	chunks := map[uint32][]byte{}
+	count := int(data[6])
+	toc := data[8:]
+	if len(toc) < (count+1)*12 {
+		return nil, fmt.Errorf("%s: commit-graph truncated", path)
+	}
+	for i := 0; i < count; i++ {
+		id := binary.BigEndian.Uint32(toc[i*12:])
+		start := binary.BigEndian.Uint64(toc[i*12+4:])
+		end := binary.BigEndian.Uint64(toc[i*12+16:])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("%s: commit-graph chunk out of bounds", path)
+		}
+		chunks[id] = data[start:end]
+	}
+
+	fanout := chunks[graphChunkFanout]
+	if len(fanout) != 256*4 {
+		return nil, fmt.Errorf("%s: commit-graph fanout chunk missing or malformed", path)
+	}
+	for i := range g.fanout {
+		g.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && g.fanout[i] < g.fanout[i-1] {
+			return nil, fmt.Errorf("%s: commit-graph fanout out of order", path)
+		}
+	}
+	n := int(g.fanout[255])
+	g.oids = chunks[graphChunkLookup]
+	g.data = chunks[graphChunkData]
+	g.edges = chunks[graphChunkExtEdge]
+	if len(g.oids) != n*g.hashSize || len(g.data) != n*(g.hashSize+16) {
+		return nil, fmt.Errorf("%s: commit-graph lookup or data chunk malformed", path)
+	}
+	return g, nil
+}
+
+// position returns the index of the commit named hash in the graph.
+func (g *commitGraph) position(hash string) (int, bool) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != g.hashSize || g.hashSize == 0 {
+		return 0, false
+	}
+	lo := 0
+	if raw[0] > 0 {
+		lo = int(g.fanout[raw[0]-1])
+	}
+	hi := int(g.fanout[raw[0]])
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch c := bytes.Compare(g.oids[mid*g.hashSize:(mid+1)*g.hashSize], raw); {
+		case c == 0:
+			return mid, true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// name returns the hex name of the commit at position i.
+func (g *commitGraph) name(i uint32) (string, error) {
+	if int(i) >= len(g.oids)/max(g.hashSize, 1) {
+		return "", fmt.Errorf("%s: commit-graph parent %d out of range", g.path, i)
+	}
+	return hex.EncodeToString(g.oids[int(i)*g.hashSize : int(i+1)*g.hashSize]), nil
+}
+
+// lookup returns what the graph records about the commit named hash,
+// or nil if it does not list it.
+func (g *commitGraph) lookup(hash string) (*graphCommit, error) {
+	i, ok := g.position(hash)
+	if !ok {
+		return nil, nil
+	}
+	rec := g.data[i*(g.hashSize+16) : (i+1)*(g.hashSize+16)]
+	c := &graphCommit{tree: hex.EncodeToString(rec[:g.hashSize])}
+	rec = rec[g.hashSize:]
+	first, second := binary.BigEndian.Uint32(rec), binary.BigEndian.Uint32(rec[4:])
+	if first != graphNoParent {
+		parent, err := g.name(first)
+		if err != nil {
+			return nil, err
+		}
+		c.parents = append(c.parents, parent)
+	}
+	switch {
+	case second == graphNoParent:
+	case second&graphExtraEdges == 0:
+		parent, err := g.name(second)
+		if err != nil {
+			return nil, err
+		}
+		c.parents = append(c.parents, parent)
+	default:
+		// The EDGE chunk lists the second and later parents, the last
+		// with its top bit set.
+		for e := int(second &^ graphExtraEdges); ; e++ {
+			if (e+1)*4 > len(g.edges) {
+				return nil, fmt.Errorf("%s: commit-graph edge list out of range", g.path)
+			}
+			edge := binary.BigEndian.Uint32(g.edges[e*4:])
+			parent, err := g.name(edge &^ graphExtraEdges)
+			if err != nil {
+				return nil, err
+			}
+			c.parents = append(c.parents, parent)
+			if edge&graphExtraEdges != 0 {
+				break
+			}
+		}
+	}
+	// The generation takes the top 30 bits of the last eight bytes and
+	// the commit time the remaining 34.
+	word := binary.BigEndian.Uint32(rec[8:])
+	c.generation = word >> 2
+	c.time = int64(word&3)<<32 | int64(binary.BigEndian.Uint32(rec[12:]))
+	return c, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCommitGraph writes a commit-graph over a history with a merge and
+// a tag, then adds commits it does not list, and compares describe,
+// rev-list, log and the ahead/behind counts of status with git's. With
+// the objects of the commits below the tips removed, the walks that
+// need only parents and dates still work from the graph alone, and
+// fail without it.
+func TestCommitGraph(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("merge", "-q", "--no-edit", "topic")
+	r.git("tag", "-a", "-m", "release", "v1", "HEAD~1")
+	r.git("checkout", "-q", "-b", "feature")
+	r.git("branch", "-q", "--set-upstream-to=main")
+	for i := 0; i < 3; i++ {
+		r.git("commit", "-q", "--allow-empty", "-m", "feature")
+	}
+	r.git("commit-graph", "write", "--reachable")
+	r.git("commit", "-q", "--allow-empty", "-m", "after the graph")
+	r.git("checkout", "-q", "main")
+	r.git("commit", "-q", "--allow-empty", "-m", "main after the graph")
+	r.git("checkout", "-q", "feature")
+
+	compare := func() {
+		t.Helper()
+		for _, args := range [][]string{
+			{"describe"},
+			{"describe", "--tags"},
+			{"status"},
+			{"rev-list", "HEAD"},
+			{"rev-list", "main..feature"},
+		} {
+			r.same(args...)
+		}
+	}
+	compare()
+	r.same("log")
+
+	tips := map[string]bool{}
+	for _, rev := range []string{"main", "feature", "main~1", "feature~1", "v1^{commit}"} {
+		tips[strings.TrimSpace(r.git("rev-parse", rev))] = true
+	}
+	for _, hash := range strings.Fields(r.git("rev-list", "--all")) {
+		if !tips[hash] {
+			if err := os.Remove(filepath.Join(r.dir, ".git", "objects", hash[:2], hash[2:])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	compare()
+	if err := os.Remove(filepath.Join(r.dir, ".git", "objects", "info", "commit-graph")); err != nil {
+		t.Fatal(err)
+	}
+	r.mygitFails("rev-list", "HEAD")
+}
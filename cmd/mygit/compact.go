@@ -0,0 +1,267 @@
+package main
+
+// compactChanges moves the runs of changed lines in one side of a diff
+// the way git's xdiff does, so that diffs read as git's do. Each run is
+// merged with any run it can slide into, and is then placed to line up
+// with a change on the other side or, failing that, where the indent
+// heuristic finds the most natural boundary. Every move keeps the
+// script valid: a run slides past a line only when the line it leaves
+// behind is identical.
+func compactChanges(lines []string, changed, other []bool) {
+	g := newChangeGroup(changed)
+	o := newChangeGroup(other)
+	for {
+		if g.end != g.start {
+			var size, earliestEnd int
+			endMatchingOther := -1
+			for {
+				size = g.end - g.start
+				endMatchingOther = -1
+				for g.slideUp(lines) {
+					o.previous()
+				}
+				earliestEnd = g.end
+				if o.end > o.start {
+					endMatchingOther = g.end
+				}
+				for g.slideDown(lines) {
+					o.next()
+					if o.end > o.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// The run could not move.
+			case endMatchingOther != -1:
+				for o.end == o.start {
+					g.slideUp(lines)
+					o.previous()
+				}
+			default:
+				shift := max(earliestEnd, g.end-size-1, g.end-indentMaxSliding)
+				bestShift := -1
+				var best splitScore
+				for ; shift <= g.end; shift++ {
+					var score splitScore
+					score.add(measureSplit(lines, shift))
+					score.add(measureSplit(lines, shift-size))
+					if bestShift == -1 || score.cmp(best) <= 0 {
+						best, bestShift = score, shift
+					}
+				}
+				for g.end > bestShift {
+					g.slideUp(lines)
+					o.previous()
+				}
+			}
+		}
+		if !g.next() {
+			break
+		}
+		o.next()
+	}
+}
+
+// changeGroup is a run of changed lines, start to end, in one side of a
+// diff. The run may be empty; the runs of the two sides pair up, divided
+// by the lines the sides have in common.
+type changeGroup struct {
+	changed    []bool
+	start, end int
+}
+
+func newChangeGroup(changed []bool) *changeGroup {
+	g := &changeGroup{changed: changed}
+	for g.end < len(changed) && changed[g.end] {
+		g.end++
+	}
+	return g
+}
+
+// next moves to the following run, reporting false at the end.
+func (g *changeGroup) next() bool {
+	if g.end == len(g.changed) {
+		return false
+	}
+	g.start = g.end + 1
+	g.end = g.start
+	for g.end < len(g.changed) && g.changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+// previous moves to the run before this one.
+func (g *changeGroup) previous() {
+	if g.start == 0 {
+		return
+	}
+	g.end = g.start - 1
+	g.start = g.end
+	for g.start > 0 && g.changed[g.start-1] {
+		g.start--
+	}
+}
+
+// slideDown moves the run one line down if the line after it equals its
+// first line, absorbing any run it then touches.
+func (g *changeGroup) slideDown(lines []string) bool {
+	if g.end >= len(lines) || lines[g.start] != lines[g.end] {
+		return false
+	}
+	g.changed[g.start], g.changed[g.end] = false, true
+	g.start, g.end = g.start+1, g.end+1
+	for g.end < len(g.changed) && g.changed[g.end] {
+		g.end++
+	}
+	return true
+}
+
+// slideUp moves the run one line up if the line before it equals its
+// last line, absorbing any run it then touches.
+func (g *changeGroup) slideUp(lines []string) bool {
+	if g.start == 0 || lines[g.start-1] != lines[g.end-1] {
+		return false
+	}
+	g.start, g.end = g.start-1, g.end-1
+	g.changed[g.start], g.changed[g.end] = true, false
+	for g.start > 0 && g.changed[g.start-1] {
+		g.start--
+	}
+	return true
+}
+
+// The weights of git's indent heuristic, which prefers to start and end
+// runs of changes at blank lines and at the edges of indented blocks.
+const (
+	indentMaxSliding      = 100
+	maxIndentWidth        = 200
+	maxBlankRun           = 20
+	startOfFilePenalty    = 1
+	endOfFilePenalty      = 21
+	totalBlankWeight      = -30
+	postBlankWeight       = 6
+	relativeIndentPenalty = -4
+	relativeIndentBlank   = 10
+	relativeOutdent       = 24
+	relativeOutdentBlank  = 17
+	relativeDedent        = 23
+	relativeDedentBlank   = 17
+	indentWeight          = 60
+)
+
+// lineIndent is the width of line's leading whitespace, with tabs
+// stopping every eight columns, or -1 for a blank line.
+func lineIndent(line string) int {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			n++
+		case '\t':
+			n += 8 - n%8
+		case '\n', '\r', '\v', '\f':
+		default:
+			return n
+		}
+		if n >= maxIndentWidth {
+			return maxIndentWidth
+		}
+	}
+	return -1
+}
+
+// splitMeasure describes the lines around a boundary between lines.
+type splitMeasure struct {
+	endOfFile             bool
+	indent                int // of the line after the boundary
+	preBlank, preIndent   int // blank lines before it, and the indent above them
+	postBlank, postIndent int // blank lines after the next, and the indent below
+}
+
+func measureSplit(lines []string, split int) splitMeasure {
+	m := splitMeasure{indent: -1, preIndent: -1, postIndent: -1}
+	if split >= len(lines) {
+		m.endOfFile = true
+	} else {
+		m.indent = lineIndent(lines[split])
+	}
+	for i := split - 1; i >= 0; i-- {
+		if m.preIndent = lineIndent(lines[i]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == maxBlankRun {
+			m.preIndent = 0
+			break
+		}
+	}
+	for i := split + 1; i < len(lines); i++ {
+		if m.postIndent = lineIndent(lines[i]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == maxBlankRun {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+// splitScore rates the boundaries of a run; lower is better.
+type splitScore struct {
+	effectiveIndent, penalty int
+}
+
+func (s *splitScore) add(m splitMeasure) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight*totalBlank + postBlankWeight*postBlank
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	blanks := totalBlank != 0
+	s.effectiveIndent += indent
+	switch {
+	case indent == -1, m.preIndent == -1, indent == m.preIndent:
+	case indent > m.preIndent:
+		s.penalty += pick(blanks, relativeIndentBlank, relativeIndentPenalty)
+	case m.postIndent != -1 && m.postIndent > indent:
+		s.penalty += pick(blanks, relativeOutdentBlank, relativeOutdent)
+	default:
+		s.penalty += pick(blanks, relativeDedentBlank, relativeDedent)
+	}
+}
+
+func (s splitScore) cmp(t splitScore) int {
+	indents := 0
+	if s.effectiveIndent > t.effectiveIndent {
+		indents = 1
+	} else if s.effectiveIndent < t.effectiveIndent {
+		indents = -1
+	}
+	return indentWeight*indents + s.penalty - t.penalty
+}
+
+// pick returns a if cond holds and b otherwise.
+func pick(cond bool, a, b int) int {
+	if cond {
+		return a
+	}
+	return b
+}
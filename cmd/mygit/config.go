@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// config is a parsed .git/config file. Section and key names are
+// case-insensitive; subsection names are case-sensitive.
+type config struct {
+	sections []*configSection
+}
+
+type configSection struct {
+	name       string
+	subsection string
+	entries    []configEntry
+}
+
+type configEntry struct {
+	key   string
+	value string
+	line  int // where in the file it was read, 0 if it was set since
+}
+
+func configPath() string {
+	return gitPath("config")
+}
+
+// readConfig parses .git/config, returning an empty config if it is missing.
+func readConfig() (*config, error) {
+	return readConfigFile(configPath())
+}
+
+// readUserConfig layers .git/config over the user's ~/.gitconfig, for
+// settings such as user.name that are usually configured globally. The
+// result is for reading only; writing it would copy global settings into
+// the repository.
+func readUserConfig() (*config, error) {
+	cfg := &config{}
+	if home, err := os.UserHomeDir(); err == nil {
+		global, err := readConfigFile(filepath.Join(home, ".gitconfig"))
+		if err != nil {
+			return nil, err
+		}
+		cfg.sections = append(cfg.sections, global.sections...)
+	}
+	local, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.sections = append(cfg.sections, local.sections...)
+	return cfg, nil
+}
+
+// readConfigFile parses a git config file, returning an empty config if
+// it is missing.
+func readConfigFile(path string) (*config, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &config{}
+	var cur *configSection
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad config line %d in %s", lineno, path)
+			}
+			header := line[1:end]
+			cur = &configSection{}
+			if name, sub, ok := strings.Cut(header, " "); ok {
+				cur.name = strings.ToLower(name)
+				cur.subsection = strings.Trim(strings.TrimSpace(sub), `"`)
+			} else if name, sub, ok := strings.Cut(header, "."); ok {
+				// Deprecated [section.subsection] syntax.
+				cur.name, cur.subsection = strings.ToLower(name), sub
+			} else {
+				cur.name = strings.ToLower(header)
+			}
+			cfg.sections = append(cfg.sections, cur)
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("bad config line %d in %s", lineno, path)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			value = "true" // a bare key is a boolean true
+		}
+		cur.entries = append(cur.entries, configEntry{
+			key:   strings.ToLower(strings.TrimSpace(key)),
+			value: parseConfigValue(strings.TrimSpace(value)),
+			line:  lineno,
+		})
+	}
+	return cfg, scanner.Err()
+}
+
+// parseConfigSize parses an integer setting with git's optional k, m
+// or g suffix.
+func parseConfigSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToLower(s[len(s)-min(len(s), 1):]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseConfigValue strips quotes, escapes and trailing comments.
+func parseConfigValue(s string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (c *config) section(name, subsection string) *configSection {
+	for _, s := range c.sections {
+		if s.name == strings.ToLower(name) && s.subsection == subsection {
+			return s
+		}
+	}
+	return nil
+}
+
+// get returns the last value of section[.subsection].key.
+func (c *config) get(section, subsection, key string) (string, bool) {
+	values := c.getAll(section, subsection, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// getAll returns every value of a multi-valued key such as remote.<name>.fetch.
+func (c *config) getAll(section, subsection, key string) []string {
+	var values []string
+	key = strings.ToLower(key)
+	for _, s := range c.sections {
+		if s.name != strings.ToLower(section) || s.subsection != subsection {
+			continue
+		}
+		for _, e := range s.entries {
+			if e.key == key {
+				values = append(values, e.value)
+			}
+		}
+	}
+	return values
+}
+
+// set replaces every value of key with value, adding the section if needed.
+func (c *config) set(section, subsection, key, value string) {
+	c.unset(section, subsection, key)
+	c.add(section, subsection, key, value)
+}
+
+// add appends another value for key.
+func (c *config) add(section, subsection, key, value string) {
+	s := c.section(section, subsection)
+	if s == nil {
+		s = &configSection{name: strings.ToLower(section), subsection: subsection}
+		c.sections = append(c.sections, s)
+	}
+	s.entries = append(s.entries, configEntry{key: strings.ToLower(key), value: value})
+}
+
+// unset removes every value of key.
+func (c *config) unset(section, subsection, key string) {
+	key = strings.ToLower(key)
+	for _, s := range c.sections {
+		if s.name != strings.ToLower(section) || s.subsection != subsection {
+			continue
+		}
+		kept := s.entries[:0]
+		for _, e := range s.entries {
+			if e.key != key {
+				kept = append(kept, e)
+			}
+		}
+		s.entries = kept
+	}
+}
+
+// renameSection moves every section[.old] to section[.new], replacing
+// any existing section[.new], and reports whether anything moved.
+func (c *config) renameSection(section, old, new string) bool {
+	if c.section(section, old) == nil {
+		return false
+	}
+	kept := c.sections[:0]
+	for _, s := range c.sections {
+		if s.name == strings.ToLower(section) && s.subsection == new {
+			continue
+		}
+		if s.name == strings.ToLower(section) && s.subsection == old {
+			s.subsection = new
+		}
+		kept = append(kept, s)
+	}
+	c.sections = kept
+	return true
+}
+
+// write serializes the config back to .git/config.
+func (c *config) write() error {
+	return c.writeFile(configPath())
+}
+
+// writeFile serializes the config to path.
+func (c *config) writeFile(path string) error {
+	var b strings.Builder
+	for _, s := range c.sections {
+		if len(s.entries) == 0 {
+			continue
+		}
+		if s.subsection != "" {
+			fmt.Fprintf(&b, "[%s %q]\n", s.name, s.subsection)
+		} else {
+			fmt.Fprintf(&b, "[%s]\n", s.name)
+		}
+		for _, e := range s.entries {
+			fmt.Fprintf(&b, "\t%s = %s\n", e.key, formatConfigValue(e.value))
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// formatConfigValue quotes a value if reading it back would change it.
+func formatConfigValue(v string) string {
+	if v == "" || strings.ContainsAny(v, "\"\\#;\n\t") || strings.TrimSpace(v) != v {
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+		return `"` + r.Replace(v) + `"`
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package main
+
+import "time"
+
+// cmdContext holds state shared across the work of a single command
+// invocation. It is created in main and discarded when the command ends.
+type cmdContext struct {
+	commits map[string]*Commit
+	nodes   map[string]*Commit // commits as far as the commit-graph knows them
+	graph   map[string]*graphCommit
+}
+
+func newCmdContext() *cmdContext {
+	return &cmdContext{
+		commits: map[string]*Commit{},
+		nodes:   map[string]*Commit{},
+		graph:   map[string]*graphCommit{},
+	}
+}
+
+// getCommit returns the parsed commit named by hash, reading it from the
+// object store only the first time it is asked for. Callers must not
+// modify the returned commit.
+func (ctx *cmdContext) getCommit(hash string) (*Commit, error) {
+	if c, ok := ctx.commits[hash]; ok {
+		return c, nil
+	}
+	c, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	ctx.commits[hash] = c
+	return c, nil
+}
+
+// graphCommit returns what the commit-graph records about hash, or nil
+// if the commit is not in it.
+func (ctx *cmdContext) graphCommit(hash string) (*graphCommit, error) {
+	if gc, ok := ctx.graph[hash]; ok {
+		return gc, nil
+	}
+	gc, err := openCommitGraph().lookup(hash)
+	if err != nil {
+		return nil, err
+	}
+	ctx.graph[hash] = gc
+	return gc, nil
+}
+
+// getCommitNode returns the commit named by hash with at least its
+// tree, parents and committer time filled in, taking them from the
+// commit-graph when it lists the commit and reading the object
+// otherwise. It is for walks that need nothing more.
+func (ctx *cmdContext) getCommitNode(hash string) (*Commit, error) {
+	if c, ok := ctx.commits[hash]; ok {
+		return c, nil
+	}
+	if c, ok := ctx.nodes[hash]; ok {
+		return c, nil
+	}
+	gc, err := ctx.graphCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	if gc == nil {
+		return ctx.getCommit(hash)
+	}
+	c := &Commit{Hash: hash, Tree: gc.tree, Parents: gc.parents}
+	c.Committer.When = time.Unix(gc.time, 0)
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	if shallow[hash] {
+		c.Parents = nil
+	}
+	ctx.nodes[hash] = c
+	return c, nil
+}
+
+// generation returns the commit-graph generation number of hash: one
+// more than the largest of its parents', so a commit can only reach
+// commits with smaller ones. It is 0 when unknown.
+func (ctx *cmdContext) generation(hash string) (uint32, error) {
+	gc, err := ctx.graphCommit(hash)
+	if err != nil || gc == nil {
+		return 0, err
+	}
+	return gc.generation, nil
+}
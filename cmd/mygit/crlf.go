@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// autocrlfSetting caches core.autocrlf for this run; nil means unread.
+var autocrlfSetting *string
+
+// autocrlf returns core.autocrlf normalized to "true", "input" or "false".
+func autocrlf() string {
+	if autocrlfSetting == nil {
+		value := "false"
+		if cfg, err := readConfig(); err == nil {
+			if v, ok := cfg.get("core", "", "autocrlf"); ok {
+				switch v = strings.ToLower(v); v {
+				case "true", "yes", "on", "1":
+					value = "true"
+				case "input":
+					value = "input"
+				}
+			}
+		}
+		autocrlfSetting = &value
+	}
+	return *autocrlfSetting
+}
+
+// isBinary applies git's heuristic: content with a NUL byte in its first
+// 8000 bytes is binary.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// coreEOL returns core.eol, the line ending text files are checked out
+// with when attributes mark them as text: "crlf" or "lf".
+func coreEOL() string {
+	if cfg, err := readConfig(); err == nil {
+		if v, ok := cfg.get("core", "", "eol"); ok && strings.ToLower(v) == "crlf" {
+			return "crlf"
+		}
+	}
+	return "lf"
+}
+
+// textConversion says whether the line endings of a file are converted.
+type textConversion int
+
+const (
+	convertNone textConversion = iota
+	convertText                // always, as for the text attribute
+	convertAuto                // only if the content does not look binary
+)
+
+// lineEndings decides how the file at path is converted from its
+// attributes, falling back to core.autocrlf when it has neither text
+// nor eol, and whether it is checked out with CRLF line endings.
+func lineEndings(path string) (conv textConversion, crlf bool) {
+	attrs := pathAttributes(path)
+	text, eol := attrs["text"], attrs["eol"]
+	switch {
+	case text == attrUnset:
+		return convertNone, false
+	case text == "auto":
+		conv = convertAuto
+	case text == attrSet || eol == "lf" || eol == "crlf":
+		// An eol attribute marks the file as text as well.
+		conv = convertText
+	default:
+		switch autocrlf() {
+		case "true":
+			return convertAuto, true
+		case "input":
+			return convertAuto, false
+		}
+		return convertNone, false
+	}
+	switch eol {
+	case "crlf":
+		return conv, true
+	case "lf":
+		return conv, false
+	}
+	return conv, autocrlf() == "true" || (autocrlf() == "false" && coreEOL() == "crlf")
+}
+
+// toRepoText converts the CRLF line endings of the file at path to LF
+// before it is stored, when its attributes or core.autocrlf ask for it.
+func toRepoText(path string, data []byte) []byte {
+	conv, _ := lineEndings(path)
+	if conv == convertNone || (conv == convertAuto && isBinary(data)) || !bytes.Contains(data, []byte("\r\n")) {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
+// toWorktreeText converts LF line endings to CRLF when the file at path
+// is checked out with CRLF line endings. Lines already ending in CRLF
+// are kept.
+func toWorktreeText(path string, data []byte) []byte {
+	conv, crlf := lineEndings(path)
+	if conv == convertNone || !crlf || (conv == convertAuto && isBinary(data)) || !bytes.Contains(data, []byte("\n")) {
+		return data
+	}
+	var out bytes.Buffer
+	out.Grow(len(data) + bytes.Count(data, []byte("\n")))
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			out.WriteByte('\r')
+		}
+		out.WriteByte(c)
+	}
+	return out.Bytes()
+}
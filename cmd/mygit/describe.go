@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// describeCandidate is a tag that can name a commit.
+type describeCandidate struct {
+	name      string
+	annotated bool
+}
+
+// describe implements `mygit describe [--tags] [<commit>]`.
+func describe(ctx *cmdContext, rev string, lightweight bool) (string, error) {
+	start, err := resolveObjectName(rev)
+	if err != nil {
+		return "", err
+	}
+	if start, err = peelToCommit(start); err != nil {
+		return "", err
+	}
+
+	names, refs, err := listRefs("refs/tags/")
+	if err != nil {
+		return "", err
+	}
+	tagged := map[string]describeCandidate{}
+	for _, ref := range names {
+		hash := refs[ref]
+		objType, _, err := readObject(hash)
+		if err != nil {
+			return "", err
+		}
+		annotated := objType == TagObject
+		if !annotated && !lightweight {
+			continue
+		}
+		commit, err := peelToCommit(hash)
+		if err != nil {
+			continue // tags of trees and blobs cannot describe a commit
+		}
+		// Prefer annotated tags when several point at the same commit.
+		if prev, ok := tagged[commit]; ok && (prev.annotated || !annotated) {
+			continue
+		}
+		tagged[commit] = describeCandidate{strings.TrimPrefix(ref, "refs/tags/"), annotated}
+	}
+	if len(tagged) == 0 {
+		if len(names) == 0 {
+			return "", errors.New("No names found, cannot describe anything.")
+		}
+		return "", errors.New("No annotated tags can describe '" + start + "'.\nHowever, there were unannotated tags: try --tags.")
+	}
+
+	var tag describeCandidate
+	tagCommit := ""
+	err = ctx.walkTopology([]string{start}, func(c *Commit) error {
+		var ok bool
+		if tag, ok = tagged[c.Hash]; ok {
+			tagCommit = c.Hash
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if tagCommit == "" {
+		return "", fmt.Errorf("No tags can describe '%s'.", start)
+	}
+	if tagCommit == start {
+		return tag.name, nil
+	}
+
+	// Count the commits that are not already part of the tag's history.
+	inTag := map[string]bool{}
+	err = ctx.walkTopology([]string{tagCommit}, func(c *Commit) error {
+		inTag[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	n := 0
+	err = ctx.walkTopology([]string{start}, func(c *Commit) error {
+		if !inTag[c.Hash] {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-g%s", tag.name, n, start[:7]), nil
+}
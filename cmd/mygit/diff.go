@@ -0,0 +1,170 @@
+package main
+
+import "bytes"
+
+type diffKind int
+
+const (
+	diffEqual diffKind = iota
+	diffDelete
+	diffInsert
+)
+
+// diffOp is one line of an edit script. oldLine and newLine index the
+// old and new inputs; only the side(s) relevant to kind are meaningful.
+type diffOp struct {
+	kind    diffKind
+	oldLine int
+	newLine int
+}
+
+// splitLines splits data into lines, keeping each line's terminator so
+// that a missing final newline is still visible to the diff.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		lines = append(lines, string(data[:n]))
+		data = data[n:]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script turning a into b using
+// Myers' O(ND) algorithm, with changes placed where git places them.
+func diffLines(a, b []string) []diffOp {
+	changedA, changedB := make([]bool, len(a)), make([]bool, len(b))
+	for _, op := range myersLines(a, b) {
+		switch op.kind {
+		case diffDelete:
+			changedA[op.oldLine] = true
+		case diffInsert:
+			changedB[op.newLine] = true
+		}
+	}
+	compactChanges(a, changedA, changedB)
+	compactChanges(b, changedB, changedA)
+
+	// Rebuild the script, deletions before insertions in each block.
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && changedA[i]:
+			ops = append(ops, diffOp{diffDelete, i, j})
+			i++
+		case j < len(b) && changedB[j]:
+			ops = append(ops, diffOp{diffInsert, i, j})
+			j++
+		default:
+			ops = append(ops, diffOp{diffEqual, i, j})
+			i, j = i+1, j+1
+		}
+	}
+	return ops
+}
+
+// myersLines is the edit script found by Myers' algorithm alone.
+func myersLines(a, b []string) []diffOp {
+	// Common prefixes and suffixes never take part in the edit.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{diffEqual, i, i})
+	}
+	for _, op := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		op.oldLine += prefix
+		op.newLine += prefix
+		ops = append(ops, op)
+	}
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, diffOp{diffEqual, len(a) - suffix + i, len(b) - suffix + i})
+	}
+	return ops
+}
+
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v[-d..d] as it was before step d.
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return myersBacktrack(trace, n, m)
+			}
+		}
+	}
+	return nil
+}
+
+func myersBacktrack(trace [][]int, n, m int) []diffOp {
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{diffEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{diffInsert, x, prevY})
+			} else {
+				ops = append(ops, diffOp{diffDelete, prevX, y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
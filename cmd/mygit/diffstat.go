@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// statWidth is the width git gives a diffstat when not writing to a
+// terminal.
+const statWidth = 80
+
+// fileStat is one line of a diffstat. A binary file counts bytes rather
+// than lines: added is the size of its new side and deleted of its old.
+type fileStat struct {
+	name           string
+	added, deleted int
+	binary         bool
+}
+
+// statName is how a diffstat names the file c changes. A rename shows
+// both paths with what they share factored out, as in "dir/{a => b}.go".
+func statName(c treeChange) string {
+	if c.oldPath == "" {
+		return quotePath(c.path)
+	}
+	a, b := c.oldPath, c.path
+	// The common prefix and suffix both end at a slash.
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	suffix := 0
+	for i, j := len(a)-1, len(b)-1; i >= prefix-1 && j >= prefix-1 && i >= 0 && j >= 0 && a[i] == b[j]; i, j = i-1, j-1 {
+		if a[i] == '/' {
+			suffix = len(a) - i
+		}
+	}
+	aMid, bMid := max(len(a)-prefix-suffix, 0), max(len(b)-prefix-suffix, 0)
+	if prefix+suffix == 0 {
+		return a + " => " + b
+	}
+	return a[:prefix] + "{" + a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid] + "}" + a[len(a)-suffix:]
+}
+
+// diffStats counts the lines each of changes adds and removes.
+func diffStats(changes []treeChange) ([]fileStat, error) {
+	var stats []fileStat
+	for _, c := range changes {
+		old, err := blobSide(c.old)
+		if err != nil {
+			return nil, err
+		}
+		new, err := blobSide(c.new)
+		if err != nil {
+			return nil, err
+		}
+		s := fileStat{name: statName(c)}
+		switch {
+		case diffAsBinary(c.path, old.data, new.data):
+			s.binary = true
+			if old.hash != new.hash {
+				s.added, s.deleted = len(new.data), len(old.data)
+			}
+		case old.hash != new.hash:
+			for _, op := range diffLines(splitLines(old.data), splitLines(new.data)) {
+				switch op.kind {
+				case diffInsert:
+					s.added++
+				case diffDelete:
+					s.deleted++
+				}
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// writeDiffStat writes the diffstat of changes as git does: a line per
+// file with its name, the number of lines changed and a bar of + and -
+// scaled to fit the width, then a summary line. With color the bar and
+// the sizes of binary files are green and red. Nothing is written when
+// there are no changes.
+func writeDiffStat(w io.Writer, changes []treeChange, color bool) error {
+	stats, err := diffStats(changes)
+	if err != nil || len(stats) == 0 {
+		return err
+	}
+	maxName, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for _, s := range stats {
+		maxName = max(maxName, utf8.RuneCountInString(s.name))
+		if s.binary {
+			// "Bin XXX -> YYY bytes", with the counts aligned under "Bin".
+			binWidth = max(binWidth, 14+len(strconv.Itoa(s.added))+len(strconv.Itoa(s.deleted)))
+			numberWidth = 3
+			continue
+		}
+		maxChange = max(maxChange, s.added+s.deleted)
+	}
+	numberWidth = max(numberWidth, len(strconv.Itoa(maxChange)))
+
+	// The name gets what it needs and the bar the rest, unless that is
+	// more than the width allows: then the bar gets at most 3/8 of it.
+	width := max(statWidth, 16+6+numberWidth)
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxName
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = max(width*3/8-numberWidth-6, 6)
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	insertions, deletions := 0, 0
+	for _, s := range stats {
+		// A name too long for its column loses its start, and then
+		// everything up to its next slash.
+		prefix, name := "", s.name
+		room := nameWidth
+		if n := utf8.RuneCountInString(name); n > nameWidth {
+			prefix, room = "...", max(nameWidth-3, 0)
+			for ; n > room; n-- {
+				_, size := utf8.DecodeRuneInString(name)
+				name = name[size:]
+			}
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				name = name[i:]
+			}
+		}
+		padding := strings.Repeat(" ", max(room-utf8.RuneCountInString(name), 0))
+		if s.binary {
+			if s.added == 0 && s.deleted == 0 {
+				fmt.Fprintf(w, " %s%s%s | %*s\n", prefix, name, padding, numberWidth, "Bin")
+			} else {
+				fmt.Fprintf(w, " %s%s%s | %*s %s -> %s bytes\n", prefix, name, padding, numberWidth, "Bin",
+					paint(color, colorRed, strconv.Itoa(s.deleted)), paint(color, colorGreen, strconv.Itoa(s.added)))
+			}
+			continue
+		}
+		insertions += s.added
+		deletions += s.deleted
+		added, deleted := s.added, s.deleted
+		if graphWidth <= maxChange {
+			total := scaleStat(added+deleted, graphWidth, maxChange)
+			if total < 2 && added > 0 && deleted > 0 {
+				total = 2
+			}
+			if added < deleted {
+				added = scaleStat(added, graphWidth, maxChange)
+				deleted = total - added
+			} else {
+				deleted = scaleStat(deleted, graphWidth, maxChange)
+				added = total - deleted
+			}
+		}
+		sep := ""
+		if s.added+s.deleted > 0 {
+			sep = " "
+		}
+		fmt.Fprintf(w, " %s%s%s | %*d%s%s%s\n", prefix, name, padding, numberWidth, s.added+s.deleted, sep,
+			paintBar(color, colorGreen, "+", added), paintBar(color, colorRed, "-", deleted))
+	}
+
+	summary := fmt.Sprintf(" %d file%s changed", len(stats), plural(len(stats)))
+	if insertions > 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %d insertion%s(+)", insertions, plural(insertions))
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %d deletion%s(-)", deletions, plural(deletions))
+	}
+	fmt.Fprintln(w, summary)
+	return nil
+}
+
+// paintBar returns n of mark, in color when on is set. An empty bar is
+// left unpainted, as in git.
+func paintBar(on bool, color, mark string, n int) string {
+	if n == 0 {
+		return ""
+	}
+	return paint(on, color, strings.Repeat(mark, n))
+}
+
+// plural returns the "s" that follows a count other than one.
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// scaleStat scales n, out of at most maxChange, to width columns,
+// keeping a non-zero count visible.
+func scaleStat(n, width, maxChange int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/maxChange
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeChange is one line of diff-tree's output. A rename has status 'R',
+// the similarity of its two sides as score and both paths set; so does
+// a copy, with status 'C'.
+type treeChange struct {
+	status        byte // 'A', 'D', 'M', 'T', 'R' or 'C'
+	score         int  // percent, for renames and copies
+	oldPath, path string
+	old, new      TreeEntry // the zero TreeEntry for a missing side
+}
+
+// diffTreeOptions are the flags of `mygit diff-tree`.
+type diffTreeOptions struct {
+	recursive  bool
+	renames    bool
+	copies     bool // find copies of files the diff modifies, as well as renames
+	threshold  int  // minimum similarity, in percent, of a rename or copy
+	nameOnly   bool
+	nameStatus bool
+	stat       bool // a diffstat, which always recurses
+	nul        bool // end fields with NUL and leave paths unquoted
+	abbrev     int  // hex digits of object names to show; 0 for all
+}
+
+// defaultRenameThreshold is the similarity git requires of a rename
+// when -M gives no score.
+const defaultRenameThreshold = 50
+
+// parseRenameScore parses the <n> of -M<n> as git does: digits are a
+// fraction, so "5" and "50" both mean 50%, unless a "%" follows them.
+func parseRenameScore(s string) (int, error) {
+	digits, percent := strings.CutSuffix(s, "%")
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rename score %q", s)
+	}
+	if percent {
+		return min(n, 100), nil
+	}
+	score := float64(n)
+	for i := 0; i < len(digits); i++ {
+		score /= 10
+	}
+	return int(score * 100), nil
+}
+
+// treeFiles returns the entries of tree by path: every file below it
+// when recursive, and only its immediate entries otherwise.
+func treeFiles(tree string, recursive bool) (map[string]TreeEntry, error) {
+	if tree == "" {
+		return map[string]TreeEntry{}, nil
+	}
+	if recursive {
+		return flattenTree(tree)
+	}
+	entries, err := readTree(tree)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]TreeEntry, len(entries))
+	for _, e := range entries {
+		files[e.Name] = e
+	}
+	return files, nil
+}
+
+// diffTrees lists the paths that differ between two sets of tree
+// entries, sorted by path. A path that changes between a file and a
+// tree is a deletion and an addition.
+func diffTrees(from, to map[string]TreeEntry) []treeChange {
+	var changes []treeChange
+	for path, old := range from {
+		e, ok := to[path]
+		switch {
+		case !ok || (old.Mode == "40000") != (e.Mode == "40000"):
+			changes = append(changes, treeChange{status: 'D', path: path, old: old})
+		case old.Mode == e.Mode && old.Hash == e.Hash:
+		case old.Mode == e.Mode || (old.Mode != "120000" && e.Mode != "120000"):
+			changes = append(changes, treeChange{status: 'M', path: path, old: old, new: e})
+		default:
+			changes = append(changes, treeChange{status: 'T', path: path, old: old, new: e})
+		}
+	}
+	for path, e := range to {
+		if old, ok := from[path]; !ok || (old.Mode == "40000") != (e.Mode == "40000") {
+			changes = append(changes, treeChange{status: 'A', path: path, new: e})
+		}
+	}
+	sortChanges(changes)
+	return changes
+}
+
+// sortChanges orders changes by path, deletions before additions.
+func sortChanges(changes []treeChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].path != changes[j].path {
+			return changes[i].path < changes[j].path
+		}
+		return changes[i].status == 'D'
+	})
+}
+
+// detectRenames pairs deleted files with added ones whose content is at
+// least threshold percent similar, replacing each pair with a rename.
+// The most similar pairs are taken first and every file is used once.
+func detectRenames(changes []treeChange, threshold int) ([]treeChange, error) {
+	type candidate struct {
+		del, add, score int
+	}
+	var deleted, added []int
+	for i, c := range changes {
+		if c.status == 'D' && c.old.Mode != "40000" {
+			deleted = append(deleted, i)
+		} else if c.status == 'A' && c.new.Mode != "40000" {
+			added = append(added, i)
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return changes, nil
+	}
+
+	content := blobReader()
+	var candidates []candidate
+	for _, d := range deleted {
+		for _, a := range added {
+			score := 100
+			if from, to := changes[d].old.Hash, changes[a].new.Hash; from != to {
+				old, err := content(from)
+				if err != nil {
+					return nil, err
+				}
+				data, err := content(to)
+				if err != nil {
+					return nil, err
+				}
+				score = similarity(old, data)
+			}
+			if score >= threshold {
+				candidates = append(candidates, candidate{d, a, score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	used := map[int]bool{}
+	for _, c := range candidates {
+		if used[c.del] || used[c.add] {
+			continue
+		}
+		used[c.del], used[c.add] = true, true
+		add := &changes[c.add]
+		add.status, add.score = 'R', c.score
+		add.oldPath, add.old = changes[c.del].path, changes[c.del].old
+	}
+	kept := changes[:0]
+	for i, c := range changes {
+		if c.status != 'D' || !used[i] {
+			kept = append(kept, c)
+		}
+	}
+	return kept, nil
+}
+
+// detectCopies turns each file changes add into a copy of the file,
+// modified or renamed by changes, that it is most similar to, if that is
+// at least threshold percent. As with git's -C, files the changes leave
+// alone are not looked at.
+func detectCopies(changes []treeChange, threshold int) ([]treeChange, error) {
+	var sources []treeChange
+	for _, c := range changes {
+		if (c.status == 'M' || c.status == 'R') && c.old.Mode != "40000" {
+			src := c
+			if c.status == 'M' {
+				src.oldPath = c.path
+			}
+			sources = append(sources, src)
+		}
+	}
+	content := blobReader()
+	for i := range changes {
+		add := &changes[i]
+		if add.status != 'A' || add.new.Mode == "40000" {
+			continue
+		}
+		best, bestScore := -1, threshold-1
+		for j, src := range sources {
+			score := 100
+			if src.old.Hash != add.new.Hash {
+				old, err := content(src.old.Hash)
+				if err != nil {
+					return nil, err
+				}
+				data, err := content(add.new.Hash)
+				if err != nil {
+					return nil, err
+				}
+				score = similarity(old, data)
+			}
+			if score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		if best >= 0 {
+			add.status, add.score = 'C', bestScore
+			add.oldPath, add.old = sources[best].oldPath, sources[best].old
+		}
+	}
+	// Of the files made from a deleted one, git calls the last a rename
+	// and the others copies.
+	renamed := map[string]bool{}
+	for _, c := range changes {
+		if c.status == 'R' {
+			renamed[c.oldPath] = true
+		}
+	}
+	seen := map[string]bool{}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if c := &changes[i]; renamed[c.oldPath] && (c.status == 'R' || c.status == 'C') {
+			c.status = 'C'
+			if !seen[c.oldPath] {
+				c.status, seen[c.oldPath] = 'R', true
+			}
+		}
+	}
+	return changes, nil
+}
+
+// blobReader returns a function that reads blobs by name, reading each
+// only once.
+func blobReader() func(hash string) ([]byte, error) {
+	contents := map[string][]byte{}
+	return func(hash string) ([]byte, error) {
+		if data, ok := contents[hash]; ok {
+			return data, nil
+		}
+		_, data, err := readObject(hash)
+		contents[hash] = data
+		return data, err
+	}
+}
+
+// similarity is the share, in percent, of the larger of two blobs made
+// up of lines the two have in common.
+func similarity(a, b []byte) int {
+	size := max(len(a), len(b))
+	if size == 0 {
+		return 100
+	}
+	oldLines := splitLines(a)
+	common := 0
+	for _, op := range diffLines(oldLines, splitLines(b)) {
+		if op.kind == diffEqual {
+			common += len(oldLines[op.oldLine])
+		}
+	}
+	return common * 100 / size
+}
+
+// diffTree implements `mygit diff-tree [-r] [-z] [-M[<n>] | -C[<n>]]
+// [--name-only | --name-status | --stat] <tree-ish> [<tree-ish>]`.
+// Given a single commit it compares the commit to its first parent,
+// after a line naming it. With -z every field, paths included, ends with
+// a NUL rather than a tab or newline, and paths are not quoted.
+func diffTree(w io.Writer, revs []string, opts diffTreeOptions) error {
+	end := "\n"
+	if opts.nul {
+		end = "\x00"
+	}
+	var trees [2]string
+	switch len(revs) {
+	case 1:
+		hash, err := resolveObjectName(revs[0])
+		if err != nil {
+			return fmt.Errorf("bad revision '%s'", revs[0])
+		}
+		if hash, err = peelToCommit(hash); err != nil {
+			return err
+		}
+		c, err := readCommit(hash)
+		if err != nil {
+			return err
+		}
+		if len(c.Parents) == 0 {
+			return nil // a root commit has nothing to compare to
+		}
+		parent, err := readCommit(c.Parents[0])
+		if err != nil {
+			return err
+		}
+		trees = [2]string{parent.Tree, c.Tree}
+		fmt.Fprint(w, hash, end)
+	case 2:
+		for i, rev := range revs {
+			hash, err := resolveObjectName(rev)
+			if err != nil {
+				return fmt.Errorf("bad revision '%s'", rev)
+			}
+			if trees[i], _, err = peelToTree(hash); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("diff-tree takes one commit or two trees")
+	}
+
+	changes, err := treeChanges(trees[0], trees[1], opts)
+	if err != nil {
+		return err
+	}
+	return writeTreeChanges(w, changes, opts)
+}
+
+// treeChanges compares two trees, either of which may be "" for the
+// empty tree, finding renames and copies as opts asks.
+func treeChanges(fromTree, toTree string, opts diffTreeOptions) ([]treeChange, error) {
+	from, err := treeFiles(fromTree, opts.recursive || opts.stat)
+	if err != nil {
+		return nil, err
+	}
+	to, err := treeFiles(toTree, opts.recursive || opts.stat)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffTrees(from, to)
+	if opts.renames {
+		if changes, err = detectRenames(changes, opts.threshold); err != nil {
+			return nil, err
+		}
+	}
+	if opts.copies {
+		if changes, err = detectCopies(changes, opts.threshold); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// writeTreeChanges writes changes in the format opts picks: a diffstat,
+// names, names with statuses, or by default raw lines, whose object
+// names are cut to opts.abbrev digits when that is set.
+func writeTreeChanges(w io.Writer, changes []treeChange, opts diffTreeOptions) error {
+	sep, end, quote := "\t", "\n", quotePath
+	if opts.nul {
+		sep, end, quote = "\x00", "\x00", func(path string) string { return path }
+	}
+	if opts.stat {
+		return writeDiffStat(w, changes, false)
+	}
+	zero := strings.Repeat("0", repoFormat().hexLen())
+	for _, c := range changes {
+		status := string(c.status)
+		if c.status == 'R' || c.status == 'C' {
+			status = fmt.Sprintf("%c%03d", c.status, c.score)
+		}
+		paths := quote(c.path)
+		if c.oldPath != "" {
+			paths = quote(c.oldPath) + sep + paths
+		}
+		switch {
+		case opts.nameOnly:
+			fmt.Fprint(w, quote(c.path), end)
+		case opts.nameStatus:
+			fmt.Fprint(w, status, sep, paths, end)
+		default:
+			oldHash, newHash := c.old.Hash, c.new.Hash
+			if oldHash == "" {
+				oldHash = zero
+			}
+			if newHash == "" {
+				newHash = zero
+			}
+			if opts.abbrev > 0 {
+				oldHash, newHash = oldHash[:opts.abbrev], newHash[:opts.abbrev]
+			}
+			fmt.Fprintf(w, ":%06o %06o %s %s %s%s%s%s", parseMode(c.old.Mode), parseMode(c.new.Mode), oldHash, newHash, status, sep, paths, end)
+		}
+	}
+	return nil
+}
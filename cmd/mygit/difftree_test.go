@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+// TestDiffTreeNameStatusNul compares diff-tree -z --name-status with
+// git: a NUL after the status and after each path, an R or C record
+// with its score and both paths, and names that would need quoting
+// given as they are.
+func TestDiffTreeNameStatusNul(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.write("docs/guide.txt", "line one\nline two\nline three\nline four\n", 0o644)
+	r.write("tab\there.txt", "tab\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+
+	r.git("mv", "docs/guide.txt", "docs/manual.txt")
+	r.write("docs/manual.txt", "line one\nline two\nline three\nline four\nline five\n", 0o644)
+	r.write("README", "changed\n", 0o644)
+	r.git("rm", "-q", "run.sh")
+	r.write("new\nline.txt", "new\n", 0o644)
+	r.write("src/lib/copy.txt", "tab\n", 0o644)
+	r.write("tab\there.txt", "tab changed\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "change")
+
+	for _, args := range [][]string{
+		{"diff-tree", "-r", "-z", "--name-status", "HEAD~1", "HEAD"},
+		{"diff-tree", "-r", "-z", "--name-status", "-M", "HEAD~1", "HEAD"},
+		{"diff-tree", "-r", "-z", "--name-status", "-C", "HEAD~1", "HEAD"},
+		{"diff-tree", "-z", "--name-status", "HEAD~1", "HEAD"},
+		{"diff-tree", "-r", "-z", "--name-only", "-M", "HEAD~1", "HEAD"},
+		{"diff-tree", "-r", "--name-status", "-M", "HEAD~1", "HEAD"},
+	} {
+		r.same(args...)
+	}
+}
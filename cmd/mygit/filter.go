@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// toRepoBlob converts the content of the file at the slash-separated
+// path to what git stores for it, in git's order: the clean command of
+// the filter driver its filter attribute names, then the line ending
+// conversion of toRepoText, then, with the ident attribute, collapsing
+// each "$Id: ... $" to "$Id$". A failing clean command leaves the
+// content as it was unless filter.<driver>.required is set.
+func toRepoBlob(path string, data []byte) ([]byte, error) {
+	attrs := pathAttributes(path)
+	switch driver := attrs["filter"]; driver {
+	case "", attrSet, attrUnset:
+	default:
+		cleaned, err := runCleanFilter(driver, path, data)
+		if err != nil {
+			return nil, err
+		}
+		data = cleaned
+	}
+	data = toRepoText(path, data)
+	if attrs["ident"] == attrSet {
+		data = collapseIdent(data)
+	}
+	return data, nil
+}
+
+// runCleanFilter passes data through filter.<driver>.clean, run by the
+// shell with %f replaced by the quoted path.
+func runCleanFilter(driver, path string, data []byte) ([]byte, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	command, _ := cfg.get("filter", driver, "clean")
+	required := false
+	if v, ok := cfg.get("filter", driver, "required"); ok {
+		switch strings.ToLower(v) {
+		case "", "true", "yes", "on", "1":
+			required = true
+		}
+	}
+	failed := fmt.Errorf("%s: clean filter '%s' failed", path, driver)
+	if command == "" {
+		if required {
+			return nil, failed
+		}
+		return data, nil
+	}
+	quoted := "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, "%f", quoted))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(data), &out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		// git reports the exit status, then the failure.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			fmt.Fprintf(os.Stderr, "error: external filter '%s' failed %d\n", command, exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "error: external filter '%s' failed\n", command)
+		if required {
+			return nil, failed
+		}
+		return data, nil
+	}
+	return out.Bytes(), nil
+}
+
+// collapseIdent replaces each "$Id: ... $" in data, ended on the same
+// line, with "$Id$", undoing what checkout's ident expansion writes.
+func collapseIdent(data []byte) []byte {
+	var out bytes.Buffer
+	for {
+		i := bytes.Index(data, []byte("$Id:"))
+		if i < 0 {
+			break
+		}
+		rest := data[i+len("$Id:"):]
+		end := bytes.IndexAny(rest, "$\n")
+		if end < 0 || rest[end] != '$' {
+			out.Write(data[:i+len("$Id:")])
+			data = rest
+			continue
+		}
+		out.Write(data[:i])
+		out.WriteString("$Id$")
+		data = rest[end+1:]
+	}
+	if out.Len() == 0 {
+		return data
+	}
+	out.Write(data)
+	return out.Bytes()
+}
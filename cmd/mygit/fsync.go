@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fsyncComponents caches which kinds of file are flushed to disk as
+// they are written; nil means unread. fsyncComponentsMu guards it, since
+// files are written from several goroutines.
+var (
+	fsyncComponents   map[string]bool
+	fsyncComponentsMu sync.Mutex
+)
+
+// fsyncGroups are git's names for sets of fsync components.
+var fsyncGroups = map[string][]string{
+	"objects":          {"loose-object", "pack"},
+	"derived-metadata": {"pack-metadata", "commit-graph"},
+	"committed":        {"loose-object", "pack", "reference"},
+	"added":            {"loose-object", "pack", "reference", "index"},
+	"all":              {"loose-object", "pack", "pack-metadata", "commit-graph", "reference", "index"},
+}
+
+// fsyncs reports whether files of component are synced to disk before
+// being renamed into place, and their directory after. The components
+// are git's: "loose-object", "pack" for pack files, "pack-metadata" for
+// their .idx and .promisor files, "reference" for loose refs and
+// packed-refs, and "index". GIT_FSYNC, or else core.fsync, lists them as
+// git's core.fsync does, comma separated: a group such as "objects",
+// "committed" or "all" stands for several, a leading "-" removes one, and
+// "none" clears the list. A true boolean means all, a false one none. By
+// default nothing is synced.
+func fsyncs(component string) bool {
+	fsyncComponentsMu.Lock()
+	defer fsyncComponentsMu.Unlock()
+	if fsyncComponents == nil {
+		fsyncComponents = parseFsync()
+	}
+	return fsyncComponents[component]
+}
+
+// parseFsync reads the fsync components from GIT_FSYNC or core.fsync.
+func parseFsync() map[string]bool {
+	value, ok := os.LookupEnv("GIT_FSYNC")
+	if !ok {
+		if cfg, err := readConfig(); err == nil {
+			value, _ = cfg.get("core", "", "fsync")
+		}
+	}
+	components := map[string]bool{}
+	for _, item := range strings.Split(strings.ToLower(value), ",") {
+		item = strings.TrimSpace(item)
+		switch item {
+		case "none", "false", "no", "off", "0":
+			components = map[string]bool{}
+			continue
+		case "true", "yes", "on", "1":
+			item = "all"
+		}
+		name, remove := strings.CutPrefix(item, "-")
+		names, ok := fsyncGroups[name]
+		if !ok {
+			names = []string{name}
+		}
+		for _, name := range names {
+			if remove {
+				delete(components, name)
+			} else if name != "" {
+				components[name] = true
+			}
+		}
+	}
+	return components
+}
+
+// resetFsyncs forgets the fsync components read, for another repository.
+func resetFsyncs() {
+	fsyncComponentsMu.Lock()
+	fsyncComponents = nil
+	fsyncComponentsMu.Unlock()
+}
+
+// writeFileSynced writes data to the file at path through a temporary
+// file in its directory named from pattern, renamed into place so that
+// the file is never seen half written. Files of component are synced
+// before the rename, and the directory after.
+func writeFileSynced(path string, data []byte, perm os.FileMode, pattern, component string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), pattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = closeSynced(tmp, component)
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return renameSynced(tmp.Name(), path, perm, component)
+}
+
+// closeSynced closes f, first syncing it if files of component are.
+func closeSynced(f *os.File, component string) error {
+	var err error
+	if fsyncs(component) {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// renameSynced gives the closed file at tmp perm and renames it to path,
+// syncing the directory after if files of component are synced.
+func renameSynced(tmp, path string, perm os.FileMode, component string) error {
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if fsyncs(component) {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// syncDir flushes a directory to disk, making the names of files just
+// renamed into it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFsyncComponents(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"none", nil},
+		{"false", nil},
+		{"reference", []string{"reference"}},
+		{"objects", []string{"loose-object", "pack"}},
+		{"committed,-loose-object", []string{"pack", "reference"}},
+		{"added", []string{"index", "loose-object", "pack", "reference"}},
+		{"derived-metadata, index", []string{"commit-graph", "index", "pack-metadata"}},
+		{"true", []string{"commit-graph", "index", "loose-object", "pack", "pack-metadata", "reference"}},
+		{"all,none,pack", []string{"pack"}},
+	} {
+		t.Setenv("GIT_FSYNC", tt.value)
+		var got []string
+		for name := range parseFsync() {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GIT_FSYNC=%q: got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestFsyncPackAndIndex writes a pack, the index and refs with every
+// component synced, and checks that each lands in place with no
+// temporary file left behind.
+func TestFsyncPackAndIndex(t *testing.T) {
+	t.Setenv("GIT_FSYNC", "all")
+	hashes := packTempRepo(t, 10)
+	for _, component := range []string{"pack", "pack-metadata", "index", "reference"} {
+		if !fsyncs(component) {
+			t.Errorf("%s is not synced", component)
+		}
+	}
+	if err := writeLooseRef("refs/tags/blob", hashes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := packRefs(true, false); err != nil {
+		t.Fatal(err)
+	}
+	idx := &index{}
+	if err := idx.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pattern := range []string{"*.pack", "*.idx"} {
+		if paths, _ := filepath.Glob(filepath.Join(objectsDir(), "pack", pattern)); len(paths) != 1 {
+			t.Errorf("objects/pack has %q, want one %s", paths, pattern)
+		}
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(objectsDir(), "pack", "tmp_*")); len(leftover) > 0 {
+		t.Errorf("temporary files left: %q", leftover)
+	}
+	for _, name := range []string{"index", "packed-refs"} {
+		if _, err := os.Stat(gitPath(name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, _, err := (packStore{objectsDir()}).Read(hashes[9]); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// repoDirs caches where the repository lives for this run; nil means
+// not yet looked up.
+var repoDirs *struct{ git, common string }
+
+// findRepoDirs locates the git directory of the working tree and the
+// common directory that holds what all working trees of the repository
+// share. The git directory is GIT_DIR if that is set and ".git"
+// otherwise. For a linked working tree .git is a file, "gitdir: <dir>",
+// naming a directory whose commondir file leads back to the main
+// repository.
+func findRepoDirs() {
+	git := ".git"
+	if dir := os.Getenv("GIT_DIR"); dir != "" {
+		git = dir
+	} else if data, err := os.ReadFile(".git"); err == nil {
+		if dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: "); ok {
+			git = dir
+		}
+	}
+	common := git
+	if c, err := os.ReadFile(filepath.Join(git, "commondir")); err == nil {
+		common = strings.TrimSpace(string(c))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(git, common)
+		}
+	}
+	repoDirs = &struct{ git, common string }{git, filepath.Clean(common)}
+}
+
+// gitDir is the git directory of this working tree.
+func gitDir() string {
+	if repoDirs == nil {
+		findRepoDirs()
+	}
+	return repoDirs.git
+}
+
+// commonDir is the git directory shared by every working tree of the
+// repository; for the main working tree it is gitDir.
+func commonDir() string {
+	if repoDirs == nil {
+		findRepoDirs()
+	}
+	return repoDirs.common
+}
+
+// gitPath returns the location of a slash-separated path inside the
+// repository, such as "refs/heads/main" or "index". Like git, HEAD, the
+// index, the HEAD reflog and the other files at the top are kept per
+// working tree; objects, refs, config and the rest are shared.
+func gitPath(name string) string {
+	dir := commonDir()
+	if perWorktreePath(name) {
+		dir = gitDir()
+	}
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// perWorktreePath reports whether name belongs to a single working tree
+// rather than the whole repository.
+func perWorktreePath(name string) bool {
+	switch {
+	case name == "logs/HEAD", strings.HasPrefix(name, "refs/worktree/"), strings.HasPrefix(name, "refs/bisect/"):
+		return true
+	}
+	top, _, _ := strings.Cut(name, "/")
+	switch top {
+	case "objects", "refs", "logs", "config", "packed-refs", "shallow", "info", "hooks", "worktrees", "remotes", "branches":
+		return false
+	}
+	return true
+}
+
+// repoPrefix is where mygit was started within the working tree, a
+// slash-separated path ending in "/", or "" at its top.
+var repoPrefix string
+
+// findRepoTop looks for the top of the working tree the current
+// directory lies in as git does, going up from it to the first
+// directory that holds .git, a directory or a gitfile. It returns ""
+// when there is none, or when GIT_DIR names the repository, in which
+// case the current directory is the top.
+func findRepoTop() (string, error) {
+	if os.Getenv("GIT_DIR") != "" {
+		return "", nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// enterRepoTop moves from a subdirectory of a working tree to its top,
+// where every command runs, and records the subdirectory as repoPrefix
+// so that paths given relative to it can be found. Inside the git
+// directory itself nothing moves.
+func enterRepoTop() error {
+	top, err := findRepoTop()
+	if err != nil || top == "" {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(top, wd)
+	if err != nil || rel == "." {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return nil
+	}
+	if err := enterWorktree(top); err != nil {
+		return err
+	}
+	repoPrefix = rel + "/"
+	return nil
+}
+
+// repoPath turns p, a path given relative to where mygit was started,
+// into a slash-separated path from the top of the working tree, "." for
+// the top itself. A path outside the working tree is refused as git
+// refuses it.
+func repoPath(p string) (string, error) {
+	full := filepath.ToSlash(p)
+	if filepath.IsAbs(p) {
+		top, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(top, p)
+		if err != nil {
+			return "", err
+		}
+		full = filepath.ToSlash(rel)
+	} else {
+		full = path.Clean(repoPrefix + full)
+	}
+	if full == ".." || strings.HasPrefix(full, "../") {
+		top, _ := os.Getwd()
+		return "", fmt.Errorf("%s: '%s' is outside repository at '%s'", p, p, top)
+	}
+	return full, nil
+}
+
+// repoPaths is repoPath for each of paths.
+func repoPaths(paths []string) ([]string, error) {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		var err error
+		if out[i], err = repoPath(p); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// startPath returns where the file p, named relative to where mygit was
+// started, is from the top of the working tree.
+func startPath(p string) string {
+	if repoPrefix == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.FromSlash(repoPrefix), p)
+}
+
+// relPath returns the slash-separated path p, from the top of the
+// working tree, as seen from where mygit was started, as git shows
+// paths to the user: "../README" from a subdirectory.
+func relPath(p string) string {
+	prefix := repoPrefix
+	for prefix != "" {
+		dir, rest, _ := strings.Cut(prefix, "/")
+		name, below, ok := strings.Cut(p, "/")
+		if !ok || name != dir {
+			break
+		}
+		p, prefix = below, rest
+	}
+	return strings.Repeat("../", strings.Count(prefix, "/")) + p
+}
+
+// repoLocation answers the rev-parse options scripts use to find the
+// repository: --git-dir, --show-toplevel, --show-prefix and
+// --is-inside-work-tree. mygit runs at the top of the working tree,
+// having gone up to it from any subdirectory it was started in, and
+// both paths are given absolute. A bare repository has no working tree.
+func repoLocation(option string) (string, error) {
+	git, err := filepath.Abs(gitDir())
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(git); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("not a git repository (or any of the parent directories): %s", gitDir())
+	}
+	bare := false
+	if cfg, err := readConfig(); err == nil {
+		value, _ := cfg.get("core", "", "bare")
+		bare = value == "true"
+	}
+	switch option {
+	case "--git-dir":
+		return git, nil
+	case "--is-inside-work-tree":
+		return strconv.FormatBool(!bare), nil
+	case "--show-prefix":
+		return repoPrefix, nil
+	}
+	if bare {
+		return "", errors.New("this operation must be run in a work tree")
+	}
+	return os.Getwd()
+}
+
+// enterWorktree makes dir, the top of a working tree, the current
+// directory, and forgets what was cached about the repository there.
+func enterWorktree(dir string) error {
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	repoDirs, repoObjects, repoObjectFormat = nil, nil, nil
+	repoPrefix = ""
+	resetAttrFiles()
+	resetPackCache()
+	resetFsyncs()
+	return nil
+}
+
+// Repository extensions mygit understands. Those marked are only
+// allowed when core.repositoryFormatVersion is 1; in a version 0
+// repository git ignores the others, known or not.
+var repoExtensions = map[string]bool{ // name: version 1 only
+	"noop":            false,
+	"noop-v1":         true,
+	"objectformat":    true,
+	"partialclone":    false,
+	"preciousobjects": false,
+}
+
+// checkRepoFormat refuses a repository whose format mygit could damage:
+// a core.repositoryFormatVersion above 1, an extension it does not know
+// in a version 1 repository, a version 1 extension in a version 0 one,
+// or an extensions.objectFormat naming no hash it has. The messages are
+// git's.
+func checkRepoFormat() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	version := 0
+	if v, ok := cfg.get("core", "", "repositoryformatversion"); ok {
+		if version, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("bad numeric config value '%s' for 'core.repositoryformatversion' in file %s: invalid unit", v, gitPath("config"))
+		}
+	}
+	if version > 1 {
+		return fmt.Errorf("Expected git repo version <= 1, found %d", version)
+	}
+	var unknown, v1Only []string
+	for _, s := range cfg.sections {
+		if s.name != "extensions" || s.subsection != "" {
+			continue
+		}
+		for _, e := range s.entries {
+			if _, err := parseObjectFormat(e.value); e.key == "objectformat" && err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid value for 'extensions.objectformat': '%s'\n", e.value)
+				return fmt.Errorf("bad config line %d in file %s", e.line, gitPath("config"))
+			}
+			onlyV1, known := repoExtensions[e.key]
+			switch {
+			case version == 0 && onlyV1:
+				v1Only = append(v1Only, e.key)
+			case version == 1 && !known:
+				unknown = append(unknown, e.key)
+			}
+		}
+	}
+	switch {
+	case len(unknown) == 1:
+		return fmt.Errorf("unknown repository extension found:\n\t%s", unknown[0])
+	case len(unknown) > 1:
+		return fmt.Errorf("unknown repository extensions found:\n\t%s", strings.Join(unknown, "\n\t"))
+	case len(v1Only) == 1:
+		return fmt.Errorf("repo version is 0, but v1-only extension found:\n\t%s", v1Only[0])
+	case len(v1Only) > 1:
+		return fmt.Errorf("repo version is 0, but v1-only extensions found:\n\t%s", strings.Join(v1Only, "\n\t"))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSubdirectory runs commands from a subdirectory of the working
+// tree and compares them with git: the repository is found above it,
+// paths given are taken from it, and paths shown are named from it
+// except where git names them from the top.
+func TestSubdirectory(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "changed\n", 0o644)
+	r.write("src/main.go", "package main // changed\n", 0o644)
+	r.write("src/new.txt", "new\n", 0o644)
+
+	sub := *r
+	sub.dir = filepath.Join(r.dir, "src")
+	for _, args := range [][]string{
+		{"rev-parse", "--show-toplevel"},
+		{"rev-parse", "--show-prefix"},
+		{"rev-parse", "--git-dir"},
+		{"rev-parse", "--is-inside-work-tree"},
+		{"status"},
+		{"status", "--porcelain"},
+		{"ls-files"},
+		{"ls-files", "-s", "--full-name"},
+		{"ls-tree", "HEAD"},
+		{"ls-tree", "-r", "HEAD"},
+		{"ls-tree", "-r", "--full-name", "HEAD"},
+		{"ls-tree", "--full-tree", "HEAD"},
+		{"ls-tree", "--full-tree", "--full-name", "-r", "HEAD"},
+		{"diff"},
+	} {
+		sub.same(args...)
+	}
+	deep := sub
+	deep.dir = filepath.Join(r.dir, "src", "lib")
+	deep.same("ls-tree", "HEAD")
+	deep.same("status")
+
+	sub.mygit("add", "new.txt", "../README")
+	if got, want := r.mygit("status", "--porcelain"), "M  README\n M src/main.go\nA  src/new.txt\n"; got != want {
+		t.Errorf("after add from src: status %q, want %q", got, want)
+	}
+	sub.mygit("reset", "-q", "--", "../README")
+	sub.mygit("add", ".")
+	if got, want := r.git("status", "--porcelain"), " M README\nM  src/main.go\nA  src/new.txt\n"; got != want {
+		t.Errorf("after add . from src: status %q, want %q", got, want)
+	}
+	sub.same("hash-object", "main.go")
+	sub.mygit("commit", "-m", "from src")
+	if got := r.git("ls-tree", "-r", "--name-only", "HEAD"); !strings.Contains(got, "src/new.txt\n") {
+		t.Errorf("commit from src holds %q", got)
+	}
+
+	if stderr := sub.mygitFails("add", "../.."); !strings.Contains(stderr, "is outside repository at '"+r.dir+"'") {
+		t.Errorf("add ../..: %q", stderr)
+	}
+}
+
+// TestWorktree adds a linked working tree for a branch and compares
+// what it writes with git's: the .git file, the administrative files
+// under .git/worktrees and the checkout. Commands run in it find the
+// repository through the .git file, a commit there moves only its
+// branch, and branch marks the branch each has checked out.
+func TestWorktree(t *testing.T) {
+	r := mergeRepo(t, true)
+	wt := filepath.Join(t.TempDir(), "wt")
+	admin := filepath.Join(r.dir, ".git", "worktrees", "wt")
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		return string(data)
+	}
+	linked := *r
+	linked.dir = wt
+	state := func() string {
+		s := read(filepath.Join(wt, ".git")) + read(filepath.Join(admin, "HEAD")) +
+			read(filepath.Join(admin, "gitdir")) + read(filepath.Join(admin, "commondir")) + r.git("worktree", "list")
+		if _, err := os.Stat(wt); err == nil {
+			s += linked.git("status", "--porcelain") + linked.git("ls-files", "-s") + read(filepath.Join(wt, "a"))
+		}
+		return s
+	}
+	restore := r.snapshot()
+	undo := func() {
+		restore()
+		if err := os.RemoveAll(wt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.sameRun(undo, state, "worktree", "add", wt, "main")
+	r.sameRun(undo, state, "worktree", "add", wt, "topic")
+	r.same("worktree", "list")
+
+	for _, args := range [][]string{
+		{"rev-parse", "--git-dir"},
+		{"rev-parse", "--show-toplevel"},
+		{"rev-parse", "HEAD"},
+		{"status"},
+		{"log"},
+		{"branch"},
+	} {
+		linked.same(args...)
+	}
+	linked.write("a", "changed in the worktree\n", 0o644)
+	linked.mygit("add", "a")
+	linked.mygit("commit", "-m", "in the worktree")
+	if got, want := r.git("rev-parse", "topic"), linked.git("rev-parse", "HEAD"); got != want {
+		t.Errorf("topic is at %q after a commit in its worktree, HEAD there at %q", got, want)
+	}
+	if got := r.git("symbolic-ref", "HEAD"); got != "refs/heads/main\n" {
+		t.Errorf("main working tree's HEAD is %q", got)
+	}
+	r.same("status")
+	r.same("branch")
+}
+
+// TestRepoFormat checks that mygit refuses, with git's messages, a
+// repository whose core.repositoryFormatVersion or extensions it does not
+// understand, and works in one whose extensions it knows.
+func TestRepoFormat(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	for _, c := range []struct {
+		config []string
+		ok     bool
+	}{
+		{[]string{"core.repositoryformatversion", "2"}, false},
+		{[]string{"core.repositoryformatversion", "one"}, false},
+		{[]string{"core.repositoryformatversion", "1", "extensions.frobnicate", "true"}, false},
+		{[]string{"core.repositoryformatversion", "1", "extensions.frobnicate", "true", "extensions.twiddle", "true"}, false},
+		{[]string{"core.repositoryformatversion", "1", "extensions.objectformat", "md5"}, false},
+		{[]string{"core.repositoryformatversion", "0", "extensions.objectformat", "md5"}, false},
+		{[]string{"core.repositoryformatversion", "0", "extensions.noop-v1", "true"}, false},
+		{[]string{"core.repositoryformatversion", "0", "extensions.frobnicate", "true"}, true},
+		{[]string{"core.repositoryformatversion", "1", "extensions.noop", "true", "extensions.objectformat", "sha1"}, true},
+	} {
+		undo := r.snapshot()
+		for i := 0; i < len(c.config); i += 2 {
+			r.git("config", "-f", ".git/config", c.config[i], c.config[i+1])
+		}
+		if c.ok {
+			r.same("cat-file", "-p", "HEAD")
+		} else {
+			r.sameFailure("cat-file", "-p", "HEAD")
+		}
+		undo()
+	}
+}
+
+// TestHooksPath checks that with core.hooksPath set hooks are run from
+// there, as in git, and those in the repository's hooks directory are
+// not.
+func TestHooksPath(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.write(".git/hooks/pre-commit", "#!/bin/sh\necho hooks directory >&2\nexit 1\n", 0o755)
+	r.write("hooks/pre-commit", "#!/bin/sh\necho hooks path >&2\nexit 1\n", 0o755)
+	r.sameFailure("commit", "-m", "refused")
+	r.git("config", "core.hooksPath", "hooks")
+	r.sameFailure("commit", "-m", "refused")
+	r.git("config", "core.hooksPath", filepath.Join(r.dir, "nowhere"))
+	r.sameRun(func() { r.git("update-ref", "-d", "HEAD") }, func() string { return r.git("log", "--format=%s") }, "commit", "-m", "no hook")
+}
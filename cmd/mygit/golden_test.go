@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary stand in for mygit: run with
+// MYGIT_TEST_MAIN=1 it is the command itself, so the golden tests can
+// run mygit and git side by side without building anything.
+func TestMain(m *testing.M) {
+	if os.Getenv("MYGIT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// goldenRepo is a repository that the golden tests run both git and
+// mygit in, with the identities and dates of both pinned so that the
+// objects they write can be compared.
+type goldenRepo struct {
+	t   *testing.T
+	dir string
+	env []string
+}
+
+// newGoldenRepo creates an empty repository with git, skipping the test
+// when git is not installed.
+func newGoldenRepo(t *testing.T) *goldenRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	home := t.TempDir()
+	r := &goldenRepo{t: t, dir: t.TempDir(), env: append(os.Environ(),
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+home,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=A U Thor",
+		"GIT_AUTHOR_EMAIL=author@example.com",
+		"GIT_AUTHOR_DATE=1700000000 +0100",
+		"GIT_COMMITTER_NAME=C O Mitter",
+		"GIT_COMMITTER_EMAIL=committer@example.com",
+		"GIT_COMMITTER_DATE=1700000100 -0500",
+	)}
+	r.git("init", "-q", "-b", "main")
+	return r
+}
+
+// write creates the file at the slash-separated path, with its parent
+// directories.
+func (r *goldenRepo) write(path, content string, perm os.FileMode) {
+	r.t.Helper()
+	full := filepath.Join(r.dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), perm); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// run runs a command in the repository and returns its standard output,
+// failing the test if it fails.
+func (r *goldenRepo) run(stdin string, name string, args []string, extraEnv ...string) string {
+	r.t.Helper()
+	cmd := exec.Command(name, args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(append([]string{}, r.env...), extraEnv...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		r.t.Fatalf("%s %s: %v\n%s", filepath.Base(name), strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func (r *goldenRepo) git(args ...string) string {
+	r.t.Helper()
+	return r.run("", "git", args)
+}
+
+func (r *goldenRepo) mygit(args ...string) string {
+	r.t.Helper()
+	return r.mygitIn("", args...)
+}
+
+// mygitIn is mygit with stdin as its standard input.
+func (r *goldenRepo) mygitIn(stdin string, args ...string) string {
+	r.t.Helper()
+	return r.run(stdin, os.Args[0], args, "MYGIT_TEST_MAIN=1")
+}
+
+// mygitFails runs mygit with args, fails the test unless it fails too,
+// and returns what it printed to standard error.
+func (r *goldenRepo) mygitFails(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(append([]string{}, r.env...), "MYGIT_TEST_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		r.t.Fatalf("mygit %s succeeded", strings.Join(args, " "))
+	}
+	return stderr.String()
+}
+
+// stderrOf runs git or, for an empty name, mygit with args and returns
+// what it printed to standard error and whether it succeeded.
+func (r *goldenRepo) stderrOf(name string, args ...string) (string, bool) {
+	r.t.Helper()
+	env := r.env
+	if name == "" {
+		name, env = os.Args[0], append(append([]string{}, env...), "MYGIT_TEST_MAIN=1")
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir, cmd.Env = r.dir, env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		r.t.Fatal(err)
+	}
+	return stderr.String(), err == nil
+}
+
+// same runs git and mygit with args and fails unless they print the same.
+func (r *goldenRepo) same(args ...string) string {
+	r.t.Helper()
+	return r.sameIn("", args...)
+}
+
+// sameIn is same with stdin as the standard input of both.
+func (r *goldenRepo) sameIn(stdin string, args ...string) string {
+	r.t.Helper()
+	want := r.run(stdin, "git", args)
+	if got := r.mygitIn(stdin, args...); got != want {
+		r.t.Errorf("%s:\ngit:   %q\nmygit: %q", strings.Join(args, " "), want, got)
+	}
+	return want
+}
+
+// sameRun runs args with git, then, once undo has put the repository
+// back, with mygit, and fails unless both print the same to standard
+// error, succeed or fail alike and leave the same state.
+func (r *goldenRepo) sameRun(undo func(), state func() string, args ...string) {
+	r.t.Helper()
+	wantErr, wantOK := r.stderrOf("git", args...)
+	want := state()
+	undo()
+	gotErr, gotOK := r.stderrOf("", args...)
+	if got := state(); gotErr != wantErr || gotOK != wantOK || got != want {
+		r.t.Errorf("%s:\ngit:   %q %v\n%s\nmygit: %q %v\n%s", strings.Join(args, " "), wantErr, wantOK, want, gotErr, gotOK, got)
+	}
+}
+
+// snapshot copies the repository, working tree and all, and returns a
+// function that puts it back as it was then.
+func (r *goldenRepo) snapshot() func() {
+	r.t.Helper()
+	saved := filepath.Join(r.t.TempDir(), "snapshot")
+	r.run("", "cp", []string{"-a", r.dir, saved})
+	return func() {
+		r.t.Helper()
+		if err := os.RemoveAll(r.dir); err != nil {
+			r.t.Fatal(err)
+		}
+		if out, err := exec.Command("cp", "-a", saved, r.dir).CombinedOutput(); err != nil {
+			r.t.Fatalf("cp: %v\n%s", err, out)
+		}
+	}
+}
+
+// fill writes the files the golden tests work on: text, an executable,
+// binary content, a symlink and a nested directory.
+func (r *goldenRepo) fill() {
+	r.t.Helper()
+	r.write("README", "hello\nworld\n", 0o644)
+	r.write("run.sh", "#!/bin/sh\necho hi\n", 0o755)
+	r.write("data.bin", "\x00\x01\x02binary\x00 with spaces\xff", 0o644)
+	r.write("src/lib/deep.txt", "deep\n", 0o644)
+	r.write("src/main.go", "package main\n", 0o644)
+	if err := os.Symlink("README", filepath.Join(r.dir, "link")); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestGoldenObjects(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+
+	files := []string{"README", "run.sh", "data.bin", "src/lib/deep.txt"}
+	for _, file := range files {
+		r.same("hash-object", file)
+	}
+	r.same(append([]string{"hash-object"}, files...)...)
+	r.sameIn("piped\n", "hash-object", "--stdin", "README")
+	r.same(append([]string{"hash-object", "-w"}, files...)...)
+
+	r.git("add", "-A")
+	tree := strings.TrimSpace(r.same("write-tree"))
+	r.same("ls-tree", tree)
+	r.same("ls-tree", strings.TrimSpace(r.git("rev-parse", tree+":src")))
+
+	root := strings.TrimSpace(r.same("commit-tree", tree, "-m", "root"))
+	child := strings.TrimSpace(r.same("commit-tree", tree, "-p", root, "-m", "child", "-m", "body"))
+	r.git("update-ref", "refs/heads/main", child)
+	r.git("tag", "-a", "v1", "-m", "release", child)
+	tag := strings.TrimSpace(r.git("rev-parse", "v1"))
+
+	blob := strings.TrimSpace(r.git("rev-parse", tree+":data.bin"))
+	for _, object := range []string{blob, tree, child, tag} {
+		r.same("cat-file", "-t", object)
+		r.same("cat-file", "-s", object)
+	}
+	for _, object := range []string{blob, tree, root, child, tag} {
+		r.same("cat-file", "-p", object)
+	}
+}
+
+// TestGoldenTreeRoundTrip has mygit stage and write the tree of a
+// regular file, an executable, a symlink and a subdirectory, and checks
+// that git reads back the tree git would have written, and that mygit
+// prints it as git does.
+func TestGoldenTreeRoundTrip(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("file.txt", "regular\n", 0o644)
+	r.write("tool", "#!/bin/sh\n", 0o755)
+	r.write("dir/inner.txt", "inner\n", 0o644)
+	if err := os.Symlink("file.txt", filepath.Join(r.dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	r.git("add", "-A")
+	want := strings.TrimSpace(r.git("write-tree"))
+	if err := os.Remove(filepath.Join(r.dir, ".git", "index")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.mygit("add", ".")
+	tree := strings.TrimSpace(r.mygit("write-tree"))
+	if tree != want {
+		t.Fatalf("mygit write-tree = %s, git wrote %s", tree, want)
+	}
+	r.git("fsck", "--strict", "--no-dangling")
+	r.same("cat-file", "-p", tree)
+	r.same("cat-file", "-p", strings.TrimSpace(r.git("rev-parse", tree+":dir")))
+	r.same("ls-tree", "-r", "-t", tree)
+}
+
+// TestWriteTreePrefix compares write-tree --prefix with git's for
+// directories at each depth, with and without the trailing slash, and
+// checks that a prefix no entry is under fails as in git.
+func TestWriteTreePrefix(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	for _, prefix := range []string{"src/", "src", "src/lib/", "src/lib"} {
+		r.same("write-tree", "--prefix="+prefix)
+	}
+	r.sameFailure("write-tree", "--prefix=nothing/")
+	r.sameFailure("write-tree", "--prefix=README/")
+}
+
+func TestHashObjectNoNewline(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hash-object", "README"}, "94954abda49de8615a048f8d2e64b5de848e27a1\n"},
+		{[]string{"hash-object", "-z", "README"}, "94954abda49de8615a048f8d2e64b5de848e27a1"},
+		{[]string{"hash-object", "--no-newline", "README", "src/main.go"},
+			"94954abda49de8615a048f8d2e64b5de848e27a1\n06ab7d0f9a35a7d1070711496d6ca1cb892a258f"},
+	} {
+		if got := r.mygit(tc.args...); got != tc.want {
+			t.Errorf("%s: got %q, want %q", strings.Join(tc.args, " "), got, tc.want)
+		}
+	}
+}
+
+// TestGoldenMktree feeds mktree ls-tree's output, in another order and
+// with -z, and checks that it writes the tree git does, and that it
+// refuses, as git does, an entry whose type its mode or object
+// contradicts, a path with a slash, and an object that is missing unless
+// told --missing.
+func TestGoldenMktree(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	tree := strings.TrimSpace(r.git("write-tree"))
+	listing := r.git("ls-tree", tree)
+	lines := strings.Split(strings.TrimSuffix(listing, "\n"), "\n")
+	slices.Reverse(lines)
+	if got := strings.TrimSpace(r.sameIn(strings.Join(lines, "\n")+"\n", "mktree")); got != tree {
+		t.Errorf("mktree = %s, want %s", got, tree)
+	}
+	r.sameIn(r.git("ls-tree", "-z", tree), "mktree", "-z")
+	r.sameIn("", "mktree")
+
+	blob := strings.TrimSpace(r.git("rev-parse", tree+":README"))
+	missing := strings.Repeat("1", len(blob))
+	for _, input := range []string{
+		"040000 tree " + blob + "\tdir\n",
+		"100644 tree " + blob + "\tfile\n",
+		"100644 blob " + blob + "\tsub/file\n",
+		"100644 blob " + missing + "\tgone\n",
+	} {
+		// Only the error is compared, and the exit status is that of true.
+		want := r.run(input, "sh", []string{"-c", "git mktree 2>&1 >/dev/null; true"})
+		got := r.run(input, "sh", []string{"-c", `"$0" mktree 2>&1 >/dev/null; true`, os.Args[0]}, "MYGIT_TEST_MAIN=1")
+		if got != want || got == "" {
+			t.Errorf("mktree %q:\ngit:   %q\nmygit: %q", input, want, got)
+		}
+	}
+	r.sameIn("100644 blob "+missing+"\tgone\n", "mktree", "--missing")
+}
+
+// TestObjectFormatOverride checks that --object-format names objects in
+// the other format, as git does in a repository of that format, and that
+// hash-object -w and mktree refuse to write objects in it.
+func TestObjectFormatOverride(t *testing.T) {
+	other := newGoldenRepo(t)
+	if err := os.RemoveAll(filepath.Join(other.dir, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	other.git("init", "-q", "--object-format=sha256")
+	other.write("README", "hello\n", 0o644)
+	want := other.git("hash-object", "README")
+
+	r := newGoldenRepo(t)
+	r.write("README", "hello\n", 0o644)
+	if got := r.mygit("hash-object", "--object-format=sha256", "README"); got != want {
+		t.Errorf("hash-object --object-format=sha256 = %q, want %q", got, want)
+	}
+	if got, want := r.mygit("hash-object", "-w", "--object-format=sha1", "README"), r.git("hash-object", "README"); got != want {
+		t.Errorf("hash-object -w --object-format=sha1 = %q, want %q", got, want)
+	}
+	for _, args := range [][]string{
+		{"hash-object", "-w", "--object-format=sha256", "README"},
+		{"hash-object", "--object-format=sha256", "-w", "README"},
+		{"mktree", "--object-format=sha256"},
+	} {
+		if got := r.mygitFails(args...); !strings.Contains(got, "cannot write sha256 objects to a sha1 repository") {
+			t.Errorf("%s printed %q", strings.Join(args, " "), got)
+		}
+	}
+	if got := r.git("count-objects"); got != "1 objects, 4 kilobytes\n" {
+		t.Errorf("count-objects = %q, want only README's blob", got)
+	}
+	r.mygitFails("cat-file", "--object-format=sha256", "-t", strings.TrimSpace(want))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// topoOrder reorders commits so that no commit appears before all of its
+// children and separate lines of history are not interleaved, the way
+// git's --topo-order does. commits must be newest first.
+func topoOrder(commits []*Commit) []*Commit {
+	byHash := make(map[string]*Commit, len(commits))
+	for _, c := range commits {
+		byHash[c.Hash] = c
+	}
+	children := map[string]int{}
+	for _, c := range commits {
+		for _, p := range c.Parents {
+			if _, ok := byHash[p]; ok {
+				children[p]++
+			}
+		}
+	}
+
+	// The stack holds commits whose children have all been shown; the
+	// newest tip is on top.
+	var stack []*Commit
+	for i := len(commits) - 1; i >= 0; i-- {
+		if children[commits[i].Hash] == 0 {
+			stack = append(stack, commits[i])
+		}
+	}
+	ordered := make([]*Commit, 0, len(commits))
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		ordered = append(ordered, c)
+		for _, p := range c.Parents {
+			parent, ok := byHash[p]
+			if !ok {
+				continue
+			}
+			children[p]--
+			if children[p] == 0 {
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return ordered
+}
+
+// graph tracks the lanes of an ASCII commit graph. Each column holds the
+// commit that the lane is waiting to reach.
+type graph struct {
+	columns []string
+}
+
+// graphLane is a line of history moving between columns after a commit.
+type graphLane struct {
+	pos    int
+	target int
+}
+
+// next places commit c in the graph and returns the line containing its
+// "*" plus the lines that fan out to its parents and collapse lanes that
+// now lead to the same commit.
+func (g *graph) next(c *Commit) (string, []string) {
+	idx := -1
+	for i, h := range g.columns {
+		if h == c.Hash {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		g.columns = append(g.columns, c.Hash)
+		idx = len(g.columns) - 1
+	}
+
+	// An octopus merge spreads its node into "*-." or "*---." and so on
+	// so each extra parent gets its own edge, pushing the lanes to its
+	// right over.
+	extra := max(len(c.Parents)-2, 0)
+	commitLine := newGraphLine(len(g.columns) + extra)
+	for i := range g.columns {
+		switch {
+		case i < idx:
+			commitLine[2*i] = '|'
+		case i == idx:
+			commitLine[2*i] = '*'
+			for k := 1; k < 2*extra; k++ {
+				commitLine[2*i+k] = '-'
+			}
+			if extra > 0 {
+				commitLine[2*i+2*extra] = '.'
+			}
+		default:
+			commitLine[2*(i+extra)] = '|'
+		}
+	}
+
+	// Replace the commit's lane with one lane per parent, then work out
+	// where each lane ends up once duplicates are merged.
+	var expanded []string
+	expanded = append(expanded, g.columns[:idx]...)
+	expanded = append(expanded, c.Parents...)
+	expanded = append(expanded, g.columns[idx+1:]...)
+	var columns []string
+	target := map[string]int{}
+	for _, h := range expanded {
+		if _, ok := target[h]; !ok {
+			target[h] = len(columns)
+			columns = append(columns, h)
+		}
+	}
+
+	lanes := make([]graphLane, len(expanded))
+	for i, h := range expanded {
+		lanes[i] = graphLane{pos: i, target: target[h]}
+	}
+
+	var after []string
+	if len(c.Parents) > 1 {
+		// Fan out: extra parents branch off to the right of the commit and
+		// the lanes beyond them step over, leaving every lane at its
+		// position in expanded.
+		line := newGraphLine(len(expanded))
+		for i := range g.columns {
+			switch {
+			case i < idx:
+				line[2*i] = '|'
+			case i == idx:
+				line[2*i] = '|'
+				for k := 0; k < len(c.Parents)-1; k++ {
+					line[2*i+2*k+1] = '\\'
+				}
+			default:
+				line[2*(i+extra)+1] = '\\'
+			}
+		}
+		after = append(after, string(line))
+	} else if len(c.Parents) == 0 {
+		// The lane ends here; everything to its right shifts left.
+		for i := idx; i < len(lanes); i++ {
+			lanes[i].pos = i + 1
+		}
+	}
+
+	for {
+		moving := false
+		for _, l := range lanes {
+			if l.pos != l.target {
+				moving = true
+			}
+		}
+		if !moving {
+			break
+		}
+		width := 0
+		for _, l := range lanes {
+			if l.pos+1 > width {
+				width = l.pos + 1
+			}
+		}
+		line := newGraphLine(width)
+		for i := range lanes {
+			l := &lanes[i]
+			switch {
+			case l.pos == l.target:
+				line[2*l.pos] = '|'
+			case l.pos < l.target:
+				line[2*l.pos+1] = '\\'
+				l.pos++
+			default:
+				line[2*l.pos-1] = '/'
+				l.pos--
+			}
+		}
+		after = append(after, string(line))
+	}
+
+	g.columns = columns
+	return string(commitLine), after
+}
+
+// padding returns the graph prefix for lines between commits: a "| "
+// for each lane, the trailing space included, as git writes it.
+func (g *graph) padding() string {
+	return strings.Repeat("| ", len(g.columns))
+}
+
+func newGraphLine(columns int) []byte {
+	line := make([]byte, 2*columns)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// write places c in the graph and writes text, the commit's formatted
+// output, with the graph drawn to its left. Lines beyond the ones needed
+// to reach the parents are prefixed with the resulting lanes. With
+// separate set a line of the lanes c is reached by comes first, as wide
+// as c's prefix, as git pads it.
+func (g *graph) write(w io.Writer, c *Commit, text string, separate bool) {
+	separator := g.padding()
+	commitLine, after := g.next(c)
+	prefixes := append([]string{commitLine}, after...)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for len(prefixes) < len(lines) {
+		prefixes = append(prefixes, g.padding())
+	}
+	width := 0
+	for _, p := range prefixes {
+		width = max(width, len(strings.TrimRight(p, " ")))
+	}
+	if width%2 == 0 {
+		width++ // a lane mid-move ends in a slash; keep columns aligned
+	}
+	if separate {
+		fmt.Fprintf(w, "%-*s\n", width+1, separator)
+	}
+	for i, p := range prefixes {
+		p = strings.TrimRight(p, " ")
+		if i < len(lines) {
+			fmt.Fprintf(w, "%-*s %s\n", width, p, lines[i])
+		} else {
+			fmt.Fprintln(w, p)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// objectFormat is a hash function objects can be named with. Only the
+// names change between formats; headers and storage stay the same.
+type objectFormat struct {
+	name    string
+	size    int // raw hash length in bytes
+	newHash func() hash.Hash
+}
+
+var (
+	sha1Format   = objectFormat{"sha1", sha1.Size, sha1.New}
+	sha256Format = objectFormat{"sha256", sha256.Size, sha256.New}
+)
+
+// hexLen is the length of an object name in this format.
+func (f objectFormat) hexLen() int {
+	return 2 * f.size
+}
+
+// parseObjectFormat looks up a format by its extensions.objectFormat name.
+func parseObjectFormat(name string) (objectFormat, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return sha1Format, nil
+	case "sha256":
+		return sha256Format, nil
+	}
+	return objectFormat{}, fmt.Errorf("unknown object format %q", name)
+}
+
+// repoObjectFormat caches the repository's object format for this run.
+var repoObjectFormat *objectFormat
+
+// repoFormat returns the format selected by extensions.objectFormat,
+// defaulting to SHA-1.
+func repoFormat() objectFormat {
+	if repoObjectFormat == nil {
+		f := sha1Format
+		if cfg, err := readConfig(); err == nil {
+			if name, ok := cfg.get("extensions", "", "objectformat"); ok {
+				if parsed, err := parseObjectFormat(name); err == nil {
+					f = parsed
+				}
+			}
+		}
+		repoObjectFormat = &f
+	}
+	return *repoObjectFormat
+}
+
+// overrideObjectFormat makes the format named name the one objects are
+// hashed with for the rest of this run, whatever the repository is
+// configured for, as the --object-format option of plumbing commands
+// asks. A command that writes objects passes write, and may then only
+// name the repository's format: its store holds names of that one.
+func overrideObjectFormat(name string, write bool) error {
+	f, err := parseObjectFormat(name)
+	if err != nil {
+		return err
+	}
+	if repo := repoFormat(); write && f.name != repo.name {
+		return fmt.Errorf("cannot write %s objects to a %s repository", f.name, repo.name)
+	}
+	repoObjectFormat = &f
+	return nil
+}
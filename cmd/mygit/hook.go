@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errHookFailed ends a command whose hook refused it; the hook has
+// already said why.
+var errHookFailed = errors.New("hook failed")
+
+// hookPath returns where hook name lives: in core.hooksPath if that is
+// set, otherwise in the repository's hooks directory.
+func hookPath(name string) string {
+	if cfg, err := readConfig(); err == nil {
+		if dir, ok := cfg.get("core", "", "hookspath"); ok && dir != "" {
+			if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+				if home, err := os.UserHomeDir(); err == nil {
+					dir = filepath.Join(home, rest)
+				}
+			}
+			return filepath.Join(dir, name)
+		}
+	}
+	return gitPath("hooks/" + name)
+}
+
+// runHook runs hook name with args from the top of the working tree, if
+// the hook exists, with the environment of this process plus
+// GIT_INDEX_FILE. Its output goes to stderr and it reads nothing. A hook
+// that is present but not executable is skipped with git's hint, and one
+// that exits non-zero yields errHookFailed.
+func runHook(name string, args ...string) error {
+	path := hookPath(name)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&0o111 == 0 {
+		fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n", path)
+		fmt.Fprintf(os.Stderr, "hint: You can disable this warning with `git config advice.ignoredHook false`.\n")
+		return nil
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+indexPath())
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return errHookFailed
+		}
+		return fmt.Errorf("cannot run %s: %w", path, err)
+	}
+	return nil
+}
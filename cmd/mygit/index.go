@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexEntry is one path in the index, with the stat data git uses to
+// tell whether the working tree file has changed since it was staged.
+type indexEntry struct {
+	ctime time.Time
+	mtime time.Time
+	dev   uint32
+	ino   uint32
+	mode  uint32
+	uid   uint32
+	gid   uint32
+	size  uint32
+	hash  string
+	flags uint16
+	ext   uint16 // version 3 extended flags, such as indexSkipWorktree
+	path  string
+}
+
+// index is the parsed .git/index, with entries sorted by path.
+type index struct {
+	entries []*indexEntry
+	mtime   time.Time // when the index file was written; zero if none exists
+}
+
+const indexNameMask = 0x0fff
+
+// indexExtended in an entry's flags says extended flags follow them.
+// Of those, indexSkipWorktree marks a path sparse checkout leaves out of
+// the working tree, whose index entry stands in for the missing file.
+const (
+	indexExtended     = 0x4000
+	indexSkipWorktree = 0x4000
+)
+
+// indexAssumeValid in an entry's flags is git's assume-unchanged bit:
+// the working tree file is taken to match the entry without looking.
+const indexAssumeValid = 0x8000
+
+// indexStageShift places an entry's merge stage in its flags. Stage 0
+// is a resolved path; a conflicted path instead has an entry for each
+// side it exists on: 1 for the merge base, 2 for ours, 3 for theirs.
+const indexStageShift = 12
+
+// stage returns the merge stage of e.
+func (e *indexEntry) stage() int {
+	return int(e.flags>>indexStageShift) & 3
+}
+
+// skipWorktree reports whether e's file is left out of the working tree.
+func (e *indexEntry) skipWorktree() bool {
+	return e.ext&indexSkipWorktree != 0
+}
+
+// assumeUnchanged reports whether e's file is taken to be unchanged.
+func (e *indexEntry) assumeUnchanged() bool {
+	return e.flags&indexAssumeValid != 0
+}
+
+// unexamined reports whether commands comparing the index with the
+// working tree leave e's file alone: a submodule, a path sparse
+// checkout left out, or a file assumed unchanged.
+func (e *indexEntry) unexamined() bool {
+	return e.mode == 0o160000 || e.skipWorktree() || e.assumeUnchanged()
+}
+
+// indexPath is where the index lives: GIT_INDEX_FILE if it is set, so
+// tools can stage into an index of their own, and the git directory's
+// index otherwise.
+func indexPath() string {
+	if path := os.Getenv("GIT_INDEX_FILE"); path != "" {
+		return path
+	}
+	return gitPath("index")
+}
+
+// readIndex parses a version 2 or 3 index, returning an empty index if
+// none exists yet. Extensions are skipped.
+func readIndex() (*index, error) {
+	return readIndexFile(indexPath())
+}
+
+// readIndexFile is readIndex for the index file at path.
+func readIndexFile(path string) (*index, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := repoFormat()
+	if len(data) < 12+f.size || string(data[:4]) != "DIRC" {
+		return nil, errors.New("index file corrupt: bad signature")
+	}
+	h := f.newHash()
+	h.Write(data[:len(data)-f.size])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-f.size:]) {
+		return nil, errors.New("index file corrupt: bad checksum")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("index version %d is not supported", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	idx := &index{}
+	if info, err := os.Stat(path); err == nil {
+		idx.mtime = info.ModTime()
+	}
+	pos := 12
+	end := len(data) - f.size
+	flagsAt := 40 + f.size // the stat fields are followed by the object name
+	for i := uint32(0); i < count; i++ {
+		if pos+flagsAt+2 > end {
+			return nil, errors.New("index file corrupt: truncated entry")
+		}
+		b := data[pos:]
+		u32 := func(off int) uint32 { return binary.BigEndian.Uint32(b[off:]) }
+		e := &indexEntry{
+			ctime: time.Unix(int64(u32(0)), int64(u32(4))),
+			mtime: time.Unix(int64(u32(8)), int64(u32(12))),
+			dev:   u32(16),
+			ino:   u32(20),
+			mode:  u32(24),
+			uid:   u32(28),
+			gid:   u32(32),
+			size:  u32(36),
+			hash:  hex.EncodeToString(b[40:flagsAt]),
+			flags: binary.BigEndian.Uint16(b[flagsAt:]),
+		}
+		nameStart := flagsAt + 2
+		if e.flags&indexExtended != 0 {
+			if pos+nameStart+2 > end {
+				return nil, errors.New("index file corrupt: truncated entry")
+			}
+			e.ext = binary.BigEndian.Uint16(b[nameStart:])
+			nameStart += 2
+		}
+		if pos+nameStart > end {
+			return nil, errors.New("index file corrupt: truncated entry")
+		}
+		nul := bytes.IndexByte(b[nameStart:end-pos], 0)
+		if nul < 0 {
+			return nil, errors.New("index file corrupt: unterminated path")
+		}
+		e.path = string(b[nameStart : nameStart+nul])
+		// Entries are NUL-padded to a multiple of eight bytes.
+		pos += (nameStart + nul + 8) &^ 7
+		idx.entries = append(idx.entries, e)
+	}
+	return idx, nil
+}
+
+// write stores the index as version 2, or as version 3 when an entry
+// has extended flags, dropping any extensions. As in git it goes through
+// index.lock, and fails if another command holds that.
+func (idx *index) write() error {
+	idx.sort()
+	f := repoFormat()
+	version := uint32(2)
+	for _, e := range idx.entries {
+		if e.ext != 0 {
+			version = 3
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.entries)))
+	for _, e := range idx.entries {
+		raw, err := hex.DecodeString(e.hash)
+		if err != nil || len(raw) != f.size {
+			return fmt.Errorf("index entry %s has invalid object name %q", e.path, e.hash)
+		}
+		start := buf.Len()
+		for _, v := range []uint32{
+			uint32(e.ctime.Unix()), uint32(e.ctime.Nanosecond()),
+			uint32(e.mtime.Unix()), uint32(e.mtime.Nanosecond()),
+			e.dev, e.ino, e.mode, e.uid, e.gid, e.size,
+		} {
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+		buf.Write(raw)
+		nameLen := min(len(e.path), indexNameMask)
+		flags := e.flags&^(indexNameMask|indexExtended) | uint16(nameLen)
+		if e.ext != 0 {
+			binary.Write(&buf, binary.BigEndian, flags|indexExtended)
+			binary.Write(&buf, binary.BigEndian, e.ext)
+		} else {
+			binary.Write(&buf, binary.BigEndian, flags)
+		}
+		buf.WriteString(e.path)
+		for n := buf.Len() - start; ; n++ {
+			buf.WriteByte(0)
+			if (n+1)%8 == 0 {
+				break
+			}
+		}
+	}
+	h := f.newHash()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return writeLocked(indexPath(), buf.Bytes(), "index")
+}
+
+func (idx *index) sort() {
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		a, b := idx.entries[i], idx.entries[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.stage() < b.stage()
+	})
+}
+
+// unmerged returns the paths the index holds conflicts for, in order.
+func (idx *index) unmerged() []string {
+	var paths []string
+	for _, e := range idx.entries {
+		if e.stage() != 0 && (len(paths) == 0 || paths[len(paths)-1] != e.path) {
+			paths = append(paths, e.path)
+		}
+	}
+	return paths
+}
+
+// entry returns the entry for path, or nil. Of a conflicted path's
+// entries it returns the lowest stage.
+func (idx *index) entry(path string) *indexEntry {
+	i := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].path >= path })
+	if i < len(idx.entries) && idx.entries[i].path == path {
+		return idx.entries[i]
+	}
+	return nil
+}
+
+// upToDate reports whether the file info describes, stat data still
+// matching, the content e was staged from, so that it need not be read
+// again. As in git, the mode, size, modification time, inode and owner
+// must all match. A file changed no earlier than the index was written
+// may have changed again within the same timestamp, and is never up to
+// date.
+func (idx *index) upToDate(e *indexEntry, info os.FileInfo) bool {
+	if idx.mtime.IsZero() || !info.ModTime().Before(idx.mtime) {
+		return false
+	}
+	mode := uint32(0o100644)
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		mode = 0o120000
+	case !info.Mode().IsRegular():
+		return false
+	case info.Mode()&0o111 != 0:
+		mode = 0o100755
+	}
+	var now indexEntry
+	fillStat(&now, info)
+	return mode == e.mode && uint32(info.Size()) == e.size && info.ModTime().Equal(e.mtime) &&
+		now.ino == e.ino && now.uid == e.uid && now.gid == e.gid
+}
+
+// lsFilesOptions are the flags of `mygit ls-files`.
+type lsFilesOptions struct {
+	nul      bool   // end paths with NUL rather than newline
+	stage    bool   // show "<mode> <hash> <stage>" before each path
+	unmerged bool   // show only conflicted entries, as with stage
+	tags     bool   // start each line with its status tag, as -v does
+	prefix   string // list only paths below this directory, named from it
+	fullName bool   // name paths from the top even with a prefix
+}
+
+// lsFiles implements `mygit ls-files [-z] [-s] [-u] [-v] [--full-name]`, listing the
+// paths in the index, each ended by a newline or, with nul, a NUL. As
+// in git, a conflicted path is listed once for each of its stages. Only
+// newline-ended paths are quoted. With tags each line starts with "H "
+// for a cached file, "S " for one with skip-worktree set or "M " for an
+// unmerged one, the letter lowercase if the file is assumed unchanged.
+// Run in a subdirectory, only the paths below it are listed, named from
+// there unless with --full-name.
+func lsFiles(w io.Writer, opts lsFilesOptions) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	stage := opts.stage || opts.unmerged
+	for _, e := range idx.entries {
+		if opts.unmerged && e.stage() == 0 || !strings.HasPrefix(e.path, opts.prefix) {
+			continue
+		}
+		path := e.path
+		if !opts.fullName {
+			path = strings.TrimPrefix(path, opts.prefix)
+		}
+		if opts.tags {
+			tag := "H"
+			switch {
+			case e.stage() != 0:
+				tag = "M"
+			case e.skipWorktree():
+				tag = "S"
+			}
+			if e.assumeUnchanged() {
+				tag = strings.ToLower(tag)
+			}
+			fmt.Fprint(w, tag, " ")
+		}
+		if stage {
+			fmt.Fprintf(w, "%06o %s %d\t", e.mode, e.hash, e.stage())
+		}
+		if opts.nul {
+			fmt.Fprint(w, path, "\x00")
+		} else {
+			fmt.Fprintln(w, quotePath(path))
+		}
+	}
+	return nil
+}
+
+// updateIndex implements `mygit update-index [--[no-]assume-unchanged]
+// [--[no-]skip-worktree] <path>...`: each flag sets or clears its bit on
+// the entries of the paths that follow it, though, as in git, a path
+// that follows both only has its assume-unchanged bit changed. Status,
+// diff and stash take a file assumed unchanged to be as staged, and one
+// with skip-worktree set to be absent on purpose, without looking at it.
+// As in git, one path missing from the index leaves every entry as it
+// was.
+func updateIndex(args []string) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	var assume, skip *bool
+	for _, arg := range args {
+		on := !strings.HasPrefix(arg, "--no-")
+		switch arg {
+		case "--assume-unchanged", "--no-assume-unchanged":
+			assume = &on
+			continue
+		case "--skip-worktree", "--no-skip-worktree":
+			skip = &on
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unknown option '%s'", strings.TrimLeft(arg, "-"))
+		}
+		if assume == nil && skip == nil {
+			return fmt.Errorf("%s: staging files is not supported; give --[no-]assume-unchanged or --[no-]skip-worktree", arg)
+		}
+		e := idx.entry(arg)
+		if e == nil {
+			return fmt.Errorf("Unable to mark file %s", arg)
+		}
+		if assume != nil {
+			e.flags &^= indexAssumeValid
+			if *assume {
+				e.flags |= indexAssumeValid
+			}
+		} else {
+			e.ext &^= indexSkipWorktree
+			if *skip {
+				e.ext |= indexSkipWorktree
+			}
+		}
+	}
+	return idx.write()
+}
+
+// conflicts implements `mygit conflicts [-z]`, listing the paths still
+// unmerged in the index: for each, the stages it has, "1" for the merge
+// base, "2" for ours and "3" for theirs, then a tab and the path. A path
+// added on both sides is "23"; one deleted by them is "12". As with
+// ls-files, nul ends each line with a NUL and leaves the path unquoted.
+func conflicts(w io.Writer, nul bool) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(idx.entries); {
+		e := idx.entries[i]
+		if e.stage() == 0 {
+			i++
+			continue
+		}
+		stages := ""
+		for ; i < len(idx.entries) && idx.entries[i].path == e.path; i++ {
+			stages += fmt.Sprint(idx.entries[i].stage())
+		}
+		if nul {
+			fmt.Fprintf(w, "%s\t%s\x00", stages, e.path)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", stages, quotePath(e.path))
+		}
+	}
+	return nil
+}
+
+// add inserts e, replacing any entry for the same path. Adding a stage
+// 0 entry for a conflicted path resolves it, replacing every stage.
+func (idx *index) add(e *indexEntry) {
+	i := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].path >= e.path })
+	j := i
+	for j < len(idx.entries) && idx.entries[j].path == e.path {
+		j++
+	}
+	if j > i {
+		idx.entries[i] = e
+		idx.entries = append(idx.entries[:i+1], idx.entries[j:]...)
+		return
+	}
+	idx.entries = append(idx.entries, nil)
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = e
+}
+
+// newIndexEntry builds an entry for the working tree file at path, which
+// must already hold the content of hash.
+func newIndexEntry(path, hash string, mode uint32) (*indexEntry, error) {
+	e := &indexEntry{path: path, hash: hash, mode: mode}
+	info, err := os.Lstat(filepath.FromSlash(path))
+	if err != nil {
+		if mode == 0o160000 {
+			return e, nil // submodules have no stat data worth keeping
+		}
+		return nil, err
+	}
+	e.mtime = info.ModTime()
+	e.ctime = info.ModTime()
+	e.size = uint32(info.Size())
+	fillStat(e, info)
+	return e, nil
+}
+
+// parseMode converts a tree entry mode such as "100644" to its numeric form.
+func parseMode(mode string) uint32 {
+	m, _ := strconv.ParseUint(mode, 8, 32)
+	return uint32(m)
+}
+
+// formatMode is the inverse of parseMode, using a tree's spelling of
+// directory modes.
+func formatMode(mode uint32) string {
+	return strconv.FormatUint(uint64(mode), 8)
+}
+
+// indexFromTree builds an index matching the tree, reusing stat data
+// from old for entries that did not change.
+func indexFromTree(treeHash string, old *index) (*index, error) {
+	files, err := flattenTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	idx := &index{}
+	for _, path := range sortedPaths(files) {
+		te := files[path]
+		mode := parseMode(te.Mode)
+		if prev := old.entry(path); prev != nil && prev.hash == te.Hash && prev.mode == mode {
+			idx.entries = append(idx.entries, prev)
+			continue
+		}
+		e, err := newIndexEntry(path, te.Hash, mode)
+		if err != nil {
+			return nil, err
+		}
+		idx.entries = append(idx.entries, e)
+	}
+	return idx, nil
+}
+
+// writeIndexTree writes tree objects for the index contents and returns
+// the name of the root tree. An index with conflicts left in it has no
+// tree.
+func writeIndexTree(entries []*indexEntry) (string, error) {
+	files, err := indexTreeFiles(entries)
+	if err != nil {
+		return "", err
+	}
+	return writeTreeFiles(files)
+}
+
+// indexTreeFiles maps the path of each index entry to the tree entry it
+// is written as.
+func indexTreeFiles(entries []*indexEntry) (map[string]TreeEntry, error) {
+	files := make(map[string]TreeEntry, len(entries))
+	for _, e := range entries {
+		if e.stage() != 0 {
+			return nil, fmt.Errorf("%s: unmerged (%s)", e.path, e.hash)
+		}
+		files[e.path] = TreeEntry{Mode: formatMode(e.mode), Hash: e.hash}
+	}
+	return files, nil
+}
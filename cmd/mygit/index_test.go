@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIndexLock checks that the index is written through index.lock: a
+// command finding the lock held fails and leaves the index alone, and
+// one that succeeds leaves no lock behind.
+func TestIndexLock(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.mygit("add", "README")
+	index := filepath.Join(r.dir, ".git", "index")
+	before, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := index + ".lock"
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr := r.mygitFails("add", "run.sh")
+	if want := "Unable to create '" + lock + "': File exists."; !strings.Contains(stderr, want) {
+		t.Errorf("add with index.lock held: stderr %q, want %q", stderr, want)
+	}
+	if after, err := os.ReadFile(index); err != nil || string(after) != string(before) {
+		t.Errorf("add with index.lock held changed the index")
+	}
+
+	if err := os.Remove(lock); err != nil {
+		t.Fatal(err)
+	}
+	r.mygit("add", "run.sh")
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("index.lock left behind: %v", err)
+	}
+	if got := r.git("ls-files"); got != "README\nrun.sh\n" {
+		t.Errorf("git ls-files = %q", got)
+	}
+}
+
+// TestGitIndexFile stages into an index named by GIT_INDEX_FILE: add,
+// ls-files, write-tree, status and commit all use it, agree with git
+// using the same index, and leave .git/index as it was.
+func TestGitIndexFile(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "README")
+	r.git("commit", "-q", "-m", "root")
+	index := filepath.Join(r.dir, ".git", "index")
+	before, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alt := "GIT_INDEX_FILE=" + filepath.Join(t.TempDir(), "alt-index")
+	mygitAlt := func(args ...string) string {
+		t.Helper()
+		return r.run("", os.Args[0], args, "MYGIT_TEST_MAIN=1", alt)
+	}
+	mygitAlt("read-tree", "HEAD")
+	mygitAlt("add", "run.sh", "src")
+	for _, args := range [][]string{
+		{"ls-files", "-s"},
+		{"write-tree"},
+		{"status", "--porcelain"},
+	} {
+		if got, want := mygitAlt(args...), r.run("", "git", args, alt); got != want {
+			t.Errorf("%s with GIT_INDEX_FILE:\ngit:   %q\nmygit: %q", strings.Join(args, " "), want, got)
+		}
+	}
+	mygitAlt("commit", "-m", "from the other index")
+	if got := r.git("ls-tree", "-r", "--name-only", "HEAD"); got != "README\nrun.sh\nsrc/lib/deep.txt\nsrc/main.go\n" {
+		t.Errorf("the commit holds %q", got)
+	}
+	if after, err := os.ReadFile(index); err != nil || string(after) != string(before) {
+		t.Errorf(".git/index changed")
+	}
+	if got := r.mygit("ls-files"); got != "README\n" {
+		t.Errorf("ls-files without GIT_INDEX_FILE = %q", got)
+	}
+}
+
+// TestStatCache checks that status trusts matching stat data as git
+// does: with core.trustctime off, a file rewritten in place with the same
+// size and modification time is taken to be unchanged without being read,
+// by mygit and git alike, until its modification time moves. A file
+// modified no earlier than the index was written is always read.
+func TestStatCache(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.git("config", "core.trustctime", "false")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"a", "b"} {
+		r.write(name, name+" one\n", 0o644)
+		if err := os.Chtimes(filepath.Join(r.dir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+
+	r.write("a", "a two\n", 0o644)
+	if err := os.Chtimes(filepath.Join(r.dir, "a"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.same("status", "--porcelain"); got != "" {
+		t.Errorf("status read a file whose stat data matched: %q", got)
+	}
+	if err := os.Chtimes(filepath.Join(r.dir, "a"), past, past.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.same("status", "--porcelain"); got != " M a\n" {
+		t.Errorf("status after a's mtime moved = %q", got)
+	}
+
+	// A file whose modification time is not before the index's may have
+	// changed again within the same timestamp.
+	r.git("checkout", "a")
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	r.write("b", "b two\n", 0o644)
+	if err := os.Chtimes(filepath.Join(r.dir, "b"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	r.git("add", "b")
+	r.write("b", "b six\n", 0o644)
+	if err := os.Chtimes(filepath.Join(r.dir, "b"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.same("status", "--porcelain"); got != "MM b\n" {
+		t.Errorf("status with b changed after the index = %q", got)
+	}
+}
+
+// TestIndexBits sets and clears the assume-unchanged and skip-worktree
+// bits with update-index, checking against git the bits ls-files -v
+// shows, that status and diff take such files as staged whatever is in
+// the working tree, and that a path missing from the index leaves every
+// entry alone. As in git, a path after both flags only has its
+// assume-unchanged bit changed.
+func TestIndexBits(t *testing.T) {
+	r := newGoldenRepo(t)
+	for _, name := range []string{"a", "b", "c"} {
+		r.write(name, name+"\n", 0o644)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("a", "a changed\n", 0o644)
+	os.Remove(filepath.Join(r.dir, "b"))
+
+	state := func() string {
+		return r.git("ls-files", "-v") + r.git("status", "--porcelain")
+	}
+	steps := [][]string{
+		{"update-index", "--assume-unchanged", "a"},
+		{"update-index", "--skip-worktree", "b"},
+		{"update-index", "--assume-unchanged", "a", "--skip-worktree", "b", "c"},
+		{"update-index", "--assume-unchanged", "c", "nosuch"},
+	}
+	for _, args := range steps {
+		undo := r.snapshot()
+		r.sameRun(undo, state, args...)
+		undo()
+	}
+
+	r.mygit("update-index", "--assume-unchanged", "a")
+	r.mygit("update-index", "--skip-worktree", "b")
+	if got := r.same("ls-files", "-v"); got != "h a\nS b\nH c\n" {
+		t.Errorf("ls-files -v with both bits set = %q", got)
+	}
+	r.same("status", "--porcelain")
+	r.same("diff")
+	r.mygit("update-index", "--no-assume-unchanged", "a")
+	r.mygit("update-index", "--no-skip-worktree", "b")
+	if got := r.same("status", "--porcelain"); got != " M a\n D b\n" {
+		t.Errorf("status with both bits cleared = %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonArray writes a JSON array to w one element at a time, so a long
+// listing is never held in memory whole.
+type jsonArray struct {
+	w io.Writer
+	n int
+}
+
+// add appends v to the array.
+func (a *jsonArray) add(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if a.n == 0 {
+		sep = "[\n"
+	}
+	a.n++
+	_, err = io.WriteString(a.w, sep+"  "+string(data))
+	return err
+}
+
+// close ends the array.
+func (a *jsonArray) close() error {
+	end := "\n]\n"
+	if a.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
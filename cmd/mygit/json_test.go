@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestJSONOutput decodes ls-tree's and log's --format=json output and
+// checks it against what git reports for the same entries and commits,
+// names with special characters and a merge included.
+func TestJSONOutput(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.write("dir/tab\there \"quoted\"", "odd\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "odd name", "-m", "with a body")
+	r.git("merge", "-q", "--no-edit", "topic")
+
+	for _, args := range [][]string{{"HEAD"}, {"-r", "HEAD"}, {"-r", "-t", "HEAD"}} {
+		var want []treeEntryJSON
+		for _, rec := range strings.Split(strings.TrimSuffix(r.git(append([]string{"ls-tree", "-z"}, args...)...), "\x00"), "\x00") {
+			info, path, _ := strings.Cut(rec, "\t")
+			f := strings.Fields(info)
+			want = append(want, treeEntryJSON{Mode: f[0], Type: f[1], SHA: f[2], Path: path})
+		}
+		var got []treeEntryJSON
+		if err := json.Unmarshal([]byte(r.mygit(append([]string{"ls-tree", "--format=json"}, args...)...)), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ls-tree --format=json %s:\ngit:   %+v\nmygit: %+v", strings.Join(args, " "), want, got)
+		}
+	}
+
+	want := []commitJSON{}
+	format := "--format=%H%x01%P%x01%an%x01%ae%x01%aI%x01%cn%x01%ce%x01%cI%x01%B"
+	for _, rec := range strings.Split(strings.TrimSuffix(r.git("log", "-z", format), "\x00"), "\x00") {
+		f := strings.Split(rec, "\x01")
+		parents := strings.Fields(f[1])
+		if parents == nil {
+			parents = []string{}
+		}
+		want = append(want, commitJSON{f[0], parents, signatureJSON{f[2], f[3], f[4]}, signatureJSON{f[5], f[6], f[7]}, f[8]})
+	}
+	var got []commitJSON
+	if err := json.Unmarshal([]byte(r.mygit("log", "--format=json")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("log --format=json:\ngit:   %+v\nmygit: %+v", want, got)
+	}
+	if got := r.mygit("log", "--format=json", "-n", "0"); got != "[]\n" {
+		t.Errorf("log --format=json of no commits printed %q", got)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localRepo is a repository on this machine fetched from by reading its
+// files: its git directory and the store of its objects.
+type localRepo struct {
+	dir    string
+	store  ObjectStore
+	format objectFormat
+}
+
+// localRemotePath returns the path of the repository url names if it is
+// on this machine, either as a file:// URL, reported by fileURL, or as a
+// plain path. As in git, "host:path" with no slash before the colon is
+// a remote host, not a path.
+func localRemotePath(url string) (path string, fileURL, ok bool) {
+	if path, ok := strings.CutPrefix(url, "file://"); ok {
+		return path, true, true
+	}
+	if strings.Contains(url, "://") {
+		return "", false, false
+	}
+	if colon := strings.IndexByte(url, ':'); colon >= 0 {
+		if slash := strings.IndexByte(url, '/'); slash < 0 || colon < slash {
+			return "", false, false
+		}
+	}
+	return url, false, true
+}
+
+// openLocalRemote opens the repository at url if it is on this machine,
+// a working tree with its .git directory or a bare repository, and
+// returns nil if url is not a local one. Objects are read from its
+// loose objects, its packs and its alternates, and named in the object
+// format its config selects.
+func openLocalRemote(url string) (*localRepo, error) {
+	path, _, ok := localRemotePath(url)
+	if !ok {
+		return nil, nil
+	}
+	for _, dir := range []string{filepath.Join(path, ".git"), path} {
+		head, err := os.Stat(filepath.Join(dir, "HEAD"))
+		if err != nil || head.IsDir() {
+			continue
+		}
+		objects := filepath.Join(dir, "objects")
+		if info, err := os.Stat(objects); err != nil || !info.IsDir() {
+			continue
+		}
+		seen := map[string]bool{}
+		if abs, err := filepath.Abs(objects); err == nil {
+			seen[abs] = true
+		}
+		stores := multiStore{looseStore{objects}, packStore{objects}}
+		stores = append(stores, alternateStores(objects, seen, 0)...)
+		r := &localRepo{dir: dir, store: stores, format: sha1Format}
+		if cfg, err := readConfigFile(filepath.Join(dir, "config")); err == nil {
+			if name, ok := cfg.get("extensions", "", "objectformat"); ok {
+				if r.format, err = parseObjectFormat(name); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("repository '%s' does not exist", url)
+}
+
+// readRef is readRef for a ref of the local repository, loose or packed,
+// symbolic refs followed.
+func (r *localRepo) readRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) && strings.HasPrefix(name, "refs/") {
+			packed, perr := readPackedRefsFile(filepath.Join(r.dir, "packed-refs"))
+			if perr != nil {
+				return "", perr
+			}
+			if hash, ok := packed[name]; ok {
+				return hash, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(value, "ref: ") {
+			return value, nil
+		}
+		name = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("symbolic ref %s nests too deeply", name)
+}
+
+// refs returns what upload-pack would advertise for the local
+// repository: HEAD, then every ref by name, each annotated tag followed
+// by what it peels to as "<tag>^{}", and a symref capability naming the
+// branch HEAD is on. Refs that point nowhere are left out.
+func (r *localRepo) refs() (*remoteRefs, error) {
+	refs, err := readPackedRefsFile(filepath.Join(r.dir, "packed-refs"))
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(filepath.Join(r.dir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if hash, err := r.readRef(name); err == nil {
+			refs[name] = hash
+		} else {
+			delete(refs, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	adv := &remoteRefs{refs: map[string]string{}, caps: map[string]string{}}
+	if head, err := r.readRef("HEAD"); err == nil {
+		adv.names = append(adv.names, "HEAD")
+		adv.refs["HEAD"] = head
+		if data, err := os.ReadFile(filepath.Join(r.dir, "HEAD")); err == nil {
+			if target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: "); ok {
+				adv.caps["symref"] = "HEAD:" + target
+			}
+		}
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		adv.names = append(adv.names, name)
+		adv.refs[name] = refs[name]
+		if peeled := r.peel(refs[name]); peeled != refs[name] {
+			adv.names = append(adv.names, name+"^{}")
+			adv.refs[name+"^{}"] = peeled
+		}
+	}
+	return adv, nil
+}
+
+// peel follows annotated tags from hash to the object they tag, as far
+// as the local repository holds them.
+func (r *localRepo) peel(hash string) string {
+	for depth := 0; depth < 10; depth++ {
+		objType, body, err := r.store.Read(hash)
+		if err != nil || objType != TagObject {
+			break
+		}
+		t, err := parseTag(hash, body)
+		if err != nil {
+			break
+		}
+		hash = t.Object
+	}
+	return hash
+}
+
+// copyObject copies an object of the local repository into this one and
+// returns its type and body.
+func (r *localRepo) copyObject(hash string) (ObjectType, []byte, error) {
+	objType, body, err := r.store.Read(hash)
+	if errors.Is(err, errObjectNotFound) {
+		return 0, nil, fmt.Errorf("%s: object %s is missing", r.dir, hash)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	written, err := writeObject(objType, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if written != hash {
+		return 0, nil, fmt.Errorf("%s: object %s is corrupt", r.dir, hash)
+	}
+	return objType, body, nil
+}
+
+// copyObjects copies into this repository what it lacks of the objects
+// reachable from wants, stopping at commits it already has. With depth,
+// only that many commits down from each want are copied. It returns the
+// commits whose parents were left out, by depth or because the local
+// repository is shallow there, to be recorded as shallow.
+func (r *localRepo) copyObjects(wants []string, depth int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, "shallow"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sourceShallow := map[string]bool{}
+	for _, hash := range strings.Fields(string(data)) {
+		sourceShallow[hash] = true
+	}
+
+	copied := map[string]bool{}
+	var copyTree func(hash string) error
+	copyTree = func(hash string) error {
+		if copied[hash] || hasObject(hash) {
+			return nil
+		}
+		copied[hash] = true
+		if _, _, err := r.copyObject(hash); err != nil {
+			return err
+		}
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch e.Mode {
+			case "40000":
+				if err := copyTree(e.Hash); err != nil {
+					return err
+				}
+			case "160000":
+				// Submodule commits live in another repository.
+			default:
+				if !copied[e.Hash] && !hasObject(e.Hash) {
+					copied[e.Hash] = true
+					if _, _, err := r.copyObject(e.Hash); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	// Commits are walked breadth first, so each is met first at its
+	// least depth.
+	type queued struct {
+		hash  string
+		level int
+	}
+	var queue []queued
+	for _, hash := range wants {
+		queue = append(queue, queued{hash, 1})
+	}
+	var shallow []string
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+		if copied[q.hash] || depth == 0 && hasObject(q.hash) {
+			continue
+		}
+		objType, _, err := r.store.Stat(q.hash)
+		if errors.Is(err, errObjectNotFound) {
+			return nil, fmt.Errorf("%s: object %s is missing", r.dir, q.hash)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if objType == TreeObject {
+			if err := copyTree(q.hash); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		copied[q.hash] = true
+		_, body, err := r.copyObject(q.hash)
+		if err != nil {
+			return nil, err
+		}
+		switch objType {
+		case TagObject:
+			t, err := parseTag(q.hash, body)
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, queued{t.Object, q.level})
+		case CommitObject:
+			c, err := parseCommit(q.hash, body)
+			if err != nil {
+				return nil, err
+			}
+			if err := copyTree(c.Tree); err != nil {
+				return nil, err
+			}
+			switch {
+			case len(c.Parents) == 0:
+			case sourceShallow[q.hash], depth > 0 && q.level >= depth:
+				shallow = append(shallow, q.hash)
+			default:
+				for _, parent := range c.Parents {
+					queue = append(queue, queued{parent, q.level + 1})
+				}
+			}
+		}
+	}
+	return shallow, nil
+}
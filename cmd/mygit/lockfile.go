@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockFile is a held "<path>.lock", through which the file at path is
+// replaced the way git commits a lock file: the lock is created
+// exclusively, so that two writers cannot both hold it, and the new
+// content written to it is renamed over path, so the file is never seen
+// half written. A command that reads the file to change it takes the
+// lock first, so no other writer can change it in between.
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+// lock takes the lock of the file at path. As in git, a lock already
+// held is reported by its absolute path.
+func lock(path string) (*lockFile, error) {
+	name := path + ".lock"
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		if abs, aerr := filepath.Abs(name); aerr == nil {
+			name = abs
+		}
+		return nil, fmt.Errorf("Unable to create '%s': File exists.", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+// commit writes data to the lock and renames it over the file, releasing
+// the lock. Files of the fsync component component are synced before
+// the rename, and their directory after.
+func (l *lockFile) commit(data []byte, component string) error {
+	_, err := l.f.Write(data)
+	if err == nil {
+		err = closeSynced(l.f, component)
+	} else {
+		l.f.Close()
+	}
+	if err == nil {
+		err = os.Rename(l.f.Name(), l.path)
+	}
+	if err != nil {
+		os.Remove(l.f.Name())
+		return err
+	}
+	if fsyncs(component) {
+		return syncDir(filepath.Dir(l.path))
+	}
+	return nil
+}
+
+// rollback releases the lock, leaving the file as it was.
+func (l *lockFile) rollback() {
+	l.f.Close()
+	os.Remove(l.f.Name())
+}
+
+// writeLocked replaces the file at path with data through its lock.
+func writeLocked(path string, data []byte, component string) error {
+	l, err := lock(path)
+	if err != nil {
+		return err
+	}
+	return l.commit(data, component)
+}
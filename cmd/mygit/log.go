@@ -0,0 +1,534 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commitQueue orders commits newest first by committer date.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// walkHistory calls fn for each commit reachable from starts, newest
+// first by committer date, visiting every commit once. Traversal stops
+// at shallow boundaries, whose parents are not present. If fn returns
+// errStopWalk the walk ends and walkHistory returns nil; any other error
+// is returned as is.
+func (ctx *cmdContext) walkHistory(starts []string, fn func(c *Commit) error) error {
+	return walkCommits(starts, ctx.getCommit, fn)
+}
+
+// walkTopology is walkHistory for callers that look at no more of each
+// commit than its name, tree, parents and committer time, which it takes
+// from the commit-graph where it can.
+func (ctx *cmdContext) walkTopology(starts []string, fn func(c *Commit) error) error {
+	return walkCommits(starts, ctx.getCommitNode, fn)
+}
+
+// walkCommits walks history as walkHistory does, loading each commit
+// with get.
+func walkCommits(starts []string, get func(hash string) (*Commit, error), fn func(c *Commit) error) error {
+	seen := map[string]bool{}
+	q := &commitQueue{}
+	for _, hash := range starts {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		c, err := get(hash)
+		if err != nil {
+			return err
+		}
+		heap.Push(q, c)
+	}
+
+	for q.Len() > 0 {
+		c := heap.Pop(q).(*Commit)
+		if err := fn(c); err == errStopWalk {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, parent := range c.Parents {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			p, err := get(parent)
+			if err != nil {
+				return err
+			}
+			heap.Push(q, p)
+		}
+	}
+	return nil
+}
+
+// revList returns the commits reachable from starts, newest first.
+func (ctx *cmdContext) revList(starts []string) ([]*Commit, error) {
+	var commits []*Commit
+	err := ctx.walkHistory(starts, func(c *Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	return commits, err
+}
+
+// ambiguousArgument is git's error for an argument that names neither a
+// revision nor a path.
+func ambiguousArgument(arg string) error {
+	return fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree.\n"+
+		"Use '--' to separate paths from revisions, like this:\n"+
+		"'git <command> [<revision>...] -- [<file>...]'", arg)
+}
+
+// resolveRevs resolves each revision argument, defaulting to HEAD.
+func resolveRevs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	var hashes []string
+	for _, arg := range args {
+		hash, err := resolveObjectName(arg)
+		if errors.Is(err, os.ErrNotExist) {
+			// A symbolic ref, such as HEAD on an unborn branch, with
+			// nothing behind it.
+			return nil, ambiguousArgument(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// printCommit writes c in git log's default medium format, rendering
+// the date in the given --date mode and the commit line in yellow when
+// color is set. A merge's parents are abbreviated unless noAbbrev.
+func printCommit(w io.Writer, c *Commit, dateMode string, color, noAbbrev bool) {
+	fmt.Fprintf(w, "%s\n", paint(color, colorYellow, "commit "+c.Hash))
+	if len(c.Parents) > 1 {
+		var short []string
+		for _, p := range c.Parents {
+			if !noAbbrev {
+				p = p[:7]
+			}
+			short = append(short, p)
+		}
+		fmt.Fprintf(w, "Merge: %s\n", strings.Join(short, " "))
+	}
+	fmt.Fprintf(w, "Author: %s <%s>\n", c.Author.Name, c.Author.Email)
+	fmt.Fprintf(w, "Date:   %s\n\n", formatDate(c.Author.When, dateMode))
+	for _, line := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// writeLogRaw writes what log --raw shows after c: after a blank line,
+// the files c changed from its first parent, or for a root commit from
+// the empty tree, as diff-tree -r prints them. As in git a merge, or a
+// commit that changed nothing, shows nothing.
+func writeLogRaw(w io.Writer, c *Commit, noAbbrev bool) error {
+	if len(c.Parents) > 1 {
+		return nil
+	}
+	parentTree := ""
+	if len(c.Parents) == 1 {
+		parent, err := readCommit(c.Parents[0])
+		if err != nil {
+			return err
+		}
+		parentTree = parent.Tree
+	}
+	opts := diffTreeOptions{recursive: true, abbrev: 7}
+	if noAbbrev {
+		opts.abbrev = 0
+	}
+	changes, err := treeChanges(parentTree, c.Tree, opts)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	fmt.Fprintln(w)
+	return writeTreeChanges(w, changes, opts)
+}
+
+// logOptions controls which commits log prints.
+type logOptions struct {
+	maxCount int // -1 means no limit
+	authors  []*regexp.Regexp
+	greps    []*regexp.Regexp
+	since    time.Time // zero means unbounded
+	until    time.Time
+	date     string // --date format; "" is git's default
+	graph    bool
+	color    string // --color: "always", "never" or "auto"
+	json     bool   // --format=json
+	raw      bool   // --raw: each commit's changes in diff-tree's format
+	noAbbrev bool   // --no-abbrev: whole object names in --raw lines
+}
+
+// signatureJSON is an author or committer in log's JSON output.
+type signatureJSON struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"` // RFC 3339, in the signature's own zone
+}
+
+// commitJSON is a commit in log's --format=json output.
+type commitJSON struct {
+	SHA       string        `json:"sha"`
+	Parents   []string      `json:"parents"`
+	Author    signatureJSON `json:"author"`
+	Committer signatureJSON `json:"committer"`
+	Message   string        `json:"message"`
+}
+
+// newCommitJSON converts c for JSON output.
+func newCommitJSON(c *Commit) commitJSON {
+	sig := func(s Signature) signatureJSON {
+		return signatureJSON{s.Name, s.Email, s.When.Format(time.RFC3339)}
+	}
+	parents := c.Parents
+	if parents == nil {
+		parents = []string{} // a root commit has an empty list, not null
+	}
+	return commitJSON{c.Hash, parents, sig(c.Author), sig(c.Committer), c.Message}
+}
+
+// dateFormats maps --date modes to time layouts; relative, unix and raw
+// are computed instead.
+var dateFormats = map[string]string{
+	"":           "Mon Jan 2 15:04:05 2006 -0700",
+	"default":    "Mon Jan 2 15:04:05 2006 -0700",
+	"iso":        "2006-01-02 15:04:05 -0700",
+	"iso8601":    "2006-01-02 15:04:05 -0700",
+	"iso-strict": "2006-01-02T15:04:05-07:00",
+	"rfc":        "Mon, 2 Jan 2006 15:04:05 -0700",
+	"rfc2822":    "Mon, 2 Jan 2006 15:04:05 -0700",
+	"short":      "2006-01-02",
+	"relative":   "",
+	"unix":       "",
+	"raw":        "",
+}
+
+// formatDate renders t in one of the --date modes, keeping the
+// timezone offset recorded in the object.
+func formatDate(t time.Time, mode string) string {
+	switch mode {
+	case "relative":
+		return relativeDate(t, time.Now())
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "raw":
+		return fmt.Sprintf("%d %s", t.Unix(), t.Format("-0700"))
+	}
+	return t.Format(dateFormats[mode])
+}
+
+// relativeDate mirrors git's show_date_relative.
+func relativeDate(t, now time.Time) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	diff := int64(now.Sub(t).Seconds())
+	if diff < 0 {
+		return "in the future"
+	}
+	if diff < 90 {
+		return plural(diff, "second") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 90 {
+		return plural(diff, "minute") + " ago"
+	}
+	diff = (diff + 30) / 60
+	if diff < 36 {
+		return plural(diff, "hour") + " ago"
+	}
+	diff = (diff + 12) / 24
+	if diff < 14 {
+		return plural(diff, "day") + " ago"
+	}
+	if diff < 70 {
+		return plural((diff+3)/7, "week") + " ago"
+	}
+	if diff < 365 {
+		return plural((diff+15)/30, "month") + " ago"
+	}
+	if diff < 1825 {
+		totalMonths := (diff*12*2 + 365) / (365 * 2)
+		years, months := totalMonths/12, totalMonths%12
+		if months > 0 {
+			return plural(years, "year") + ", " + plural(months, "month") + " ago"
+		}
+		return plural(years, "year") + " ago"
+	}
+	return plural((diff+183)/365, "year") + " ago"
+}
+
+// logDateLayouts are the date formats accepted by --since and --until.
+var logDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseLogDate parses a --since/--until value or the date of a reflog
+// selector. Dates without a zone are taken to be local time. A number
+// is a Unix time, and as in git "now", "yesterday" and "<n> <unit>s
+// ago", its words joined by spaces or dots, are relative to now.
+func parseLogDate(value string) (time.Time, error) {
+	for _, layout := range logDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	now := time.Now()
+	words := strings.Fields(strings.ReplaceAll(strings.ToLower(value), ".", " "))
+	switch {
+	case len(words) == 1 && words[0] == "now":
+		return now, nil
+	case len(words) == 1 && words[0] == "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case len(words) == 3 && words[2] == "ago":
+		n, err := strconv.Atoi(words[0])
+		if err != nil {
+			break
+		}
+		switch strings.TrimSuffix(words[1], "s") {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// compilePattern compiles a --author/--grep pattern, treating it as a
+// literal substring when it is not a valid regular expression.
+func compilePattern(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return regexp.MustCompile(regexp.QuoteMeta(pattern))
+	}
+	return re
+}
+
+// parseLogArgs separates log flags from revision arguments.
+func parseLogArgs(args []string) (logOptions, []string, error) {
+	opts := logOptions{maxCount: -1, color: "auto"}
+	var revs []string
+	var err error
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-n" || arg == "--max-count":
+			if i+1 >= len(args) {
+				return opts, nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			arg = "--max-count=" + args[i]
+			fallthrough
+		case strings.HasPrefix(arg, "--max-count="), strings.HasPrefix(arg, "-n") && len(arg) > 2,
+			len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9':
+			value := strings.TrimLeft(strings.TrimPrefix(strings.TrimPrefix(arg, "--max-count="), "-n"), "-")
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, nil, fmt.Errorf("invalid commit count %q", value)
+			}
+			opts.maxCount = n
+		case strings.HasPrefix(arg, "--author="):
+			opts.authors = append(opts.authors, compilePattern(strings.TrimPrefix(arg, "--author=")))
+		case strings.HasPrefix(arg, "--grep="):
+			opts.greps = append(opts.greps, compilePattern(strings.TrimPrefix(arg, "--grep=")))
+		case strings.HasPrefix(arg, "--since="), strings.HasPrefix(arg, "--after="):
+			_, value, _ := strings.Cut(arg, "=")
+			if opts.since, err = parseLogDate(value); err != nil {
+				return opts, nil, err
+			}
+		case strings.HasPrefix(arg, "--until="), strings.HasPrefix(arg, "--before="):
+			_, value, _ := strings.Cut(arg, "=")
+			if opts.until, err = parseLogDate(value); err != nil {
+				return opts, nil, err
+			}
+		case strings.HasPrefix(arg, "--date="):
+			opts.date = strings.TrimPrefix(arg, "--date=")
+			if _, ok := dateFormats[opts.date]; !ok {
+				return opts, nil, fmt.Errorf("unknown date format %s", opts.date)
+			}
+		case arg == "--graph":
+			opts.graph = true
+		case arg == "--raw":
+			opts.raw = true
+		case arg == "--no-abbrev":
+			opts.noAbbrev = true
+		case strings.HasPrefix(arg, "--format="), strings.HasPrefix(arg, "--pretty="):
+			_, format, _ := strings.Cut(arg, "=")
+			if format != "json" {
+				return opts, nil, fmt.Errorf("unsupported log format '%s'; only json is supported", format)
+			}
+			opts.json = true
+		case arg == "--color":
+			opts.color = "always"
+		case arg == "--no-color":
+			opts.color = "never"
+		case strings.HasPrefix(arg, "--color="):
+			if opts.color, err = parseColorWhen(strings.TrimPrefix(arg, "--color=")); err != nil {
+				return opts, nil, err
+			}
+		case strings.HasPrefix(arg, "-"):
+			return opts, nil, fmt.Errorf("unknown log option %s", arg)
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if opts.graph && opts.json {
+		return opts, nil, errors.New("--graph cannot be used with --format=json")
+	}
+	if opts.raw && opts.json {
+		return opts, nil, errors.New("--raw cannot be used with --format=json")
+	}
+	return opts, revs, nil
+}
+
+// matchAny reports whether s matches one of patterns, or patterns is empty.
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether c passes the author, message and date filters.
+func (o logOptions) match(c *Commit) bool {
+	when := c.Committer.When
+	if !o.since.IsZero() && when.Before(o.since) {
+		return false
+	}
+	if !o.until.IsZero() && when.After(o.until) {
+		return false
+	}
+	author := fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email)
+	return matchAny(o.authors, author) && matchAny(o.greps, c.Message)
+}
+
+// runLog implements `mygit log [<options>] [<rev>...]`.
+func runLog(ctx *cmdContext, w io.Writer, args []string) error {
+	opts, revs, err := parseLogArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		branch, err := unbornBranch()
+		if err != nil {
+			return err
+		}
+		if branch != "" {
+			return fmt.Errorf("your current branch '%s' does not have any commits yet", strings.TrimPrefix(branch, "refs/heads/"))
+		}
+	}
+	hashes, err := resolveRevs(revs)
+	if err != nil {
+		return err
+	}
+	// Filtering happens after the walk so ancestry still follows every parent.
+	commits, err := ctx.revList(hashes)
+	if err != nil {
+		return err
+	}
+	var g *graph
+	if opts.graph {
+		// The graph needs every child drawn before its parents.
+		commits = topoOrder(commits)
+		g = &graph{}
+	}
+	mm := readMailmap()
+	color := useColor(opts.color, w)
+	out := &jsonArray{w: w}
+	shown := 0
+	for _, c := range commits {
+		if opts.maxCount >= 0 && shown >= opts.maxCount {
+			break
+		}
+		if !opts.match(mm.mapCommit(c)) {
+			if g != nil {
+				g.next(c) // keep the lanes moving past hidden commits
+			}
+			continue
+		}
+		switch {
+		case opts.json:
+			if err := out.add(newCommitJSON(mm.mapCommit(c))); err != nil {
+				return err
+			}
+		case g != nil:
+			var b strings.Builder
+			printCommit(&b, mm.mapCommit(c), opts.date, color, opts.noAbbrev)
+			if opts.raw {
+				if err := writeLogRaw(&b, c, opts.noAbbrev); err != nil {
+					return err
+				}
+			}
+			g.write(w, c, b.String(), shown > 0)
+		default:
+			if shown > 0 {
+				fmt.Fprintln(w)
+			}
+			printCommit(w, mm.mapCommit(c), opts.date, color, opts.noAbbrev)
+			if opts.raw {
+				if err := writeLogRaw(w, c, opts.noAbbrev); err != nil {
+					return err
+				}
+			}
+		}
+		shown++
+	}
+	if opts.json {
+		return out.close()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestWalkCommits walks a history with a merge from both of its tips
+// and checks that each commit is visited once, newest first, that
+// errStopWalk ends the walk without an error and that any other error
+// from the callback is returned.
+func TestWalkCommits(t *testing.T) {
+	// root <- a <- merge, root <- b <- merge, with b newer than a.
+	commits := map[string]*Commit{}
+	for i, c := range []struct {
+		hash    string
+		parents []string
+	}{{"root", nil}, {"a", []string{"root"}}, {"b", []string{"root"}}, {"merge", []string{"a", "b"}}} {
+		commits[c.hash] = &Commit{Hash: c.hash, Parents: c.parents, Committer: Signature{When: time.Unix(int64(i), 0)}}
+	}
+	get := func(hash string) (*Commit, error) { return commits[hash], nil }
+
+	var order []string
+	err := walkCommits([]string{"merge", "a"}, get, func(c *Commit) error {
+		order = append(order, c.Hash)
+		return nil
+	})
+	if want := []string{"merge", "b", "a", "root"}; err != nil || !slices.Equal(order, want) {
+		t.Errorf("walk visited %v, %v; want %v", order, err, want)
+	}
+
+	order = nil
+	err = walkCommits([]string{"merge"}, get, func(c *Commit) error {
+		order = append(order, c.Hash)
+		if len(order) == 2 {
+			return errStopWalk
+		}
+		return nil
+	})
+	if want := []string{"merge", "b"}; err != nil || !slices.Equal(order, want) {
+		t.Errorf("walk stopped after %v, %v; want %v", order, err, want)
+	}
+
+	failed := errors.New("failed")
+	if err := walkCommits([]string{"merge"}, get, func(*Commit) error { return failed }); err != failed {
+		t.Errorf("walk returned %v, want the callback's error", err)
+	}
+}
+
+// TestLogGraph compares log --graph against git over a merge and an
+// octopus merge, from main and from a branch that ends before them.
+func TestLogGraph(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("merge", "-q", "--no-edit", "topic")
+	for _, name := range []string{"x", "y", "z"} {
+		r.git("checkout", "-q", "-b", name, "main~1")
+		r.write(name, name+"\n", 0o644)
+		r.git("add", name)
+		r.git("commit", "-q", "-m", name)
+	}
+	r.git("checkout", "-q", "main")
+	r.git("merge", "-q", "--no-edit", "x", "y", "z")
+	r.write("a", "after\n", 0o644)
+	r.git("commit", "-q", "-am", "after the octopus")
+	for _, args := range [][]string{
+		{"log", "--graph"},
+		{"log", "--graph", "topic"},
+	} {
+		r.same(args...)
+	}
+}
+
+// TestLogRaw compares log --raw with git's, with and without
+// --no-abbrev, for a root commit, which is diffed against the empty
+// tree, changes of content and mode, an addition and deletion, a merge,
+// which shows no changes, and a commit changing nothing.
+func TestLogRaw(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("merge", "-q", "--no-edit", "topic")
+	r.write("b", "b changed\n", 0o644)
+	if err := os.Chmod(filepath.Join(r.dir, "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.git("rm", "-q", "c")
+	r.write("d", "d\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "mixed")
+	r.git("commit", "-q", "--allow-empty", "-m", "empty")
+	r.same("log", "--raw")
+	r.same("log", "--raw", "--no-abbrev")
+	r.same("log", "--raw", "HEAD~1")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// mailmapIdentity is the canonical name and email a mailmap line gives;
+// either may be empty to keep the one from the commit.
+type mailmapIdentity struct {
+	name, email string
+}
+
+// mailmap maps the identities recorded in commits to canonical ones.
+// Keys are lowercased, as git matches names and emails case-insensitively.
+type mailmap struct {
+	byEmail     map[string]mailmapIdentity    // commit email
+	byNameEmail map[[2]string]mailmapIdentity // commit name and email
+}
+
+// readMailmap parses .mailmap at the top of the working tree. A missing
+// file maps nothing. Each line is one of
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func readMailmap() *mailmap {
+	m := &mailmap{byEmail: map[string]mailmapIdentity{}, byNameEmail: map[[2]string]mailmapIdentity{}}
+	data, err := os.ReadFile(".mailmap")
+	if err != nil {
+		return m
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		var names, emails []string
+		for {
+			lt := strings.IndexByte(line, '<')
+			gt := strings.IndexByte(line, '>')
+			if lt < 0 || gt < lt {
+				break
+			}
+			names = append(names, strings.TrimSpace(line[:lt]))
+			emails = append(emails, line[lt+1:gt])
+			line = line[gt+1:]
+		}
+		switch len(emails) {
+		case 1:
+			if names[0] != "" {
+				m.byEmail[strings.ToLower(emails[0])] = mailmapIdentity{name: names[0]}
+			}
+		case 2:
+			proper := mailmapIdentity{names[0], emails[0]}
+			if names[1] == "" {
+				m.byEmail[strings.ToLower(emails[1])] = proper
+			} else {
+				m.byNameEmail[[2]string{strings.ToLower(names[1]), strings.ToLower(emails[1])}] = proper
+			}
+		}
+	}
+	return m
+}
+
+// lookup returns sig with its name and email replaced by the canonical
+// ones, preferring a line that matches both the name and the email.
+func (m *mailmap) lookup(sig Signature) Signature {
+	email := strings.ToLower(sig.Email)
+	to, ok := m.byNameEmail[[2]string{strings.ToLower(sig.Name), email}]
+	if !ok {
+		if to, ok = m.byEmail[email]; !ok {
+			return sig
+		}
+	}
+	if to.name != "" {
+		sig.Name = to.name
+	}
+	if to.email != "" {
+		sig.Email = to.email
+	}
+	return sig
+}
+
+// mapCommit returns c with its author and committer mapped, copying the
+// commit rather than changing the one cached by cmdContext.
+func (m *mailmap) mapCommit(c *Commit) *Commit {
+	mapped := *c
+	mapped.Author = m.lookup(c.Author)
+	mapped.Committer = m.lookup(c.Committer)
+	return &mapped
+}
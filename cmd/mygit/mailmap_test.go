@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestMailmap commits as several identities that a .mailmap maps, with
+// each of its line forms and a differently cased email, and checks that
+// log, shortlog and blame show the canonical ones as git does.
+func TestMailmap(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write(".mailmap", "# canonical identities\n"+
+		"Proper Name <proper@example.com>\n"+
+		"<new@example.com> <old@example.com>\n"+
+		"Both Fixed <both@example.com> Wrong Name <wrong@example.com>\n"+
+		"Name Only <any@example.com> <ANY@example.com>\n", 0o644)
+	authors := [][2]string{
+		{"proper", "proper@example.com"},
+		{"Old", "old@example.com"},
+		{"Wrong Name", "wrong@example.com"},
+		{"Right Name", "wrong@example.com"},
+		{"Anyone", "Any@Example.com"},
+		{"A U Thor", "author@example.com"},
+	}
+	content := ""
+	for i, a := range authors {
+		content += fmt.Sprintf("line %d\n", i)
+		r.write("f", content, 0o644)
+		r.git("add", "-A")
+		r.run("", "git", []string{"commit", "-q", "-m", fmt.Sprintf("commit %d", i)},
+			"GIT_AUTHOR_NAME="+a[0], "GIT_AUTHOR_EMAIL="+a[1])
+	}
+	r.same("log")
+	r.same("shortlog", "HEAD")
+	r.same("shortlog", "-sn", "HEAD")
+	r.same("blame", "f")
+	r.same("blame", "--porcelain", "f")
+}
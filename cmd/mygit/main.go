@@ -1,150 +1,1397 @@
 package main
 
 import (
-	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// DecompressAndRead inflates the zlib-compressed file fileName. A stream
+// that is damaged or cut short is reported as such; objects are never
+// read past it.
 func DecompressAndRead(fileName string) (string, error) {
 	compressedFile, err := os.Open(fileName)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return "", errors.New("")
+		return "", err
 	}
 	defer compressedFile.Close()
 
-	// Create a zlib reader
 	zlibReader, err := zlib.NewReader(compressedFile)
 	if err != nil {
-		fmt.Println("Error creating zlib reader:", err)
-		return "", errors.New("")
+		return "", inflateError(err)
 	}
 	defer zlibReader.Close()
 
-	// Read decompressed data
-	decompressedData, err := io.ReadAll(zlibReader)
+	// Read decompressed data, leaving room for the header on top of the
+	// largest body allowed so an oversized object is caught unread.
+	limit := maxObjectSize() + 64
+	decompressedData, err := io.ReadAll(&io.LimitedReader{R: zlibReader, N: limit + 1})
 	if err != nil {
-		fmt.Println("Error reading decompressed data:", err)
-		return "", errors.New("")
+		return "", inflateError(err)
+	}
+	if int64(len(decompressedData)) > limit {
+		return "", fmt.Errorf("%s: object exceeds the maximum object size of %d", fileName, maxObjectSize())
 	}
-
-	// Print the decompressed content
 	return string(decompressedData), nil
 }
 
+// inflateError words a failure to inflate a zlib stream. A stream that
+// ends early, even inside its header, is truncated.
+func inflateError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("inflate: truncated zlib stream")
+	}
+	return fmt.Errorf("inflate: %w", err)
+}
+
 // Usage: your_program.sh <command> <arg1> <arg2> ...
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	// fmt.Fprintf(os.Stderr, "Logs from your program will appear here!\n")
 
+	// Options before the command apply to every command.
+	paginate := "auto"
+options:
+	for len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "-P", "--no-pager":
+			paginate = "never"
+		case "-p", "--paginate":
+			paginate = "always"
+		default:
+			break options
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
+		fmt.Fprintf(os.Stderr, "usage: mygit [-P | --no-pager | -p | --paginate] <command> [<args>...]\n")
 		os.Exit(1)
 	}
 
+	if command := os.Args[1]; command != "init" && command != "clone" {
+		// Commands run at the top of the working tree, wherever in it
+		// they were started.
+		if err := enterRepoTop(); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		if err := checkRepoFormat(); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx := newCmdContext()
 	switch command := os.Args[1]; command {
 	case "init":
-		// Uncomment this block to pass the first stage!
-		//
-		for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-			if err := os.MkdirAll(dir, 0o755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
+		var opts initOptions
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--bare":
+				opts.bare = true
+			case strings.HasPrefix(arg, "--initial-branch="):
+				opts.branch = strings.TrimPrefix(arg, "--initial-branch=")
+			case (arg == "-b" || arg == "--initial-branch") && i+1 < len(os.Args):
+				opts.branch = os.Args[i+1]
+				i++
+			default:
+				opts.dir = arg
 			}
 		}
-
-		headFileContents := []byte("ref: refs/heads/main\n")
-		if err := os.WriteFile(".git/HEAD", headFileContents, 0o644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
+		if err := initRepo(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
 		}
 
 		fmt.Println("Initialized git directory")
 	case "cat-file":
-		if len(os.Args) > 2 {
-			pArg := os.Args[2]
-			if pArg == "-p" {
-				hash := os.Args[3]
-				dirName := hash[:2]
-				fileName := hash[2:]
-
-				if err := os.Chdir(fmt.Sprintf(".git/objects/%s", dirName)); err != nil {
-					fmt.Fprintf(os.Stderr, "specified hash %s does not exist\n", hash)
-					return
+		if err := catFile(os.Stdin, os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+	case "hash-object":
+		// --no-newline (or -z) leaves the newline off the last name, as
+		// hash-object printed names before they each got a line.
+		// --path applies the clean filter, line ending conversion and
+		// ident collapsing of a file at that path, and --no-filters
+		// stores the bytes as they are.
+		write, stdin, noNewline, noFilters := false, false, false, false
+		var files []string
+		filterPath, format := "", ""
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-w":
+				write = true
+			case arg == "--stdin":
+				stdin = true
+			case arg == "-z" || arg == "--no-newline":
+				noNewline = true
+			case arg == "--no-filters":
+				noFilters = true
+			case strings.HasPrefix(arg, "--path="):
+				filterPath = strings.TrimPrefix(arg, "--path=")
+				if path, err := repoPath(filterPath); err == nil {
+					filterPath = path
+				}
+			case strings.HasPrefix(arg, "--object-format="):
+				format = strings.TrimPrefix(arg, "--object-format=")
+			default:
+				files = append(files, arg)
+			}
+		}
+		if format != "" {
+			if err := overrideObjectFormat(format, write); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		if noFilters && filterPath != "" {
+			fmt.Fprintf(os.Stderr, "error: Can't use --path with --no-filters\n"+
+				"usage: mygit hash-object [-w] [--path=<file> | --no-filters] [--object-format=<format>] [--stdin] <file>...\n")
+			os.Exit(1)
+		}
+		sep := ""
+		hashBlob := func(path string, data []byte) {
+			if filterPath != "" {
+				path = filterPath
+			}
+			var err error
+			if path != "" && !noFilters {
+				if data, err = toRepoBlob(filepath.ToSlash(path), data); err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+					os.Exit(1)
+				}
+			}
+			var hash string
+			if write {
+				hash, err = writeObject(BlobObject, data)
+			} else {
+				hash = hashObject(BlobObject, data)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Print(sep, hash)
+			sep = "\n"
+		}
+		if stdin {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			hashBlob("", data)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(startPath(file))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: could not open '%s' for reading\n", file)
+				os.Exit(1)
+			}
+			// Attributes are looked up by the path in the working tree.
+			if path, err := repoPath(file); err == nil {
+				file = path
+			}
+			hashBlob(file, data)
+		}
+		if sep != "" && !noNewline {
+			fmt.Println()
+		}
+	case "write-tree":
+		var prefix string
+		for _, arg := range os.Args[2:] {
+			p, ok := strings.CutPrefix(arg, "--prefix=")
+			if !ok {
+				fmt.Fprintf(os.Stderr, "usage: mygit write-tree [--prefix=<prefix>/]\n")
+				os.Exit(1)
+			}
+			prefix = p
+		}
+		hash, err := writeTree(prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(hash)
+	case "mktree":
+		nul, missing := false, false
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-z":
+				nul = true
+			case arg == "--missing":
+				missing = true
+			case strings.HasPrefix(arg, "--object-format="):
+				if err := overrideObjectFormat(strings.TrimPrefix(arg, "--object-format="), true); err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+					os.Exit(1)
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit mktree [-z] [--missing] [--object-format=<format>]\n")
+				os.Exit(1)
+			}
+		}
+		hash, err := mkTree(os.Stdin, nul, missing)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(hash)
+	case "read-tree":
+		var opts readTreeOptions
+		var trees []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-m":
+				opts.merge = true
+			case arg == "--empty":
+				opts.empty = true
+			case strings.HasPrefix(arg, "--prefix="):
+				opts.prefix = strings.TrimPrefix(arg, "--prefix=")
+			default:
+				trees = append(trees, arg)
+			}
+		}
+		if err := readTreeIndex(trees, opts); err != nil {
+			var refused unpackError
+			if errors.As(err, &refused) {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			}
+			os.Exit(1)
+		}
+	case "checkout-index":
+		var opts checkoutIndexOptions
+		var paths []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-a" || arg == "--all":
+				opts.all = true
+			case arg == "-f" || arg == "--force":
+				opts.force = true
+			case strings.HasPrefix(arg, "--prefix="):
+				opts.prefix = strings.TrimPrefix(arg, "--prefix=")
+			default:
+				paths = append(paths, arg)
+			}
+		}
+		paths, err := repoPaths(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		ok, err := checkoutIndex(os.Stderr, paths, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case "add":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Nothing specified, nothing added.\n")
+			os.Exit(1)
+		}
+		paths, err := repoPaths(os.Args[2:])
+		if err == nil {
+			err = add(paths)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		porcelain, nul := false, false
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--porcelain", "--porcelain=v1":
+				porcelain = true
+			case "-z":
+				nul = true
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit status [--porcelain[=v1]] [-z]\n")
+				os.Exit(1)
+			}
+		}
+		var err error
+		if porcelain || nul {
+			// As in git, -z implies the porcelain format.
+			err = porcelainStatus(os.Stdout, nul)
+		} else {
+			err = status(ctx, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "commit":
+		var messages []string
+		var opts commitOptions
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case (arg == "-m" || arg == "--message") && i+1 < len(os.Args):
+				messages = append(messages, os.Args[i+1])
+				i++
+			case strings.HasPrefix(arg, "--message="):
+				messages = append(messages, strings.TrimPrefix(arg, "--message="))
+			case arg == "--allow-empty":
+				opts.allowEmpty = true
+			case arg == "--amend":
+				opts.amend = true
+			case arg == "-n" || arg == "--no-verify":
+				opts.noVerify = true
+			case strings.HasPrefix(arg, "-S") || arg == "--gpg-sign" || strings.HasPrefix(arg, "--gpg-sign="):
+				opts.sign = parseSignFlag(arg)
+			case arg == "--no-gpg-sign":
+				opts.sign = nil
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit commit [--allow-empty] [--amend] [-n] [-S[<keyid>]] -m <message>\n")
+				os.Exit(1)
+			}
+		}
+		// A merge, cherry-pick or revert in progress supplies its own
+		// message.
+		heads, _ := mergeHeads()
+		if picking, _, _ := pickInProgress(); len(messages) == 0 && heads == nil && picking == "" {
+			fmt.Fprintf(os.Stderr, "usage: mygit commit [--allow-empty] [--amend] [-n] [-S[<keyid>]] -m <message>\n")
+			os.Exit(1)
+		}
+		err := commitIndex(os.Stdout, strings.Join(messages, "\n\n"), opts)
+		if errors.Is(err, errHookFailed) {
+			os.Exit(1)
+		}
+		if errors.Is(err, errNothingToCommit) {
+			status(ctx, os.Stdout)
+			os.Exit(1)
+		}
+		if errors.Is(err, errAmendEmpty) || errors.Is(err, errCommitUnmerged) {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "commit-tree":
+		var trees, parents, messages []string
+		var sign *signer
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "-p" && i+1 < len(os.Args):
+				parents = append(parents, os.Args[i+1])
+				i++
+			case arg == "-m" && i+1 < len(os.Args):
+				messages = append(messages, os.Args[i+1]+"\n")
+				i++
+			case strings.HasPrefix(arg, "-S") || arg == "--gpg-sign" || strings.HasPrefix(arg, "--gpg-sign="):
+				sign = parseSignFlag(arg)
+			case arg == "--no-gpg-sign":
+				sign = nil
+			default:
+				trees = append(trees, arg)
+			}
+		}
+		if len(trees) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit commit-tree <tree> [-p <parent>]... [-S[<keyid>]] [-m <message>]...\n")
+			os.Exit(1)
+		}
+		message := strings.Join(messages, "\n")
+		if len(messages) == 0 {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			message = string(data)
+		}
+		hash, err := commitTree(trees[0], parents, message, sign)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(hash)
+	case "checkout":
+		force, create := false, ""
+		var targets []string
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "-f" || arg == "--force":
+				force = true
+			case arg == "-b" && i+1 < len(os.Args):
+				create = os.Args[i+1]
+				i++
+			default:
+				targets = append(targets, arg)
+			}
+		}
+		if len(targets) > 1 || (len(targets) == 0 && create == "") {
+			fmt.Fprintf(os.Stderr, "usage: mygit checkout [-f | --force] (-b <new-branch> [<start-point>] | <branch | commit>)\n")
+			os.Exit(1)
+		}
+		if create != "" {
+			start := ""
+			if len(targets) == 1 {
+				start = targets[0]
+			}
+			if err := switchBranch(os.Stderr, start, switchOptions{create: create, force: force}); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			break
+		}
+		if err := checkout(os.Stderr, targets[0], force); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+	case "switch":
+		var opts switchOptions
+		var targets []string
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case (arg == "-c" || arg == "--create") && i+1 < len(os.Args):
+				opts.create = os.Args[i+1]
+				i++
+			case arg == "-d" || arg == "--detach":
+				opts.detach = true
+			case arg == "-f" || arg == "--force" || arg == "--discard-changes":
+				opts.force = true
+			default:
+				targets = append(targets, arg)
+			}
+		}
+		if len(targets) > 1 || (len(targets) == 0 && opts.create == "" && !opts.detach) {
+			fmt.Fprintf(os.Stderr, "usage: mygit switch [-f] (-c <new-branch> [<start-point>] | --detach [<commit>] | <branch>)\n")
+			os.Exit(1)
+		}
+		if opts.create != "" && opts.detach {
+			fmt.Fprintf(os.Stderr, "fatal: '--detach' cannot be used with '-b/-B/--orphan'\n")
+			os.Exit(1)
+		}
+		name := ""
+		if len(targets) == 1 {
+			name = targets[0]
+		}
+		if err := switchBranch(os.Stderr, name, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	case "branch":
+		args := os.Args[2:]
+		var err error
+		switch {
+		case len(args) == 0:
+			err = listBranches(os.Stdout)
+		case args[0] == "-m" || args[0] == "-M":
+			switch len(args) {
+			case 2:
+				err = renameBranch("", args[1], args[0] == "-M")
+			case 3:
+				err = renameBranch(args[1], args[2], args[0] == "-M")
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit branch (-m | -M) [<old-branch>] <new-branch>\n")
+				os.Exit(1)
+			}
+		case strings.HasPrefix(args[0], "--set-upstream-to=") && len(args) <= 2:
+			args = append([]string{"-u", strings.TrimPrefix(args[0], "--set-upstream-to=")}, args[1:]...)
+			fallthrough
+		case (args[0] == "-u" || args[0] == "--set-upstream-to") && len(args) >= 2 && len(args) <= 3:
+			branch := ""
+			if len(args) == 3 {
+				branch = args[2]
+			}
+			err = setUpstream(os.Stdout, branch, args[1])
+		case len(args) <= 2 && !strings.HasPrefix(args[0], "-"):
+			start := "HEAD"
+			if len(args) == 2 {
+				start = args[1]
+			}
+			err = createBranch(args[0], start)
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit branch [<branch> [<start-point>] | (-m | -M) [<old-branch>] <new-branch>]\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "tag":
+		var opts tagOptions
+		var args []string
+		usage := false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "-a" || arg == "--annotate":
+				opts.annotate = true
+			case arg == "-s" || arg == "--sign":
+				opts.annotate, opts.sign = true, &signer{}
+			case (arg == "-u" || arg == "--local-user") && i+1 < len(os.Args):
+				opts.annotate, opts.sign = true, &signer{key: os.Args[i+1]}
+				i++
+			case strings.HasPrefix(arg, "--local-user="):
+				opts.annotate, opts.sign = true, &signer{key: strings.TrimPrefix(arg, "--local-user=")}
+			case (arg == "-m" || arg == "--message") && i+1 < len(os.Args):
+				if opts.hasMessage {
+					opts.message += "\n\n"
+				}
+				opts.annotate, opts.hasMessage = true, true
+				opts.message += os.Args[i+1]
+				i++
+			case arg == "-f" || arg == "--force":
+				opts.force = true
+			case strings.HasPrefix(arg, "-"):
+				usage = true
+			default:
+				args = append(args, arg)
+			}
+		}
+		var err error
+		switch {
+		case usage || len(args) > 2 || (len(args) == 0 && len(os.Args) > 2):
+			fmt.Fprintf(os.Stderr, "usage: mygit tag [-a | -s | -u <key-id>] [-f] [-m <msg>] <tagname> [<object>]\n")
+			os.Exit(1)
+		case len(args) == 0:
+			err = listTags(os.Stdout)
+		default:
+			rev := "HEAD"
+			if len(args) == 2 {
+				rev = args[1]
+			}
+			err = createTag(os.Stdout, args[0], rev, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "archive":
+		format, prefix, output := "tar", "", ""
+		var revs []string
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case strings.HasPrefix(arg, "--format="):
+				format = strings.TrimPrefix(arg, "--format=")
+			case strings.HasPrefix(arg, "--prefix="):
+				prefix = strings.TrimPrefix(arg, "--prefix=")
+			case strings.HasPrefix(arg, "--output="):
+				output = strings.TrimPrefix(arg, "--output=")
+			case arg == "-o" && i+1 < len(os.Args):
+				output = os.Args[i+1]
+				i++
+			default:
+				revs = append(revs, arg)
+			}
+		}
+		if len(revs) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit archive [--format=tar] [--prefix=<prefix>/] [-o <file>] <tree-ish>\n")
+			os.Exit(1)
+		}
+		var out io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(startPath(output))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := archive(out, revs[0], format, prefix); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "bundle":
+		var err error
+		switch args := os.Args[2:]; {
+		case len(args) >= 3 && args[0] == "create":
+			err = createBundle(ctx, startPath(args[1]), args[2:])
+		case len(args) == 2 && args[0] == "verify":
+			err = verifyBundle(os.Stdout, startPath(args[1]))
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit bundle (create <file> <git-rev-list-args> | verify <file>)\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+	case "diff-tree":
+		opts := diffTreeOptions{threshold: defaultRenameThreshold}
+		var revs []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-r":
+				opts.recursive = true
+			case arg == "--name-only":
+				opts.nameOnly = true
+			case arg == "--name-status":
+				opts.nameStatus = true
+			case arg == "--stat":
+				opts.stat = true
+			case arg == "-z":
+				opts.nul = true
+			case arg == "-M" || arg == "--find-renames":
+				opts.renames, opts.copies = true, false
+			case arg == "-C" || arg == "--find-copies":
+				opts.renames, opts.copies = true, true
+			case strings.HasPrefix(arg, "-M") || strings.HasPrefix(arg, "--find-renames="),
+				strings.HasPrefix(arg, "-C") || strings.HasPrefix(arg, "--find-copies="):
+				// As in git, the last of -M and -C says whether copies are found.
+				copies := strings.HasPrefix(arg, "-C") || strings.HasPrefix(arg, "--find-copies=")
+				value := arg
+				for _, prefix := range []string{"-M", "-C", "--find-renames=", "--find-copies="} {
+					if v, ok := strings.CutPrefix(arg, prefix); ok {
+						value = v
+						break
+					}
+				}
+				score, err := parseRenameScore(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+					os.Exit(1)
 				}
+				opts.renames, opts.copies, opts.threshold = true, copies, score
+			default:
+				revs = append(revs, arg)
+			}
+		}
+		if err := diffTree(os.Stdout, revs, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
 
-				readFileContent, err := DecompressAndRead(fileName)
+	case "prune":
+		dryRun, verbose := false, false
+		expireArg := defaultPruneExpire
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-n" || arg == "--dry-run":
+				dryRun = true
+			case arg == "-v" || arg == "--verbose":
+				verbose = true
+			case strings.HasPrefix(arg, "--expire="):
+				expireArg = strings.TrimPrefix(arg, "--expire=")
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit prune [-n | --dry-run] [-v | --verbose] [--expire=<time>]\n")
+				os.Exit(1)
+			}
+		}
+		expire, err := parseExpiry(expireArg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		if err := prune(ctx, os.Stdout, dryRun, verbose, expire); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "prune-packed":
+		dryRun := false
+		for _, arg := range os.Args[2:] {
+			if arg != "-n" && arg != "--dry-run" {
+				fmt.Fprintf(os.Stderr, "usage: mygit prune-packed [-n | --dry-run]\n")
+				os.Exit(1)
+			}
+			dryRun = true
+		}
+		if err := prunePacked(os.Stdout, dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "verify-commit", "verify-tag":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit %s <object>...\n", os.Args[1])
+			os.Exit(1)
+		}
+		want := CommitObject
+		if os.Args[1] == "verify-tag" {
+			want = TagObject
+		}
+		if !verifyObjects(os.Stderr, want, os.Args[2:]) {
+			os.Exit(1)
+		}
+	case "show-index":
+		if len(os.Args) != 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit show-index < <pack-idx-file>\n")
+			os.Exit(1)
+		}
+		if err := showIndex(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "worktree":
+		var err error
+		switch {
+		case len(os.Args) == 5 && os.Args[2] == "add":
+			err = worktreeAdd(startPath(os.Args[3]), os.Args[4])
+		case len(os.Args) == 3 && os.Args[2] == "list":
+			err = worktreeList(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit worktree add <path> <branch>\n   or: mygit worktree list\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "stash":
+		var err error
+		switch {
+		case len(os.Args) == 2 || (len(os.Args) == 3 && os.Args[2] == "push"):
+			err = stashSave(os.Stdout)
+		case len(os.Args) == 3 && os.Args[2] == "pop":
+			err = stashPop(ctx, os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit stash [push | pop]\n")
+			os.Exit(1)
+		}
+		if errors.Is(err, errStashKept) {
+			// git tells of the kept stash with the conflicts, on stdout.
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if errors.Is(err, errNoStash) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+	case "reset":
+		quiet, separated := false, false
+		mode := ""
+		var paths []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case separated:
+				paths = append(paths, arg)
+			case arg == "-q" || arg == "--quiet":
+				quiet = true
+			case arg == "--soft" || arg == "--mixed" || arg == "--hard":
+				mode = strings.TrimPrefix(arg, "--")
+			case arg == "--":
+				separated = true
+			default:
+				paths = append(paths, arg)
+			}
+		}
+		// Without a mode, a lone argument naming a commit is reset to
+		// rather than taken as a path, as in git.
+		if mode == "" && !separated && len(paths) <= 1 {
+			if len(paths) == 0 {
+				mode = "mixed"
+			} else if _, err := resolveRevs(paths); err == nil {
+				mode = "mixed"
+			}
+		}
+		if mode != "" {
+			if separated || len(paths) > 1 {
+				fmt.Fprintf(os.Stderr, "usage: mygit reset [--soft | --mixed | --hard] [-q] [<commit>]\n")
+				os.Exit(1)
+			}
+			rev := "HEAD"
+			if len(paths) == 1 {
+				rev = paths[0]
+			}
+			if err := resetCommit(os.Stdout, rev, mode, quiet); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			break
+		}
+		paths, err := repoPaths(paths)
+		if err == nil {
+			err = resetPaths(os.Stdout, paths, quiet, separated)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "sparse-checkout":
+		var err error
+		switch {
+		case len(os.Args) >= 3 && os.Args[2] == "set":
+			err = sparseCheckoutSet(os.Stderr, os.Args[3:])
+		case len(os.Args) == 3 && os.Args[2] == "disable":
+			err = sparseCheckoutDisable(os.Stderr)
+		case len(os.Args) == 3 && os.Args[2] == "list":
+			err = sparseCheckoutList(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "usage: mygit sparse-checkout (set <pattern>... | disable | list)\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "diff":
+		cached, when := false, "auto"
+		for _, arg := range os.Args[2:] {
+			if ok, err := colorFlag(arg, &when); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			} else if ok {
+				continue
+			}
+			if arg != "--cached" && arg != "--staged" {
+				fmt.Fprintf(os.Stderr, "usage: mygit diff [--cached | --staged] [--color[=<when>]]\n")
+				os.Exit(1)
+			}
+			cached = true
+		}
+		out, closePager := startPager(paginate)
+		var err error
+		if cached {
+			err = diffCached(out, useColor(when, out))
+		} else {
+			err = diffWorktree(out, useColor(when, out))
+		}
+		closePager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "update-index":
+		args := append([]string{}, os.Args[2:]...)
+		for i, arg := range args {
+			if !strings.HasPrefix(arg, "-") {
+				path, err := repoPath(arg)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-					return
+					fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+					os.Exit(1)
 				}
+				args[i] = path
+			}
+		}
+		if err := updateIndex(args); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "ls-files":
+		opts := lsFilesOptions{prefix: repoPrefix}
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "-z":
+				opts.nul = true
+			case "-s", "--stage":
+				opts.stage = true
+			case "-u", "--unmerged":
+				opts.unmerged = true
+			case "-v":
+				opts.tags = true
+			case "--full-name":
+				opts.fullName = true
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit ls-files [-z] [-s] [-u] [-v] [--full-name]\n")
+				os.Exit(1)
+			}
+		}
+		if err := lsFiles(os.Stdout, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "conflicts":
+		nul := false
+		for _, arg := range os.Args[2:] {
+			if arg != "-z" {
+				fmt.Fprintf(os.Stderr, "usage: mygit conflicts [-z]\n")
+				os.Exit(1)
+			}
+			nul = true
+		}
+		if err := conflicts(os.Stdout, nul); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+	case "ls-tree":
+		opts := lsTreeOptions{prefix: repoPrefix}
+		var revs []string
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--name-only", "--name-status":
+				opts.nameOnly = true
+			case "-r":
+				opts.recursive = true
+			case "-t":
+				opts.showTrees = true
+			case "-d":
+				opts.treesOnly = true
+			case "--full-name":
+				opts.fullName = true
+			case "--full-tree":
+				opts.fullTree = true
+			case "-z":
+				opts.nul = true
+			case "--format=json":
+				opts.json = true
+			default:
+				revs = append(revs, arg)
+			}
+		}
+		if len(revs) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit ls-tree [-r] [-t] [-d] [--name-only] [--full-name] [--full-tree] [-z | --format=json] <tree-ish>\n")
+			os.Exit(1)
+		}
+		if err := lsTree(os.Stdout, revs[0], opts); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
 
-				// TODO: revisit and simplify this
-				content := ""
-				flag := false
-				for i := range readFileContent {
-					if readFileContent[i] == 0 {
-						flag = true
-					}
-					if flag && readFileContent[i] != 0 {
-						content += string(readFileContent[i])
-					}
+	case "fetch":
+		var opts fetchOptions
+		var positional []string
+		for _, arg := range os.Args[2:] {
+			if arg == "-q" || arg == "--quiet" {
+				opts.quiet = true
+				continue
+			}
+			positional = append(positional, arg)
+		}
+		remote := "origin"
+		if len(positional) > 0 {
+			remote = positional[0]
+			positional = positional[1:]
+		}
+		if err := fetch(ctx, remote, positional, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "update-ref":
+		message := ""
+		args := os.Args[2:]
+		if len(args) >= 2 && args[0] == "-m" {
+			message, args = args[1], args[2:]
+		}
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit update-ref [-m <reason>] <ref> <new> [<old>]\n")
+			os.Exit(1)
+		}
+		oldRev := ""
+		if len(args) == 3 {
+			oldRev = args[2]
+		}
+		if err := updateRefCommand(args[0], args[1], oldRev, message); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "pack-refs":
+		all, noPrune := false, false
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--all":
+				all = true
+			case "--no-prune":
+				noPrune = true
+			case "--prune":
+				noPrune = false
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit pack-refs [--all] [--no-prune]\n")
+				os.Exit(1)
+			}
+		}
+		if err := packRefs(all, noPrune); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "reflog":
+		args := os.Args[2:]
+		if len(args) > 0 && args[0] == "expire" {
+			var opts reflogExpireOptions
+			var refs []string
+			for _, arg := range args[1:] {
+				switch {
+				case strings.HasPrefix(arg, "--expire="):
+					opts.expire = strings.TrimPrefix(arg, "--expire=")
+				case strings.HasPrefix(arg, "--expire-unreachable="):
+					opts.expireUnreachable = strings.TrimPrefix(arg, "--expire-unreachable=")
+				case arg == "--all":
+					opts.all = true
+				case arg == "-n" || arg == "--dry-run":
+					opts.dryRun = true
+				case arg == "--verbose":
+					opts.verbose = true
+				case strings.HasPrefix(arg, "-"):
+					fmt.Fprintf(os.Stderr, "usage: mygit reflog expire [--expire=<time>] [--expire-unreachable=<time>] [-n | --dry-run] [--verbose] [--all | <ref>...]\n")
+					os.Exit(1)
+				default:
+					refs = append(refs, arg)
 				}
+			}
+			ok, err := reflogExpire(ctx, os.Stdout, refs, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			break
+		}
+		if len(args) > 0 && args[0] == "show" {
+			args = args[1:]
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit reflog [show] [<ref>]\n")
+			os.Exit(1)
+		}
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if err := reflog(os.Stdout, name); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "ls-remote":
+		if len(os.Args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit ls-remote <remote>|<url>\n")
+			os.Exit(1)
+		}
+		if err := lsRemote(os.Stdout, os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
 
-				fmt.Print(content)
+	case "clone":
+		var opts fetchOptions
+		var positional []string
+		for i := 2; i < len(os.Args); i++ {
+			if os.Args[i] == "--depth" && i+1 < len(os.Args) {
+				depth, err := strconv.Atoi(os.Args[i+1])
+				if err != nil || depth <= 0 {
+					fmt.Fprintf(os.Stderr, "depth %s is not a positive number\n", os.Args[i+1])
+					os.Exit(1)
+				}
+				opts.depth = depth
+				i++
+				continue
+			}
+			if os.Args[i] == "-q" || os.Args[i] == "--quiet" {
+				opts.quiet = true
+				continue
 			}
+			if spec, ok := strings.CutPrefix(os.Args[i], "--filter="); ok {
+				if spec != "blob:none" {
+					fmt.Fprintf(os.Stderr, "invalid filter-spec '%s'\n", spec)
+					os.Exit(1)
+				}
+				opts.filter = spec
+				continue
+			}
+			positional = append(positional, os.Args[i])
 		}
-	case "hash-object":
-		if len(os.Args) > 2 {
-			file, _ := os.ReadFile(os.Args[3])
-			stats, _ := os.Stat(os.Args[3])
-			content := string(file)
-			contentAndHeader := fmt.Sprintf("blob %d\x00%s", stats.Size(), content)
-			sha := (sha1.Sum([]byte(contentAndHeader)))
-			hash := fmt.Sprintf("%x", sha)
-			blobName := []rune(hash)
-			blobPath := ".git/objects/"
-			for i, v := range blobName {
-				blobPath += string(v)
-				if i == 1 {
-					blobPath += "/"
+		if len(positional) < 1 || len(positional) > 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit clone [--depth <n>] [--filter=<spec>] [--quiet] <url> [<dir>]\n")
+			os.Exit(1)
+		}
+		dir := ""
+		if len(positional) == 2 {
+			dir = positional[1]
+		}
+		if err := clone(ctx, positional[0], dir, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "push":
+		if len(os.Args) != 4 {
+			fmt.Fprintf(os.Stderr, "usage: mygit push <remote>|<url> <refspec>\n")
+			os.Exit(1)
+		}
+		if err := push(ctx, os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "rev-list":
+		var opts revListOptions
+		var revs []string
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--count":
+				opts.count = true
+			case "--objects":
+				opts.objects = true
+			default:
+				revs = append(revs, arg)
+			}
+		}
+		if err := listRevs(ctx, os.Stdout, revs, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "rev-parse":
+		for _, arg := range os.Args[2:] {
+			if arg == "--git-dir" || arg == "--show-toplevel" || arg == "--show-prefix" || arg == "--is-inside-work-tree" {
+				out, err := repoLocation(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+					os.Exit(1)
 				}
+				fmt.Println(out)
+				continue
 			}
-			var buffer bytes.Buffer
-			z := zlib.NewWriter(&buffer)
-			z.Write([]byte(contentAndHeader))
-			z.Close()
-			os.MkdirAll(filepath.Dir(blobPath), os.ModePerm)
-			f, _ := os.Create(blobPath)
-			defer f.Close()
-			f.Write(buffer.Bytes())
-			fmt.Print(hash)
+			hashes, err := resolveRevs([]string{arg})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(hashes[0])
 		}
-	case "ls-tree":
-		if len(os.Args) > 2 {
-			// nameOnly := os.Args[2]
-			hash := os.Args[3]
 
-			dirName := hash[:2]
-			fileName := hash[2:]
+	case "describe":
+		lightweight := false
+		rev := "HEAD"
+		for _, arg := range os.Args[2:] {
+			if arg == "--tags" {
+				lightweight = true
+				continue
+			}
+			rev = arg
+		}
+		name, err := describe(ctx, rev, lightweight)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(name)
 
-			if err := os.Chdir(fmt.Sprintf(".git/objects/%s", dirName)); err != nil {
-				fmt.Fprintf(os.Stderr, "specified hash %s does not exist\n", hash)
-				return
+	case "blame":
+		var opts blameOptions
+		var args []string
+		usage := false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--porcelain":
+				opts.porcelain = true
+			case arg == "-L":
+				if i++; i == len(os.Args) {
+					usage = true
+					break
+				}
+				opts.lineRange = os.Args[i]
+			case strings.HasPrefix(arg, "-L"):
+				opts.lineRange = arg[len("-L"):]
+			default:
+				args = append(args, arg)
 			}
+		}
+		if usage || len(args) < 1 || len(args) > 2 {
+			fmt.Fprintf(os.Stderr, "usage: mygit blame [--porcelain] [-L <start>,<end>] [<rev>] <file>\n")
+			os.Exit(1)
+		}
+		rev, path := "HEAD", args[0]
+		if len(args) == 2 {
+			rev, path = args[0], args[1]
+		}
+		path, err := repoPath(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+		out, closePager := startPager(paginate)
+		err = blame(ctx, out, rev, path, opts)
+		closePager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
 
-			readFileContent, _ := DecompressAndRead(fileName)
-			split := bytes.Split([]byte(readFileContent), []byte("\x00"))
-			use := split[1 : len(split)-1]
-			for _, dByte := range use {
-				splitter := []byte(" ")
-				splitByte := bytes.Split(dByte, splitter)[1]
-				fmt.Println(string(splitByte))
+	case "shortlog":
+		var opts shortlogOptions
+		var revs []string
+		for _, arg := range os.Args[2:] {
+			if len(arg) > 1 && arg[0] == '-' && strings.Trim(arg[1:], "sn") == "" {
+				opts.summary = opts.summary || strings.Contains(arg, "s")
+				opts.numbered = opts.numbered || strings.Contains(arg, "n")
+				continue
 			}
+			revs = append(revs, arg)
+		}
+		hashes, err := resolveRevs(revs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		out, closePager := startPager(paginate)
+		err = shortlog(ctx, out, hashes, opts)
+		closePager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "log":
+		out, closePager := startPager(paginate)
+		err := runLog(ctx, out, os.Args[2:])
+		closePager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "show":
+		stat, rev, when := false, "HEAD", "auto"
+		for _, arg := range os.Args[2:] {
+			if ok, err := colorFlag(arg, &when); err != nil {
+				fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+				os.Exit(1)
+			} else if ok {
+				continue
+			}
+			switch {
+			case arg == "--stat":
+				stat = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Fprintf(os.Stderr, "usage: mygit show [--stat] [--color[=<when>]] [<commit>]\n")
+				os.Exit(1)
+			default:
+				rev = arg
+			}
+		}
+		out, closePager := startPager(paginate)
+		err := show(ctx, out, rev, stat, useColor(when, out))
+		closePager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "merge":
+		var opts mergeOptions
+		var args []string
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--no-commit":
+				opts.noCommit = true
+			case "--commit":
+				opts.noCommit = false
+			case "--ff-only":
+				opts.ffOnly, opts.noFF = true, false
+			case "--no-ff":
+				opts.ffOnly, opts.noFF = false, true
+			case "--ff":
+				opts.ffOnly, opts.noFF = false, false
+			default:
+				args = append(args, arg)
+			}
+		}
+		if len(args) != 1 || args[0] == "--abort" && len(os.Args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit merge (--abort | [--no-commit] [--ff-only | --no-ff] <branch>)\n")
+			os.Exit(1)
+		}
+		var err error
+		if args[0] == "--abort" {
+			err = mergeAbort()
+		} else {
+			err = merge(ctx, os.Stdout, args[0], opts)
+		}
+		if errors.Is(err, errMergeConflict) {
+			// git reports it after the conflicts, on stdout.
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "rebase":
+		if len(os.Args) != 3 {
+			fmt.Fprintf(os.Stderr, "usage: mygit rebase (--continue | --skip | --abort | <upstream>)\n")
+			os.Exit(1)
+		}
+		var err error
+		switch os.Args[2] {
+		case "--continue":
+			err = rebaseContinue(ctx, os.Stdout)
+		case "--skip":
+			err = rebaseSkip(ctx, os.Stdout)
+		case "--abort":
+			err = rebaseAbort()
+		default:
+			err = rebase(ctx, os.Stdout, os.Args[2])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "cherry-pick", "revert":
+		if len(os.Args) == 3 && (os.Args[2] == "--continue" || os.Args[2] == "--abort") {
+			var err error
+			if os.Args[2] == "--continue" {
+				err = cherryPickContinue(os.Stdout, command)
+			} else {
+				err = cherryPickAbort(command)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				os.Exit(1)
+			}
+			break
+		}
+		mainline := 0
+		var revs []string
+		for i := 2; i < len(os.Args); i++ {
+			if (os.Args[i] == "-m" || os.Args[i] == "--mainline") && i+1 < len(os.Args) {
+				n, err := strconv.Atoi(os.Args[i+1])
+				if err != nil || n <= 0 {
+					fmt.Fprintf(os.Stderr, "switch `m' expects a numerical value\n")
+					os.Exit(1)
+				}
+				mainline = n
+				i++
+				continue
+			}
+			revs = append(revs, os.Args[i])
+		}
+		if len(revs) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit %s (--continue | --abort | [-m <parent-number>] <commit>)\n", command)
+			os.Exit(1)
+		}
+		pick := cherryPick
+		if command == "revert" {
+			pick = revert
+		}
+		if err := pick(os.Stdout, revs[0], mainline); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "apply":
+		var check, reverse bool
+		var patches []string
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--check":
+				check = true
+			case "-R", "--reverse":
+				reverse = true
+			default:
+				patches = append(patches, arg)
+			}
+		}
+		if len(patches) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: mygit apply [--check] [-R | --reverse] <patch>\n")
+			os.Exit(1)
+		}
+		if err := applyPatch(startPath(patches[0]), check, reverse); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
 		}
 
 	default:
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMemStoreMatchesLooseStore writes the same objects to a memStore and
+// a looseStore and checks that both name, read, stat and know them
+// alike.
+func TestMemStoreMatchesLooseStore(t *testing.T) {
+	enterTempRepo(t)
+	mem, loose := NewMemStore(), looseStore{t.TempDir()}
+	objects := []struct {
+		objType ObjectType
+		body    string
+	}{
+		{BlobObject, ""},
+		{BlobObject, "hello\n"},
+		{BlobObject, "\x00\x01binary\xff"},
+		{BlobObject, strings.Repeat("long line\n", 10000)},
+		{TreeObject, ""},
+		{CommitObject, "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"author A <a@example.com> 0 +0000\ncommitter A <a@example.com> 0 +0000\n\nmessage\n"},
+		{TagObject, "object 4b825dc642cb6eb9a060e54bf8d69288fbee4904\ntype tree\ntag t\n\n"},
+	}
+	for _, o := range objects {
+		memHash, err := mem.Write(o.objType, []byte(o.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		looseHash, err := loose.Write(o.objType, []byte(o.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if memHash != looseHash || memHash != hashObject(o.objType, []byte(o.body)) {
+			t.Fatalf("%v %q: memStore names it %s, looseStore %s", o.objType, o.body, memHash, looseHash)
+		}
+		for _, s := range []ObjectStore{mem, loose} {
+			objType, body, err := s.Read(memHash)
+			if err != nil || objType != o.objType || string(body) != o.body {
+				t.Errorf("%T read %s as %v %q %v", s, memHash, objType, body, err)
+			}
+			objType, size, err := s.Stat(memHash)
+			if err != nil || objType != o.objType || size != int64(len(o.body)) {
+				t.Errorf("%T stat %s as %v %d %v", s, memHash, objType, size, err)
+			}
+			if !s.Has(memHash) {
+				t.Errorf("%T does not have %s", s, memHash)
+			}
+		}
+	}
+
+	missing := strings.Repeat("0", repoFormat().hexLen())
+	for _, s := range []ObjectStore{mem, loose} {
+		if s.Has(missing) {
+			t.Errorf("%T has %s", s, missing)
+		}
+		if _, _, err := s.Read(missing); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("%T read of a missing object: %v", s, err)
+		}
+		if _, _, err := s.Stat(missing); !errors.Is(err, errObjectNotFound) {
+			t.Errorf("%T stat of a missing object: %v", s, err)
+		}
+	}
+}
+
+// TestMemStoreCommands runs add, write-tree and commit-tree against a
+// memStore: they write nothing to .git/objects, and name the tree as
+// they do against the loose store.
+func TestMemStoreCommands(t *testing.T) {
+	dir := enterTempRepo(t)
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	for path, content := range map[string]string{"README": "hello\n", "src/main.go": "package main\n"} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repoObjects = NewMemStore()
+	if err := add([]string{"."}); err != nil {
+		t.Fatal(err)
+	}
+	memTree, err := writeTree("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := commitTree(memTree, nil, "in memory\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objType, _, err := readObject(commit); err != nil || objType != CommitObject {
+		t.Fatalf("reading the commit back: %v %v", objType, err)
+	}
+	filepath.WalkDir(filepath.Join(dir, ".git", "objects"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("%s written with a memStore", path)
+		}
+		return err
+	})
+
+	repoObjects = nil
+	if err := os.Remove(indexPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := add([]string{"."}); err != nil {
+		t.Fatal(err)
+	}
+	if looseTree, err := writeTree(""); err != nil || looseTree != memTree {
+		t.Errorf("the loose store names the tree %s, %v, the memStore %s", looseTree, err, memTree)
+	}
+}
+
+// TestMemStoreConcurrentAdd adds many files with a memStore installed,
+// which add hashes and writes from several goroutines at once; run with
+// -race, it checks the store is safe for that.
+func TestMemStoreConcurrentAdd(t *testing.T) {
+	enterTempRepo(t)
+	for i := 0; i < 200; i++ {
+		// The last fifty repeat the first, so writes of one object race.
+		path := fmt.Sprintf("dir%d/file%d", i%7, i)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("file %d\n", i%150)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repoObjects = NewMemStore()
+	if err := add([]string{"."}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.entries) != 200 {
+		t.Fatalf("added %d files, want 200", len(idx.entries))
+	}
+	for _, e := range idx.entries {
+		if !repoObjects.Has(e.hash) {
+			t.Errorf("%s: %s is not in the store", e.path, e.hash)
+		}
+	}
+}
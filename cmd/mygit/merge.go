@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// mergeHunk is a changed region of the base: lines [start, end) were
+// replaced by lines.
+type mergeHunk struct {
+	start, end int
+	lines      []string
+}
+
+// diffHunks groups an edit script from base into replaced regions.
+func diffHunks(base, other []string) []mergeHunk {
+	var hunks []mergeHunk
+	var cur *mergeHunk
+	i := 0
+	for _, op := range diffLines(base, other) {
+		switch op.kind {
+		case diffEqual:
+			if cur != nil {
+				hunks = append(hunks, *cur)
+				cur = nil
+			}
+			i++
+			continue
+		case diffDelete:
+			if cur == nil {
+				cur = &mergeHunk{start: i, end: i}
+			}
+			i++
+			cur.end = i
+		case diffInsert:
+			if cur == nil {
+				cur = &mergeHunk{start: i, end: i}
+			}
+			cur.lines = append(cur.lines, other[op.newLine])
+		}
+	}
+	if cur != nil {
+		hunks = append(hunks, *cur)
+	}
+	return hunks
+}
+
+// applyHunks returns base[start:end] with hunks, which must lie inside
+// that range, applied.
+func applyHunks(base []string, start, end int, hunks []mergeHunk) []string {
+	var out []string
+	for _, h := range hunks {
+		out = append(out, base[start:h.start]...)
+		out = append(out, h.lines...)
+		start = h.end
+	}
+	return append(out, base[start:end]...)
+}
+
+// mergeBlobs performs a diff3-style three-way merge. Changes made on only
+// one side, or identically on both, are applied; overlapping changes are
+// written between conflict markers and reported as a conflict.
+func mergeBlobs(base, ours, theirs []byte) (result []byte, conflict bool) {
+	return mergeBlobsLabeled(base, ours, theirs, "ours", "theirs")
+}
+
+// mergeBlobsLabeled is mergeBlobs with the names shown after the
+// <<<<<<< and >>>>>>> markers.
+func mergeBlobsLabeled(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, bool) {
+	baseLines := splitLines(base)
+	a := diffHunks(baseLines, splitLines(ours))
+	b := diffHunks(baseLines, splitLines(theirs))
+
+	var out bytes.Buffer
+	conflict := false
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Start a region at the earliest hunk and grow it while hunks
+		// from either side touch it.
+		var ra, rb []mergeHunk
+		var start, end int
+		if len(b) == 0 || len(a) > 0 && a[0].start <= b[0].start {
+			start, end = a[0].start, a[0].end
+			ra, a = a[:1], a[1:]
+		} else {
+			start, end = b[0].start, b[0].end
+			rb, b = b[:1], b[1:]
+		}
+		for grew := true; grew; {
+			grew = false
+			if len(a) > 0 && a[0].start <= end && len(rb) > 0 {
+				end = max(end, a[0].end)
+				ra, a = append(ra, a[0]), a[1:]
+				grew = true
+			}
+			if len(b) > 0 && b[0].start <= end && len(ra) > 0 {
+				end = max(end, b[0].end)
+				rb, b = append(rb, b[0]), b[1:]
+				grew = true
+			}
+		}
+
+		writeLines(&out, baseLines[pos:start])
+		pos = end
+		oursPart := applyHunks(baseLines, start, end, ra)
+		theirsPart := applyHunks(baseLines, start, end, rb)
+		switch {
+		case len(rb) == 0:
+			writeLines(&out, oursPart)
+		case len(ra) == 0:
+			writeLines(&out, theirsPart)
+		case strings.Join(oursPart, "") == strings.Join(theirsPart, ""):
+			writeLines(&out, oursPart)
+		default:
+			conflict = true
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			writeConflictSide(&out, oursPart)
+			out.WriteString("=======\n")
+			writeConflictSide(&out, theirsPart)
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+	}
+	writeLines(&out, baseLines[pos:])
+	return out.Bytes(), conflict
+}
+
+func writeLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+	}
+}
+
+// writeConflictSide writes one side of a conflict, terminating its last
+// line so the following marker starts on a line of its own.
+func writeConflictSide(buf *bytes.Buffer, lines []string) {
+	writeLines(buf, lines)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		buf.WriteByte('\n')
+	}
+}
+
+// treeMerge is the outcome of merging two flattened trees against their base.
+type treeMerge struct {
+	files     map[string]TreeEntry    // merged tree; conflicted paths keep ours
+	conflicts map[string][]byte       // conflicted paths and their marked-up content
+	stages    map[string][3]TreeEntry // base, ours and theirs of each conflicted path
+}
+
+// mergeTrees three-way merges the files of ours and theirs, writing the
+// blobs of clean content merges and reporting progress to w.
+func mergeTrees(w io.Writer, base, ours, theirs map[string]TreeEntry, oursLabel, theirsLabel string) (*treeMerge, error) {
+	all := map[string]TreeEntry{}
+	for _, files := range []map[string]TreeEntry{base, ours, theirs} {
+		for p, e := range files {
+			all[p] = e
+		}
+	}
+	m := &treeMerge{files: map[string]TreeEntry{}, conflicts: map[string][]byte{}, stages: map[string][3]TreeEntry{}}
+	for _, path := range sortedPaths(all) {
+		b, inBase := base[path]
+		o, inOurs := ours[path]
+		t, inTheirs := theirs[path]
+		switch {
+		case inOurs == inTheirs && o == t, inBase == inTheirs && b == t:
+			if inOurs {
+				m.files[path] = o
+			}
+			continue
+		case inBase == inOurs && b == o:
+			if inTheirs {
+				m.files[path] = t
+			}
+			continue
+		}
+
+		if !inOurs || !inTheirs {
+			// Changed on one side and deleted on the other; keep the change.
+			kept, deletedIn, modifiedIn := o, theirsLabel, oursLabel
+			if !inOurs {
+				kept, deletedIn, modifiedIn = t, oursLabel, theirsLabel
+			}
+			fmt.Fprintf(w, "CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.\n",
+				path, deletedIn, modifiedIn, modifiedIn, path)
+			m.files[path] = kept
+			m.conflicts[path] = nil
+			m.stages[path] = [3]TreeEntry{b, o, t}
+			continue
+		}
+		if !isRegularMode(o.Mode) || !isRegularMode(t.Mode) {
+			fmt.Fprintf(w, "CONFLICT (content): Merge conflict in %s\n", path)
+			m.files[path] = o
+			m.conflicts[path] = nil
+			m.stages[path] = [3]TreeEntry{b, o, t}
+			continue
+		}
+
+		fmt.Fprintf(w, "Auto-merging %s\n", path)
+		var baseBody []byte
+		if inBase {
+			_, body, err := readObject(b.Hash)
+			if err != nil {
+				return nil, err
+			}
+			baseBody = body
+		}
+		_, oursBody, err := readObject(o.Hash)
+		if err != nil {
+			return nil, err
+		}
+		_, theirsBody, err := readObject(t.Hash)
+		if err != nil {
+			return nil, err
+		}
+		merged, conflict := mergeBlobsLabeled(baseBody, oursBody, theirsBody, oursLabel, theirsLabel)
+		mode := o.Mode
+		if inBase && o.Mode == b.Mode {
+			mode = t.Mode
+		}
+		if conflict {
+			kind := "content"
+			if !inBase {
+				kind = "add/add"
+			}
+			fmt.Fprintf(w, "CONFLICT (%s): Merge conflict in %s\n", kind, path)
+			m.files[path] = o
+			m.conflicts[path] = merged
+			m.stages[path] = [3]TreeEntry{b, o, t}
+			continue
+		}
+		hash, err := writeObject(BlobObject, merged)
+		if err != nil {
+			return nil, err
+		}
+		m.files[path] = TreeEntry{Mode: mode, Name: o.Name, Hash: hash}
+	}
+	return m, nil
+}
+
+// isRegularMode reports whether a tree entry mode is a plain file whose
+// content can be merged line by line.
+func isRegularMode(mode string) bool {
+	return mode == "100644" || mode == "100755"
+}
+
+// mergeMessage is git's default merge commit subject for merging name
+// into the current branch.
+func mergeMessage(name, hash string) string {
+	var msg string
+	switch {
+	case strings.HasPrefix(name, "refs/remotes/"), hasRef("refs/remotes/" + name):
+		msg = fmt.Sprintf("Merge remote-tracking branch '%s'", strings.TrimPrefix(name, "refs/remotes/"))
+	case strings.HasPrefix(name, "refs/heads/"), hasRef("refs/heads/" + name):
+		msg = fmt.Sprintf("Merge branch '%s'", strings.TrimPrefix(name, "refs/heads/"))
+	case strings.HasPrefix(name, "refs/tags/"), hasRef("refs/tags/" + name):
+		msg = fmt.Sprintf("Merge tag '%s'", strings.TrimPrefix(name, "refs/tags/"))
+	default:
+		msg = fmt.Sprintf("Merge commit '%s'", hash)
+	}
+	if ref, _ := headRef(); ref != "" && ref != "refs/heads/main" && ref != "refs/heads/master" {
+		msg += " into " + strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return msg + "\n"
+}
+
+// hasRef reports whether a ref exists, as a loose file or in
+// packed-refs.
+func hasRef(name string) bool {
+	if info, err := os.Stat(gitPath(name)); err == nil {
+		return !info.IsDir()
+	}
+	if !strings.HasPrefix(name, "refs/") || perWorktreePath(name) {
+		return false
+	}
+	packed, err := readPackedRefs()
+	_, ok := packed[name]
+	return err == nil && ok
+}
+
+var errMergeConflict = errors.New("Automatic merge failed; fix conflicts and then commit the result.")
+
+// The files recording a merge that stopped before its commit: the
+// commits being merged, one per line, the message to conclude it with,
+// and the merge's options, "no-ff" when the merge was told not to
+// fast-forward.
+const (
+	mergeHeadFile = "MERGE_HEAD"
+	mergeMsgFile  = "MERGE_MSG"
+	mergeModeFile = "MERGE_MODE"
+)
+
+// mergeHeads returns the commits of the merge in progress, or nil when
+// the repository is not in the middle of one.
+func mergeHeads() ([]string, error) {
+	data, err := os.ReadFile(gitPath(mergeHeadFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// writeMergeState records a merge of theirs that stopped before its
+// commit, listing the conflicted paths, if any, in the message as git
+// does.
+func writeMergeState(theirs, message string, conflicts []string, noFF bool) error {
+	if err := writeMergeMsg(message, conflicts); err != nil {
+		return err
+	}
+	var mode []byte
+	if noFF {
+		mode = []byte("no-ff")
+	}
+	if err := os.WriteFile(gitPath(mergeModeFile), mode, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(gitPath(mergeHeadFile), []byte(theirs+"\n"), 0o644)
+}
+
+// writeMergeMsg writes the message to conclude a stopped merge, or
+// cherry-pick or revert, with, as git lists them, the conflicted paths.
+func writeMergeMsg(message string, conflicts []string) error {
+	var msg strings.Builder
+	msg.WriteString(message)
+	if len(conflicts) > 0 {
+		msg.WriteString("\n# Conflicts:\n")
+	}
+	for _, path := range conflicts {
+		fmt.Fprintf(&msg, "#\t%s\n", path)
+	}
+	return os.WriteFile(gitPath(mergeMsgFile), []byte(msg.String()), 0o644)
+}
+
+// clearMergeState forgets the merge, cherry-pick or revert in progress.
+func clearMergeState() error {
+	for _, name := range []string{mergeHeadFile, mergeMsgFile, mergeModeFile, cherryPickHeadFile, revertHeadFile} {
+		if err := os.Remove(gitPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmergedError is git's refusal to go on with action while the index
+// holds conflicts.
+func unmergedError(action string) error {
+	return errors.New("error: " + unmergedMessage(action) + "\nfatal: Exiting because of an unresolved conflict.")
+}
+
+// unmergedMessage is the body of unmergedError, with its hints.
+func unmergedMessage(action string) string {
+	return action + " is not possible because you have unmerged files.\n" +
+		"hint: Fix them up in the work tree, and then use 'git add/rm <file>'\n" +
+		"hint: as appropriate to mark resolution and make a commit."
+}
+
+var errMergeUnfinished = errors.New("fatal: You have not concluded your merge (MERGE_HEAD exists).\nPlease, commit your changes before you merge.")
+
+// mergeCheck refuses to write the merge m over local changes, as git
+// does: the index must match HEAD, and no file the merge changes or
+// leaves conflicted may have unstaged changes, or be untracked in the
+// way of one it adds. Files the merge leaves alone keep their changes.
+func (s *worktreeStatus) mergeCheck(m *treeMerge) error {
+	result := make(map[string]TreeEntry, len(m.files))
+	for path, e := range m.files {
+		result[path] = e
+	}
+	for path := range m.conflicts {
+		// Whatever is there, a conflicted path is rewritten.
+		result[path] = TreeEntry{}
+	}
+	changed, untracked := s.checkoutConflicts(result)
+	for _, e := range s.entries {
+		if e.staged != ' ' && e.staged != '?' && !slices.Contains(changed, e.path) {
+			changed = append(changed, e.path)
+		}
+	}
+	if len(changed)+len(untracked) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	return overwriteError("merge", changed, untracked)
+}
+
+// applyMerge merges theirs into ours and writes the result to the
+// working tree, which must hold ours, and to the index, where each
+// conflicted path has an entry at stage 1, 2 and 3 for the base, ours
+// and theirs, leaving out the sides it is missing from. It returns the
+// merged tree and whether any paths were left conflicted. Given the
+// status s of the working tree, it first refuses, writing nothing, a
+// merge that would lose local changes.
+func applyMerge(w io.Writer, s *worktreeStatus, base, ours, theirs map[string]TreeEntry, oursLabel, theirsLabel string) (string, bool, error) {
+	// The report waits until the merge is known to go ahead.
+	var report bytes.Buffer
+	m, err := mergeTrees(&report, base, ours, theirs, oursLabel, theirsLabel)
+	if err != nil {
+		return "", false, err
+	}
+	if s != nil {
+		if err := s.mergeCheck(m); err != nil {
+			return "", false, err
+		}
+	}
+	w.Write(report.Bytes())
+	if err := updateWorktree(ours, m.files); err != nil {
+		return "", false, err
+	}
+	tree, err := writeTreeFiles(m.files)
+	if err != nil {
+		return "", false, err
+	}
+	old, err := readIndex()
+	if err != nil {
+		return "", false, err
+	}
+	idx, err := indexFromTree(tree, old)
+	if err != nil {
+		return "", false, err
+	}
+	for path, content := range m.conflicts {
+		if content != nil {
+			if err := os.WriteFile(filepath.FromSlash(path), content, 0o644); err != nil {
+				return "", false, err
+			}
+		}
+		idx.removeUnder(path, nil)
+		for i, side := range m.stages[path] {
+			if side.Hash != "" {
+				idx.entries = append(idx.entries, &indexEntry{
+					path:  path,
+					hash:  side.Hash,
+					mode:  parseMode(side.Mode),
+					flags: uint16(i+1) << indexStageShift,
+				})
+			}
+		}
+	}
+	if err := idx.write(); err != nil {
+		return "", false, err
+	}
+	return tree, len(m.conflicts) > 0, nil
+}
+
+// mergeOptions are the flags of `mygit merge`.
+type mergeOptions struct {
+	noCommit bool // --no-commit: stop before committing a merge
+	ffOnly   bool // --ff-only: refuse anything but a fast-forward
+	noFF     bool // --no-ff: make a merge commit even to fast-forward
+}
+
+// merge implements `mygit merge [--no-commit] [--ff-only | --no-ff]
+// <branch>`: it fast-forwards when possible and otherwise merges the two
+// trees against their merge base, committing the result if there were
+// no conflicts. With ffOnly nothing but a fast-forward is done; with
+// noFF a merge commit is made instead of one. With noCommit the merge is
+// left in the index and working tree for `mygit commit` to conclude; as
+// in git, a fast-forward still happens unless noFF is set too.
+func merge(ctx *cmdContext, w io.Writer, name string, opts mergeOptions) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if len(idx.unmerged()) > 0 {
+		return unmergedError("Merging")
+	}
+	if heads, err := mergeHeads(); err != nil {
+		return err
+	} else if heads != nil {
+		return errMergeUnfinished
+	}
+	ours, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	theirs, err := resolveObjectName(name)
+	if err != nil {
+		return fmt.Errorf("%s - not something we can merge", name)
+	}
+	if theirs, err = peelToCommit(theirs); err != nil {
+		return err
+	}
+	if ok, err := ctx.isAncestor(theirs, ours); err != nil {
+		return err
+	} else if ok {
+		fmt.Fprintln(w, "Already up to date.")
+		return nil
+	}
+	base, err := ctx.mergeBase(ours, theirs)
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		return errors.New("refusing to merge unrelated histories")
+	}
+	if err := saveOrigHead(ours); err != nil {
+		return err
+	}
+	if base != ours && opts.ffOnly {
+		return errors.New("fatal: Not possible to fast-forward, aborting.")
+	}
+
+	oursCommit, err := ctx.getCommit(ours)
+	if err != nil {
+		return err
+	}
+	theirsCommit, err := ctx.getCommit(theirs)
+	if err != nil {
+		return err
+	}
+	oursFiles, err := flattenTree(oursCommit.Tree)
+	if err != nil {
+		return err
+	}
+	theirsFiles, err := flattenTree(theirsCommit.Tree)
+	if err != nil {
+		return err
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	if base == ours && !opts.noFF {
+		// As a checkout would, a fast-forward keeps local changes to the
+		// files it leaves alone, staged or not.
+		fmt.Fprintf(w, "Updating %s..%s\n", ours[:7], theirs[:7])
+		if changed, untracked := s.checkoutConflicts(theirsFiles); len(changed)+len(untracked) > 0 {
+			return fmt.Errorf("error: %w", overwriteError("merge", changed, untracked))
+		}
+		fmt.Fprintln(w, "Fast-forward")
+		if err := s.checkoutFiles(theirsFiles, false); err != nil {
+			return err
+		}
+		return updateHead(theirs, "merge "+name+": Fast-forward")
+	}
+
+	baseCommit, err := ctx.getCommit(base)
+	if err != nil {
+		return err
+	}
+	baseFiles, err := flattenTree(baseCommit.Tree)
+	if err != nil {
+		return err
+	}
+	tree, conflicted, err := applyMerge(w, s, baseFiles, oursFiles, theirsFiles, "HEAD", name)
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	if conflicted {
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		if err := writeMergeState(theirs, mergeMessage(name, theirs), idx.unmerged(), opts.noFF); err != nil {
+			return err
+		}
+		return errMergeConflict
+	}
+	if opts.noCommit {
+		if err := writeMergeState(theirs, mergeMessage(name, theirs), nil, opts.noFF); err != nil {
+			return err
+		}
+		// git prints this one to standard error.
+		fmt.Fprintln(os.Stderr, "Automatic merge went well; stopped before committing as requested")
+		return nil
+	}
+
+	hash, err := writeCommit(tree, []string{ours, theirs}, mergeMessage(name, theirs), nil)
+	if err != nil {
+		return err
+	}
+	if err := updateHead(hash, "merge "+name+": Merge made by the 'resolve' strategy."); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Merge made by the 'resolve' strategy.")
+	return nil
+}
+
+// mergeAbort implements `mygit merge --abort`: the index and the files
+// the stopped merge changed go back to HEAD, and the merge state is
+// removed. Local changes to files the merge did not touch are kept.
+func mergeAbort() error {
+	heads, err := mergeHeads()
+	if err != nil {
+		return err
+	}
+	if heads == nil {
+		return errors.New("fatal: There is no merge to abort (MERGE_HEAD missing).")
+	}
+	return abortToHead()
+}
+
+// abortToHead puts the index and the files a stopped merge, cherry-pick
+// or revert changed back to HEAD, and removes its state.
+func abortToHead() error {
+	head, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	// A path is the merge's doing if its index entry differs from HEAD.
+	kept := &index{}
+	touched := map[string]bool{}
+	for _, e := range idx.entries {
+		if te, ok := files[e.path]; ok && e.stage() == 0 && te.Hash == e.hash && parseMode(te.Mode) == e.mode {
+			kept.entries = append(kept.entries, e)
+		} else {
+			touched[e.path] = true
+		}
+	}
+	for path := range files {
+		if idx.entry(path) == nil {
+			touched[path] = true
+		}
+	}
+	restore := map[string]TreeEntry{}
+	for _, path := range sortedKeys(touched) {
+		te, ok := files[path]
+		if !ok {
+			if err := removeWorktreeFile(path); err != nil {
+				return err
+			}
+			continue
+		}
+		restore[path] = te
+	}
+	if err := updateWorktree(map[string]TreeEntry{}, restore); err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(restore) {
+		e, err := newIndexEntry(path, restore[path].Hash, parseMode(restore[path].Mode))
+		if err != nil {
+			return err
+		}
+		kept.add(e)
+	}
+	if err := kept.write(); err != nil {
+		return err
+	}
+	return clearMergeState()
+}
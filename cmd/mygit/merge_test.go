@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mergeRepo sets up a repository whose branch topic changes a and adds
+// new.txt over main's root commit. With diverge, main changes c too, so
+// merging topic is a true merge rather than a fast-forward.
+func mergeRepo(t *testing.T, diverge bool) *goldenRepo {
+	r := newGoldenRepo(t)
+	r.write("a", "a\n", 0o644)
+	r.write("b", "b\n", 0o644)
+	r.write("c", "c\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("checkout", "-q", "-b", "topic")
+	r.write("a", "a from topic\n", 0o644)
+	r.write("new.txt", "new\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "topic")
+	r.git("checkout", "-q", "main")
+	if diverge {
+		r.write("c", "c from main\n", 0o644)
+		r.git("commit", "-q", "-am", "main")
+	}
+	return r
+}
+
+// TestMergeKeepsLocalChanges checks that merge, fast-forward or not,
+// refuses to overwrite a modified file, an untracked one or a staged
+// change, leaving all three and HEAD as they were.
+func TestMergeKeepsLocalChanges(t *testing.T) {
+	for _, diverge := range []bool{false, true} {
+		for _, tc := range []struct {
+			name    string
+			prepare func(r *goldenRepo)
+			path    string
+			want    string
+		}{
+			{"modified", func(r *goldenRepo) { r.write("a", "local\n", 0o644) },
+				"a", "Your local changes to the following files would be overwritten by merge:\n\ta\n"},
+			{"untracked", func(r *goldenRepo) { r.write("new.txt", "local\n", 0o644) },
+				"new.txt", "The following untracked working tree files would be overwritten by merge:\n\tnew.txt\n"},
+			{"staged", func(r *goldenRepo) { r.write("a", "local\n", 0o644); r.git("add", "a") },
+				"a", "Your local changes to the following files would be overwritten by merge:\n\ta\n"},
+		} {
+			r := mergeRepo(t, diverge)
+			head := r.git("rev-parse", "HEAD")
+			tc.prepare(r)
+			status := r.git("status", "--porcelain")
+			got := r.mygitFails("merge", "topic")
+			if !strings.Contains(got, tc.want) || !strings.HasPrefix(got, "error: ") {
+				t.Errorf("%s (diverged %v): merge printed %q, want %q", tc.name, diverge, got, tc.want)
+			}
+			if data, err := os.ReadFile(filepath.Join(r.dir, tc.path)); err != nil || string(data) != "local\n" {
+				t.Errorf("%s (diverged %v): %s is now %q, %v", tc.name, diverge, tc.path, data, err)
+			}
+			if after := r.git("status", "--porcelain"); after != status {
+				t.Errorf("%s (diverged %v): status went from %q to %q", tc.name, diverge, status, after)
+			}
+			if after := r.git("rev-parse", "HEAD"); after != head {
+				t.Errorf("%s (diverged %v): HEAD moved", tc.name, diverge)
+			}
+		}
+	}
+}
+
+// TestMergeStagedChangeElsewhere checks that a fast-forward keeps a
+// staged change to a file it does not touch, as git's does, while a
+// true merge refuses it, since as in git its index must match HEAD.
+func TestMergeStagedChangeElsewhere(t *testing.T) {
+	r := mergeRepo(t, false)
+	r.write("b", "staged\n", 0o644)
+	r.git("add", "b")
+	r.mygit("merge", "topic")
+	if got := r.git("status", "--porcelain"); got != "M  b\n" {
+		t.Errorf("status after a fast-forward = %q, want b staged", got)
+	}
+	if got := r.git("show", ":a"); got != "a from topic\n" {
+		t.Errorf("a in the index is %q", got)
+	}
+
+	r = mergeRepo(t, true)
+	r.write("b", "staged\n", 0o644)
+	r.git("add", "b")
+	if got := r.mygitFails("merge", "topic"); !strings.Contains(got, "overwritten by merge:\n\tb\n") {
+		t.Errorf("merge with b staged printed %q", got)
+	}
+}
+
+// hostileTree writes a tree with git, which does not check what it
+// is given, whose entries are (mode, name, object) triples in order.
+func (r *goldenRepo) hostileTree(entries ...[3]string) string {
+	r.t.Helper()
+	var b strings.Builder
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e[2])
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		b.WriteString(e[0] + " " + e[1] + "\x00" + string(raw))
+	}
+	return strings.TrimSpace(r.run(b.String(), "git", []string{"hash-object", "-t", "tree", "-w", "--literally", "--stdin"}))
+}
+
+// TestHostileTreeRefused checks out, merges and clones trees that would
+// write outside the working tree through a symlink, and ones whose
+// entries collide, and checks that each is refused with nothing written
+// outside.
+func TestHostileTreeRefused(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("README", "hello\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	outside := t.TempDir()
+
+	blob := strings.TrimSpace(r.run("evil\n", "git", []string{"hash-object", "-w", "--stdin"}))
+	link := strings.TrimSpace(r.run(outside, "git", []string{"hash-object", "-w", "--stdin"}))
+	readme := strings.TrimSpace(r.git("rev-parse", "HEAD:README"))
+	sub := r.hostileTree([3]string{"100644", "x", blob})
+	trees := map[string]string{
+		// "d" both a symlink out of the tree and a directory holding x.
+		"duplicate": r.hostileTree([3]string{"100644", "README", readme}, [3]string{"120000", "d", link}, [3]string{"40000", "d", sub}),
+		// "D" a symlink, and "d/x" through it where case is folded.
+		"case": r.hostileTree([3]string{"120000", "D", link}, [3]string{"100644", "README", readme}, [3]string{"40000", "d", sub}),
+		// d/x, to be written through a symlink already in the way.
+		"leading": r.hostileTree([3]string{"100644", "README", readme}, [3]string{"40000", "d", sub}),
+	}
+	head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	for name, tree := range trees {
+		commit := strings.TrimSpace(r.git("commit-tree", "-p", head, "-m", name, tree))
+		r.git("branch", "-f", name, commit)
+	}
+	if err := os.Symlink(outside, filepath.Join(r.dir, "d")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"duplicate", "case", "leading"} {
+		for _, args := range [][]string{{"checkout", name}, {"merge", name}} {
+			r.mygitFails(args...)
+			if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+				t.Fatalf("%s wrote %s outside the working tree", strings.Join(args, " "), entries[0].Name())
+			}
+		}
+	}
+
+	for _, name := range []string{"duplicate", "case"} {
+		r.git("symbolic-ref", "HEAD", "refs/heads/"+name)
+		target := filepath.Join(t.TempDir(), "clone")
+		r.mygitFails("clone", r.dir, target)
+		if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+			t.Fatalf("clone of %s wrote %s outside the working tree", name, entries[0].Name())
+		}
+	}
+}
+
+// TestMergeBlobs checks mergeBlobsLabeled's three-way merges against the
+// results git merge-file gives for the same files.
+func TestMergeBlobs(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflict           bool
+	}{
+		{"clean", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n",
+			"A\nb\nc\nd\nE\n", false},
+		{"overlap", "a\nb\nc\n", "a\nours\nc\n", "a\ntheirs\nc\n",
+			"a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> topic\nc\n", true},
+		{"same change", "a\nb\nc\n", "a\nsame\nc\n", "a\nsame\nc\n",
+			"a\nsame\nc\n", false},
+		{"deleted and modified", "a\nb\nc\n", "a\nc\n", "a\nchanged\nc\n",
+			"a\n<<<<<<< HEAD\n=======\nchanged\n>>>>>>> topic\nc\n", true},
+		{"emptied and extended", "a\nb\nc\n", "", "a\nb\nc\nd\n",
+			"<<<<<<< HEAD\n=======\na\nb\nc\nd\n>>>>>>> topic\n", true},
+		{"no final newline", "a\nb\nc", "a\nB\nc", "a\nb\nC",
+			"a\n<<<<<<< HEAD\nB\nc\n=======\nb\nC\n>>>>>>> topic\n", true},
+		{"final newline added", "a\nb\nc", "a\nb\nc\n", "a\nb\nc",
+			"a\nb\nc\n", false},
+	} {
+		got, conflict := mergeBlobsLabeled([]byte(tc.base), []byte(tc.ours), []byte(tc.theirs), "HEAD", "topic")
+		if string(got) != tc.want || conflict != tc.conflict {
+			t.Errorf("%s: got %q, conflict %v; want %q, conflict %v", tc.name, got, conflict, tc.want, tc.conflict)
+		}
+	}
+	if got, conflict := mergeBlobs([]byte("a\n"), []byte("b\n"), []byte("c\n")); !conflict ||
+		string(got) != "<<<<<<< ours\nb\n=======\nc\n>>>>>>> theirs\n" {
+		t.Errorf("mergeBlobs labels: got %q, conflict %v", got, conflict)
+	}
+}
+
+// conflictRepo sets up a repository whose branch topic conflicts with
+// main in three ways: both change a, main deletes b while topic changes
+// it, and both add c. d changes only on topic and merges cleanly.
+func conflictRepo(t *testing.T) *goldenRepo {
+	r := newGoldenRepo(t)
+	for _, name := range []string{"a", "b", "d"} {
+		r.write(name, name+"\n", 0o644)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("checkout", "-q", "-b", "topic")
+	r.write("a", "a from topic\n", 0o644)
+	r.write("b", "b from topic\n", 0o644)
+	r.write("c", "c from topic\n", 0o644)
+	r.write("d", "d from topic\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "topic")
+	r.git("checkout", "-q", "main")
+	r.write("a", "a from main\n", 0o644)
+	r.write("c", "c from main\n", 0o644)
+	r.git("rm", "-q", "b")
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "main")
+	return r
+}
+
+// mergeState describes a repository in the middle of a merge: the merge
+// state files, status in both forms, the index and the files.
+func mergeState(r *goldenRepo) string {
+	var b strings.Builder
+	for _, name := range []string{".git/MERGE_HEAD", ".git/MERGE_MSG", ".git/MERGE_MODE", "a", "b", "c", "d"} {
+		data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+		if err != nil {
+			data = []byte("missing\n")
+		}
+		b.WriteString(name + ": " + string(data))
+	}
+	return b.String() + r.git("status") + r.git("status", "--porcelain") + r.git("ls-files", "-s") + r.git("rev-parse", "HEAD")
+}
+
+// TestMergeConflict stops a merge for conflicts and compares the state
+// it leaves with git's, then checks merge --abort, which puts back the
+// state from before it, and that without a merge in progress there is
+// nothing to abort.
+func TestMergeConflict(t *testing.T) {
+	r := conflictRepo(t)
+	state := func() string { return mergeState(r) }
+	r.sameFailure("merge", "--abort")
+	r.sameRun(r.snapshot(), state, "merge", "topic")
+	r.sameFailure("commit", "-m", "unresolved")
+	r.sameFailure("merge", "topic")
+	r.sameRun(r.snapshot(), state, "merge", "--abort")
+}
+
+// TestMergeConflictStages checks the index a stopped merge leaves
+// against git's: stages 1 to 3 for a path both sides changed, no stage 2
+// for one main deleted and no stage 1 for one both added, as ls-files -u
+// lists them, and that adding a path, resolved or deleted, takes it out
+// of the higher stages so the merge can be committed.
+func TestMergeConflictStages(t *testing.T) {
+	r := conflictRepo(t)
+	r.stderrOf("git", "merge", "topic")
+	r.same("ls-files", "-u")
+	r.same("ls-files", "-s")
+	r.same("ls-files", "--unmerged")
+
+	state := func() string { return mergeState(r) }
+	r.write("a", "a resolved\n", 0o644)
+	r.sameRun(r.snapshot(), state, "add", "a")
+	os.Remove(filepath.Join(r.dir, "b"))
+	r.sameRun(r.snapshot(), state, "add", "b")
+	r.write("c", "c resolved\n", 0o644)
+	r.mygit("add", "c")
+	r.same("ls-files", "-u")
+	r.mygit("commit", "-m", "merged")
+	if got := r.git("rev-list", "--parents", "-1", "HEAD"); len(strings.Fields(got)) != 3 {
+		t.Errorf("resolved merge committed with parents %q", got)
+	}
+}
+
+// TestConflicts stops a merge of conflictRepo with git and checks that
+// conflicts lists each unmerged path with the stages git ls-files -u
+// shows for it, with -z as well, and drops a path once it is resolved.
+func TestConflicts(t *testing.T) {
+	r := conflictRepo(t)
+	if got := r.mygit("conflicts"); got != "" {
+		t.Errorf("conflicts with no merge = %q", got)
+	}
+	r.stderrOf("git", "merge", "topic")
+	want := func() (string, string) {
+		var lines, nul strings.Builder
+		stages := map[string]string{}
+		var paths []string
+		for _, line := range strings.Split(strings.TrimSuffix(r.git("ls-files", "-u"), "\n"), "\n") {
+			info, path, _ := strings.Cut(line, "\t")
+			if stages[path] == "" {
+				paths = append(paths, path)
+			}
+			stages[path] += info[len(info)-1:]
+		}
+		for _, path := range paths {
+			lines.WriteString(stages[path] + "\t" + path + "\n")
+			nul.WriteString(stages[path] + "\t" + path + "\x00")
+		}
+		return lines.String(), nul.String()
+	}
+	lines, nul := want()
+	if lines != "123\ta\n13\tb\n23\tc\n" {
+		t.Fatalf("git left unmerged %q", lines)
+	}
+	if got := r.mygit("conflicts"); got != lines {
+		t.Errorf("conflicts = %q, want %q", got, lines)
+	}
+	if got := r.mygit("conflicts", "-z"); got != nul {
+		t.Errorf("conflicts -z = %q, want %q", got, nul)
+	}
+	r.git("add", "a")
+	lines, _ = want()
+	if got := r.mygit("conflicts"); got != lines {
+		t.Errorf("conflicts after a is resolved = %q, want %q", got, lines)
+	}
+}
+
+// TestMergeOptions merges topic with --no-commit, --ff-only and --no-ff,
+// alone and together, as a fast-forward and as a true merge, checking the
+// state each leaves against git's, and that a commit concludes a merge
+// stopped by --no-commit with the same commit git makes.
+func TestMergeOptions(t *testing.T) {
+	for _, diverge := range []bool{false, true} {
+		r := mergeRepo(t, diverge)
+		state := func() string {
+			return mergeState(r) + r.git("log", "--format=%H %P %s")
+		}
+		for _, opts := range [][]string{
+			{"--no-commit"},
+			{"--ff-only"},
+			{"--no-ff"},
+			{"--no-commit", "--no-ff"},
+			{"--no-ff", "--ff-only"},
+			{"--ff-only", "--no-ff"},
+			{"--no-ff", "--ff"},
+		} {
+			undo := r.snapshot()
+			r.sameRun(undo, state, append(append([]string{"merge"}, opts...), "topic")...)
+			undo()
+		}
+
+		undo := r.snapshot()
+		r.git("merge", "--no-commit", "--no-ff", "topic")
+		r.sameRun(r.snapshot(), state, "commit", "-m", "merged")
+		undo()
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Chunk IDs of a multi-pack-index file.
+const (
+	midxChunkPackNames    = 0x504e414d // "PNAM"
+	midxChunkFanout       = 0x4f494446 // "OIDF"
+	midxChunkLookup       = 0x4f49444c // "OIDL"
+	midxChunkOffsets      = 0x4f4f4646 // "OOFF"
+	midxChunkLargeOffsets = 0x4c4f4646 // "LOFF"
+)
+
+// multiPackIndex is a parsed pack/multi-pack-index file: one sorted
+// list of the objects in many packs, with the pack and offset of each,
+// so finding an object is a single binary search rather than one per
+// pack.
+type multiPackIndex struct {
+	path         string
+	hashSize     int
+	packs        []string // .idx names, sorted
+	fanout       [256]uint32
+	hashes       []byte
+	offsets      []byte // pack number and offset, 8 bytes per object
+	largeOffsets []byte
+}
+
+// multiPackIndexes caches the multi-pack-index of each object directory
+// for this run; nil means the directory has none. multiPackIndexesMu
+// guards it, since objects are looked up from several goroutines.
+var (
+	multiPackIndexes   = map[string]*multiPackIndex{}
+	multiPackIndexesMu sync.Mutex
+)
+
+// openMultiPackIndex returns the multi-pack-index of the object
+// directory objects, or nil if it has none or it cannot be used, in
+// which case the .idx of each pack is searched instead.
+func openMultiPackIndex(objects string) *multiPackIndex {
+	multiPackIndexesMu.Lock()
+	defer multiPackIndexesMu.Unlock()
+	if m, ok := multiPackIndexes[objects]; ok {
+		return m
+	}
+	m, err := readMultiPackIndex(filepath.Join(objects, "pack", "multi-pack-index"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	multiPackIndexes[objects] = m
+	return m
+}
+
+// resetMultiPackIndexes forgets the multi-pack-index of every object
+// directory.
+func resetMultiPackIndexes() {
+	multiPackIndexesMu.Lock()
+	multiPackIndexes = map[string]*multiPackIndex{}
+	multiPackIndexesMu.Unlock()
+}
+
+// readMultiPackIndex parses the version 1 multi-pack-index at path.
+func readMultiPackIndex(path string) (*multiPackIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || !bytes.Equal(data[:4], []byte("MIDX")) {
+		return nil, fmt.Errorf("%s: not a multi-pack-index", path)
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("%s: unsupported multi-pack-index version %d", path, data[4])
+	}
+	m := &multiPackIndex{path: path}
+	switch format := repoFormat(); {
+	case data[5] == 1 && format.name == "sha1", data[5] == 2 && format.name == "sha256":
+		m.hashSize = format.size
+	default:
+		return nil, fmt.Errorf("%s: multi-pack-index hash version %d does not match the repository", path, data[5])
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("%s: multi-pack-index base files are not supported", path)
+	}
+	packCount := int(binary.BigEndian.Uint32(data[8:]))
+
+	// As in a commit-graph, the table of contents ends with an entry
+	// whose offset ends the last chunk.
+	chunks := map[uint32][]byte{}
+	count := int(data[6])
+	toc := data[12:]
+	if len(toc) < (count+1)*12 {
+		return nil, fmt.Errorf("%s: multi-pack-index truncated", path)
+	}
+	for i := 0; i < count; i++ {
+		id := binary.BigEndian.Uint32(toc[i*12:])
+		start := binary.BigEndian.Uint64(toc[i*12+4:])
+		end := binary.BigEndian.Uint64(toc[i*12+16:])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("%s: multi-pack-index chunk out of bounds", path)
+		}
+		chunks[id] = data[start:end]
+	}
+
+	// Pack names are NUL-terminated, with padding after the last.
+	for _, name := range strings.Split(string(chunks[midxChunkPackNames]), "\x00") {
+		if name != "" {
+			m.packs = append(m.packs, name)
+		}
+	}
+	if len(m.packs) != packCount {
+		return nil, fmt.Errorf("%s: multi-pack-index names %d packs, not %d", path, len(m.packs), packCount)
+	}
+	fanout := chunks[midxChunkFanout]
+	if len(fanout) != 256*4 {
+		return nil, fmt.Errorf("%s: multi-pack-index fanout chunk missing or malformed", path)
+	}
+	for i := range m.fanout {
+		m.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && m.fanout[i] < m.fanout[i-1] {
+			return nil, fmt.Errorf("%s: multi-pack-index fanout out of order", path)
+		}
+	}
+	n := int(m.fanout[255])
+	m.hashes = chunks[midxChunkLookup]
+	m.offsets = chunks[midxChunkOffsets]
+	m.largeOffsets = chunks[midxChunkLargeOffsets]
+	if len(m.hashes) != n*m.hashSize || len(m.offsets) != n*8 {
+		return nil, fmt.Errorf("%s: multi-pack-index lookup or offset chunk malformed", path)
+	}
+	return m, nil
+}
+
+// covers reports whether the pack whose index is at path is listed.
+func (m *multiPackIndex) covers(path string) bool {
+	name := filepath.Base(path)
+	for _, p := range m.packs {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the packfile and offset of the object with the raw
+// hash.
+func (m *multiPackIndex) lookup(hash []byte) (packLocation, bool) {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(m.fanout[hash[0]-1])
+	}
+	hi := int(m.fanout[hash[0]])
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch c := bytes.Compare(m.hashes[mid*m.hashSize:(mid+1)*m.hashSize], hash); {
+		case c == 0:
+			pack := binary.BigEndian.Uint32(m.offsets[mid*8:])
+			off := int64(binary.BigEndian.Uint32(m.offsets[mid*8+4:]))
+			if off&(1<<31) != 0 {
+				i := int(off &^ (1 << 31))
+				if (i+1)*8 > len(m.largeOffsets) {
+					return packLocation{}, false
+				}
+				off = int64(binary.BigEndian.Uint64(m.largeOffsets[i*8:]))
+			}
+			if int(pack) >= len(m.packs) {
+				return packLocation{}, false
+			}
+			name := strings.TrimSuffix(m.packs[pack], ".idx") + ".pack"
+			return packLocation{filepath.Join(filepath.Dir(m.path), name), off}, true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return packLocation{}, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os/exec"
+	"sync"
+	"testing"
+)
+
+// TestOpenMultiPackIndexConcurrently opens the multi-pack-index from
+// several goroutines at once, as parallel lookups do; run with -race.
+func TestOpenMultiPackIndexConcurrently(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	hashes := packTempRepo(t, 100)
+	if out, err := exec.Command("git", "multi-pack-index", "write").CombinedOutput(); err != nil {
+		t.Fatalf("git multi-pack-index write: %v\n%s", err, out)
+	}
+	resetPackCache()
+
+	midxs := make([]*multiPackIndex, 8)
+	var wg sync.WaitGroup
+	for i := range midxs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			midxs[i] = openMultiPackIndex(objectsDir())
+		}()
+	}
+	wg.Wait()
+	for i, m := range midxs {
+		if m == nil || m != midxs[0] {
+			t.Fatalf("goroutine %d opened %p, want the one multi-pack-index %p", i, m, midxs[0])
+		}
+	}
+	if _, _, err := objectStore().Read(hashes[42]); err != nil {
+		t.Fatal(err)
+	}
+}
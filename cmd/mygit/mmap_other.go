@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// openPackFile opens the packfile at path for ordinary reads where
+// memory mapping is not available.
+func openPackFile(path string) (packFile, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// mappedFile is a read-only memory mapping of a whole file.
+type mappedFile struct {
+	data []byte
+}
+
+func (m *mappedFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close unmaps the file; the mapping must not be used afterwards.
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// openPackFile maps the packfile at path into memory, falling back to
+// ordinary reads when the file cannot be mapped.
+func openPackFile(path string) (packFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return f, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return f, nil
+	}
+	f.Close() // the mapping stays valid without the descriptor
+	return &mappedFile{data: data}, nil
+}
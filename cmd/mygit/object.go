@@ -0,0 +1,242 @@
+package main
+
+import (
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// ObjectType is the kind of a git object. The values match the type
+// codes used in packfiles.
+type ObjectType int
+
+const (
+	CommitObject ObjectType = 1
+	TreeObject   ObjectType = 2
+	BlobObject   ObjectType = 3
+	TagObject    ObjectType = 4
+)
+
+var objectTypeNames = map[ObjectType]string{
+	CommitObject: "commit",
+	TreeObject:   "tree",
+	BlobObject:   "blob",
+	TagObject:    "tag",
+}
+
+// String returns the name used in object headers.
+func (t ObjectType) String() string {
+	if name, ok := objectTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ObjectType(%d)", int(t))
+}
+
+// valid reports whether t is one of the four object types.
+func (t ObjectType) valid() bool {
+	_, ok := objectTypeNames[t]
+	return ok
+}
+
+// ParseObjectType converts an object header type name to an ObjectType.
+func ParseObjectType(name string) (ObjectType, error) {
+	for t, n := range objectTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid object type %q", name)
+}
+
+// parseGitObject splits a decompressed object into its type and body.
+func parseGitObject(data string) (ObjectType, []byte, error) {
+	typeName, body, err := parseObjectHeader(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	objType, err := ParseObjectType(typeName)
+	if err != nil {
+		return 0, nil, err
+	}
+	return objType, []byte(body), nil
+}
+
+// parseObjectHeader checks the "<type> <size>\x00" header of a
+// decompressed object without validating the type name.
+func parseObjectHeader(data string) (string, string, error) {
+	nul := strings.IndexByte(data, 0)
+	if nul < 0 {
+		return "", "", errors.New("malformed object: missing NUL after header")
+	}
+	typeName, size, err := parseHeaderLine(data[:nul])
+	if err != nil {
+		return "", "", err
+	}
+	if size > maxObjectSize() {
+		return "", "", fmt.Errorf("object of %d bytes exceeds the maximum object size of %d", size, maxObjectSize())
+	}
+	body := data[nul+1:]
+	if size != int64(len(body)) {
+		return "", "", fmt.Errorf("object size mismatch: header says %d, got %d", size, len(body))
+	}
+	return typeName, body, nil
+}
+
+// parseHeaderLine splits the "<type> <size>" of an object header, the
+// NUL that ends it already removed.
+func parseHeaderLine(header string) (string, int64, error) {
+	typeName, sizeText, ok := strings.Cut(header, " ")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed object header %q", header)
+	}
+	// ParseInt would also take a sign or leading zeros, which git does
+	// not allow.
+	size, err := strconv.ParseInt(sizeText, 10, 64)
+	if err != nil || sizeText[0] < '0' || sizeText[0] > '9' || sizeText[0] == '0' && len(sizeText) > 1 {
+		return "", 0, fmt.Errorf("malformed object size %q", sizeText)
+	}
+	return typeName, size, nil
+}
+
+// defaultMaxObjectSize bounds how large an object may inflate to unless
+// core.maxObjectSize says otherwise.
+const defaultMaxObjectSize = 1 << 30
+
+// maxObjectSizeSetting caches the limit for this run; 0 means unread.
+var maxObjectSizeSetting int64
+
+// maxObjectSize returns the largest object body mygit will inflate, so
+// that a hostile object cannot exhaust memory.
+func maxObjectSize() int64 {
+	if maxObjectSizeSetting == 0 {
+		maxObjectSizeSetting = defaultMaxObjectSize
+		if cfg, err := readConfig(); err == nil {
+			if v, ok := cfg.get("core", "", "maxobjectsize"); ok {
+				if n, err := parseConfigSize(v); err == nil && n > 0 {
+					maxObjectSizeSetting = n
+				}
+			}
+		}
+	}
+	return maxObjectSizeSetting
+}
+
+// defaultMaxDeltaDepth is how long a chain of deltas may be unless
+// core.maxDeltaDepth says otherwise. It matches git's default pack.depth,
+// so packs written by git with default settings always fit.
+const defaultMaxDeltaDepth = 50
+
+// maxDeltaDepthSetting caches the limit for this run; 0 means unread.
+var maxDeltaDepthSetting int
+
+// maxDeltaDepth returns the longest delta chain mygit will resolve, so a
+// hostile pack cannot make resolution run away.
+func maxDeltaDepth() int {
+	if maxDeltaDepthSetting == 0 {
+		maxDeltaDepthSetting = defaultMaxDeltaDepth
+		if cfg, err := readConfig(); err == nil {
+			if v, ok := cfg.get("core", "", "maxdeltadepth"); ok {
+				if n, err := strconv.Atoi(v); err == nil && n > 0 {
+					maxDeltaDepthSetting = n
+				}
+			}
+		}
+	}
+	return maxDeltaDepthSetting
+}
+
+// inflateObject decompresses a zlib stream that should hold exactly size
+// bytes, refusing sizes over the limit and streams that run past size.
+func inflateObject(r io.Reader, size int64) ([]byte, error) {
+	if size > maxObjectSize() {
+		return nil, fmt.Errorf("object of %d bytes exceeds the maximum object size of %d", size, maxObjectSize())
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(&io.LimitedReader{R: zr, N: size + 1})
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, errors.New("size mismatch")
+	}
+	return data, nil
+}
+
+// readObject returns the type and body of the object named by hash,
+// looking in the loose object store, then in packfiles and then in any
+// alternate object directories.
+func readObject(hash string) (ObjectType, []byte, error) {
+	if len(hash) != repoFormat().hexLen() {
+		return 0, nil, fmt.Errorf("invalid object name %q", hash)
+	}
+	objType, body, err := objectStore().Read(hash)
+	if errors.Is(err, errObjectNotFound) {
+		if remote := promisorRemote(); remote != "" {
+			// A partial clone left the object on the server; fetch it.
+			if err := fetchPromised([]string{hash}); err != nil {
+				return 0, nil, fmt.Errorf("%s: promised by remote %s but absent: %w", hash, remote, err)
+			}
+			return objectStore().Read(hash)
+		}
+		return 0, nil, fmt.Errorf("%s: %w", hash, errObjectNotFound)
+	}
+	return objType, body, err
+}
+
+// statObject returns the type and size of the object named by hash,
+// decompressing no more of it than the store needs to find them.
+func statObject(hash string) (ObjectType, int64, error) {
+	if len(hash) != repoFormat().hexLen() {
+		return 0, 0, fmt.Errorf("invalid object name %q", hash)
+	}
+	objType, size, err := objectStore().Stat(hash)
+	if errors.Is(err, errObjectNotFound) {
+		// readObject fetches promised objects and words the error.
+		objType, body, err := readObject(hash)
+		return objType, int64(len(body)), err
+	}
+	return objType, size, err
+}
+
+// readUnknownObject is readObject for debugging corrupt objects: the
+// type name is returned as written, even if it is not a known type.
+// Packfiles cannot hold unknown types, so only loose objects qualify.
+func readUnknownObject(hash string) (string, []byte, error) {
+	if len(hash) == repoFormat().hexLen() {
+		if data, err := (looseStore{objectsDir()}).readRaw(hash); err == nil {
+			typeName, body, err := parseObjectHeader(data)
+			return typeName, []byte(body), err
+		} else if !errors.Is(err, errObjectNotFound) {
+			return "", nil, err
+		}
+	}
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	return objType.String(), body, nil
+}
+
+// hasObject reports whether hash is present in the object store.
+func hasObject(hash string) bool {
+	return len(hash) == repoFormat().hexLen() && objectStore().Has(hash)
+}
+
+// writeObject stores an object and returns its name. Objects that
+// already exist are left alone.
+func writeObject(objType ObjectType, body []byte) (string, error) {
+	hash := hashObject(objType, body)
+	if hasObject(hash) {
+		return hash, nil
+	}
+	return objectStore().Write(objType, body)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderLine(t *testing.T) {
+	for _, tt := range []struct {
+		header   string
+		typeName string
+		size     int64
+		err      string
+	}{
+		{header: "blob 0", typeName: "blob", size: 0},
+		{header: "tree 37", typeName: "tree", size: 37},
+		{header: "commit 1234", typeName: "commit", size: 1234},
+		{header: "tag 9223372036854775807", typeName: "tag", size: 1<<63 - 1},
+		{header: "blob12", err: "malformed object header"},
+		{header: "", err: "malformed object header"},
+		{header: "blob ", err: "malformed object size"},
+		{header: "blob 12a", err: "malformed object size"},
+		{header: "blob 0x10", err: "malformed object size"},
+		{header: "blob -1", err: "malformed object size"},
+		{header: "blob +1", err: "malformed object size"},
+		{header: "blob 01", err: "malformed object size"},
+		{header: "blob  1", err: "malformed object size"},
+		{header: "blob 9223372036854775808", err: "malformed object size"},
+	} {
+		typeName, size, err := parseHeaderLine(tt.header)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.header, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || typeName != tt.typeName || size != tt.size {
+			t.Errorf("%q: got %q %d %v, want %q %d", tt.header, typeName, size, err, tt.typeName, tt.size)
+		}
+	}
+}
+
+func TestParseGitObject(t *testing.T) {
+	for _, tt := range []struct {
+		data    string
+		objType ObjectType
+		body    string
+		err     string
+	}{
+		{data: "blob 6\x00hello\n", objType: BlobObject, body: "hello\n"},
+		{data: "blob 0\x00", objType: BlobObject, body: ""},
+		{data: "blob 5\x00a\x00b\x00c", objType: BlobObject, body: "a\x00b\x00c"},
+		{data: "tree 9\x00100644 a\x00", objType: TreeObject, body: "100644 a\x00"},
+		{data: "commit 5\x00tree ", objType: CommitObject, body: "tree "},
+		{data: "tag 7\x00object ", objType: TagObject, body: "object "},
+		{data: "blob6\x00hello\n", err: "malformed object header"},
+		{data: "blob 6hello\n", err: "missing NUL"},
+		{data: "", err: "missing NUL"},
+		{data: "blob six\x00hello\n", err: "malformed object size"},
+		{data: "blob -6\x00hello\n", err: "malformed object size"},
+		{data: "blob 5\x00hello\n", err: "size mismatch"},
+		{data: "blob 7\x00hello\n", err: "size mismatch"},
+		{data: "blob 3\x00a\x00b\x00", err: "size mismatch"},
+		{data: "blob 99999999999\x00", err: "exceeds the maximum object size"},
+		{data: "blub 0\x00", err: "invalid object type"},
+		{data: " 0\x00", err: "invalid object type"},
+	} {
+		objType, body, err := parseGitObject(tt.data)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.data, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || objType != tt.objType || string(body) != tt.body {
+			t.Errorf("%q: got %v %q %v, want %v %q", tt.data, objType, body, err, tt.objType, tt.body)
+		}
+	}
+}
+
+// FuzzParseGitObject checks that parseGitObject never panics and that
+// whatever it accepts is exactly a header for the body it returns.
+func FuzzParseGitObject(f *testing.F) {
+	for _, seed := range []string{
+		"blob 6\x00hello\n",
+		"blob 0\x00",
+		"tree 9\x00100644 a\x00",
+		"commit 5\x00tree ",
+		"tag 7\x00object ",
+		"blob 3\x00a\x00b",
+		"blob6\x00hello\n",
+		"blob -1\x00",
+		"blob 01\x00a",
+		"blob 18446744073709551616\x00",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		objType, body, err := parseGitObject(string(data))
+		if err != nil {
+			return
+		}
+		if header := fmt.Sprintf("%s %d\x00", objType, len(body)); header+string(body) != string(data) {
+			t.Errorf("accepted %q as %q and %q", data, header, body)
+		}
+	})
+}
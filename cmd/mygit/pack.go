@@ -0,0 +1,949 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	packObjCommit   = 1
+	packObjTree     = 2
+	packObjBlob     = 3
+	packObjTag      = 4
+	packObjOfsDelta = 6
+	packObjRefDelta = 7
+)
+
+// packEntry is a single object read out of a packfile.
+type packEntry struct {
+	offset   int64
+	typ      int
+	data     []byte // inflated data; delta instructions for delta entries
+	baseOfs  int64
+	baseHash string
+	crc      uint32
+
+	objType ObjectType
+	body    []byte
+	hash    string
+	depth   int // deltas between this entry and a whole object
+}
+
+// readPackEntryHeader decodes the type and inflated size of a pack entry.
+func readPackEntryHeader(r io.ByteReader) (int, int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	shift := uint(4)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		size |= int64(c&0x7f) << shift
+		shift += 7
+	}
+	return typ, size, nil
+}
+
+// readOfsDeltaOffset decodes the negative base offset of an OFS_DELTA entry.
+func readOfsDeltaOffset(r io.ByteReader) (int64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	ofs := int64(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		ofs = ((ofs + 1) << 7) | int64(c&0x7f)
+	}
+	return ofs, nil
+}
+
+// packReader tracks the current offset into a pack stream, copying
+// everything read to w and hashing it for the trailer check and the
+// per-entry CRCs.
+type packReader struct {
+	r      *bufio.Reader
+	w      io.Writer
+	sum    hash.Hash
+	crc    hash.Hash32
+	offset int64
+}
+
+func newPackReader(r io.Reader, w io.Writer) *packReader {
+	p := &packReader{r: bufio.NewReader(r), sum: repoFormat().newHash(), crc: crc32.NewIEEE()}
+	p.w = io.MultiWriter(w, p.sum, p.crc)
+	return p
+}
+
+func (p *packReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if _, werr := p.w.Write(b[:n]); werr != nil {
+		return n, werr
+	}
+	p.offset += int64(n)
+	return n, err
+}
+
+func (p *packReader) ReadByte() (byte, error) {
+	c, err := p.r.ReadByte()
+	if err == nil {
+		if _, werr := p.w.Write([]byte{c}); werr != nil {
+			return 0, werr
+		}
+		p.offset++
+	}
+	return c, err
+}
+
+// parsePack reads every entry of a packfile stream, copying the raw
+// pack to w as it goes so the pack itself is never held in memory. It
+// returns the entries and the pack checksum, after checking the
+// checksum against the bytes received. Progress is reported on prog.
+func parsePack(r io.Reader, w io.Writer, prog *progress) ([]*packEntry, []byte, error) {
+	pr := newPackReader(r, w)
+	var header [12]byte
+	if _, err := io.ReadFull(pr, header[:]); err != nil || string(header[:4]) != "PACK" {
+		return nil, nil, errors.New("not a packfile")
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		return nil, nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+	count := binary.BigEndian.Uint32(header[8:12])
+	prog.start("Receiving objects", int(count))
+
+	// The count comes from the sender; a pack claiming billions of
+	// objects must not allocate room for them before they arrive.
+	entries := make([]*packEntry, 0, min(count, 1<<16))
+	for i := uint32(0); i < count; i++ {
+		pos := pr.offset
+		pr.crc.Reset()
+		typ, size, err := readPackEntryHeader(pr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+		}
+		e := &packEntry{offset: pos, typ: typ}
+		switch typ {
+		case packObjCommit, packObjTree, packObjBlob, packObjTag:
+		case packObjOfsDelta:
+			ofs, err := readOfsDeltaOffset(pr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			e.baseOfs = e.offset - ofs
+		case packObjRefDelta:
+			base := make([]byte, repoFormat().size)
+			if _, err := io.ReadFull(pr, base); err != nil {
+				return nil, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			e.baseHash = hex.EncodeToString(base)
+		default:
+			return nil, nil, fmt.Errorf("pack entry at %d: unknown type %d", pos, typ)
+		}
+
+		if e.data, err = inflateObject(pr, size); err != nil {
+			return nil, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+		}
+
+		e.crc = pr.crc.Sum32()
+		entries = append(entries, e)
+		prog.update(len(entries), pr.offset)
+	}
+
+	// The trailer is not part of what it checksums, so it bypasses pr.
+	want := pr.sum.Sum(nil)
+	trailer := make([]byte, len(want))
+	if _, err := io.ReadFull(pr.r, trailer); err != nil {
+		return nil, nil, errors.New("pack truncated")
+	}
+	if !bytes.Equal(trailer, want) {
+		return nil, nil, fmt.Errorf("pack is corrupted (SHA1 mismatch): trailer says %x, content hashes to %x", trailer, want)
+	}
+	if _, err := pr.r.ReadByte(); err != io.EOF {
+		return nil, nil, errors.New("pack has junk at the end")
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return nil, nil, err
+	}
+	prog.done(pr.offset + int64(len(trailer)))
+	return entries, trailer, nil
+}
+
+// resolvePackEntries computes the type, body and hash of every entry,
+// applying deltas against their bases.
+func resolvePackEntries(entries []*packEntry, prog *progress) error {
+	byOffset := make(map[int64]*packEntry, len(entries))
+	deltas := 0
+	for _, e := range entries {
+		byOffset[e.offset] = e
+		if e.typ == packObjOfsDelta || e.typ == packObjRefDelta {
+			deltas++
+		}
+	}
+	if deltas > 0 {
+		prog.start("Resolving deltas", deltas)
+	}
+	resolved := 0
+	byHash := make(map[string]*packEntry, len(entries))
+
+	// resolve reports false when e depends on a REF_DELTA base that has
+	// not been resolved yet. It walks down the delta chain to the first
+	// known base, then applies the deltas on the way back up, so a deep
+	// chain cannot exhaust the stack.
+	resolve := func(e *packEntry) (bool, error) {
+		var chain []*packEntry
+		var baseType ObjectType
+		var baseBody []byte
+		depth := 0
+		for cur := e; ; {
+			if cur.hash != "" {
+				baseType, baseBody, depth = cur.objType, cur.body, cur.depth
+				break
+			}
+			if cur.typ != packObjOfsDelta && cur.typ != packObjRefDelta {
+				cur.objType, cur.body = ObjectType(cur.typ), cur.data
+				if !cur.objType.valid() {
+					return false, fmt.Errorf("pack entry at %d: invalid object type %d", cur.offset, cur.typ)
+				}
+				cur.hash = hashObject(cur.objType, cur.body)
+				byHash[cur.hash] = cur
+				continue
+			}
+			chain = append(chain, cur)
+			if len(chain) > maxDeltaDepth() {
+				return false, fmt.Errorf("delta at %d: chain deeper than %d", e.offset, maxDeltaDepth())
+			}
+			if cur.typ == packObjOfsDelta {
+				base, ok := byOffset[cur.baseOfs]
+				if !ok {
+					return false, fmt.Errorf("delta at %d: missing base at offset %d", cur.offset, cur.baseOfs)
+				}
+				cur = base
+				continue
+			}
+			if base, ok := byHash[cur.baseHash]; ok {
+				cur = base
+				continue
+			}
+			if !hasObject(cur.baseHash) {
+				return false, nil
+			}
+			var err error
+			if baseType, baseBody, err = readObject(cur.baseHash); err != nil {
+				return false, err
+			}
+			break
+		}
+		if depth+len(chain) > maxDeltaDepth() {
+			return false, fmt.Errorf("delta at %d: chain deeper than %d", e.offset, maxDeltaDepth())
+		}
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			d := chain[i]
+			body, err := applyDelta(baseBody, d.data)
+			if err != nil {
+				return false, fmt.Errorf("delta at %d: %w", d.offset, err)
+			}
+			depth++
+			d.objType, d.body, d.depth = baseType, body, depth
+			d.hash = hashObject(d.objType, d.body)
+			byHash[d.hash] = d
+			baseBody = body
+			resolved++
+			prog.update(resolved, 0)
+		}
+		return true, nil
+	}
+
+	pending := entries
+	for len(pending) > 0 {
+		var next []*packEntry
+		for _, e := range pending {
+			ok, err := resolve(e)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				next = append(next, e)
+			}
+		}
+		if len(next) == len(pending) {
+			return fmt.Errorf("delta at %d: missing base %s", next[0].offset, next[0].baseHash)
+		}
+		pending = next
+	}
+	if deltas > 0 {
+		prog.done(0)
+	}
+	return nil
+}
+
+// hashObject returns the object name of body stored as objType.
+func hashObject(objType ObjectType, body []byte) string {
+	h := repoFormat().newHash()
+	fmt.Fprintf(h, "%s %d\x00", objType, len(body))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readDeltaSize decodes one of the little-endian varints at the start of a delta.
+func readDeltaSize(delta []byte, pos *int) (int, error) {
+	size, shift := 0, uint(0)
+	for {
+		if *pos >= len(delta) {
+			return 0, errors.New("truncated delta header")
+		}
+		if shift > 56 {
+			return 0, errors.New("delta size too large")
+		}
+		c := delta[*pos]
+		*pos++
+		size |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return size, nil
+		}
+	}
+}
+
+// applyDelta reconstructs an object from its base and a git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	pos := 0
+	srcSize, err := readDeltaSize(delta, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != len(base) {
+		return nil, fmt.Errorf("delta base size mismatch: want %d, have %d", srcSize, len(base))
+	}
+	dstSize, err := readDeltaSize(delta, &pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(dstSize) > maxObjectSize() {
+		return nil, fmt.Errorf("delta result of %d bytes exceeds the maximum object size of %d", dstSize, maxObjectSize())
+	}
+	// A small delta claiming a huge result must not allocate it up front.
+	out := make([]byte, 0, min(dstSize, len(base)+len(delta)))
+	for pos < len(delta) {
+		cmd := delta[pos]
+		pos++
+		switch {
+		case cmd&0x80 != 0:
+			var offset, size int
+			for i := uint(0); i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, errors.New("truncated delta copy")
+					}
+					offset |= int(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, errors.New("truncated delta copy")
+					}
+					size |= int(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, errors.New("delta copy out of range")
+			}
+			out = append(out, base[offset:offset+size]...)
+		case cmd != 0:
+			if pos+int(cmd) > len(delta) {
+				return nil, errors.New("truncated delta insert")
+			}
+			out = append(out, delta[pos:pos+int(cmd)]...)
+			pos += int(cmd)
+		default:
+			return nil, errors.New("invalid delta opcode 0")
+		}
+	}
+	if len(out) != dstSize {
+		return nil, fmt.Errorf("delta result size mismatch: want %d, got %d", dstSize, len(out))
+	}
+	return out, nil
+}
+
+// indexPack stores a received packfile under .git/objects/pack along with
+// a version 2 index, and returns the number of objects it contains. A
+// promisor pack, one fetched with a filter from a partial clone's remote,
+// is marked with a .promisor file so git knows the objects it leaves out
+// can be fetched again.
+func indexPack(r io.Reader, prog *progress, promisor bool) (int, error) {
+	dir := filepath.Join(objectsDir(), "pack")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	// Stream the pack into a temporary file, which is renamed into place
+	// only once every object in it has been resolved.
+	tmp, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	entries, checksum, err := parsePack(r, tmp, prog)
+	if cerr := closeSynced(tmp, "pack"); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := resolvePackEntries(entries, prog); err != nil {
+		return 0, err
+	}
+
+	// The pack goes into place before its index, so a reader that finds
+	// the index always finds the pack. Like git, the .idx is written
+	// through a temporary file too, and is synced as pack metadata.
+	name := "pack-" + hex.EncodeToString(checksum)
+	if err := renameSynced(tmp.Name(), filepath.Join(dir, name+".pack"), 0o444, "pack"); err != nil {
+		return 0, err
+	}
+	idx := buildPackIndex(entries, checksum)
+	if err := writeFileSynced(filepath.Join(dir, name+".idx"), idx, 0o444, "tmp_idx_", "pack-metadata"); err != nil {
+		return 0, err
+	}
+	if promisor {
+		if err := writeFileSynced(filepath.Join(dir, name+".promisor"), nil, 0o444, "tmp_promisor_", "pack-metadata"); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// buildPackIndex serializes a version 2 .idx for the resolved entries.
+func buildPackIndex(entries []*packEntry, packChecksum []byte) []byte {
+	sorted := make([]*packEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].hash < sorted[j].hash })
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 't', 'O', 'c'})
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, e := range sorted {
+		b, _ := hex.DecodeString(e.hash[:2])
+		fanout[b[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, e := range sorted {
+		raw, _ := hex.DecodeString(e.hash)
+		buf.Write(raw)
+	}
+	for _, e := range sorted {
+		binary.Write(&buf, binary.BigEndian, e.crc)
+	}
+	var large []uint64
+	for _, e := range sorted {
+		if e.offset < 1<<31 {
+			binary.Write(&buf, binary.BigEndian, uint32(e.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(large))|1<<31)
+		large = append(large, uint64(e.offset))
+	}
+	for _, off := range large {
+		binary.Write(&buf, binary.BigEndian, off)
+	}
+	buf.Write(packChecksum)
+	h := repoFormat().newHash()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return buf.Bytes()
+}
+
+// packIndex is a parsed version 2 .idx file.
+type packIndex struct {
+	packPath     string
+	hashSize     int // raw object name length, that of the repository's format
+	fanout       [256]uint32
+	hashes       []byte
+	crcs         []byte
+	offsets      []uint32
+	largeOffsets []uint64
+}
+
+// readPackIndex parses the .idx file at path.
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parsePackIndex(data, path)
+	if err != nil {
+		return nil, err
+	}
+	idx.packPath = path[:len(path)-len(".idx")] + ".pack"
+	return idx, nil
+}
+
+// parsePackIndex parses the contents of a .idx file, naming it as path
+// in errors. Object names, and the two checksums at the end, are as long
+// as the repository's object format makes them.
+func parsePackIndex(data []byte, path string) (*packIndex, error) {
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) {
+		return nil, fmt.Errorf("%s: not a version 2 pack index", path)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported index version %d", path, version)
+	}
+
+	size := repoFormat().size
+	idx := &packIndex{hashSize: size}
+	pos := 8
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[pos:])
+		if i > 0 && idx.fanout[i] < idx.fanout[i-1] {
+			return nil, fmt.Errorf("%s: fanout table out of order", path)
+		}
+		pos += 4
+	}
+	n := int(idx.fanout[255])
+	if len(data) < pos+n*(size+4+4)+2*size {
+		return nil, fmt.Errorf("%s: index truncated", path)
+	}
+	idx.hashes = data[pos : pos+n*size]
+	pos += n * size
+	idx.crcs = data[pos : pos+n*4]
+	pos += n * 4
+	idx.offsets = make([]uint32, n)
+	for i := range idx.offsets {
+		idx.offsets[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+	for pos+8 <= len(data)-2*size {
+		idx.largeOffsets = append(idx.largeOffsets, binary.BigEndian.Uint64(data[pos:]))
+		pos += 8
+	}
+	return idx, nil
+}
+
+// lookup returns the pack offset of the object with the raw hash.
+func (idx *packIndex) lookup(hash []byte) (int64, bool) {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(idx.fanout[hash[0]-1])
+	}
+	hi := int(idx.fanout[hash[0]])
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch c := bytes.Compare(idx.hashes[mid*idx.hashSize:(mid+1)*idx.hashSize], hash); {
+		case c == 0:
+			return idx.offset(mid)
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, false
+}
+
+// offset returns the pack offset of the i'th object in the index, read
+// from the large offset table if it is past 2GiB. It is false if the
+// index points past the end of that table.
+func (idx *packIndex) offset(i int) (int64, bool) {
+	off := idx.offsets[i]
+	if off&(1<<31) == 0 {
+		return int64(off), true
+	}
+	large := int(off &^ (1 << 31))
+	if large >= len(idx.largeOffsets) {
+		return 0, false
+	}
+	return int64(idx.largeOffsets[large]), true
+}
+
+// showIndex implements `mygit show-index`, reading a version 2 .idx from
+// r and writing "<offset> <hash> (<crc32>)" for each object in it, in the
+// index's order of hashes as git does.
+func showIndex(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	idx, err := parsePackIndex(data, "<stdin>")
+	if err != nil {
+		return err
+	}
+	for i := range idx.offsets {
+		off, ok := idx.offset(i)
+		if !ok {
+			return errors.New("inconsistent 64b offset index")
+		}
+		fmt.Fprintf(w, "%d %x (%08x)\n", off, idx.hashes[i*idx.hashSize:(i+1)*idx.hashSize], binary.BigEndian.Uint32(idx.crcs[i*4:]))
+	}
+	return nil
+}
+
+// openPackIndexes parses every .idx file in the pack directory of an
+// object store.
+func openPackIndexes(objects string) ([]*packIndex, error) {
+	paths, err := filepath.Glob(filepath.Join(objects, "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []*packIndex
+	for _, path := range paths {
+		idx, err := readPackIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, nil
+}
+
+// packLocation identifies an object stored in a packfile.
+type packLocation struct {
+	packPath string
+	offset   int64
+}
+
+// packDir is what is known of the packs of one object directory: its
+// multi-pack-index, if any, and the parsed .idx of each pack that index
+// does not cover, in the order of their paths. It is never changed once
+// built, so it can be searched without holding packCache.
+type packDir struct {
+	midx    *multiPackIndex
+	paths   []string
+	indexes map[string]*packIndex
+}
+
+// packCache holds, for the rest of this run, the packDir of each object
+// directory and each packfile opened, so that reading many objects
+// parses each index and maps each pack once. It is dropped, and the
+// packs closed, when enterWorktree moves to another repository.
+var packCache = struct {
+	sync.Mutex
+	dirs  map[string]*packDir
+	files map[string]packFile
+}{dirs: map[string]*packDir{}, files: map[string]packFile{}}
+
+// loadPackDir returns the packDir of the object directory objects. With
+// rescan, the pack directory is listed again and the indexes of packs
+// added since are parsed, those already known being kept. It reports
+// whether any were added.
+func loadPackDir(objects string, rescan bool) (*packDir, bool, error) {
+	packCache.Lock()
+	defer packCache.Unlock()
+	old := packCache.dirs[objects]
+	if old != nil && !rescan {
+		return old, false, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(objects, "pack", "*.idx"))
+	if err != nil {
+		return nil, false, err
+	}
+	d := &packDir{indexes: map[string]*packIndex{}}
+	if old != nil {
+		d.midx = old.midx
+	} else {
+		d.midx = openMultiPackIndex(objects)
+	}
+	added := false
+	for _, path := range paths {
+		if d.midx != nil && d.midx.covers(path) {
+			continue
+		}
+		idx := old.index(path)
+		if idx == nil {
+			if idx, err = readPackIndex(path); err != nil {
+				return nil, false, err
+			}
+			added = true
+		}
+		d.paths = append(d.paths, path)
+		d.indexes[path] = idx
+	}
+	packCache.dirs[objects] = d
+	return d, added, nil
+}
+
+// index returns the parsed .idx at path, or nil if it is not known.
+func (d *packDir) index(path string) *packIndex {
+	if d == nil {
+		return nil
+	}
+	return d.indexes[path]
+}
+
+// lookup finds the object with the raw hash in the directory's packs.
+func (d *packDir) lookup(raw []byte) (packLocation, bool) {
+	if d.midx != nil {
+		if loc, ok := d.midx.lookup(raw); ok {
+			return loc, true
+		}
+	}
+	for _, path := range d.paths {
+		idx := d.indexes[path]
+		if off, ok := idx.lookup(raw); ok {
+			return packLocation{idx.packPath, off}, true
+		}
+	}
+	return packLocation{}, false
+}
+
+// cachedPackFile returns the packfile at path, opening it the first
+// time it is asked for.
+func cachedPackFile(path string) (packFile, error) {
+	packCache.Lock()
+	defer packCache.Unlock()
+	if f, ok := packCache.files[path]; ok {
+		return f, nil
+	}
+	f, err := openPackFile(path)
+	if err != nil {
+		return nil, err
+	}
+	packCache.files[path] = f
+	return f, nil
+}
+
+// resetPackCache closes every cached packfile and forgets the packs
+// known of every object directory, and their multi-pack-indexes.
+func resetPackCache() {
+	resetMultiPackIndexes()
+	packCache.Lock()
+	defer packCache.Unlock()
+	for _, f := range packCache.files {
+		f.Close()
+	}
+	packCache.dirs = map[string]*packDir{}
+	packCache.files = map[string]packFile{}
+}
+
+// find locates hash in the store's packfiles, through the
+// multi-pack-index for the packs it lists and through each remaining
+// pack's own index. As in git, an object that is not found has the pack
+// directory looked at again, in case a pack holding it has arrived
+// since it was last listed.
+func (s packStore) find(hash string) (packLocation, bool, error) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != repoFormat().size {
+		return packLocation{}, false, fmt.Errorf("invalid object name %q", hash)
+	}
+	d, _, err := loadPackDir(s.dir, false)
+	if err != nil {
+		return packLocation{}, false, err
+	}
+	if loc, ok := d.lookup(raw); ok {
+		return loc, true, nil
+	}
+	d, added, err := loadPackDir(s.dir, true)
+	if err != nil || !added {
+		return packLocation{}, false, err
+	}
+	loc, ok := d.lookup(raw)
+	return loc, ok, nil
+}
+
+// packFile is an open packfile: memory mapped where the platform allows,
+// otherwise an *os.File.
+type packFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// readPackObjectAt reads and fully resolves the pack entry at offset,
+// following OFS_DELTA chains iteratively up to maxDeltaDepth deep.
+func readPackObjectAt(f io.ReaderAt, offset int64) (ObjectType, []byte, error) {
+	var deltas [][]byte // delta data, outermost first
+	var baseType ObjectType
+	var baseBody []byte
+	for pos := offset; baseType == 0; {
+		if len(deltas) > maxDeltaDepth() {
+			return 0, nil, fmt.Errorf("pack entry at %d: delta chain deeper than %d", offset, maxDeltaDepth())
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, pos, 1<<62))
+		typ, size, err := readPackEntryHeader(r)
+		if err != nil {
+			return 0, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+		}
+		next := pos
+		switch typ {
+		case packObjCommit, packObjTree, packObjBlob, packObjTag:
+		case packObjOfsDelta:
+			ofs, err := readOfsDeltaOffset(r)
+			if err != nil {
+				return 0, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			if ofs == 0 {
+				return 0, nil, fmt.Errorf("pack entry at %d: delta is its own base", pos)
+			}
+			next = pos - ofs
+		case packObjRefDelta:
+			base := make([]byte, repoFormat().size)
+			if _, err := io.ReadFull(r, base); err != nil {
+				return 0, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			if baseType, baseBody, err = readObject(hex.EncodeToString(base)); err != nil {
+				return 0, nil, err
+			}
+		default:
+			return 0, nil, fmt.Errorf("pack entry at %d: unknown type %d", pos, typ)
+		}
+
+		data, err := inflateObject(r, size)
+		if err != nil {
+			return 0, nil, fmt.Errorf("pack entry at %d: %w", pos, err)
+		}
+		if typ == packObjOfsDelta || typ == packObjRefDelta {
+			deltas = append(deltas, data)
+		} else {
+			baseType, baseBody = ObjectType(typ), data
+		}
+		pos = next
+	}
+
+	body := baseBody
+	for i := len(deltas) - 1; i >= 0; i-- {
+		var err error
+		if body, err = applyDelta(body, deltas[i]); err != nil {
+			return 0, nil, fmt.Errorf("pack entry at %d: %w", offset, err)
+		}
+	}
+	return baseType, body, nil
+}
+
+// statPackObjectAt returns the type and size of the pack entry at
+// offset. An undeltified entry's header holds both. For a delta, the
+// size is read from the start of the delta and the type is the type of
+// the base at the end of its chain, whose entry headers are all that is
+// read of it.
+func statPackObjectAt(f io.ReaderAt, offset int64) (ObjectType, int64, error) {
+	size := int64(-1)
+	for pos, depth := offset, 0; ; depth++ {
+		if depth > maxDeltaDepth() {
+			return 0, 0, fmt.Errorf("pack entry at %d: delta chain deeper than %d", offset, maxDeltaDepth())
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, pos, 1<<62))
+		typ, entrySize, err := readPackEntryHeader(r)
+		if err != nil {
+			return 0, 0, fmt.Errorf("pack entry at %d: %w", pos, err)
+		}
+		if size < 0 {
+			size = int64(entrySize)
+		}
+		var base string
+		switch typ {
+		case packObjCommit, packObjTree, packObjBlob, packObjTag:
+			return ObjectType(typ), size, nil
+		case packObjOfsDelta:
+			ofs, err := readOfsDeltaOffset(r)
+			if err != nil {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			if ofs == 0 {
+				return 0, 0, fmt.Errorf("pack entry at %d: delta is its own base", pos)
+			}
+			pos -= ofs
+		case packObjRefDelta:
+			raw := make([]byte, repoFormat().size)
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", pos, err)
+			}
+			base = hex.EncodeToString(raw)
+		default:
+			return 0, 0, fmt.Errorf("pack entry at %d: unknown type %d", pos, typ)
+		}
+		if depth == 0 {
+			// The object is as large as the target size of its delta,
+			// the second varint of the delta data.
+			zr, err := zlib.NewReader(r)
+			if err != nil {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", offset, err)
+			}
+			head := make([]byte, 20)
+			n, err := io.ReadFull(zr, head)
+			zr.Close()
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", offset, err)
+			}
+			at := 0
+			if _, err := readDeltaSize(head[:n], &at); err != nil {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", offset, err)
+			}
+			target, err := readDeltaSize(head[:n], &at)
+			if err != nil {
+				return 0, 0, fmt.Errorf("pack entry at %d: %w", offset, err)
+			}
+			size = int64(target)
+		}
+		if base != "" {
+			objType, _, err := statObject(base)
+			return objType, size, err
+		}
+	}
+}
+
+// writePack writes the objects named by hashes to w as an undeltified
+// version 2 packfile.
+func writePack(w io.Writer, hashes []string) error {
+	h := repoFormat().newHash()
+	out := io.MultiWriter(w, h)
+
+	var header [12]byte
+	copy(header[:], "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(hashes)))
+	if _, err := out.Write(header[:]); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		objType, body, err := readObject(hash)
+		if err != nil {
+			return err
+		}
+		size := len(body)
+		c := byte(objType<<4) | byte(size&0x0f)
+		size >>= 4
+		var entry bytes.Buffer
+		for size > 0 {
+			entry.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+			size >>= 7
+		}
+		entry.WriteByte(c)
+		zw := zlib.NewWriter(&entry)
+		zw.Write(body)
+		zw.Close()
+		if _, err := out.Write(entry.Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(h.Sum(nil))
+	return err
+}
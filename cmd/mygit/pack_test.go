@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPackedSHA256 reads a SHA-256 repository whose objects git has
+// packed: its .idx, and its multi-pack-index, hold 32-byte names.
+func TestPackedSHA256(t *testing.T) {
+	r := newGoldenRepo(t)
+	if err := os.RemoveAll(filepath.Join(r.dir, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	r.git("init", "-q", "--object-format=sha256", "-b", "main")
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "hello\nagain\n", 0o644)
+	r.git("commit", "-q", "-a", "-m", "child")
+	r.git("tag", "-a", "v1", "-m", "release")
+	r.git("gc", "-q")
+	if loose, _ := filepath.Glob(filepath.Join(r.dir, ".git", "objects", "??")); len(loose) > 0 {
+		t.Fatalf("gc left loose objects: %v", loose)
+	}
+
+	check := func() {
+		t.Helper()
+		r.same("log")
+		r.same("cat-file", "-p", "HEAD")
+		r.same("cat-file", "-p", "v1")
+		r.same("cat-file", "-t", "HEAD:src")
+		r.same("ls-tree", "-r", "HEAD")
+		readme := strings.TrimSpace(r.git("rev-parse", "HEAD~1:README"))
+		r.same("cat-file", "-p", readme)
+	}
+	check()
+	r.git("multi-pack-index", "write")
+	check()
+
+	dst := filepath.Join(t.TempDir(), "copy")
+	r.mygit("clone", "-q", r.dir, dst)
+	r.git("-C", dst, "fsck", "--strict")
+	if got, want := r.git("-C", dst, "rev-parse", "HEAD"), r.git("rev-parse", "HEAD"); got != want {
+		t.Errorf("clone HEAD = %s, want %s", got, want)
+	}
+	if got := r.git("-C", dst, "rev-parse", "--show-object-format"); got != "sha256\n" {
+		t.Errorf("clone object format = %q", got)
+	}
+}
+
+// packTempRepo enters a new repository holding n small blobs, all of
+// them in one pack and none loose, and returns their names. The blobs
+// are written to memory, and only the pack of them to disk.
+func packTempRepo(tb testing.TB, n int) []string {
+	tb.Helper()
+	enterTempRepo(tb)
+	repoObjects = NewMemStore()
+	hashes := make([]string, n)
+	for i := range hashes {
+		hash, err := writeObject(BlobObject, []byte(fmt.Sprintf("blob %d\n", i)))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		hashes[i] = hash
+	}
+	var pack bytes.Buffer
+	if err := writePack(&pack, hashes); err != nil {
+		tb.Fatal(err)
+	}
+	repoObjects = nil
+	if _, err := indexPack(&pack, nil, false); err != nil {
+		tb.Fatal(err)
+	}
+	return hashes
+}
+
+func TestPackStoreReadsEveryObject(t *testing.T) {
+	hashes := packTempRepo(t, 500)
+	store := packStore{objectsDir()}
+	for i, hash := range hashes {
+		objType, body, err := store.Read(hash)
+		if err != nil {
+			t.Fatalf("%s: %v", hash, err)
+		}
+		if want := fmt.Sprintf("blob %d\n", i); objType != BlobObject || string(body) != want {
+			t.Errorf("%s: read %v %q, want blob %q", hash, objType, body, want)
+		}
+		if objType, size, err := store.Stat(hash); err != nil || objType != BlobObject || size != int64(len(body)) {
+			t.Errorf("%s: stat %v %d %v", hash, objType, size, err)
+		}
+	}
+	if store.Has(strings.Repeat("0", repoFormat().hexLen())) {
+		t.Error("the null object is found in the pack")
+	}
+}
+
+// TestPackStoreSeesNewPacks checks that a pack added after the pack
+// directory was first read is found without starting again.
+func TestPackStoreSeesNewPacks(t *testing.T) {
+	hashes := packTempRepo(t, 10)
+	store := packStore{objectsDir()}
+	if !store.Has(hashes[0]) {
+		t.Fatal("packed object not found")
+	}
+	hash, err := writeObject(BlobObject, []byte("late\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack bytes.Buffer
+	if err := writePack(&pack, []string{hash}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexPack(&pack, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has(hash) {
+		t.Error("object of the new pack not found")
+	}
+}
+
+// BenchmarkPackStoreStat looks up every object of a 20000-object pack,
+// as cat-file --batch-check does.
+func BenchmarkPackStoreStat(b *testing.B) {
+	hashes := packTempRepo(b, 20000)
+	store := packStore{objectsDir()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, hash := range hashes {
+			if _, _, err := store.Stat(hash); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkPackStoreRead reads every object of a 20000-object pack, as
+// cat-file --batch does.
+func BenchmarkPackStoreRead(b *testing.B) {
+	hashes := packTempRepo(b, 20000)
+	store := packStore{objectsDir()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, hash := range hashes {
+			if _, _, err := store.Read(hash); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// packBuilder assembles a packfile entry by entry, deltas included, for
+// tests that need packs git would not write.
+type packBuilder struct {
+	entries bytes.Buffer
+	count   int
+}
+
+// entry appends an entry of type typ whose inflated data is data, with
+// extra, a delta's base, between its header and data, and returns its
+// offset.
+func (p *packBuilder) entry(typ int, extra, data []byte) int64 {
+	offset := int64(12 + p.entries.Len())
+	size := len(data)
+	c := byte(typ<<4) | byte(size&0x0f)
+	for size >>= 4; size > 0; size >>= 7 {
+		p.entries.WriteByte(c | 0x80)
+		c = byte(size & 0x7f)
+	}
+	p.entries.WriteByte(c)
+	p.entries.Write(extra)
+	zw := zlib.NewWriter(&p.entries)
+	zw.Write(data)
+	zw.Close()
+	p.count++
+	return offset
+}
+
+// object appends a whole object.
+func (p *packBuilder) object(objType ObjectType, body string) int64 {
+	return p.entry(int(objType), nil, []byte(body))
+}
+
+// ofsDelta appends an OFS_DELTA against the entry at base.
+func (p *packBuilder) ofsDelta(base int64, delta []byte) int64 {
+	ofs := int64(12+p.entries.Len()) - base
+	enc := []byte{byte(ofs & 0x7f)}
+	for ofs >>= 7; ofs > 0; ofs >>= 7 {
+		ofs--
+		enc = append([]byte{byte(0x80 | ofs&0x7f)}, enc...)
+	}
+	return p.entry(packObjOfsDelta, enc, delta)
+}
+
+// refDelta appends a REF_DELTA against the object named base.
+func (p *packBuilder) refDelta(base string, delta []byte) int64 {
+	raw, err := hex.DecodeString(base)
+	if err != nil {
+		panic(err)
+	}
+	return p.entry(packObjRefDelta, raw, delta)
+}
+
+// bytes returns the pack: header, entries and checksum trailer.
+func (p *packBuilder) bytes() []byte {
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(p.count))
+	pack.Write(p.entries.Bytes())
+	h := repoFormat().newHash()
+	h.Write(pack.Bytes())
+	return h.Sum(pack.Bytes())
+}
+
+// appendDelta returns a delta turning base into base followed by suffix,
+// of at most 127 bytes: one copy of the whole base, then one insert.
+func appendDelta(base, suffix string) []byte {
+	varint := func(b []byte, n int) []byte {
+		for ; n >= 0x80; n >>= 7 {
+			b = append(b, byte(n)|0x80)
+		}
+		return append(b, byte(n))
+	}
+	d := varint(nil, len(base))
+	d = varint(d, len(base)+len(suffix))
+	d = append(d, 0x80|0x10|0x20, byte(len(base)), byte(len(base)>>8))
+	d = append(d, byte(len(suffix)))
+	return append(d, suffix...)
+}
+
+// deltaChainPack returns a pack of a blob and depth OFS_DELTAs, each
+// against the one before and adding a line to it, with the offset of
+// the last and the body it resolves to.
+func deltaChainPack(depth int) ([]byte, int64, string) {
+	var p packBuilder
+	body := "base\n"
+	offset := p.object(BlobObject, body)
+	for i := 0; i < depth; i++ {
+		line := fmt.Sprintf("%d\n", i)
+		offset = p.ofsDelta(offset, appendDelta(body, line))
+		body += line
+	}
+	return p.bytes(), offset, body
+}
+
+// setMaxDeltaDepth sets the delta chain limit for the rest of the test.
+func setMaxDeltaDepth(t *testing.T, n int) {
+	old := maxDeltaDepthSetting
+	maxDeltaDepthSetting = n
+	t.Cleanup(func() { maxDeltaDepthSetting = old })
+}
+
+func TestDeltaChainDepthLimit(t *testing.T) {
+	enterTempRepo(t)
+	pack, last, want := deltaChainPack(defaultMaxDeltaDepth + 10)
+
+	entries, _, err := parsePack(bytes.NewReader(pack), io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolvePackEntries(entries, nil); err == nil || !strings.Contains(err.Error(), "chain deeper than 50") {
+		t.Errorf("resolving a chain of 60: %v, want it refused", err)
+	}
+	if _, _, err := readPackObjectAt(bytes.NewReader(pack), last); err == nil || !strings.Contains(err.Error(), "deeper than 50") {
+		t.Errorf("reading a chain of 60: %v, want it refused", err)
+	}
+
+	setMaxDeltaDepth(t, 100)
+	if err := resolvePackEntries(entries, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries[len(entries)-1].body; string(got) != want {
+		t.Errorf("resolved %q, want %q", got, want)
+	}
+	if _, body, err := readPackObjectAt(bytes.NewReader(pack), last); err != nil || string(body) != want {
+		t.Errorf("read %q, %v, want %q", body, err, want)
+	}
+}
+
+// TestDeepDeltaChain resolves a chain far deeper than any stack of
+// recursive calls could go, as a hostile pack would send with the limit
+// raised.
+func TestDeepDeltaChain(t *testing.T) {
+	enterTempRepo(t)
+	const depth = 5000
+	setMaxDeltaDepth(t, depth)
+	pack, last, want := deltaChainPack(depth)
+	entries, _, err := parsePack(bytes.NewReader(pack), io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolvePackEntries(entries, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries[len(entries)-1]; string(got.body) != want || got.depth != depth {
+		t.Errorf("resolved to depth %d, want %d", got.depth, depth)
+	}
+	if _, body, err := readPackObjectAt(bytes.NewReader(pack), last); err != nil || string(body) != want {
+		t.Errorf("read %d bytes, %v, want %d", len(body), err, len(want))
+	}
+}
+
+// TestMixedDeltaPack indexes a pack whose deltas are both OFS_DELTAs
+// and REF_DELTAs, each kind against the other, and checks the result
+// with git verify-pack.
+func TestMixedDeltaPack(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	enterTempRepo(t)
+	var p packBuilder
+	body := "base\n"
+	bodies := []string{body}
+	base := p.object(BlobObject, body)
+	next := func(line string) []byte {
+		delta := appendDelta(body, line)
+		body += line
+		bodies = append(bodies, body)
+		return delta
+	}
+	p.ofsDelta(base, next("ofs\n"))
+	p.refDelta(hashObject(BlobObject, []byte(bodies[1])), next("ref on ofs\n"))
+	ref := p.refDelta(hashObject(BlobObject, []byte(bodies[2])), next("ref on ref\n"))
+	p.ofsDelta(ref, next("ofs on ref\n"))
+
+	n, err := indexPack(bytes.NewReader(p.bytes()), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bodies) {
+		t.Errorf("indexed %d objects, want %d", n, len(bodies))
+	}
+	for _, b := range bodies {
+		if _, got, err := (packStore{objectsDir()}).Read(hashObject(BlobObject, []byte(b))); err != nil || string(got) != b {
+			t.Errorf("read %q, %v, want %q", got, err, b)
+		}
+	}
+	idx, _ := filepath.Glob(filepath.Join(objectsDir(), "pack", "*.idx"))
+	if len(idx) != 1 {
+		t.Fatalf("objects/pack has %q, want one .idx", idx)
+	}
+	out, err := exec.Command("git", "verify-pack", "-v", idx[0]).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git verify-pack: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "chain length = 4: 1 object") {
+		t.Errorf("git verify-pack -v:\n%s", out)
+	}
+}
+
+func TestPackTrailerChecked(t *testing.T) {
+	enterTempRepo(t)
+	pack, _, _ := deltaChainPack(3)
+	if _, _, err := parsePack(bytes.NewReader(pack), io.Discard, nil); err != nil {
+		t.Fatalf("intact pack: %v", err)
+	}
+
+	corrupt := bytes.Clone(pack)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, _, err := parsePack(bytes.NewReader(corrupt), io.Discard, nil); err == nil || !strings.Contains(err.Error(), "pack is corrupted") {
+		t.Errorf("corrupted trailer: %v", err)
+	}
+	if _, _, err := parsePack(bytes.NewReader(pack[:len(pack)-5]), io.Discard, nil); err == nil || err.Error() != "pack truncated" {
+		t.Errorf("truncated trailer: %v", err)
+	}
+	if _, _, err := parsePack(bytes.NewReader(append(bytes.Clone(pack), 0)), io.Discard, nil); err == nil || err.Error() != "pack has junk at the end" {
+		t.Errorf("junk after the trailer: %v", err)
+	}
+
+	// A pack refused by index-pack leaves nothing behind.
+	if _, err := indexPack(bytes.NewReader(corrupt), nil, false); err == nil {
+		t.Fatal("index-pack stored a corrupted pack")
+	}
+	if left, _ := os.ReadDir(filepath.Join(objectsDir(), "pack")); len(left) > 0 {
+		t.Errorf("index-pack left %s", left[0].Name())
+	}
+}
+
+// TestShowIndex compares show-index with git's for the index git writes
+// for a pack, and for one where index-pack was told to put every offset
+// past the first object in the large offset table.
+func TestShowIndex(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("repack", "-q", "-a", "-d")
+	idxs, err := filepath.Glob(filepath.Join(r.dir, ".git", "objects", "pack", "*.idx"))
+	if err != nil || len(idxs) != 1 {
+		t.Fatalf("repack made %v: %v", idxs, err)
+	}
+	idx, err := os.ReadFile(idxs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.sameIn(string(idx), "show-index")
+
+	large := filepath.Join(t.TempDir(), "large.idx")
+	r.git("index-pack", "--index-version=2,1", "-o", large, strings.TrimSuffix(idxs[0], ".idx")+".pack")
+	small := len(idx)
+	idx, err = os.ReadFile(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx) <= small {
+		t.Fatalf("index-pack wrote no large offsets: %d bytes, %d without", len(idx), small)
+	}
+	r.sameIn(string(idx), "show-index")
+}
+
+// FuzzParsePack checks that parsing and resolving a pack never panics,
+// and that every entry of a pack accepted whole resolves to an object.
+func FuzzParsePack(f *testing.F) {
+	enterTempRepo(f)
+	repoObjects = NewMemStore()
+	chain, _, _ := deltaChainPack(3)
+	var p packBuilder
+	p.object(CommitObject, "tree "+strings.Repeat("0", 40)+"\n\nmessage\n")
+	p.object(TreeObject, "")
+	tag := p.object(TagObject, "object x\n")
+	p.ofsDelta(tag, appendDelta("object x\n", "more\n"))
+	for _, seed := range [][]byte{
+		chain,
+		p.bytes(),
+		chain[:len(chain)-1],
+		chain[:12],
+		[]byte("PACK\x00\x00\x00\x02\x00\x00\x00\x00"),
+		[]byte("PACK\x00\x00\x00\x02\xff\xff\xff\xff"),
+		[]byte("not a pack"),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, _, err := parsePack(bytes.NewReader(data), io.Discard, nil)
+		if err != nil {
+			return
+		}
+		if err := resolvePackEntries(entries, nil); err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e.hash != hashObject(e.objType, e.body) {
+				t.Errorf("entry at %d resolved to %s, which is not its hash", e.offset, e.hash)
+			}
+		}
+	})
+}
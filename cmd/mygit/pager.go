@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pager feeds output to a pager process running on the terminal.
+type pager struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+}
+
+// pagerCommand returns the pager to run: GIT_PAGER, core.pager, PAGER
+// or less, in that order. "" and "cat" mean no pager.
+func pagerCommand() string {
+	if p, ok := os.LookupEnv("GIT_PAGER"); ok {
+		return p
+	}
+	if cfg, err := readConfig(); err == nil {
+		if p, ok := cfg.get("core", "", "pager"); ok {
+			return p
+		}
+	}
+	if p, ok := os.LookupEnv("PAGER"); ok {
+		return p
+	}
+	return "less"
+}
+
+// startPager starts the pager when stdout is a terminal and paginate is
+// "auto" or "always", and returns where output should go and a function
+// that waits for the pager to finish. As in git, a pipe or file is never
+// paged, whatever paginate says. Without a pager the output goes
+// straight to stdout.
+func startPager(paginate string) (io.Writer, func()) {
+	command := pagerCommand()
+	if paginate == "never" || !isTerminal(os.Stdout) || command == "" || command == "cat" {
+		return os.Stdout, func() {}
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	// As in git, commands this one runs learn that a pager is in use,
+	// but the pager itself does not.
+	os.Setenv("GIT_PAGER_IN_USE", "true")
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "GIT_PAGER_IN_USE=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		// Pass colors through and do not page or clear short output.
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return os.Stdout, func() {}
+	}
+	if err := cmd.Start(); err != nil {
+		return os.Stdout, func() {}
+	}
+	p := &pager{cmd: cmd, in: in}
+	return p, p.close
+}
+
+// Write passes output to the pager. Once the pager has quit, as when
+// the user leaves less before the end, there is no one left to read the
+// rest, so the command ends quietly as git does on SIGPIPE.
+func (p *pager) Write(b []byte) (int, error) {
+	n, err := p.in.Write(b)
+	if err != nil {
+		p.close()
+		os.Exit(0)
+	}
+	return n, nil
+}
+
+// close signals the end of output and waits for the pager to exit.
+func (p *pager) close() {
+	p.in.Close()
+	p.cmd.Wait()
+}
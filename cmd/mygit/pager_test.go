@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestPager runs log, diff and show on a terminal through a pager that
+// shows the environment it gets, and checks against git that the pager
+// is taken from GIT_PAGER, core.pager or PAGER, that --no-pager and a
+// pipe turn it off, and that a pager which quits early ends the command
+// quietly.
+func TestPager(t *testing.T) {
+	if _, err := exec.LookPath("script"); err != nil {
+		t.Skip("script is not installed")
+	}
+	r := newGoldenRepo(t)
+	r.git("config", "log.decorate", "false")
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "changed\n", 0o644)
+	r.git("commit", "-q", "-am", "change README")
+	r.write("README", "unstaged\n", 0o644)
+
+	// onTerminal runs a command with script(1), which gives it a
+	// pseudo-terminal as its output, and returns what it wrote there and
+	// its exit status.
+	onTerminal := func(name string, args []string, env ...string) string {
+		t.Helper()
+		quoted := []string{"'" + name + "'"}
+		for _, arg := range args {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+		cmd := exec.Command("script", "-qec", strings.Join(quoted, " ")+"; echo exit=$?", os.DevNull)
+		cmd.Dir = r.dir
+		cmd.Env = append(append(append([]string{}, r.env...), "MYGIT_TEST_MAIN=1"), env...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("script %s: %v", strings.Join(args, " "), err)
+		}
+		return string(out)
+	}
+	compare := func(args []string, env ...string) {
+		t.Helper()
+		want, got := onTerminal("git", args, env...), onTerminal(os.Args[0], args, env...)
+		if got != want {
+			t.Errorf("%v %s:\ngit:   %q\nmygit: %q", env, strings.Join(args, " "), want, got)
+		}
+	}
+
+	show := `echo "LESS=$LESS LV=$LV in use=$GIT_PAGER_IN_USE"; sed "s/^/paged: /"`
+	for _, env := range []string{"GIT_PAGER=" + show, "PAGER=" + show} {
+		for _, args := range [][]string{{"log"}, {"diff"}, {"show"}, {"-p", "log"}, {"--no-pager", "log"}} {
+			compare(args, env)
+		}
+	}
+	compare([]string{"log"}, "GIT_PAGER=cat")
+	compare([]string{"log"}, "LESS=-R", "GIT_PAGER="+show)
+	r.git("config", "core.pager", show)
+	compare([]string{"log"}, "PAGER=cat")
+	r.same("-p", "log")
+
+	for i := 0; i < 100; i++ {
+		r.git("commit", "-q", "--allow-empty", "-m", "empty")
+	}
+	// Whether git dies of SIGPIPE here depends on how far it got before
+	// head quit, so only mygit's quiet exit is checked.
+	first := strings.SplitAfter(onTerminal("git", []string{"log"}, "GIT_PAGER=head -n 1"), "\n")[0]
+	if got := onTerminal(os.Args[0], []string{"log"}, "GIT_PAGER=head -n 1"); got != first+"exit=0\r\n" {
+		t.Errorf("log through a pager that quits early printed %q, want %q", got, first+"exit=0\r\n")
+	}
+}
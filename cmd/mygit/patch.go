@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// diffSide is one side of a file diff. A file missing from that side
+// has no hash.
+type diffSide struct {
+	hash string
+	mode uint32
+	data []byte
+}
+
+// writePatch writes the diff of the file at path between old and new in
+// git's unified format, headers included, in git's colors with color.
+func writePatch(w io.Writer, path string, old, new diffSide, color bool) {
+	from, to := quotePath("a/"+path), quotePath("b/"+path)
+	writeDiffMeta(w, color, fmt.Sprintf("diff --git %s %s", from, to))
+	switch {
+	case old.hash == "":
+		writeDiffMeta(w, color, fmt.Sprintf("new file mode %06o", new.mode))
+		from = "/dev/null"
+	case new.hash == "":
+		writeDiffMeta(w, color, fmt.Sprintf("deleted file mode %06o", old.mode))
+		to = "/dev/null"
+	case old.mode != new.mode:
+		writeDiffMeta(w, color, fmt.Sprintf("old mode %06o", old.mode), fmt.Sprintf("new mode %06o", new.mode))
+	}
+	if old.hash == new.hash {
+		return // only the mode changed
+	}
+	writePatchBody(w, path, from, to, old, new, color)
+}
+
+// writeDiffMeta writes the header lines of a file's diff, bold with
+// color.
+func writeDiffMeta(w io.Writer, color bool, lines ...string) {
+	for _, line := range lines {
+		fmt.Fprintln(w, paint(color, colorBold, line))
+	}
+}
+
+// writeRenamePatch writes the diff of a file renamed from oldPath to
+// path, whose sides are score percent alike, as writePatch does.
+func writeRenamePatch(w io.Writer, oldPath, path string, score int, old, new diffSide, color bool) {
+	from, to := quotePath("a/"+oldPath), quotePath("b/"+path)
+	writeDiffMeta(w, color, fmt.Sprintf("diff --git %s %s", from, to))
+	if old.mode != new.mode {
+		writeDiffMeta(w, color, fmt.Sprintf("old mode %06o", old.mode), fmt.Sprintf("new mode %06o", new.mode))
+	}
+	writeDiffMeta(w, color, fmt.Sprintf("similarity index %d%%", score),
+		"rename from "+quotePath(oldPath), "rename to "+quotePath(path))
+	if old.hash == new.hash {
+		return
+	}
+	writePatchBody(w, path, from, to, old, new, color)
+}
+
+// writePatchBody writes what follows the headers naming the change: the
+// index line and the hunks, or a note that binary files differ. from and
+// to are the names the --- and +++ lines give.
+func writePatchBody(w io.Writer, path, from, to string, old, new diffSide, color bool) {
+	oldHash, newHash := "0000000", "0000000"
+	if old.hash != "" {
+		oldHash = old.hash[:7]
+	}
+	if new.hash != "" {
+		newHash = new.hash[:7]
+	}
+	if old.hash != "" && new.hash != "" && old.mode == new.mode {
+		writeDiffMeta(w, color, fmt.Sprintf("index %s..%s %06o", oldHash, newHash, old.mode))
+	} else {
+		writeDiffMeta(w, color, fmt.Sprintf("index %s..%s", oldHash, newHash))
+	}
+	// A trailing tab sets off names with spaces from anything after them.
+	if diffAsBinary(path, old.data, new.data) {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", from, to)
+		return
+	}
+	writeDiffMeta(w, color, "--- "+from+nameTab(from), "+++ "+to+nameTab(to))
+	writeHunks(w, splitLines(old.data), splitLines(new.data), color)
+}
+
+// diffAsBinary reports whether the file at path is shown as binary
+// rather than as a line diff. The diff attribute decides, so "-diff" and
+// the binary macro make a file binary and "diff" makes it text; without
+// it, a side holding a NUL byte in its first 8000 bytes is binary.
+func diffAsBinary(path string, old, new []byte) bool {
+	switch pathAttributes(path)["diff"] {
+	case attrUnset:
+		return true
+	case "":
+		return isBinary(old) || isBinary(new)
+	}
+	return false
+}
+
+func nameTab(name string) string {
+	if strings.Contains(name, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// writeHunks writes the hunks of a line diff from a to b, merging
+// changes whose context would overlap into one hunk.
+func writeHunks(w io.Writer, a, b []string, color bool) {
+	ops := diffLines(a, b)
+	eofBlank := blankAtEOF(a, b)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		// Extend the hunk over every change that follows with no more
+		// than twice the context between it and the previous one.
+		start := max(0, i-diffContext)
+		end := i
+		for j := i; j < len(ops) && j <= end+2*diffContext+1; j++ {
+			if ops[j].kind != diffEqual {
+				end = j
+			}
+		}
+		stop := min(len(ops), end+1+diffContext)
+		writeHunk(w, a, b, ops[start:stop], color, eofBlank)
+		i = stop
+	}
+}
+
+// blankAtEOF returns where the blank lines that b adds at its end, past
+// those a already ended in, begin: git marks them as whitespace errors.
+// It returns len(b) when b adds none.
+func blankAtEOF(a, b []string) int {
+	trailing := func(lines []string) int {
+		n := 0
+		for n < len(lines) && strings.TrimSpace(lines[len(lines)-1-n]) == "" {
+			n++
+		}
+		return n
+	}
+	if n := trailing(b); n > trailing(a) {
+		return len(b) - n
+	}
+	return len(b)
+}
+
+// writeHunk writes one hunk: its header and the lines of ops. With
+// color, as in git, the header is cyan, removed lines red and added ones
+// green, with their whitespace errors on red: added lines from eofBlank
+// on are blank lines at the end of the file.
+func writeHunk(w io.Writer, a, b []string, ops []diffOp, color bool, eofBlank int) {
+	oldCount, newCount := 0, 0
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			oldCount++
+		}
+		if op.kind != diffDelete {
+			newCount++
+		}
+	}
+	oldStart, newStart := ops[0].oldLine, ops[0].newLine
+	header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	fmt.Fprint(w, paint(color, colorCyan, header))
+	if fn := funcName(a[:oldStart]); fn != "" {
+		fmt.Fprintf(w, " %s", paint(color, colorReset, fn))
+	}
+	fmt.Fprintln(w)
+	for _, op := range ops {
+		switch op.kind {
+		case diffEqual:
+			writeDiffLine(w, ' ', a[op.oldLine], color)
+		case diffDelete:
+			writeDiffLine(w, '-', a[op.oldLine], color)
+		case diffInsert:
+			if color {
+				writeAddedLine(w, b[op.newLine], op.newLine >= eofBlank)
+			} else {
+				writeDiffLine(w, '+', b[op.newLine], false)
+			}
+		}
+	}
+}
+
+// hunkRange formats the "start,count" of a hunk header for a hunk whose
+// lines begin after the first start lines. The count is left out when
+// it is one, and an empty range names the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// writeDiffLine writes a line of a hunk, marking a last line that has no
+// newline as git does.
+func writeDiffLine(w io.Writer, prefix byte, line string, color bool) {
+	text, newline := strings.CutSuffix(line, "\n")
+	switch {
+	case !color:
+		fmt.Fprintf(w, "%c%s\n", prefix, text)
+	case prefix == '-':
+		fmt.Fprintln(w, paint(true, colorRed, "-"+text))
+	default:
+		fmt.Fprintln(w, paint(true, "", string(prefix)+text))
+	}
+	writeNoNewline(w, newline, color)
+}
+
+// writeNoNewline marks, unless newline is set, a line at the end of a
+// file that has no newline.
+func writeNoNewline(w io.Writer, newline, color bool) {
+	if !newline {
+		fmt.Fprintln(w, paint(color, "", "\\ No newline at end of file"))
+	}
+}
+
+// writeAddedLine writes an added line of a hunk in color, painting its
+// whitespace errors as git does by default: whitespace at its end, and
+// spaces before a tab in its indent, on red, and a blank line at the end
+// of the file, eofBlank, on red whole. The rest of its indent is left
+// unpainted and the rest of the line is green.
+func writeAddedLine(w io.Writer, line string, eofBlank bool) {
+	text, newline := strings.CutSuffix(line, "\n")
+	body := strings.TrimRight(text, " \t\r\v\f")
+	if eofBlank && body == "" {
+		fmt.Fprintln(w, paint(true, colorRedBg, "+"+text))
+		writeNoNewline(w, newline, true)
+		return
+	}
+	fmt.Fprint(w, paint(true, colorGreen, "+"))
+	written := 0
+	for i := 0; i < len(body) && (body[i] == ' ' || body[i] == '\t'); i++ {
+		if body[i] != '\t' {
+			continue
+		}
+		if written < i {
+			fmt.Fprint(w, paint(true, colorRedBg, body[written:i]), "\t")
+		} else {
+			fmt.Fprint(w, body[written:i+1])
+		}
+		written = i + 1
+	}
+	if rest := body[written:]; rest != "" {
+		fmt.Fprint(w, paint(true, colorGreen, rest))
+	}
+	if blank := text[len(body):]; blank != "" {
+		fmt.Fprint(w, paint(true, colorRedBg, blank))
+	}
+	fmt.Fprintln(w)
+	writeNoNewline(w, newline, true)
+}
+
+// funcName finds the text git shows after a hunk header: the last of
+// lines that starts with a letter, "_" or "$", cut to 80 bytes and
+// without trailing space.
+func funcName(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		if c := line[0]; c == '_' || c == '$' || (c|0x20 >= 'a' && c|0x20 <= 'z') {
+			return strings.TrimRight(line[:min(len(line), 80)], " \t\r\n")
+		}
+	}
+	return ""
+}
+
+// worktreeSide reads e's file from the working tree, reusing the index's
+// blob when the file's stat data shows it has not changed since it was
+// staged. A deleted file has an empty side.
+func worktreeSide(idx *index, e *indexEntry) (diffSide, error) {
+	info, err := os.Lstat(filepath.FromSlash(e.path))
+	if errors.Is(err, os.ErrNotExist) {
+		return diffSide{}, nil
+	}
+	if err != nil {
+		return diffSide{}, err
+	}
+	if idx.upToDate(e, info) {
+		return diffSide{hash: e.hash, mode: e.mode}, nil
+	}
+	data, mode, err := readWorktreeBlob(e.path)
+	if err != nil {
+		return diffSide{}, err
+	}
+	return diffSide{hashObject(BlobObject, data), mode, data}, nil
+}
+
+// diffWorktree implements `mygit diff`: the changes in the working tree
+// that are not staged, as a unified diff of each tracked file against
+// its index entry, colored with color. Conflicted paths are only named,
+// as unmerged.
+func diffWorktree(w io.Writer, color bool) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	for i, e := range idx.entries {
+		if e.stage() != 0 {
+			if i == 0 || idx.entries[i-1].path != e.path {
+				fmt.Fprintf(w, "* Unmerged path %s\n", e.path)
+			}
+			continue
+		}
+		if e.unexamined() {
+			continue
+		}
+		side, err := worktreeSide(idx, e)
+		if err != nil {
+			return err
+		}
+		if side.hash == e.hash && side.mode == e.mode {
+			continue
+		}
+		_, staged, err := readObject(e.hash)
+		if err != nil {
+			return err
+		}
+		writePatch(w, e.path, diffSide{e.hash, e.mode, staged}, side, color)
+	}
+	return nil
+}
+
+// blobSide loads the diff side a tree entry names; the zero entry is a
+// missing file. A submodule shows as the commit it records, as in git.
+func blobSide(e TreeEntry) (diffSide, error) {
+	if e.Hash == "" {
+		return diffSide{}, nil
+	}
+	mode := parseMode(e.Mode)
+	if mode == 0o160000 {
+		return diffSide{e.Hash, mode, []byte("Subproject commit " + e.Hash + "\n")}, nil
+	}
+	_, data, err := readObject(e.Hash)
+	if err != nil {
+		return diffSide{}, err
+	}
+	return diffSide{e.Hash, mode, data}, nil
+}
+
+// diffCached implements `mygit diff --cached`: the changes staged for
+// the next commit, as a unified diff of the index against HEAD, colored
+// with color. On an unborn branch every staged file is new.
+func diffCached(w io.Writer, color bool) error {
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		head, err = "", nil
+	}
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]TreeEntry, len(idx.entries))
+	for _, e := range idx.entries {
+		staged[e.path] = TreeEntry{Mode: formatMode(e.mode), Name: path.Base(e.path), Hash: e.hash}
+	}
+	for _, c := range diffTrees(files, staged) {
+		old, err := blobSide(c.old)
+		if err != nil {
+			return err
+		}
+		new, err := blobSide(c.new)
+		if err != nil {
+			return err
+		}
+		if c.status == 'T' {
+			// A file that became a symlink or back is shown removed and re-added.
+			writePatch(w, c.path, old, diffSide{}, color)
+			writePatch(w, c.path, diffSide{}, new, color)
+			continue
+		}
+		writePatch(w, c.path, old, new, color)
+	}
+	return nil
+}
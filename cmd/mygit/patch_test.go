@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestColoredDiff compares diff and show with --color against git: bold
+// headers, a cyan hunk header with its function name, red and green
+// lines, whitespace errors on red, and a colored diffstat.
+func TestColoredDiff(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.write("main.c", "int main() {\n\tone();\n\ttwo();\n\tthree();\n\tfour();\n\treturn 0;\n}\n", 0o644)
+	r.write("lib.c", "void lib() {\n"+strings.Repeat("\tstep();\n", 8)+"}\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+
+	r.write("lib.c", "void lib() {\n"+strings.Repeat("\tstep();\n", 6)+"\tlast();\n\tstep();\n}\n", 0o644)
+	r.write("main.c", "int main() {\n\tone();\n  \t two();\n \n\tTHREE();  \n\tfour();\n\treturn 0;\n}\n\t\n\n", 0o644)
+	r.write("README", "hello\n", 0o644)
+	r.write("data.bin", "\x00changed", 0o644)
+	r.write("new.txt", "no newline", 0o644)
+	r.git("add", "new.txt", "data.bin")
+	for _, args := range [][]string{
+		{"diff", "--color=always"},
+		{"diff", "--color"},
+		{"diff", "--cached", "--color=always"},
+		{"diff", "--color=never"},
+		{"diff", "--no-color"},
+	} {
+		r.same(args...)
+	}
+
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "change")
+	r.same("show", "--color=always")
+	r.same("show", "--stat", "--color=always")
+	r.same("show", "--color=always", "HEAD~1")
+	r.same("show", "--color=auto")
+}
+
+// TestShowStat compares show --stat with git's for a root commit, which
+// is stat'ed against the empty tree, a commit whose changes are too big
+// for the bar to be drawn to scale, a binary change and a deletion, and
+// a merge, whose stat is left out.
+func TestShowStat(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.same("show", "--stat")
+
+	r.write("README", strings.Repeat("line\n", 200), 0o644)
+	r.write("small", "one\n", 0o644)
+	r.write("data.bin", "\x00changed", 0o644)
+	r.git("rm", "-q", "src/lib/deep.txt")
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "change")
+	r.same("show", "--stat")
+	r.same("show", "--stat", "HEAD~1")
+
+	r.git("checkout", "-q", "-b", "topic", "HEAD~1")
+	r.write("topic", "topic\n", 0o644)
+	r.git("add", "topic")
+	r.git("commit", "-q", "-m", "topic")
+	r.git("checkout", "-q", "main")
+	r.git("merge", "-q", "--no-edit", "topic")
+	r.same("show", "--stat")
+}
+
+// TestShowRejectsUnknownOption checks that show fails with its usage
+// for an option it does not know, rather than taking it for a commit.
+func TestShowRejectsUnknownOption(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	if got := r.mygitFails("show", "--oneline"); !strings.HasPrefix(got, "usage: mygit show") {
+		t.Errorf("show --oneline printed %q, want its usage", got)
+	}
+	if got := r.mygitFails("show", "--color=sometimes"); !strings.Contains(got, "sometimes") {
+		t.Errorf("show --color=sometimes printed %q", got)
+	}
+}
+
+// TestColoredLog compares log with --color against git: yellow commit
+// names, over a merge.
+func TestColoredLog(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.git("merge", "-q", "--no-edit", "topic")
+	for _, args := range [][]string{
+		{"log", "--color=always"},
+		{"log", "--color"},
+		{"log", "--no-color"},
+		{"log", "--color=never"},
+	} {
+		r.same(args...)
+	}
+}
+
+// TestDiffWorktree compares diff against git for unstaged changes: edits
+// far enough apart to make several hunks, a removed line at the end of
+// a file without a newline, a deleted file, a mode change, a symlink
+// retargeted, binary content, and a file only touched, which shows
+// nothing.
+func TestDiffWorktree(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	r.write("long.txt", strings.Join(lines, "\n")+"\n", 0o644)
+	r.write("tail.txt", "one\ntwo", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.same("diff")
+
+	lines[2], lines[20], lines[38] = "changed 2", "changed 20", "changed 38"
+	r.write("long.txt", strings.Join(lines, "\n")+"\n", 0o644)
+	r.write("tail.txt", "one\n", 0o644)
+	os.Remove(filepath.Join(r.dir, "src", "main.go"))
+	os.Chmod(filepath.Join(r.dir, "README"), 0o755)
+	os.Remove(filepath.Join(r.dir, "link"))
+	os.Symlink("run.sh", filepath.Join(r.dir, "link"))
+	r.write("data.bin", "\x00other", 0o644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(r.dir, "src", "lib", "deep.txt"), future, future)
+	r.same("diff")
+}
+
+// TestDiffCached compares diff --cached and --staged against git for
+// staged changes on an unborn branch, where everything is new, and
+// after a commit: an added, a deleted and an edited file and a mode
+// change, with unstaged changes on top that it leaves out.
+func TestDiffCached(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.same("diff", "--cached")
+	r.git("commit", "-q", "-m", "root")
+	r.same("diff", "--cached")
+
+	r.write("new.txt", "new\n", 0o644)
+	r.write("README", "hello\nthere\n", 0o644)
+	r.git("add", "new.txt", "README")
+	r.git("rm", "-q", "src/main.go")
+	r.git("update-index", "--chmod=+x", "data.bin")
+	r.write("README", "unstaged\n", 0o644)
+	r.same("diff", "--cached")
+	r.same("diff", "--staged")
+}
+
+// TestBinaryDiff checks that diff, diff --cached and show report binary
+// files as git does when they are added, changed and deleted: a NUL in
+// the first 8000 bytes makes a file binary, one after them does not,
+// and attributes decide before the content is looked at.
+func TestBinaryDiff(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write(".gitattributes", "forced.txt binary\ntext.bin text\n", 0o644)
+	r.write("early.bin", "\x00early", 0o644)
+	r.write("late.txt", strings.Repeat("x", 8000)+"\x00late\n", 0o644)
+	r.write("forced.txt", "plain\n", 0o644)
+	r.write("text.bin", "one\x00two\n", 0o644)
+	r.git("add", "-A")
+	r.same("diff", "--cached")
+	r.git("commit", "-q", "-m", "add")
+	r.same("show")
+
+	r.write("early.bin", "\x00changed", 0o644)
+	r.write("late.txt", strings.Repeat("y", 8000)+"\x00late\n", 0o644)
+	r.write("forced.txt", "changed\n", 0o644)
+	r.write("text.bin", "one\x00three\n", 0o644)
+	r.same("diff")
+	r.git("add", "-A")
+	r.same("diff", "--cached")
+	r.git("commit", "-q", "-m", "change")
+	r.same("show")
+
+	r.git("rm", "-q", "early.bin", "forced.txt")
+	r.same("diff", "--cached")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// pathPattern is one gitignore-style pattern, as used by .gitattributes
+// and other per-path settings.
+type pathPattern struct {
+	glob     string // the pattern without "!", a leading "/" or a trailing "/"
+	base     string // directory of the file the pattern came from, "" at the top
+	negate   bool   // the pattern started with "!"
+	dirOnly  bool   // the pattern ended with "/" and only matches directories
+	anchored bool   // the pattern has a "/" and is matched from base
+}
+
+// parsePathPattern parses a line of a gitignore-style file found in the
+// directory base. ok is false for blank lines and comments.
+func parsePathPattern(line, base string) (p pathPattern, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	p.base = base
+	if line[0] == '!' {
+		p.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if trimmed, ok := strings.CutSuffix(line, "/"); ok {
+		p.dirOnly, line = true, trimmed
+	}
+	p.anchored = strings.Contains(line, "/")
+	p.glob = strings.TrimPrefix(line, "/")
+	return p, p.glob != ""
+}
+
+// match reports whether the pattern matches the slash-separated path,
+// which names a directory when isDir is set. A pattern without a slash
+// matches the final component of paths at any depth below base.
+func (p pathPattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		rel, ok := strings.CutPrefix(name, p.base+"/")
+		if !ok {
+			return false
+		}
+		name = rel
+	}
+	if !p.anchored {
+		return wildmatch(p.glob, path.Base(name))
+	}
+	return wildmatch(p.glob, name)
+}
+
+// wildmatch matches a slash-separated name against a glob in which "*",
+// "?" and "[...]" stay within one component and a "**" component matches
+// any number of components.
+func wildmatch(glob, name string) bool {
+	return matchComponents(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchComponents(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			if len(glob) == 1 {
+				return len(name) > 0 // "dir/**" matches what is inside dir
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchComponents(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		// Go spells a negated character class "[^...]"; git also allows "[!...]".
+		ok, err := path.Match(strings.ReplaceAll(glob[0], "[!", "[^"), name[0])
+		if err != nil || !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// pickParent returns the parent whose changes a cherry-pick of c applies,
+// or "" for a root commit. mainline selects the parent (1-based) of a
+// merge and must be 0 otherwise.
+func pickParent(c *Commit, mainline int) (string, error) {
+	switch {
+	case len(c.Parents) > 1 && mainline == 0:
+		return "", fmt.Errorf("commit %s is a merge but no -m option was given.", c.Hash)
+	case len(c.Parents) <= 1 && mainline != 0:
+		return "", fmt.Errorf("mainline was specified but commit %s is not a merge.", c.Hash)
+	case mainline > len(c.Parents) || mainline < 0:
+		return "", fmt.Errorf("commit %s does not have parent %d", c.Hash, mainline)
+	case mainline > 0:
+		return c.Parents[mainline-1], nil
+	case len(c.Parents) == 1:
+		return c.Parents[0], nil
+	}
+	return "", nil
+}
+
+// commitFiles flattens the tree of the commit named by hash; "" stands
+// for the empty tree.
+func commitFiles(hash string) (map[string]TreeEntry, error) {
+	if hash == "" {
+		return map[string]TreeEntry{}, nil
+	}
+	c, err := readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	return flattenTree(c.Tree)
+}
+
+// currentBranchName is the branch name git shows in "[main abc1234]".
+func currentBranchName() string {
+	ref, _ := headRef()
+	if ref == "" {
+		return "detached HEAD"
+	}
+	return strings.TrimPrefix(ref, "refs/heads/")
+}
+
+// The files recording a cherry-pick or revert that stopped for
+// conflicts: the commit being picked or reverted. The message to commit
+// the result with waits in MERGE_MSG.
+const (
+	cherryPickHeadFile = "CHERRY_PICK_HEAD"
+	revertHeadFile     = "REVERT_HEAD"
+)
+
+// pickInProgress returns the command stopped for conflicts,
+// "cherry-pick" or "revert", and the commit it was applying, or "" when
+// neither is.
+func pickInProgress() (action, hash string, err error) {
+	for _, f := range []struct{ action, name string }{
+		{"cherry-pick", cherryPickHeadFile},
+		{"revert", revertHeadFile},
+	} {
+		data, err := os.ReadFile(gitPath(f.name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return f.action, strings.TrimSpace(string(data)), nil
+	}
+	return "", "", nil
+}
+
+// pickCheck refuses, as git does, to cherry-pick or revert while there
+// are conflicts, another merge, cherry-pick or revert is in progress, or
+// the index differs from HEAD. Files the pick would overwrite are
+// checked by applyMerge.
+func pickCheck(s *worktreeStatus, action string) error {
+	failed := "\nfatal: " + action + " failed"
+	if len(s.idx.unmerged()) > 0 {
+		doing := "Cherry-picking"
+		if action == "revert" {
+			doing = "Reverting"
+		}
+		return errors.New(unmergedMessage(doing) + failed)
+	}
+	inProgress, _, err := pickInProgress()
+	if err != nil {
+		return err
+	}
+	if inProgress != "" {
+		return fmt.Errorf("%s is already in progress\nhint: try \"git %s (--continue | --abort)\"%s", inProgress, inProgress, failed)
+	}
+	if heads, err := mergeHeads(); err != nil {
+		return err
+	} else if heads != nil {
+		return errors.New("You have not concluded your merge (MERGE_HEAD exists)." + failed)
+	}
+	for _, e := range s.entries {
+		if e.staged != ' ' && e.staged != '?' {
+			return fmt.Errorf("your local changes would be overwritten by %s.\n"+
+				"hint: commit your changes or stash them to proceed.%s", action, failed)
+		}
+	}
+	return nil
+}
+
+// cherryPickContinue implements `mygit cherry-pick --continue` and
+// `mygit revert --continue`: once its conflicts are resolved, the
+// stopped pick is committed as `mygit commit` would.
+func cherryPickContinue(w io.Writer, action string) error {
+	if inProgress, _, err := pickInProgress(); err != nil {
+		return err
+	} else if inProgress == "" {
+		return fmt.Errorf("no cherry-pick or revert in progress\nfatal: %s failed", action)
+	}
+	return commitIndex(w, "", commitOptions{})
+}
+
+// cherryPickAbort implements `mygit cherry-pick --abort` and `mygit
+// revert --abort`, undoing the stopped pick as `mygit merge --abort`
+// undoes a merge.
+func cherryPickAbort(action string) error {
+	if inProgress, _, err := pickInProgress(); err != nil {
+		return err
+	} else if inProgress == "" {
+		return fmt.Errorf("no cherry-pick or revert in progress\nfatal: %s failed", action)
+	}
+	return abortToHead()
+}
+
+// cherryPick implements `mygit cherry-pick [-m <parent>] <commit>`: the
+// changes the commit made relative to its parent are merged onto HEAD
+// and committed with the original author and message. A pick that
+// conflicts stops, with CHERRY_PICK_HEAD naming the commit, for `mygit
+// commit` or `mygit cherry-pick --continue` to conclude.
+func cherryPick(w io.Writer, rev string, mainline int) error {
+	return pickCommit(w, rev, mainline, false)
+}
+
+// revert implements `mygit revert [-m <parent>] <commit>`, merging the
+// inverse of the commit's changes onto HEAD; one that conflicts stops
+// with REVERT_HEAD naming the commit.
+func revert(w io.Writer, rev string, mainline int) error {
+	return pickCommit(w, rev, mainline, true)
+}
+
+// pickCommit applies the changes of the commit named by rev onto HEAD,
+// or undoes them when reverse is set, by a three-way merge in which the
+// commit's parent (or, reversed, the commit itself) is the base.
+func pickCommit(w io.Writer, rev string, mainline int, reverse bool) error {
+	action, stateFile := "cherry-pick", cherryPickHeadFile
+	if reverse {
+		action, stateFile = "revert", revertHeadFile
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	if err := pickCheck(s, action); err != nil {
+		return err
+	}
+	hash, err := resolveObjectName(rev)
+	if err != nil {
+		return err
+	}
+	if hash, err = peelToCommit(hash); err != nil {
+		return err
+	}
+	c, err := readCommit(hash)
+	if err != nil {
+		return err
+	}
+	parent, err := pickParent(c, mainline)
+	if err != nil {
+		return err
+	}
+	head, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	headCommit, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+
+	base, err := commitFiles(parent)
+	if err != nil {
+		return err
+	}
+	ours, err := flattenTree(headCommit.Tree)
+	if err != nil {
+		return err
+	}
+	theirs, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	label := fmt.Sprintf("%s (%s)", hash[:7], c.Subject())
+	verb, message, author := "apply", c.Message, c.Author
+	if reverse {
+		base, theirs = theirs, base
+		verb, label = "revert", "parent of "+label
+		message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", c.Subject(), hash)
+		if mainline > 0 {
+			message += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+		}
+		message += ".\n"
+		if author, err = currentSignature("AUTHOR"); err != nil {
+			return err
+		}
+	}
+	tree, conflicted, err := applyMerge(w, s, base, ours, theirs, "HEAD", label)
+	if err != nil {
+		return fmt.Errorf("%w\nfatal: %s failed", err, action)
+	}
+	if conflicted {
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		if err := writeMergeMsg(message, idx.unmerged()); err != nil {
+			return err
+		}
+		if err := os.WriteFile(gitPath(stateFile), []byte(hash+"\n"), 0o644); err != nil {
+			return err
+		}
+		return fmt.Errorf("could not %s %s... %s\n"+
+			"hint: After resolving the conflicts, mark them with\n"+
+			"hint: \"git add/rm <pathspec>\", then run\n"+
+			"hint: \"git %s --continue\".\n"+
+			"hint: To abort and get back to the state before \"git %s\",\n"+
+			"hint: run \"git %s --abort\".", verb, hash[:7], c.Subject(), action, action, action)
+	}
+	if tree == headCommit.Tree {
+		return errors.New("nothing to commit; the result is identical to HEAD")
+	}
+
+	committer, err := currentSignature("COMMITTER")
+	if err != nil {
+		return err
+	}
+	picked, err := writeCommitObject(&Commit{
+		Tree:      tree,
+		Parents:   []string{head},
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	subject := (&Commit{Message: message}).Subject()
+	if err := updateHead(picked, action+": "+subject); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "[%s %s] %s\n", currentBranchName(), picked[:7], subject)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pickRepo sets up a repository whose branch topic changes a, adds c
+// and then changes b, over main, which changes b too, so that picking
+// topic's tip conflicts. The topic commits are by another author.
+func pickRepo(t *testing.T) *goldenRepo {
+	r := newGoldenRepo(t)
+	r.write("a", "a\n", 0o644)
+	r.write("b", "b\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("checkout", "-q", "-b", "topic")
+	author := []string{"GIT_AUTHOR_NAME=Other Author", "GIT_AUTHOR_EMAIL=other@example.com"}
+	r.write("a", "a from topic\n", 0o644)
+	r.run("", "git", []string{"commit", "-q", "-am", "change a"}, author...)
+	r.write("c", "c\n", 0o644)
+	r.git("add", "c")
+	r.run("", "git", []string{"commit", "-q", "-m", "add c"}, author...)
+	r.write("b", "b from topic\n", 0o644)
+	r.run("", "git", []string{"commit", "-q", "-am", "change b"}, author...)
+	r.git("checkout", "-q", "main")
+	r.write("b", "b from main\n", 0o644)
+	r.git("commit", "-q", "-am", "change b on main")
+	return r
+}
+
+// sameFailure runs args with git and mygit, which must both fail and
+// print the same, leaving the repository as they found it.
+func (r *goldenRepo) sameFailure(args ...string) {
+	r.t.Helper()
+	want, wantOK := r.stderrOf("git", args...)
+	got, gotOK := r.stderrOf("", args...)
+	if wantOK || gotOK || got != want {
+		r.t.Errorf("%s:\ngit:   %q %v\nmygit: %q %v", strings.Join(args, " "), want, wantOK, got, gotOK)
+	}
+}
+
+// TestPickRefusesLocalChanges checks that cherry-pick and revert refuse,
+// as git does, to run over a modified file the pick changes, an
+// untracked file in its way, or a staged change anywhere.
+func TestPickRefusesLocalChanges(t *testing.T) {
+	r := pickRepo(t)
+	for _, command := range []string{"cherry-pick", "revert"} {
+		rev := "topic~2"
+		if command == "revert" {
+			r.git("cherry-pick", "topic~2", "topic~1")
+			rev = "HEAD~1"
+		}
+		r.write("a", "local\n", 0o644)
+		r.sameFailure(command, rev)
+		r.git("checkout", "a")
+
+		if command == "cherry-pick" {
+			r.write("c", "local\n", 0o644)
+			r.sameFailure(command, "topic~1")
+			os.Remove(filepath.Join(r.dir, "c"))
+		}
+
+		r.write("b", "staged\n", 0o644)
+		r.git("add", "b")
+		r.sameFailure(command, rev)
+		r.git("reset", "-q", "--hard")
+	}
+}
+
+// TestPickConflict stops a cherry-pick and a revert for conflicts and
+// checks the state they leave against git's: CHERRY_PICK_HEAD or
+// REVERT_HEAD and MERGE_MSG, a refusal to go on while conflicts remain,
+// and a commit that concludes it with the picked commit's message and
+// author, or an abort that undoes it.
+func TestPickConflict(t *testing.T) {
+	r := pickRepo(t)
+	state := func() string {
+		var b strings.Builder
+		for _, name := range []string{"CHERRY_PICK_HEAD", "REVERT_HEAD", "MERGE_MSG"} {
+			data, _ := os.ReadFile(filepath.Join(r.dir, ".git", name))
+			b.WriteString(name + ": " + string(data) + "\n")
+		}
+		return b.String() + r.git("status", "--porcelain") + r.git("ls-files", "--stage")
+	}
+
+	r.stderrOf("git", "cherry-pick", "topic")
+	want := state()
+	r.git("cherry-pick", "--abort")
+	if _, ok := r.stderrOf("", "cherry-pick", "topic"); ok {
+		t.Fatal("cherry-pick of a conflicting commit succeeded")
+	}
+	if got := state(); got != want {
+		t.Errorf("stopped cherry-pick:\ngit:   %q\nmygit: %q", want, got)
+	}
+	r.sameFailure("revert", "HEAD")
+	r.sameFailure("cherry-pick", "topic~1")
+
+	r.write("b", "resolved\n", 0o644)
+	r.git("add", "b")
+	r.mygit("commit")
+	if got := r.git("log", "-1", "--format=%an <%ae>%n%B"); got != "Other Author <other@example.com>\nchange b\n\n" {
+		t.Errorf("concluded cherry-pick is %q", got)
+	}
+	for _, name := range []string{"CHERRY_PICK_HEAD", "MERGE_MSG"} {
+		if _, err := os.Stat(filepath.Join(r.dir, ".git", name)); !os.IsNotExist(err) {
+			t.Errorf("%s left after the commit: %v", name, err)
+		}
+	}
+
+	r.write("b", "resolved again\n", 0o644)
+	r.git("commit", "-q", "-am", "again")
+	head := r.git("rev-parse", "HEAD")
+	r.stderrOf("git", "revert", "HEAD~1")
+	want = state()
+	r.git("revert", "--abort")
+	if _, ok := r.stderrOf("", "revert", "HEAD~1"); ok {
+		t.Fatal("revert of a conflicting commit succeeded")
+	}
+	if got := state(); got != want {
+		t.Errorf("stopped revert:\ngit:   %q\nmygit: %q", want, got)
+	}
+	r.mygit("revert", "--abort")
+	if got := r.git("status", "--porcelain"); got != "" {
+		t.Errorf("status after revert --abort = %q", got)
+	}
+	if got := r.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("revert --abort moved HEAD")
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, ".git", "REVERT_HEAD")); !os.IsNotExist(err) {
+		t.Errorf("REVERT_HEAD left after --abort: %v", err)
+	}
+	if got := r.mygitFails("cherry-pick", "--continue"); !strings.Contains(got, "no cherry-pick or revert in progress") {
+		t.Errorf("cherry-pick --continue with none stopped printed %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progress renders git-style "Receiving objects:  45% (9/20)" lines on
+// stderr, redrawing in place with \r. A nil *progress reports nothing.
+type progress struct {
+	title   string
+	total   int
+	count   int
+	percent int
+	began   time.Time
+	drawn   time.Time
+}
+
+// start begins a new phase counting up to total.
+func (p *progress) start(title string, total int) {
+	if p == nil {
+		return
+	}
+	*p = progress{title: title, total: total, percent: -1, began: time.Now()}
+}
+
+// update records count items processed and nbytes transferred so far,
+// redrawing when the percentage changes or at least once a second.
+func (p *progress) update(count int, nbytes int64) {
+	if p == nil {
+		return
+	}
+	p.count = count
+	percent := 100
+	if p.total > 0 {
+		percent = count * 100 / p.total
+	}
+	if percent == p.percent && time.Since(p.drawn) < time.Second {
+		return
+	}
+	p.percent = percent
+	p.drawn = time.Now()
+	fmt.Fprintf(os.Stderr, "\r%s", p.line(nbytes))
+}
+
+// done prints the final line of the phase.
+func (p *progress) done(nbytes int64) {
+	if p == nil {
+		return
+	}
+	p.count = p.total
+	fmt.Fprintf(os.Stderr, "\r%s, done.\n", p.line(nbytes))
+}
+
+func (p *progress) line(nbytes int64) string {
+	percent := 100
+	if p.total > 0 {
+		percent = p.count * 100 / p.total
+	}
+	s := fmt.Sprintf("%s: %3d%% (%d/%d)", p.title, percent, p.count, p.total)
+	if nbytes > 0 {
+		s += ", " + humanBytes(float64(nbytes))
+		if elapsed := time.Since(p.began).Seconds(); elapsed > 0 {
+			s += " | " + humanBytes(float64(nbytes)/elapsed) + "/s"
+		}
+	}
+	return s
+}
+
+// humanBytes formats n the way git's progress meter does.
+func humanBytes(n float64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", n/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", n/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", int64(n))
+}
@@ -0,0 +1,63 @@
+package main
+
+// promisorRemote returns the remote a partial clone can fetch missing
+// objects from, or "" if the repository is not a partial clone.
+func promisorRemote() string {
+	cfg, err := readConfig()
+	if err != nil {
+		return ""
+	}
+	remote, _ := cfg.get("extensions", "", "partialclone")
+	return remote
+}
+
+// partialCloneFilter returns the filter recorded for remote by a partial
+// clone, so later fetches keep leaving the same objects out.
+func partialCloneFilter(remote string) string {
+	cfg, err := readConfig()
+	if err != nil {
+		return ""
+	}
+	filter, _ := cfg.get("remote", remote, "partialclonefilter")
+	return filter
+}
+
+// fetchPromised downloads the named objects from the promisor remote in
+// a single request. Objects asked for by name are sent even when the
+// remote's filter would leave them out.
+func fetchPromised(hashes []string) error {
+	_, url, _, err := lookupRemote(promisorRemote())
+	if err != nil {
+		return err
+	}
+	adv, err := discoverRefs(url, "git-upload-pack")
+	if err != nil {
+		return err
+	}
+	resp, err := fetchPack(url, adv, fetchRequest{wants: hashes, quiet: true})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	_, err = indexPack(resp.pack, nil, true)
+	return err
+}
+
+// fetchMissingBlobs fetches every blob of the tree that a partial clone
+// left out, so a checkout does not need one request per file.
+func fetchMissingBlobs(treeHash string) error {
+	files, err := flattenTree(treeHash)
+	if err != nil {
+		return err
+	}
+	missing := map[string]bool{}
+	for _, e := range files {
+		if e.Mode != "160000" && !hasObject(e.Hash) {
+			missing[e.Hash] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fetchPromised(sortedKeys(missing))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// discoverRefsV2 reads the rest of a protocol v2 capability
+// advertisement from r and lists the server's refs with ls-refs.
+func discoverRefsV2(url string, r io.Reader) (*remoteRefs, error) {
+	adv := &remoteRefs{refs: map[string]string{}, caps: map[string]string{}, version: 2}
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: ref discovery: %w", url, err)
+		}
+		if line == nil {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSuffix(string(line), "\n"), "=")
+		adv.caps[key] = value
+	}
+	if _, ok := adv.caps["ls-refs"]; !ok {
+		return nil, fmt.Errorf("%s: server does not support ls-refs", url)
+	}
+	if err := lsRefs(url, adv); err != nil {
+		return nil, err
+	}
+	return adv, nil
+}
+
+// writeV2Command writes the command and capability lines that open
+// every protocol v2 request, followed by the delim-pkt before its
+// arguments.
+func writeV2Command(w io.Writer, adv *remoteRefs, command string) {
+	writePktLine(w, "command="+command+"\n")
+	if format, ok := adv.caps["object-format"]; ok {
+		writePktLine(w, "object-format="+format+"\n")
+	}
+	writeDelimPkt(w)
+}
+
+// postUploadPackV2 sends one protocol v2 request to upload-pack.
+func postUploadPackV2(url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url+"/git-upload-pack", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req.Header.Set("Git-Protocol", "version=2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: upload-pack failed: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// lsRefs fills in adv's refs from the ls-refs command. Peeled tags are
+// listed as name^{} and HEAD's target as a symref capability, as in a
+// v0 advertisement.
+func lsRefs(url string, adv *remoteRefs) error {
+	var body bytes.Buffer
+	writeV2Command(&body, adv, "ls-refs")
+	writePktLine(&body, "peel\n")
+	writePktLine(&body, "symrefs\n")
+	writeFlushPkt(&body)
+	resp, err := postUploadPackV2(url, &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	format, err := adv.format()
+	if err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return fmt.Errorf("%s: ls-refs: %w", url, err)
+		}
+		if line == nil {
+			return nil
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 || len(fields[0]) != format.hexLen() {
+			return fmt.Errorf("%s: malformed ls-refs line %q", url, line)
+		}
+		hash, name := fields[0], fields[1]
+		adv.names = append(adv.names, name)
+		adv.refs[name] = hash
+		for _, attr := range fields[2:] {
+			if target, ok := strings.CutPrefix(attr, "symref-target:"); ok && name == "HEAD" {
+				adv.caps["symref"] = "HEAD:" + target
+			}
+			if peeled, ok := strings.CutPrefix(attr, "peeled:"); ok {
+				adv.names = append(adv.names, name+"^{}")
+				adv.refs[name+"^{}"] = peeled
+			}
+		}
+	}
+}
+
+// fetchPackV2 is fetchPack for protocol v2 servers. Negotiation rounds
+// and the final request are all fetch commands; the server answers a
+// round with an acknowledgments section, and sends the pack once it is
+// ready or the client says done.
+func fetchPackV2(url string, adv *remoteRefs, req fetchRequest) (*fetchResponse, error) {
+	features := map[string]bool{}
+	for _, f := range strings.Fields(adv.caps["fetch"]) {
+		features[f] = true
+	}
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	if (req.depth > 0 || len(shallow) > 0) && !features["shallow"] {
+		return nil, fmt.Errorf("%s: server does not support shallow clients", url)
+	}
+	if req.filter != "" && !features["filter"] {
+		fmt.Fprintln(os.Stderr, "warning: filtering not recognized by server, ignoring")
+		req.filter = ""
+	}
+
+	var args bytes.Buffer
+	writeV2Command(&args, adv, "fetch")
+	writePktLine(&args, "ofs-delta\n")
+	if req.quiet {
+		writePktLine(&args, "no-progress\n")
+	}
+	for _, want := range req.wants {
+		writePktLine(&args, "want "+want+"\n")
+	}
+	for _, hash := range sortedKeys(shallow) {
+		writePktLine(&args, "shallow "+hash+"\n")
+	}
+	if req.depth > 0 {
+		writePktLine(&args, fmt.Sprintf("deepen %d\n", req.depth))
+	}
+	if req.filter != "" {
+		writePktLine(&args, "filter "+req.filter+"\n")
+	}
+
+	o := newHaveOffer(req.haves)
+	for {
+		batch := o.nextRound()
+		body := bytes.NewBuffer(append([]byte(nil), args.Bytes()...))
+		o.writeHaves(body, batch)
+		if batch == nil {
+			writePktLine(body, "done\n")
+		}
+		writeFlushPkt(body)
+
+		resp, err := postUploadPackV2(url, body)
+		if err != nil {
+			return nil, err
+		}
+		result, acks, err := readFetchResponseV2(url, resp)
+		if err != nil || result != nil {
+			return result, err
+		}
+		if batch == nil {
+			return nil, fmt.Errorf("%s: upload-pack sent no packfile", url)
+		}
+		o.ack(acks, len(batch))
+	}
+}
+
+// readFetchResponseV2 reads the sections of a v2 fetch response. It
+// returns the response positioned at the packfile if there is one, and
+// otherwise the acknowledged haves of a negotiation round.
+func readFetchResponseV2(url string, resp *http.Response) (*fetchResponse, []string, error) {
+	result := &fetchResponse{body: resp.Body}
+	r := bufio.NewReader(resp.Body)
+	var acks []string
+	section := ""
+	for {
+		line, err := readPktLine(r)
+		if errors.Is(err, errDelimPkt) {
+			section = ""
+			continue
+		}
+		if err != nil {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("%s: upload-pack: %w", url, err)
+		}
+		if line == nil {
+			resp.Body.Close()
+			return nil, acks, nil
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		if section == "" {
+			section = text
+			if section == "packfile" {
+				result.pack = &sideBandReader{r: r, progress: &remoteWriter{w: os.Stderr}}
+				return result, acks, nil
+			}
+			continue
+		}
+		switch section {
+		case "acknowledgments":
+			if hash, ok := strings.CutPrefix(text, "ACK "); ok {
+				acks = append(acks, hash)
+			}
+		case "shallow-info":
+			if hash, ok := strings.CutPrefix(text, "shallow "); ok {
+				result.shallow = append(result.shallow, hash)
+			} else if hash, ok := strings.CutPrefix(text, "unshallow "); ok {
+				result.unshallow = append(result.unshallow, hash)
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPruneExpire is how old an unreachable loose object must be
+// before prune deletes it, unless --expire says otherwise. Newer ones may
+// belong to a command still running, which has not yet pointed a ref at
+// them.
+const defaultPruneExpire = "2.weeks.ago"
+
+// relativeExpiry matches the "<n>.<unit>.ago" form of an expiry time.
+var relativeExpiry = regexp.MustCompile(`^(\d+)[. ](second|minute|hour|day|week|month|year)s?[. ]ago$`)
+
+// parseExpiry parses an --expire time: "now", "never", "<n>.<unit>.ago"
+// or an absolute date. The zero time stands for never.
+func parseExpiry(value string) (time.Time, error) {
+	switch value {
+	case "now", "all":
+		return time.Now(), nil
+	case "never", "false":
+		return time.Time{}, nil
+	}
+	if m := relativeExpiry.FindStringSubmatch(value); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry date %q", value)
+		}
+		unit := map[string]time.Duration{
+			"second": time.Second,
+			"minute": time.Minute,
+			"hour":   time.Hour,
+			"day":    24 * time.Hour,
+			"week":   7 * 24 * time.Hour,
+			"month":  30 * 24 * time.Hour,
+			"year":   365 * 24 * time.Hour,
+		}[m[2]]
+		return time.Now().Add(-time.Duration(n) * unit), nil
+	}
+	if t, err := parseGitDate(value); err == nil {
+		return t, nil
+	}
+	if t, err := parseLogDate(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry date %q", value)
+}
+
+// pruneTips returns what keeps objects alive: every ref, packed or
+// loose, the HEAD and index of every working tree, and both sides of
+// every reflog entry. Reflog entries whose commits are already gone are
+// skipped, as git does.
+func pruneTips() ([]string, error) {
+	var tips []string
+	_, refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range refs {
+		tips = append(tips, hash)
+	}
+
+	trees, err := listWorktrees()
+	if err != nil {
+		return nil, err
+	}
+	logs := []string{}
+	for _, t := range trees {
+		if _, hash, err := t.head(); err == nil && hash != "" {
+			tips = append(tips, hash)
+		}
+		idx, err := readIndexFile(filepath.Join(t.gitDir, "index"))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range idx.entries {
+			if e.mode != 0o160000 {
+				tips = append(tips, e.hash)
+			}
+		}
+		logs = append(logs, filepath.Join(t.gitDir, "logs", "HEAD"))
+	}
+	err = filepath.WalkDir(filepath.Join(commonDir(), "logs", "refs"), func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err == nil && !d.IsDir() {
+			logs = append(logs, path)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	zero := strings.Repeat("0", repoFormat().hexLen())
+	store := objectStore()
+	for _, path := range logs {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			for _, hash := range fields[:2] {
+				if hash != zero && store.Has(hash) {
+					tips = append(tips, hash)
+				}
+			}
+		}
+	}
+	return tips, nil
+}
+
+// reachableSet returns the set of objects reachable from tips. Unlike
+// reachableObjects it takes any kind of object: tags are kept and
+// followed, and trees and blobs are kept with what they hold.
+func (ctx *cmdContext) reachableSet(tips []string) (map[string]bool, error) {
+	keep := map[string]bool{}
+	var commits []string
+	var keepTree func(hash string) error
+	keepTree = func(hash string) error {
+		if keep[hash] {
+			return nil
+		}
+		keep[hash] = true
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch e.Mode {
+			case "40000":
+				if err := keepTree(e.Hash); err != nil {
+					return err
+				}
+			case "160000":
+			default:
+				keep[e.Hash] = true
+			}
+		}
+		return nil
+	}
+	for _, hash := range tips {
+		for depth := 0; depth < 10 && !keep[hash]; depth++ {
+			objType, body, err := readObject(hash)
+			if err != nil {
+				return nil, err
+			}
+			if objType == TreeObject {
+				if err := keepTree(hash); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if objType == CommitObject {
+				commits = append(commits, hash)
+				break
+			}
+			keep[hash] = true
+			if objType != TagObject {
+				break
+			}
+			t, err := parseTag(hash, body)
+			if err != nil {
+				return nil, err
+			}
+			hash = t.Object
+		}
+	}
+	objects, err := ctx.reachableObjects(commits, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range objects {
+		keep[hash] = true
+	}
+	return keep, nil
+}
+
+// checkPrecious refuses to delete objects from a repository whose
+// extensions.preciousObjects is set, as one sharing its objects with
+// others may be.
+func checkPrecious() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if v, _ := cfg.get("extensions", "", "preciousobjects"); strings.ToLower(v) == "true" {
+		return errors.New("cannot prune in a precious-objects repo")
+	}
+	return nil
+}
+
+// prune implements `mygit prune [-n] [-v] [--expire=<time>]`: loose
+// objects that nothing reaches and that are older than expire are
+// deleted, or with dryRun only listed as "<hash> <type>".
+func prune(ctx *cmdContext, w io.Writer, dryRun, verbose bool, expire time.Time) error {
+	if err := checkPrecious(); err != nil {
+		return err
+	}
+	tips, err := pruneTips()
+	if err != nil {
+		return err
+	}
+	keep, err := ctx.reachableSet(tips)
+	if err != nil {
+		return err
+	}
+
+	store := looseStore{objectsDir()}
+	hashes, err := store.list()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if keep[hash] {
+			continue
+		}
+		info, err := os.Lstat(store.path(hash))
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(expire) {
+			continue
+		}
+		if dryRun || verbose {
+			objType, _, err := store.Read(hash)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s %s\n", hash, objType)
+		}
+		if !dryRun {
+			if err := store.remove(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prunePacked implements `mygit prune-packed [-n]`: loose objects that a
+// pack also holds are deleted, the packed copy being the one kept. With
+// dryRun the removals are only printed, as "rm -f <file>".
+func prunePacked(w io.Writer, dryRun bool) error {
+	if err := checkPrecious(); err != nil {
+		return err
+	}
+	dir := objectsDir()
+	indexes, err := openPackIndexes(dir)
+	if err != nil {
+		return err
+	}
+	packed := map[string]bool{}
+	for _, idx := range indexes {
+		for i := 0; i+idx.hashSize <= len(idx.hashes); i += idx.hashSize {
+			packed[hex.EncodeToString(idx.hashes[i:i+idx.hashSize])] = true
+		}
+	}
+	if len(packed) == 0 {
+		return nil
+	}
+	store := looseStore{dir}
+	hashes, err := store.list()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if !packed[hash] {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(w, "rm -f %s\n", store.path(hash))
+			continue
+		}
+		if err := store.remove(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
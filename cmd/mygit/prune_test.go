@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// looseObjects returns the names of the repository's loose objects,
+// sorted.
+func looseObjects(r *goldenRepo) string {
+	r.t.Helper()
+	var names []string
+	paths, _ := filepath.Glob(filepath.Join(r.dir, ".git", "objects", "??", "*"))
+	for _, path := range paths {
+		names = append(names, filepath.Base(filepath.Dir(path))+filepath.Base(path))
+	}
+	slices.Sort(names)
+	return strings.Join(names, "\n") + "\n"
+}
+
+// TestPrune compares prune against git on objects that are reachable
+// from a branch, a tag, the index or only a reflog, kept by them, and
+// on dangling ones, old and new: --expire decides which of those go,
+// and --dry-run lists them without removing anything.
+func TestPrune(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "only in the reflog\n", 0o644)
+	r.git("commit", "-q", "-am", "dropped")
+	r.git("reset", "-q", "--hard", "HEAD~1")
+	r.write("tagged", "tagged\n", 0o644)
+	r.git("tag", "blob-tag", strings.TrimSpace(r.git("hash-object", "-w", "tagged")))
+	r.write("staged", "only in the index\n", 0o644)
+	r.git("add", "staged")
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for _, content := range []string{"old dangling\n", "new dangling\n"} {
+		r.write("dangling", content, 0o644)
+		hash := strings.TrimSpace(r.git("hash-object", "-w", "dangling"))
+		if strings.HasPrefix(content, "old") {
+			if err := os.Chtimes(filepath.Join(r.dir, ".git", "objects", hash[:2], hash[2:]), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	os.Remove(filepath.Join(r.dir, "dangling"))
+
+	state := func() string { return looseObjects(r) }
+	r.same("prune", "-n", "--expire=now")
+	r.same("prune", "--dry-run", "--expire=2.weeks.ago")
+	// Unlike git's, mygit's prune keeps objects newer than two weeks
+	// unless told otherwise, as git gc does.
+	if want, got := r.git("prune", "-n", "--expire=2.weeks.ago"), r.mygit("prune", "-n"); got != want {
+		t.Errorf("prune -n:\ngit --expire=2.weeks.ago: %q\nmygit: %q", want, got)
+	}
+	r.sameRun(r.snapshot(), state, "prune", "--expire=2.weeks.ago")
+	r.sameRun(r.snapshot(), state, "prune", "--expire=now")
+	r.git("fsck", "--strict")
+}
+
+// TestPrunePacked packs some of the repository's loose objects and
+// compares prune-packed against git: only the loose copies of packed
+// objects are removed, or with --dry-run listed.
+func TestPrunePacked(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.run(r.git("rev-list", "--objects", "HEAD"), "git", []string{"pack-objects", "-q", ".git/objects/pack/pack"})
+	r.write("README", "loose only\n", 0o644)
+	r.git("commit", "-q", "-am", "loose")
+
+	// Both list loose objects in the order their directories are read.
+	sorted := func(out string) string {
+		lines := strings.SplitAfter(out, "\n")
+		slices.Sort(lines)
+		return strings.Join(lines, "")
+	}
+	for _, flag := range []string{"-n", "--dry-run"} {
+		if want, got := sorted(r.git("prune-packed", flag)), sorted(r.mygit("prune-packed", flag)); got != want {
+			t.Errorf("prune-packed %s:\ngit:   %q\nmygit: %q", flag, want, got)
+		}
+	}
+	r.sameRun(r.snapshot(), func() string { return looseObjects(r) }, "prune-packed")
+	r.git("fsck", "--strict")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// reachableObjects returns every commit, tree and blob reachable from
+// tips, skipping anything in exclude (and what lies beneath it). History
+// stops at shallow boundaries, whose parents are not present.
+func (ctx *cmdContext) reachableObjects(tips []string, exclude map[string]bool) ([]string, error) {
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	var objects []string
+	seen := map[string]bool{}
+	for hash := range exclude {
+		seen[hash] = true
+	}
+
+	var visitTree func(hash string) error
+	visitTree = func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+		objects = append(objects, hash)
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			switch e.Mode {
+			case "40000":
+				if err := visitTree(e.Hash); err != nil {
+					return err
+				}
+			case "160000":
+				// Submodule commits live in another repository.
+			default:
+				if !seen[e.Hash] {
+					seen[e.Hash] = true
+					objects = append(objects, e.Hash)
+				}
+			}
+		}
+		return nil
+	}
+
+	queue := append([]string(nil), tips...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		objects = append(objects, hash)
+		c, err := ctx.getCommitNode(hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := visitTree(c.Tree); err != nil {
+			return nil, err
+		}
+		if !shallow[hash] {
+			queue = append(queue, c.Parents...)
+		}
+	}
+	return objects, nil
+}
+
+// push implements `mygit push <remote>|<url> <refspec>`. Only
+// fast-forward updates of existing refs and creation of new refs are
+// supported.
+func push(ctx *cmdContext, remoteArg, specArg string) error {
+	remote, url, fetchSpecs, err := lookupRemote(remoteArg)
+	if err != nil {
+		return err
+	}
+	src, dst, ok := strings.Cut(strings.TrimPrefix(specArg, "+"), ":")
+	if !ok {
+		dst = src
+	}
+	if strings.HasPrefix(specArg, "+") {
+		return errors.New("forced pushes are not supported")
+	}
+	newHash, err := resolveObjectName(src)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(dst, "refs/") {
+		dst = "refs/heads/" + dst
+	}
+
+	adv, err := discoverRefs(url, "git-receive-pack")
+	if err != nil {
+		return err
+	}
+	oldHash, exists := adv.refs[dst]
+	if !exists {
+		oldHash = zeroHash
+	}
+	short := strings.TrimPrefix(dst, "refs/heads/")
+	if oldHash == newHash {
+		fmt.Fprintln(os.Stderr, "Everything up-to-date")
+		return nil
+	}
+	if exists {
+		if !hasObject(oldHash) {
+			fmt.Fprintf(os.Stderr, "To %s\n ! [rejected]        %s -> %s (fetch first)\n", url, src, short)
+			return errors.New("failed to push some refs: the remote contains work that you do not have locally")
+		}
+		ff, err := ctx.isAncestor(oldHash, newHash)
+		if err != nil {
+			return err
+		}
+		if !ff {
+			fmt.Fprintf(os.Stderr, "To %s\n ! [rejected]        %s -> %s (non-fast-forward)\n", url, src, short)
+			return errors.New("failed to push some refs: updates were rejected because the tip of your branch is behind")
+		}
+	}
+
+	// Everything reachable from refs the remote already has is excluded.
+	var remoteTips []string
+	for _, name := range adv.names {
+		if hash := adv.refs[name]; hasObject(hash) {
+			remoteTips = append(remoteTips, hash)
+		}
+	}
+	excludeList, err := ctx.reachableObjects(remoteTips, nil)
+	if err != nil {
+		return err
+	}
+	exclude := make(map[string]bool, len(excludeList))
+	for _, hash := range excludeList {
+		exclude[hash] = true
+	}
+	objects, err := ctx.reachableObjects([]string{newHash}, exclude)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	writePktLine(&body, fmt.Sprintf("%s %s %s\x00report-status\n", oldHash, newHash, dst))
+	writeFlushPkt(&body)
+	if err := writePack(&body, objects); err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url+"/git-receive-pack", "application/x-git-receive-pack-request", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: receive-pack failed: %s", url, resp.Status)
+	}
+
+	// report-status: "unpack ok", then "ok <ref>" or "ng <ref> <reason>".
+	r := bufio.NewReader(resp.Body)
+	var failure error
+	for {
+		line, err := readPktLine(r)
+		if err != nil {
+			return fmt.Errorf("%s: reading report-status: %w", url, err)
+		}
+		if line == nil {
+			break
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		switch {
+		case text == "unpack ok":
+		case strings.HasPrefix(text, "unpack "):
+			failure = fmt.Errorf("remote unpack failed: %s", strings.TrimPrefix(text, "unpack "))
+		case strings.HasPrefix(text, "ng "):
+			ref, reason, _ := strings.Cut(strings.TrimPrefix(text, "ng "), " ")
+			fmt.Fprintf(os.Stderr, "To %s\n ! [remote rejected] %s -> %s (%s)\n", url, src, strings.TrimPrefix(ref, "refs/heads/"), reason)
+			failure = errors.New("failed to push some refs")
+		case strings.HasPrefix(text, "ok "):
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+
+	// Keep the remote-tracking ref in step with what we just pushed.
+	for _, s := range fetchSpecs {
+		spec, err := parseRefspec(s, remote)
+		if err != nil {
+			return err
+		}
+		if local, ok := spec.match(dst); ok {
+			if err := updateRef(local, newHash, "update by push"); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "To %s\n", url)
+	if exists {
+		fmt.Fprintf(os.Stderr, "   %s..%s  %s -> %s\n", oldHash[:7], newHash[:7], src, short)
+	} else {
+		fmt.Fprintf(os.Stderr, " * [new branch]      %s -> %s\n", src, short)
+	}
+	return nil
+}
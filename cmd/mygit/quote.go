@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quotePathSetting caches core.quotePath for this run; nil means unread.
+var quotePathSetting *bool
+
+// quoteHighBytes reports whether core.quotePath, true by default, asks
+// for bytes of 0x80 and above to be escaped in path names.
+func quoteHighBytes() bool {
+	if quotePathSetting == nil {
+		value := true
+		if cfg, err := readConfig(); err == nil {
+			if v, ok := cfg.get("core", "", "quotepath"); ok {
+				switch strings.ToLower(v) {
+				case "false", "no", "off", "0":
+					value = false
+				}
+			}
+		}
+		quotePathSetting = &value
+	}
+	return *quotePathSetting
+}
+
+// cEscapes are the characters quotePath writes as C escapes.
+var cEscapes = map[byte]string{
+	'\a': `\a`, '\b': `\b`, '\t': `\t`, '\n': `\n`, '\v': `\v`, '\f': `\f`, '\r': `\r`,
+	'"': `\"`, '\\': `\\`,
+}
+
+// quotePath returns name as git prints it outside -z output. A name with
+// a control character, a double quote or a backslash, or with a byte of
+// 0x80 or above while core.quotePath is on, is wrapped in double quotes
+// with those bytes written as C escapes or three octal digits. Other
+// names, spaces and all, are printed as they are.
+func quotePath(name string) string {
+	return quoteName(name, false)
+}
+
+// quoteSpacedPath is quotePath for status's short and porcelain formats,
+// which also quote a name holding a space.
+func quoteSpacedPath(name string) string {
+	return quoteName(name, true)
+}
+
+func quoteName(name string, space bool) string {
+	high := quoteHighBytes()
+	needsQuote := func(c byte) bool {
+		return c < ' ' || c == 0x7f || c == '"' || c == '\\' || (c >= 0x80 && high)
+	}
+	i := 0
+	for i < len(name) && !needsQuote(name[i]) && !(space && name[i] == ' ') {
+		i++
+	}
+	if i == len(name) {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	b.WriteString(name[:i])
+	for ; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case cEscapes[c] != "":
+			b.WriteString(cEscapes[c])
+		case needsQuote(c):
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+// unusualNamesRepo commits files whose names have spaces, quotes,
+// backslashes, control characters and non-ASCII bytes, then changes
+// some of them, stages one and adds an untracked file.
+func unusualNamesRepo(t *testing.T) *goldenRepo {
+	r := newGoldenRepo(t)
+	names := []string{"plain", "with space", "tab\there", "new\nline", `quote"d`, `back\slash`, "ctrl\x01", "café", "dir/über"}
+	for _, name := range names {
+		r.write(name, name+"\n", 0o644)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	for _, name := range names[:5] {
+		r.write(name, "changed\n", 0o644)
+	}
+	r.git("add", names[0])
+	r.write("untracked é", "new\n", 0o644)
+	return r
+}
+
+// TestNulTerminatedNames compares the -z output of ls-tree, ls-files,
+// status and diff-tree against git: names verbatim, each ended by a
+// NUL.
+func TestNulTerminatedNames(t *testing.T) {
+	r := unusualNamesRepo(t)
+	for _, args := range [][]string{
+		{"ls-tree", "-r", "-z", "HEAD"},
+		{"ls-files", "-z"},
+		{"ls-files", "-s", "-z"},
+		{"status", "-z"},
+		{"status", "--porcelain", "-z"},
+	} {
+		r.same(args...)
+	}
+	r.git("commit", "-q", "-am", "change")
+	r.same("diff-tree", "-r", "-z", "--name-status", "HEAD~1", "HEAD")
+}
+
+// TestQuotedNames compares ls-tree, ls-files, status, diff and show
+// against git with core.quotePath on and off: names with special
+// characters are quoted C-style, and non-ASCII bytes escaped in octal
+// only while it is on.
+func TestQuotedNames(t *testing.T) {
+	r := unusualNamesRepo(t)
+	for _, quotePath := range []string{"true", "false"} {
+		r.git("config", "core.quotePath", quotePath)
+		for _, args := range [][]string{
+			{"ls-tree", "-r", "HEAD"},
+			{"ls-files"},
+			{"ls-files", "-s"},
+			{"status"},
+			{"status", "--porcelain"},
+			{"diff"},
+			{"diff", "--cached"},
+		} {
+			r.same(args...)
+		}
+	}
+	r.git("commit", "-q", "-am", "change")
+	for _, quotePath := range []string{"true", "false"} {
+		r.git("config", "core.quotePath", quotePath)
+		r.same("show")
+		r.same("show", "--stat")
+		r.same("diff-tree", "-r", "--name-status", "HEAD~1", "HEAD")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readTreeOptions are the flags of `mygit read-tree`.
+type readTreeOptions struct {
+	merge  bool   // -m: merge into the index rather than replace it
+	prefix string // --prefix=<dir>/: read the tree below dir, keeping the rest
+	empty  bool   // --empty: leave the index with no entries
+}
+
+// readTreeIndex implements `mygit read-tree [-m] [--prefix=<dir>/]
+// (--empty | <tree-ish>...)`: the index is replaced by the entries of
+// the trees, later trees taking the place of earlier ones' entries at
+// the same path, every entry at stage 0 and, as in git, without stat
+// data, so that the next look at the working tree reads each file. With
+// -m and one tree, entries that did not change keep their stat data;
+// with -m and two, the index moves from the first tree to the second as
+// switching branches does, keeping changes staged on top of the first
+// and refusing to lose them. Three-way -m is not supported. With a
+// prefix the one tree is added below that directory to what the index
+// holds, none of its paths already there. Every blob the trees name
+// must be present.
+func readTreeIndex(treeishes []string, opts readTreeOptions) error {
+	switch {
+	case opts.empty && len(treeishes) > 0:
+		return errors.New("passing trees as arguments contradicts --empty")
+	case !opts.empty && len(treeishes) == 0:
+		return errors.New("usage: mygit read-tree [-m] [--prefix=<prefix>/] (--empty | <tree-ish>...)")
+	case opts.prefix != "" && len(treeishes) != 1:
+		return errors.New("--prefix reads exactly one tree")
+	case opts.merge && len(treeishes) > 2:
+		return errors.New("three-way read-tree -m is not supported")
+	}
+	var trees []map[string]TreeEntry
+	for _, name := range treeishes {
+		hash, err := resolveObjectName(name)
+		if err != nil {
+			return fmt.Errorf("Not a valid object name %s", name)
+		}
+		tree, _, err := peelToTree(hash)
+		if err != nil {
+			return fmt.Errorf("failed to unpack tree object %s", name)
+		}
+		files, err := flattenTree(tree)
+		if err != nil {
+			return err
+		}
+		for path, e := range files {
+			if e.Mode != "160000" && !hasObject(e.Hash) {
+				return fmt.Errorf("invalid object %s %s for '%s'", e.Mode, e.Hash, path)
+			}
+		}
+		trees = append(trees, files)
+	}
+
+	old, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if opts.merge && len(old.unmerged()) > 0 {
+		return errors.New("you need to resolve your current index first")
+	}
+	idx := &index{}
+	switch {
+	case opts.prefix != "":
+		dir := strings.TrimSuffix(opts.prefix, "/") + "/"
+		idx.entries = append(idx.entries, old.entries...)
+		for _, path := range sortedPaths(trees[0]) {
+			if old.entry(dir+path) != nil {
+				return unpackError(fmt.Sprintf("Entry '%s' overlaps with '%s'.  Cannot bind.", dir+path, dir+path))
+			}
+			idx.add(treeIndexEntry(dir+path, trees[0][path]))
+		}
+	case opts.merge && len(trees) == 2:
+		if idx.entries, err = twoTreeRead(old, trees[0], trees[1]); err != nil {
+			return err
+		}
+	default:
+		files := map[string]TreeEntry{}
+		for _, tree := range trees {
+			for path, e := range tree {
+				files[path] = e
+			}
+		}
+		for _, path := range sortedPaths(files) {
+			te := files[path]
+			if prev := old.entry(path); opts.merge && prev != nil && sameEntry(prev, te) {
+				idx.entries = append(idx.entries, prev)
+				continue
+			}
+			idx.entries = append(idx.entries, treeIndexEntry(path, te))
+		}
+	}
+	return idx.write()
+}
+
+// twoTreeRead is read-tree -m from tree from to tree to. A path the
+// index holds as from has it is given to's version, unless the working
+// tree file has changes left unstaged; one staged differently is kept
+// if to has it as from does or as staged, and is otherwise an error, as
+// is a path both trees agree on the index has lost but to changes.
+func twoTreeRead(old *index, from, to map[string]TreeEntry) ([]*indexEntry, error) {
+	paths := map[string]bool{}
+	for path := range from {
+		paths[path] = true
+	}
+	for path := range to {
+		paths[path] = true
+	}
+	for _, e := range old.entries {
+		paths[e.path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var entries []*indexEntry
+	for _, path := range sorted {
+		cur := old.entry(path)
+		h, inFrom := from[path]
+		m, inTo := to[path]
+		sameTrees := inFrom == inTo && (!inFrom || h == m)
+		switch {
+		case cur == nil && !inFrom && inTo:
+			entries = append(entries, treeIndexEntry(path, m))
+		case cur == nil && (sameTrees || !inTo):
+			// Removed from the index, or in neither.
+		case cur == nil:
+			return nil, overwrittenByMerge(path)
+		case sameTrees, inTo && sameEntry(cur, m):
+			entries = append(entries, cur)
+		case inFrom && sameEntry(cur, h):
+			if ok, err := old.worktreeUpToDate(cur); err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, fmt.Errorf("Entry '%s' not uptodate. Cannot merge.", path)
+			}
+			if inTo {
+				entries = append(entries, treeIndexEntry(path, m))
+			}
+		default:
+			return nil, overwrittenByMerge(path)
+		}
+	}
+	return entries, nil
+}
+
+// worktreeUpToDate reports whether the working tree file of e, if there
+// is one, holds what e stages.
+func (idx *index) worktreeUpToDate(e *indexEntry) (bool, error) {
+	info, err := os.Lstat(filepath.FromSlash(e.path))
+	if errors.Is(err, os.ErrNotExist) || e.mode == 0o160000 {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if idx.upToDate(e, info) {
+		return true, nil
+	}
+	content, mode, err := readWorktreeBlob(e.path)
+	if err != nil {
+		return false, err
+	}
+	return mode == e.mode && hashObject(BlobObject, content) == e.hash, nil
+}
+
+// unpackError is read-tree's refusal to put a tree over index entries
+// in its way, which git reports as an error rather than a fatal one.
+type unpackError string
+
+func (e unpackError) Error() string { return string(e) }
+
+// overwrittenByMerge is read-tree's refusal to drop what the index has
+// staged at path.
+func overwrittenByMerge(path string) error {
+	return unpackError(fmt.Sprintf("Entry '%s' would be overwritten by merge. Cannot merge.", path))
+}
+
+// sameEntry reports whether the index entry e stages what te holds.
+func sameEntry(e *indexEntry, te TreeEntry) bool {
+	return e.hash == te.Hash && e.mode == parseMode(te.Mode)
+}
+
+// treeIndexEntry is the index entry read-tree makes of a tree's entry,
+// with no stat data.
+func treeIndexEntry(path string, te TreeEntry) *indexEntry {
+	return &indexEntry{path: path, hash: te.Hash, mode: parseMode(te.Mode)}
+}
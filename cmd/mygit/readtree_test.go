@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReadTree compares read-tree with git's, by the index it leaves
+// and what status then makes of the working tree: one tree, several
+// overlaid, --empty, --prefix and -m with one and two trees, the latter
+// keeping a staged change and refusing to lose one, and a prefix whose
+// paths are taken is refused as in git. Unlike git, mygit also refuses a
+// tree naming a missing blob.
+func TestReadTree(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "second\n", 0o644)
+	r.write("extra", "extra\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "second")
+	r.write("run.sh", "#!/bin/sh\necho staged\n", 0o755)
+	r.git("add", "run.sh")
+
+	state := func() string { return r.git("ls-files", "-s") + r.git("status", "--porcelain") }
+	for _, args := range [][]string{
+		{"read-tree", "HEAD~1"},
+		{"read-tree", "HEAD~1", "HEAD"},
+		{"read-tree", "--empty"},
+		{"read-tree", "--prefix=copy/", "HEAD~1"},
+		{"read-tree", "--prefix=copy/", "HEAD:src"},
+		{"read-tree", "-m", "HEAD"},
+		{"read-tree", "-m", "HEAD", "HEAD~1"},
+		{"read-tree", "HEAD^{tree}"},
+	} {
+		r.sameRun(r.snapshot(), state, args...)
+		r.git("reset", "-q", "--mixed", "HEAD")
+		r.git("add", "run.sh")
+	}
+	r.git("read-tree", "HEAD")
+	r.git("add", "run.sh")
+	r.write("README", "staged\n", 0o644)
+	r.git("add", "README")
+	r.sameFailure("read-tree", "-m", "HEAD", "HEAD~1")
+	r.sameFailure("read-tree", "--prefix=src/", "HEAD:src")
+
+	blob := strings.TrimSpace(r.git("rev-parse", "HEAD:extra"))
+	object := filepath.Join(r.dir, ".git", "objects", blob[:2], blob[2:])
+	if err := os.Remove(object); err != nil {
+		t.Fatal(err)
+	}
+	// git reads the tree all the same.
+	if got := r.mygitFails("read-tree", "HEAD"); !strings.Contains(got, "invalid object 100644 "+blob+" for 'extra'") {
+		t.Errorf("read-tree of a tree with a missing blob printed %q", got)
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// rebaseDir holds the state of a rebase in progress, in the files git's
+// merge backend uses: head-name, the branch being rebased ("detached
+// HEAD" if none); onto and orig-head, the commits it is rebased onto and
+// started from; and git-rebase-todo and done, the picks still to make
+// and those made, one "pick <hash> <subject>" line each.
+const rebaseDir = "rebase-merge"
+
+// rebaseHeadFile names the commit a rebase stopped at for conflicts.
+const rebaseHeadFile = "REBASE_HEAD"
+
+// rebaseState is a rebase in progress.
+type rebaseState struct {
+	headName string
+	onto     string
+	origHead string
+	todo     []string // commits still to pick, oldest first
+	done     []string
+}
+
+var errNoRebase = errors.New("fatal: No rebase in progress?")
+
+// readRebaseState returns the rebase in progress, or nil when there is
+// none.
+func readRebaseState() (*rebaseState, error) {
+	if _, err := os.Stat(gitPath(rebaseDir)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(gitPath(rebaseDir + "/" + name))
+		return strings.TrimSpace(string(data)), err
+	}
+	picks := func(name string) ([]string, error) {
+		data, err := read(name)
+		var hashes []string
+		for _, line := range strings.Split(data, "\n") {
+			if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "pick" {
+				hashes = append(hashes, fields[1])
+			}
+		}
+		return hashes, err
+	}
+	st := &rebaseState{}
+	var err error
+	if st.headName, err = read("head-name"); err != nil {
+		return nil, err
+	}
+	if st.onto, err = read("onto"); err != nil {
+		return nil, err
+	}
+	if st.origHead, err = read("orig-head"); err != nil {
+		return nil, err
+	}
+	if st.todo, err = picks("git-rebase-todo"); err != nil {
+		return nil, err
+	}
+	if st.done, err = picks("done"); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// write records st in the rebase directory.
+func (st *rebaseState) write(ctx *cmdContext) error {
+	if err := os.MkdirAll(gitPath(rebaseDir), 0o755); err != nil {
+		return err
+	}
+	picks := func(hashes []string) (string, error) {
+		var b strings.Builder
+		for _, hash := range hashes {
+			c, err := ctx.getCommit(hash)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "pick %s %s\n", hash, c.Subject())
+		}
+		return b.String(), nil
+	}
+	todo, err := picks(st.todo)
+	if err != nil {
+		return err
+	}
+	done, err := picks(st.done)
+	if err != nil {
+		return err
+	}
+	total := len(st.done) + len(st.todo)
+	for name, value := range map[string]string{
+		"head-name":       st.headName + "\n",
+		"onto":            st.onto + "\n",
+		"orig-head":       st.origHead + "\n",
+		"git-rebase-todo": todo,
+		"done":            done,
+		"msgnum":          fmt.Sprintf("%d\n", len(st.done)),
+		"end":             fmt.Sprintf("%d\n", total),
+	} {
+		if err := os.WriteFile(gitPath(rebaseDir+"/"+name), []byte(value), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearRebaseState forgets the rebase in progress.
+func clearRebaseState() error {
+	if err := os.Remove(gitPath(rebaseHeadFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(gitPath(rebaseDir))
+}
+
+// rebaseCheckClean refuses to rebase over local changes to tracked
+// files, as git does; untracked files are left to the checkout. With
+// onlyUnstaged, staged changes are allowed, as they are when going on
+// after a conflict.
+func rebaseCheckClean(onlyUnstaged bool) error {
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	unstaged, staged := false, false
+	for _, e := range s.entries {
+		if e.staged == '?' {
+			continue
+		}
+		unstaged = unstaged || e.unstaged != ' '
+		staged = staged || e.staged != ' '
+	}
+	switch {
+	case unstaged && staged && !onlyUnstaged:
+		return errors.New("error: cannot rebase: You have unstaged changes.\n" +
+			"error: additionally, your index contains uncommitted changes.\n" +
+			"error: Please commit or stash them.")
+	case unstaged:
+		return errors.New("error: cannot rebase: You have unstaged changes.\n" +
+			"error: Please commit or stash them.")
+	case staged && !onlyUnstaged:
+		return errors.New("error: cannot rebase: Your index contains uncommitted changes.\n" +
+			"error: Please commit or stash them.")
+	}
+	return nil
+}
+
+// rebase implements `mygit rebase <upstream>`: the commits on HEAD that
+// upstream does not have are picked, oldest first, onto upstream, with
+// HEAD detached while they are, and the branch is then moved to the
+// result. Merge commits are left out, so the history comes out linear,
+// and a commit whose changes upstream already has is dropped. A pick
+// that conflicts stops the rebase for `mygit rebase --continue`,
+// `--skip` or `--abort`.
+func rebase(ctx *cmdContext, w io.Writer, upstream string) error {
+	if st, err := readRebaseState(); err != nil {
+		return err
+	} else if st != nil {
+		return fmt.Errorf("fatal: It seems that there is already a %s directory, and\n"+
+			"I wonder if you are in the middle of another rebase.  If that is the\n"+
+			"case, please try\n"+
+			"\tgit rebase (--continue | --abort | --skip)\n"+
+			"If that is not the case, please\n"+
+			"\trm -fr \"%s\"\n"+
+			"and run me again.  I am stopping in case you still have something\n"+
+			"valuable there.\n", rebaseDir, gitPath(rebaseDir))
+	}
+	onto, err := resolveObjectName(upstream)
+	if err == nil {
+		onto, err = peelToCommit(onto)
+	}
+	if err != nil {
+		return fmt.Errorf("fatal: invalid upstream '%s'", upstream)
+	}
+	head, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if err := rebaseCheckClean(false); err != nil {
+		return err
+	}
+	ref, err := headRef()
+	if err != nil {
+		return err
+	}
+	headName := ref
+	if ref == "" {
+		headName = "detached HEAD"
+	}
+
+	// The commits to pick are those HEAD has and upstream does not.
+	inUpstream := map[string]bool{}
+	err = ctx.walkTopology([]string{onto}, func(c *Commit) error {
+		inUpstream[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var picks []string
+	err = ctx.walkTopology([]string{head}, func(c *Commit) error {
+		if !inUpstream[c.Hash] && len(c.Parents) <= 1 {
+			picks = append([]string{c.Hash}, picks...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ok, err := ctx.isAncestor(onto, head); err != nil {
+		return err
+	} else if ok {
+		// Every pick would land where it already is.
+		name := strings.TrimPrefix(ref, "refs/heads/")
+		if ref == "" {
+			name = "HEAD"
+		}
+		fmt.Fprintf(w, "Current branch %s is up to date.\n", name)
+		return nil
+	}
+
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(onto)
+	if err != nil {
+		return err
+	}
+	if changed, untracked := s.checkoutConflicts(files); len(changed)+len(untracked) > 0 {
+		return overwriteError("checkout", changed, untracked)
+	}
+	if err := s.checkoutFiles(files, false); err != nil {
+		return err
+	}
+	if err := saveOrigHead(head); err != nil {
+		return err
+	}
+	if err := updateRef("HEAD", onto, "rebase (start): checkout "+upstream); err != nil {
+		return err
+	}
+	st := &rebaseState{headName: headName, onto: onto, origHead: head, todo: picks}
+	if err := st.write(ctx); err != nil {
+		return err
+	}
+	return st.run(ctx, w)
+}
+
+// run makes the picks left in st, stopping at the first that conflicts,
+// and finishes the rebase once there are none.
+func (st *rebaseState) run(ctx *cmdContext, w io.Writer) error {
+	for len(st.todo) > 0 {
+		hash := st.todo[0]
+		st.todo, st.done = st.todo[1:], append(st.done, hash)
+		fmt.Fprintf(os.Stderr, "Rebasing (%d/%d)\r", len(st.done), len(st.done)+len(st.todo))
+		if err := st.write(ctx); err != nil {
+			return err
+		}
+		if err := rebasePick(ctx, w, hash); err != nil {
+			return err
+		}
+	}
+	return st.finish()
+}
+
+// rebasePick applies the commit hash onto HEAD by a three-way merge
+// against its parent and commits the result with hash's author and
+// message. A commit that changes nothing there is dropped. On conflict
+// the merge is left in the index and working tree and REBASE_HEAD names
+// the commit.
+func rebasePick(ctx *cmdContext, w io.Writer, hash string) error {
+	c, err := ctx.getCommit(hash)
+	if err != nil {
+		return err
+	}
+	head, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	headCommit, err := ctx.getCommit(head)
+	if err != nil {
+		return err
+	}
+	parent := ""
+	if len(c.Parents) > 0 {
+		parent = c.Parents[0]
+	}
+	base, err := commitFiles(parent)
+	if err != nil {
+		return err
+	}
+	ours, err := flattenTree(headCommit.Tree)
+	if err != nil {
+		return err
+	}
+	theirs, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	// As in git, a clean merge is not reported.
+	var report bytes.Buffer
+	label := fmt.Sprintf("%s (%s)", hash[:7], c.Subject())
+	tree, conflicted, err := applyMerge(&report, nil, base, ours, theirs, "HEAD", label)
+	if err != nil {
+		return err
+	}
+	if conflicted {
+		w.Write(report.Bytes())
+		if err := os.WriteFile(gitPath(rebaseHeadFile), []byte(hash+"\n"), 0o644); err != nil {
+			return err
+		}
+		return fmt.Errorf("error: could not apply %s... %s\n"+
+			"hint: Resolve all conflicts manually, mark them as resolved with\n"+
+			"hint: \"git add/rm <conflicted_files>\", then run \"git rebase --continue\".\n"+
+			"hint: You can instead skip this commit: run \"git rebase --skip\".\n"+
+			"hint: To abort and get back to the state before \"git rebase\", run \"git rebase --abort\".\n"+
+			"Could not apply %s... %s", hash[:7], c.Subject(), hash[:7], c.Subject())
+	}
+	if tree == headCommit.Tree {
+		return nil
+	}
+	_, err = rebaseCommit(c, head, tree, "rebase (pick)")
+	return err
+}
+
+// rebaseCommit commits tree on parent with the author and message of
+// the picked commit c, moving the detached HEAD to it.
+func rebaseCommit(c *Commit, parent, tree, action string) (string, error) {
+	committer, err := currentSignature("COMMITTER")
+	if err != nil {
+		return "", err
+	}
+	hash, err := writeCommitObject(&Commit{
+		Tree:      tree,
+		Parents:   []string{parent},
+		Author:    c.Author,
+		Committer: committer,
+		Message:   c.Message,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return hash, updateRef("HEAD", hash, action+": "+c.Subject())
+}
+
+// finish moves the rebased branch to the detached HEAD and checks it out
+// again, ending the rebase.
+func (st *rebaseState) finish() error {
+	head, err := resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if st.headName != "detached HEAD" {
+		if err := updateRef(st.headName, head, fmt.Sprintf("rebase (finish): %s onto %s", st.headName, st.onto)); err != nil {
+			return err
+		}
+		if err := setHeadRef(st.headName); err != nil {
+			return err
+		}
+		if err := appendReflog("HEAD", head, head, "rebase (finish): returning to "+st.headName); err != nil {
+			return err
+		}
+	}
+	if err := clearRebaseState(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[KSuccessfully rebased and updated %s.\n", st.headName)
+	return nil
+}
+
+// rebaseContinue implements `mygit rebase --continue`: once the
+// conflicts of the stopped pick are resolved and staged, the index is
+// committed with the picked commit's author and message, unless that
+// would change nothing, and the remaining picks are made.
+func rebaseContinue(ctx *cmdContext, w io.Writer) error {
+	st, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return errNoRebase
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if unmerged := idx.unmerged(); len(unmerged) > 0 {
+		var b strings.Builder
+		for _, path := range unmerged {
+			fmt.Fprintf(&b, "%s: needs merge\n", path)
+		}
+		b.WriteString("You must edit all merge conflicts and then\nmark them as resolved using git add")
+		return errors.New(b.String())
+	}
+	if err := rebaseCheckClean(true); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(gitPath(rebaseHeadFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if stopped := strings.TrimSpace(string(data)); stopped != "" {
+		c, err := ctx.getCommit(stopped)
+		if err != nil {
+			return err
+		}
+		head, err := resolveRef("HEAD")
+		if err != nil {
+			return err
+		}
+		headCommit, err := ctx.getCommit(head)
+		if err != nil {
+			return err
+		}
+		tree, err := writeIndexTree(idx.entries)
+		if err != nil {
+			return err
+		}
+		if tree != headCommit.Tree {
+			hash, err := rebaseCommit(c, head, tree, "rebase (continue)")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "[detached HEAD %s] %s\n", hash[:7], c.Subject())
+		}
+		if err := os.Remove(gitPath(rebaseHeadFile)); err != nil {
+			return err
+		}
+	}
+	return st.run(ctx, w)
+}
+
+// rebaseSkip implements `mygit rebase --skip`: the pick the rebase
+// stopped at is dropped, the working tree and index going back to HEAD
+// as with `git reset --hard`, and the remaining picks are made.
+func rebaseSkip(ctx *cmdContext, w io.Writer) error {
+	st, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return errNoRebase
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	if err := s.checkoutFiles(s.files, true); err != nil {
+		return err
+	}
+	if err := os.Remove(gitPath(rebaseHeadFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return st.run(ctx, w)
+}
+
+// rebaseAbort implements `mygit rebase --abort`: the working tree and
+// index go back to the commit the rebase started from, discarding any
+// conflicts, and HEAD to the branch it was on.
+func rebaseAbort() error {
+	st, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return errNoRebase
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(st.origHead)
+	if err != nil {
+		return err
+	}
+	if err := s.checkoutFiles(files, true); err != nil {
+		return err
+	}
+	message := "rebase (abort): returning to " + st.headName
+	if st.headName == "detached HEAD" {
+		if err := updateRef("HEAD", st.origHead, message); err != nil {
+			return err
+		}
+	} else {
+		if err := setHeadRef(st.headName); err != nil {
+			return err
+		}
+		if err := appendReflog("HEAD", s.head, st.origHead, message); err != nil {
+			return err
+		}
+	}
+	return clearRebaseState()
+}
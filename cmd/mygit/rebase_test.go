@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRebaseSkip stops a rebase at a conflicting pick, skips it and
+// checks that the rest is picked as git would: the skipped commit's
+// change is gone from the working tree and index, REBASE_HEAD with it,
+// and the branch ends on the other picks.
+func TestRebaseSkip(t *testing.T) {
+	r := pickRepo(t)
+	r.write("d", "d\n", 0o644)
+	r.git("add", "d")
+	r.git("commit", "-q", "-m", "add d")
+	r.git("checkout", "-q", "topic")
+	r.write("e", "e\n", 0o644)
+	r.git("add", "e")
+	r.git("commit", "-q", "-m", "add e")
+	tip := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+	state := func() string {
+		return r.git("log", "--format=%s %an", "main~2..") + r.git("status", "--porcelain") +
+			r.git("ls-files", "--stage") + r.git("symbolic-ref", "HEAD")
+	}
+
+	r.stderrOf("git", "rebase", "main")
+	r.git("rebase", "--skip")
+	want := state()
+	r.git("reset", "-q", "--hard", tip)
+
+	if _, ok := r.stderrOf("", "rebase", "main"); ok {
+		t.Fatal("rebase over a conflicting pick succeeded")
+	}
+	r.write("b", "half resolved\n", 0o644)
+	r.mygit("rebase", "--skip")
+	if got := state(); got != want {
+		t.Errorf("after rebase --skip:\ngit:   %q\nmygit: %q", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, ".git", "REBASE_HEAD")); !os.IsNotExist(err) {
+		t.Errorf("REBASE_HEAD left after --skip: %v", err)
+	}
+	if got := r.mygitFails("rebase", "--skip"); got != "fatal: No rebase in progress?\n" {
+		t.Errorf("rebase --skip with none in progress printed %q", got)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func reflogPath(ref string) string {
+	return gitPath("logs/" + ref)
+}
+
+// shouldLogRef applies core.logAllRefUpdates, which defaults to true in
+// repositories with a working tree. A ref that already has a reflog is
+// always logged.
+func shouldLogRef(ref string) bool {
+	if _, err := os.Stat(reflogPath(ref)); err == nil {
+		return true
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return false
+	}
+	value, ok := cfg.get("core", "", "logallrefupdates")
+	if !ok {
+		bare, _ := cfg.get("core", "", "bare")
+		value = strconv.FormatBool(bare != "true")
+	}
+	switch strings.ToLower(value) {
+	case "always":
+		return true
+	case "true", "yes", "on", "1":
+		return ref == "HEAD" || strings.HasPrefix(ref, "refs/heads/") ||
+			strings.HasPrefix(ref, "refs/remotes/") || strings.HasPrefix(ref, "refs/notes/")
+	}
+	return false
+}
+
+// appendReflog records that ref moved from old ("" if it did not exist)
+// to new, creating the log on first use.
+func appendReflog(ref, old, new, message string) error {
+	if !shouldLogRef(ref) {
+		return nil
+	}
+	who, err := currentSignature("COMMITTER")
+	if err != nil {
+		// The ref has moved either way; git records a placeholder too.
+		who = Signature{Name: "unknown", Email: "unknown", When: time.Now()}
+	}
+	if old == "" {
+		old = strings.Repeat("0", repoFormat().hexLen())
+	}
+	path := reflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	// Like git, squeeze the message onto one line.
+	message = strings.Join(strings.Fields(message), " ")
+	_, err = fmt.Fprintf(f, "%s %s %s\t%s\n", old, new, who, message)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reflogEntry is one line of a reflog.
+type reflogEntry struct {
+	old, new string
+	who      Signature
+	message  string
+}
+
+// readReflog returns the entries of ref's reflog, oldest first.
+func readReflog(ref string) ([]reflogEntry, error) {
+	f, err := os.Open(reflogPath(ref))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, message, _ := strings.Cut(scanner.Text(), "\t")
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		who, err := parseSignature(fields[2])
+		if err != nil {
+			continue
+		}
+		entries = append(entries, reflogEntry{old: fields[0], new: fields[1], who: who, message: message})
+	}
+	return entries, scanner.Err()
+}
+
+// resolveReflogSelector resolves "<name>@{<selector>}", spec being the
+// whole name, from the reflog of the ref name abbreviates, or with no
+// name, of the branch HEAD is on. A number n is the value the ref had
+// n moves ago, the oldest entry's old value being the last there is.
+// A date is the value it had then; one from before the log began is
+// warned about and taken to be its oldest value.
+func resolveReflogSelector(name, selector, spec string) (string, error) {
+	ref := "HEAD"
+	if name == "" {
+		head, err := headRef()
+		if err != nil {
+			return "", err
+		}
+		if head != "" {
+			ref = head
+		}
+	} else {
+		full, ok := expandRef(name)
+		if !ok {
+			return "", unknownRevision(spec)
+		}
+		ref = full
+	}
+	if name == "" {
+		name = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	entries, err := readReflog(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", unknownRevision(spec)
+	} else if err != nil {
+		return "", err
+	}
+	zero := strings.Repeat("0", repoFormat().hexLen())
+
+	// As in git, numbers too big to count entries are Unix times.
+	if n, err := strconv.Atoi(selector); err == nil && n >= 0 && n < 100000000 {
+		switch {
+		case n < len(entries):
+			return entries[len(entries)-1-n].new, nil
+		case n == len(entries) && n > 0 && entries[0].old != zero:
+			return entries[0].old, nil
+		}
+		return "", fmt.Errorf("log for '%s' only has %d entries", name, len(entries))
+	}
+	when, err := parseLogDate(selector)
+	if err != nil {
+		return "", unknownRevision(spec)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].who.When.After(when) {
+			return entries[i].new, nil
+		}
+	}
+	if len(entries) == 0 {
+		return "", unknownRevision(spec)
+	}
+	first := entries[0]
+	fmt.Fprintf(os.Stderr, "warning: log for '%s' only goes back to %s\n", name, first.who.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	if first.old != zero {
+		return first.old, nil
+	}
+	return first.new, nil
+}
+
+// reflog implements `mygit reflog [show] [<ref>]`, printing the reflog
+// newest first as "<hash> <ref>@{<n>}: <message>".
+func reflog(w io.Writer, name string) error {
+	if name == "" {
+		name = "HEAD"
+	}
+	ref := findReflog(name)
+	if ref == "" {
+		if _, err := resolveRef(name); err != nil {
+			return ambiguousArgument(name)
+		}
+		return nil // the ref exists but has no log
+	}
+	entries, err := readReflog(ref)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(w, "%s %s@{%d}: %s\n", e.new[:7], name, len(entries)-1-i, e.message)
+	}
+	return nil
+}
+
+// findReflog returns the ref whose reflog name abbreviates, or "" if
+// none of the refs it could mean has one.
+func findReflog(name string) string {
+	for _, candidate := range []string{name, "refs/" + name, "refs/heads/" + name, "refs/remotes/" + name} {
+		if _, err := os.Stat(reflogPath(candidate)); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Reflog entries are kept this long, and those no longer reachable from
+// their ref this long, unless gc.reflogExpire and
+// gc.reflogExpireUnreachable or the flags of reflog expire say otherwise.
+const (
+	defaultReflogExpire            = "90.days.ago"
+	defaultReflogExpireUnreachable = "30.days.ago"
+)
+
+// reflogExpireOptions are the flags of `mygit reflog expire`. An empty
+// time means the configured one.
+type reflogExpireOptions struct {
+	expire            string // --expire=<time>: drop entries older than this
+	expireUnreachable string // --expire-unreachable=<time>: and unreachable ones older than this
+	all               bool   // --all: every reflog rather than the named ones
+	dryRun            bool   // -n: only say what would be dropped
+	verbose           bool   // print "keep" or "prune" and each entry's message
+}
+
+// reflogExpire implements `mygit reflog expire [--expire=<time>]
+// [--expire-unreachable=<time>] [-n] [--verbose] (--all | <ref>...)`:
+// entries older than the expire time are dropped from the reflogs, as
+// are those older than the expire-unreachable time whose old or new
+// value the ref no longer reaches, HEAD's counting as reached if any
+// ref reaches it. What is left of each log is not otherwise changed.
+// Refs without a reflog are reported and the rest still expired; it
+// reports whether there were none.
+func reflogExpire(ctx *cmdContext, w io.Writer, names []string, opts reflogExpireOptions) (bool, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return false, err
+	}
+	expiry := func(flag, name, key, fallback string) (time.Time, error) {
+		if flag != "" {
+			t, err := parseExpiry(flag)
+			if err != nil {
+				return t, fmt.Errorf("invalid timestamp '%s' given to '%s'", flag, name)
+			}
+			return t, nil
+		}
+		if v, ok := cfg.get("gc", "", key); ok {
+			return parseExpiry(v)
+		}
+		return parseExpiry(fallback)
+	}
+	expire, err := expiry(opts.expire, "--expire", "reflogexpire", defaultReflogExpire)
+	if err != nil {
+		return false, err
+	}
+	expireUnreachable, err := expiry(opts.expireUnreachable, "--expire-unreachable", "reflogexpireunreachable", defaultReflogExpireUnreachable)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	var refs []string
+	if opts.all {
+		err := filepath.WalkDir(filepath.Join(commonDir(), "logs", "refs"), func(path string, d os.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err == nil && !d.IsDir() {
+				rel, err := filepath.Rel(filepath.Join(commonDir(), "logs"), path)
+				if err != nil {
+					return err
+				}
+				refs = append(refs, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		if _, err := os.Stat(reflogPath("HEAD")); err == nil {
+			refs = append(refs, "HEAD")
+		}
+	}
+	for _, name := range names {
+		ref := findReflog(name)
+		if ref == "" {
+			fmt.Fprintf(os.Stderr, "error: %s points nowhere!\n", name)
+			ok = false
+			continue
+		}
+		refs = append(refs, ref)
+	}
+
+	zero := strings.Repeat("0", repoFormat().hexLen())
+	for _, ref := range refs {
+		entries, err := readReflog(ref)
+		if err != nil {
+			return false, err
+		}
+		// What the ref reaches is only looked for if an entry needs it.
+		var reached map[string]bool
+		reachable := func(hash string) (bool, error) {
+			if hash == zero {
+				return true, nil
+			}
+			if reached == nil {
+				reached = map[string]bool{}
+				var tips []string
+				if ref == "HEAD" {
+					_, all, err := listRefs("refs/")
+					if err != nil {
+						return false, err
+					}
+					for _, hash := range all {
+						tips = append(tips, hash)
+					}
+				} else if tip, err := resolveRef(ref); err == nil {
+					tips = append(tips, tip)
+				}
+				var commits []string
+				for _, tip := range tips {
+					if commit, err := peelToCommit(tip); err == nil {
+						commits = append(commits, commit)
+					}
+				}
+				err := ctx.walkHistory(commits, func(c *Commit) error {
+					reached[c.Hash] = true
+					return nil
+				})
+				if err != nil {
+					return false, err
+				}
+			}
+			return reached[hash], nil
+		}
+
+		var kept []reflogEntry
+		for _, e := range entries {
+			prune := expire.After(e.who.When)
+			if !prune && expireUnreachable.After(e.who.When) {
+				for _, hash := range []string{e.old, e.new} {
+					if r, err := reachable(hash); err != nil {
+						return false, err
+					} else if !r {
+						prune = true
+					}
+				}
+			}
+			if opts.verbose {
+				verb := "keep"
+				if prune {
+					verb = "prune"
+				}
+				fmt.Fprintf(w, "%s %s\n", verb, e.message)
+			}
+			if !prune {
+				kept = append(kept, e)
+			}
+		}
+		if !opts.dryRun && len(kept) < len(entries) {
+			if err := writeReflog(ref, kept); err != nil {
+				return false, err
+			}
+		}
+	}
+	return ok, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReflogSelectors compares rev-parse with git's for names read from
+// reflogs: <ref>@{<n>} for HEAD, a branch and the current branch, dates
+// before, between and after the moves, and @{upstream} and @{u} with and
+// without a branch, and checks that a count past the log's end and a
+// branch with no upstream fail as in git.
+func TestReflogSelectors(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	for i, message := range []string{"one", "two", "three"} {
+		date := fmt.Sprintf("GIT_COMMITTER_DATE=%d +0000", 1700000000+i*3600)
+		r.write("README", message+"\n", 0o644)
+		r.run("", "git", []string{"commit", "-q", "-am", message}, date)
+	}
+	r.run("", "git", []string{"checkout", "-q", "-b", "topic", "HEAD~1"}, "GIT_COMMITTER_DATE=1700020000 +0000")
+	r.run("", "git", []string{"checkout", "-q", "main"}, "GIT_COMMITTER_DATE=1700030000 +0000")
+	r.git("update-ref", "refs/remotes/origin/main", "HEAD~2")
+	r.git("config", "remote.origin.url", "https://example.com/repo.git")
+	r.git("config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	r.git("config", "branch.main.remote", "origin")
+	r.git("config", "branch.main.merge", "refs/heads/main")
+
+	r.same("rev-parse",
+		"HEAD@{0}", "HEAD@{1}", "HEAD@{2}", "HEAD@{4}", "@{1}", "main@{1}", "main@{2}", "topic@{0}",
+		"main@{2023-11-14 23:00:00 +0000}", "main@{1700003000}", "main@{yesterday}",
+		"@{upstream}", "@{u}", "main@{u}", "HEAD@{u}", "main@{1}~1",
+	)
+	want, _ := r.stderrOf("git", "rev-parse", "main@{1699990000}")
+	if got, _ := r.stderrOf("", "rev-parse", "main@{1699990000}"); got != want {
+		t.Errorf("rev-parse of a date before the log:\ngit:   %q\nmygit: %q", want, got)
+	}
+	r.same("rev-parse", "main@{1699990000}")
+	r.sameFailure("rev-parse", "main@{5}")
+	r.sameFailure("rev-parse", "topic@{u}")
+}
+
+// TestReflogExpire compares reflog expire with git's: the configured
+// defaults, entries dropped by age or, with --expire-unreachable, because
+// their ref no longer reaches them, a single ref or --all, --dry-run and
+// --verbose and a ref with no reflog, then checks that prune removes a
+// commit once the only reflog entry keeping it is gone.
+func TestReflogExpire(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	for i, message := range []string{"one", "two", "three"} {
+		date := fmt.Sprintf("GIT_COMMITTER_DATE=%d +0000", 1700000000+i*3600)
+		r.write("README", message+"\n", 0o644)
+		r.run("", "git", []string{"commit", "-q", "-am", message}, date)
+	}
+	r.run("", "git", []string{"reset", "-q", "--hard", "HEAD~1"}, "GIT_COMMITTER_DATE=1700010000 +0000")
+	r.run("", "git", []string{"checkout", "-q", "-b", "topic", "HEAD~1"}, "GIT_COMMITTER_DATE=1700020000 +0000")
+	r.run("", "git", []string{"checkout", "-q", "main"}, "GIT_COMMITTER_DATE=1700030000 +0000")
+
+	state := func() string {
+		var b strings.Builder
+		for _, name := range []string{"HEAD", "refs/heads/main", "refs/heads/topic"} {
+			data, _ := os.ReadFile(filepath.Join(r.dir, ".git", "logs", filepath.FromSlash(name)))
+			b.WriteString(name + ":\n" + string(data))
+		}
+		return b.String()
+	}
+	for _, args := range [][]string{
+		{"reflog", "expire", "--all"},
+		{"reflog", "expire", "--expire=never", "--expire-unreachable=now", "--all"},
+		{"reflog", "expire", "--expire=2023-11-14 23:30:00 +0000", "main"},
+		{"reflog", "expire", "--dry-run", "--expire=now", "--all"},
+		{"reflog", "expire", "--expire=now", "main", "nosuch"},
+	} {
+		undo := r.snapshot()
+		r.sameRun(undo, state, args...)
+		undo()
+	}
+	r.same("reflog", "expire", "--dry-run", "--verbose", "--expire=never", "--expire-unreachable=now", "HEAD")
+
+	r.git("config", "gc.reflogExpire", "never")
+	r.git("config", "gc.reflogExpireUnreachable", "now")
+	undo := r.snapshot()
+	r.sameRun(undo, state, "reflog", "expire", "--all")
+	r.same("prune", "-n", "--expire=now")
+	r.sameRun(r.snapshot(), func() string { return looseObjects(r) }, "prune", "--expire=now")
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readRef returns the object name a ref points at, following symbolic
+// refs. A ref with no loose file is looked up in packed-refs, where git
+// keeps refs after gc; one in neither is an os.ErrNotExist.
+func readRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		data, err := os.ReadFile(gitPath(name))
+		if errors.Is(err, os.ErrNotExist) && strings.HasPrefix(name, "refs/") && !perWorktreePath(name) {
+			packed, perr := readPackedRefs()
+			if perr != nil {
+				return "", perr
+			}
+			if hash, ok := packed[name]; ok {
+				return hash, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(value, "ref: ") {
+			return value, nil
+		}
+		name = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("symbolic ref %s nests too deeply", name)
+}
+
+// updateRef points the ref name at hash, creating it if needed, and
+// records the move in the ref's reflog with message.
+func updateRef(name, hash, message string) error {
+	old, err := readRef(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := writeLooseRef(name, hash); err != nil {
+		return err
+	}
+	return appendReflog(name, old, hash, message)
+}
+
+// writeLooseRef stores hash in the loose ref file for name, without
+// touching its reflog. As in git, the new value goes to "<ref>.lock",
+// which is renamed over the ref.
+func writeLooseRef(name, hash string) error {
+	path := gitPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeLocked(path, []byte(hash+"\n"), "reference")
+}
+
+// origHeadFile records where HEAD was before the last merge, rebase or
+// reset moved it, so that `reset --hard ORIG_HEAD` undoes the move.
+// resolveRef finds it as it finds HEAD.
+const origHeadFile = "ORIG_HEAD"
+
+// saveOrigHead records hash in ORIG_HEAD. As in git, it has no reflog.
+func saveOrigHead(hash string) error {
+	return writeLooseRef(origHeadFile, hash)
+}
+
+// headRef returns the ref HEAD points at, such as "refs/heads/main", or
+// "" when HEAD is detached.
+func headRef() (string, error) {
+	data, err := os.ReadFile(gitPath("HEAD"))
+	if err != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	if !ok {
+		return "", nil
+	}
+	return target, nil
+}
+
+// unbornBranch returns the branch HEAD points at if it has no commits
+// yet, as in a new repository, or "" if HEAD resolves or is detached.
+func unbornBranch() (string, error) {
+	ref, err := headRef()
+	if err != nil || ref == "" {
+		return "", err
+	}
+	if _, err := readRef(ref); errors.Is(err, os.ErrNotExist) {
+		return ref, nil
+	} else if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// setHeadRef makes HEAD a symbolic ref to the branch ref.
+func setHeadRef(ref string) error {
+	return os.WriteFile(gitPath("HEAD"), []byte("ref: "+ref+"\n"), 0o644)
+}
+
+// updateHead moves the current branch to hash, or HEAD itself when it
+// is detached. Both the branch and HEAD reflogs record the move.
+func updateHead(hash, message string) error {
+	ref, err := headRef()
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		return updateRef("HEAD", hash, message)
+	}
+	old, err := readRef(ref)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := updateRef(ref, hash, message); err != nil {
+		return err
+	}
+	return appendReflog("HEAD", old, hash, message)
+}
+
+// listRefs returns every ref under prefix (e.g. "refs/heads/"), loose or
+// packed, mapped to the object name it points at, sorted by ref name. A
+// loose ref hides a packed one of the same name.
+func listRefs(prefix string) ([]string, map[string]string, error) {
+	root := gitPath(prefix)
+	refs := make(map[string]string)
+	packed, err := readPackedRefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, hash := range packed {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = hash
+		}
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil // a ref being written is not a ref yet
+		}
+		rel, err := filepath.Rel(commonDir(), path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		hash, err := readRef(name)
+		if err != nil {
+			return err
+		}
+		refs[name] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, refs, nil
+}
+
+// resolveRef turns a full or abbreviated ref name, or a full object
+// name, into the object name it refers to.
+func resolveRef(name string) (string, error) {
+	if len(name) == repoFormat().hexLen() && isHex(name) {
+		return name, nil
+	}
+	if ref, ok := expandRef(name); ok {
+		return readRef(ref)
+	}
+	return "", unknownRevision(name)
+}
+
+// resolveObjectName turns an object name in git's extended syntax into
+// the object it names. After a name resolveRef knows may come any number
+// of "~<n>", the nth ancestor by first parents, "^<n>", the nth parent,
+// "^0" being the commit itself, and "^{<type>}", which peels tags, and
+// commits for a tree, until an object of that type is reached; "^{}"
+// peels tags only. A bare "~" or "^" counts one. "<rev>:<path>" is the
+// object at path in the tree of rev. The name may also be "@", for
+// HEAD, or end in one of the "@{...}" selectors of resolveAtSelector.
+func resolveObjectName(spec string) (string, error) {
+	if _, _, ok := cutTreePath(spec); ok {
+		return resolveTreePath(spec, false)
+	}
+	i := strings.IndexAny(spec, "^~")
+	if i < 0 {
+		return resolveAtSelector(spec)
+	}
+	hash, err := resolveAtSelector(spec[:i])
+	if err != nil {
+		return "", err
+	}
+	for rest := spec[i:]; rest != ""; {
+		op := rest[0]
+		rest = rest[1:]
+		if op != '^' && op != '~' {
+			return "", unknownRevision(spec)
+		}
+		if op == '^' && strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", unknownRevision(spec)
+			}
+			objType := rest[1:end]
+			rest = rest[end+1:]
+			if hash, err = peelObject(hash, objType, spec[:len(spec)-len(rest)]); err != nil {
+				return "", err
+			}
+			continue
+		}
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		n := 1
+		if digits > 0 {
+			if n, err = strconv.Atoi(rest[:digits]); err != nil {
+				return "", unknownRevision(spec)
+			}
+		}
+		rest = rest[digits:]
+		if hash, err = peelToCommit(hash); err != nil {
+			return "", err
+		}
+		for ; n > 0; n-- {
+			c, err := readCommit(hash)
+			if err != nil {
+				return "", err
+			}
+			parent := 0
+			if op == '^' {
+				parent, n = n-1, 1
+			}
+			if parent >= len(c.Parents) {
+				return "", unknownRevision(spec)
+			}
+			hash = c.Parents[parent]
+		}
+	}
+	return hash, nil
+}
+
+// resolveAtSelector is resolveRef for names that may end in "@{...}":
+// "<ref>@{<n>}", where ref was n moves ago, and "<ref>@{<date>}", where
+// it was at that date, both read from its reflog, and
+// "<branch>@{upstream}" or "<branch>@{u}", the branch's upstream. With
+// no ref before the "@" the reflog is that of the branch HEAD is on,
+// and the upstream that branch's. A lone "@" is HEAD.
+func resolveAtSelector(name string) (string, error) {
+	if name == "@" {
+		return resolveRef("HEAD")
+	}
+	at := strings.Index(name, "@{")
+	if at < 0 || !strings.HasSuffix(name, "}") {
+		return resolveRef(name)
+	}
+	ref, selector := name[:at], name[at+2:len(name)-1]
+	switch strings.ToLower(selector) {
+	case "u", "upstream":
+		return resolveUpstream(ref, name)
+	}
+	return resolveReflogSelector(ref, selector, name)
+}
+
+// cutTreePath splits "<rev>:<path>" at the colon that ends rev, which
+// is not one in a reflog date such as "main@{12:00}:README".
+func cutTreePath(name string) (rev, path string, ok bool) {
+	colon := strings.IndexByte(name, ':')
+	if at := strings.Index(name, "@{"); at >= 0 && at < colon {
+		if end := strings.IndexByte(name[at:], '}'); end >= 0 {
+			next := strings.IndexByte(name[at+end:], ':')
+			if next < 0 {
+				return name, "", false
+			}
+			colon = at + end + next
+		}
+	}
+	if colon < 0 {
+		return name, "", false
+	}
+	return name[:colon], name[colon+1:], true
+}
+
+// unknownRevision says that the name it holds refers to nothing, as
+// when asking for the parent of a root commit. It is an os.ErrNotExist,
+// which resolveRevs reports as git does.
+type unknownRevision string
+
+func (name unknownRevision) Error() string {
+	return fmt.Sprintf("unknown revision %q", string(name))
+}
+
+func (unknownRevision) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// peelObject implements "^{<objType>}", with name the spec up to it:
+// tags, and commits for their trees, are followed until hash names an
+// object of that type. An empty objType follows tags to whatever they
+// end at, and "object" leaves hash as it is.
+func peelObject(hash, objType, name string) (string, error) {
+	switch objType {
+	case "":
+		return peelTags(hash), nil
+	case "object":
+		if !hasObject(hash) {
+			return "", errObjectNotFound
+		}
+		return hash, nil
+	}
+	want, err := ParseObjectType(objType)
+	if err != nil {
+		return "", unknownRevision(name)
+	}
+	for depth := 0; depth < 10; depth++ {
+		got, body, err := readObject(hash)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case got == want:
+			return hash, nil
+		case got == TagObject:
+			t, err := parseTag(hash, body)
+			if err != nil {
+				return "", err
+			}
+			hash = t.Object
+		case got == CommitObject:
+			c, err := parseCommit(hash, body)
+			if err != nil {
+				return "", err
+			}
+			hash = c.Tree
+		default:
+			return "", fmt.Errorf("%s: expected %s type, but the object dereferences to %s type", name, want, got)
+		}
+	}
+	return "", fmt.Errorf("%s: tag chain too deep", hash)
+}
+
+// expandRef returns the full name of the ref an abbreviated name such as
+// "main" or "origin/main" refers to, trying git's candidates in order.
+func expandRef(name string) (string, bool) {
+	candidates := []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	}
+	for _, ref := range candidates {
+		if hasRef(ref) {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// isHex reports whether s consists only of lowercase hex digits.
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validRefName checks name against git's rules for ref names, as
+// check-ref-format does, and returns an error naming the first rule it
+// breaks.
+func validRefName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("ref name is empty")
+	case name == "@":
+		return errors.New("ref name cannot be '@'")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return errors.New("ref name cannot begin or end with '/'")
+	case strings.HasSuffix(name, "."):
+		return errors.New("ref name cannot end with '.'")
+	case strings.Contains(name, ".."):
+		return errors.New("ref name cannot contain '..'")
+	case strings.Contains(name, "@{"):
+		return errors.New("ref name cannot contain '@{'")
+	}
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "":
+			return errors.New("ref name cannot contain '//'")
+		case strings.HasPrefix(part, "."):
+			return errors.New("ref name components cannot begin with '.'")
+		case strings.HasSuffix(part, ".lock"):
+			return errors.New("ref name components cannot end with '.lock'")
+		}
+	}
+	for _, c := range name {
+		switch {
+		case c < ' ' || c == 0x7f:
+			return errors.New("ref name cannot contain control characters")
+		case c == ' ':
+			return errors.New("ref name cannot contain spaces")
+		case strings.ContainsRune("~^:?*[\\", c):
+			return fmt.Errorf("ref name cannot contain '%c'", c)
+		}
+	}
+	return nil
+}
+
+// validShortRefName is validRefName for the ref prefix+name, name being
+// the part of it given by the user, such as a branch's name under
+// "refs/heads/". How a name may begin and end is checked against name
+// itself, so that "/x" is reported as beginning with '/' rather than as
+// "refs/heads//x" containing '//'.
+func validShortRefName(prefix, name string) error {
+	switch {
+	case name == "":
+		return errors.New("ref name is empty")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return errors.New("ref name cannot begin or end with '/'")
+	}
+	return validRefName(prefix + name)
+}
+
+// updateRefCommand implements `mygit update-ref [-m <reason>] <ref>
+// <new> [<old>]`. When old is given the ref must currently hold it; an
+// all-zero old means the ref must not exist yet. HEAD is dereferenced,
+// so updating it moves the current branch.
+func updateRefCommand(ref, newRev, oldRev, message string) error {
+	if ref != "HEAD" {
+		if err := validRefName(ref); err != nil {
+			return fmt.Errorf("refusing to update ref with bad name '%s': %s", ref, err)
+		}
+	}
+	hash, err := resolveObjectName(newRev)
+	if err != nil {
+		return err
+	}
+	if !hasObject(hash) {
+		return fmt.Errorf("%s: not a valid SHA1", newRev)
+	}
+	if oldRev != "" {
+		current, err := readRef(ref)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if strings.Trim(oldRev, "0") == "" {
+			if current != "" {
+				return fmt.Errorf("cannot lock ref '%s': reference already exists", ref)
+			}
+		} else if current != oldRev {
+			return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", ref, current, oldRev)
+		}
+	}
+	if ref == "HEAD" {
+		return updateHead(hash, message)
+	}
+	return updateRef(ref, hash, message)
+}
+
+func packedRefsPath() string {
+	return gitPath("packed-refs")
+}
+
+// readPackedRefs parses .git/packed-refs into ref names and the object
+// names they point at. Peeled "^" lines are skipped. A missing file
+// holds no refs.
+func readPackedRefs() (map[string]string, error) {
+	return readPackedRefsFile(packedRefsPath())
+}
+
+// readPackedRefsFile is readPackedRefs for the packed-refs file at path,
+// which may belong to another repository.
+func readPackedRefsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("unexpected line in packed-refs: %q", line)
+		}
+		refs[name] = hash
+	}
+	return refs, nil
+}
+
+// removePackedRef drops name, and its peeled line, from packed-refs. As
+// in git, packed-refs.lock is held from before the file is read until
+// the new one is renamed into place.
+func removePackedRef(name string) error {
+	if _, err := os.Stat(packedRefsPath()); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	l, err := lock(packedRefsPath())
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(packedRefsPath())
+	if err != nil {
+		l.rollback()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var kept []string
+	dropping, found := false, false
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if strings.HasPrefix(line, "^") && dropping {
+			continue
+		}
+		_, ref, _ := strings.Cut(line, " ")
+		dropping = !strings.HasPrefix(line, "#") && ref == name
+		if !dropping {
+			kept = append(kept, line)
+		}
+		found = found || dropping
+	}
+	if !found {
+		l.rollback()
+		return nil
+	}
+	return l.commit([]byte(strings.Join(kept, "\n")+"\n"), "reference")
+}
+
+// deleteRef removes a ref, from packed-refs as well as its loose file,
+// and any directories that become empty.
+func deleteRef(name string) error {
+	path := gitPath(name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := removePackedRef(name); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != gitPath("refs"); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
+
+// packedRefsHeader starts the packed-refs mygit writes. Its traits tell
+// git that the file is sorted and that every ref to a tag has its
+// peeled line.
+const packedRefsHeader = "# pack-refs with: peeled fully-peeled sorted \n"
+
+// packRefs implements `mygit pack-refs [--all] [--no-prune]`: loose tags,
+// and with all every loose ref, are written to packed-refs and, unless
+// noPrune, their files deleted. As in git, symbolic refs and refs of a
+// single working tree stay loose, and a ref to an annotated tag is
+// followed by a "^" line naming the object the tag peels to.
+func packRefs(all, noPrune bool) error {
+	l, err := lock(packedRefsPath())
+	if err != nil {
+		return err
+	}
+	refs, err := readPackedRefs()
+	if err != nil {
+		l.rollback()
+		return err
+	}
+	var pruned []string
+	err = filepath.WalkDir(gitPath("refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return err
+		}
+		rel, err := filepath.Rel(commonDir(), path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if perWorktreePath(name) || (!all && !strings.HasPrefix(name, "refs/tags/")) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hash := strings.TrimSpace(string(data))
+		if len(hash) != repoFormat().hexLen() || !isHex(hash) {
+			return nil // symbolic or broken
+		}
+		refs[name] = hash
+		pruned = append(pruned, name)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		l.rollback()
+		return err
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(packedRefsHeader)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s %s\n", refs[name], name)
+		if peeled := peelTags(refs[name]); peeled != refs[name] {
+			fmt.Fprintf(&b, "^%s\n", peeled)
+		}
+	}
+	if err := l.commit([]byte(b.String()), "reference"); err != nil {
+		return err
+	}
+
+	if noPrune {
+		return nil
+	}
+	for _, name := range pruned {
+		if err := os.Remove(gitPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// Emptied directories go too, but not refs/heads and its like.
+		for dir := path.Dir(name); strings.Count(dir, "/") > 1; dir = path.Dir(dir) {
+			if os.Remove(gitPath(dir)) != nil {
+				break // not empty
+			}
+		}
+	}
+	return nil
+}
+
+// peelTags follows annotated tags from hash to the object they end at,
+// which is hash itself when it is not a tag or cannot be read.
+func peelTags(hash string) string {
+	for depth := 0; depth < 10; depth++ {
+		objType, body, err := readObject(hash)
+		if err != nil || objType != TagObject {
+			return hash
+		}
+		t, err := parseTag(hash, body)
+		if err != nil {
+			return hash
+		}
+		hash = t.Object
+	}
+	return hash
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRemovePackedRefLock renames a packed branch, which drops it from
+// packed-refs: with packed-refs.lock held the rename fails and
+// packed-refs is left alone, and otherwise the branch moves, no lock is
+// left and git reads the rewritten file.
+func TestRemovePackedRefLock(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("branch", "topic")
+	r.git("tag", "-a", "v1", "-m", "release")
+	r.git("pack-refs", "--all")
+	packed := filepath.Join(r.dir, ".git", "packed-refs")
+	before, err := os.ReadFile(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := packed + ".lock"
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr := r.mygitFails("branch", "-m", "topic", "renamed")
+	if want := "Unable to create '" + lock + "': File exists."; !strings.Contains(stderr, want) {
+		t.Errorf("branch -m with packed-refs.lock held: stderr %q, want %q", stderr, want)
+	}
+	if after, err := os.ReadFile(packed); err != nil || string(after) != string(before) {
+		t.Errorf("branch -m with packed-refs.lock held changed packed-refs")
+	}
+	if err := os.Remove(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	r.mygit("branch", "-m", "topic", "renamed")
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("packed-refs.lock left behind: %v", err)
+	}
+	if got := r.git("for-each-ref", "--format=%(refname)"); got != "refs/heads/main\nrefs/heads/renamed\nrefs/tags/v1\n" {
+		t.Errorf("git for-each-ref = %q", got)
+	}
+	if data, err := os.ReadFile(packed); err != nil || strings.Contains(string(data), "topic") {
+		t.Errorf("packed-refs still names topic: %q, %v", data, err)
+	}
+	r.git("fsck", "--strict", "--no-dangling")
+}
+
+func TestValidShortRefName(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"topic", ""},
+		{"feature/x", ""},
+		{"", "ref name is empty"},
+		{"/x", "ref name cannot begin or end with '/'"},
+		{"x/", "ref name cannot begin or end with '/'"},
+		{"x//y", "ref name cannot contain '//'"},
+		{".x", "ref name components cannot begin with '.'"},
+		{"x.", "ref name cannot end with '.'"},
+		{"x.lock", "ref name components cannot end with '.lock'"},
+		{"a..b", "ref name cannot contain '..'"},
+		{"a b", "ref name cannot contain spaces"},
+		{"a~1", "ref name cannot contain '~'"},
+		{"@", ""},
+	} {
+		err := validShortRefName("refs/heads/", tc.name)
+		if got := fmt.Sprint(err); tc.want == "" && err != nil || tc.want != "" && got != tc.want {
+			t.Errorf("%q: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestInvalidTagName checks that tag refuses a bad name with the rule it
+// breaks, and creates no ref for it.
+func TestInvalidTagName(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	for _, tc := range []struct{ name, want string }{
+		{"a..b", "'a..b' is not a valid tag name: ref name cannot contain '..'"},
+		{"v1.lock", "'v1.lock' is not a valid tag name: ref name components cannot end with '.lock'"},
+		{"a b", "'a b' is not a valid tag name: ref name cannot contain spaces"},
+	} {
+		if got := r.mygitFails("tag", "-m", "x", tc.name); !strings.Contains(got, tc.want) {
+			t.Errorf("tag %q: stderr %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if got := r.git("tag"); got != "" {
+		t.Errorf("git tag = %q, want no tags", got)
+	}
+}
+
+// TestPackRefs compares the packed-refs file and the loose refs left by
+// pack-refs, with and without --all and --no-prune, with git's, and
+// checks that branch, rev-parse, log, describe and checkout read refs
+// that live only in packed-refs as git does.
+func TestPackRefs(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("tag", "light")
+	r.git("tag", "-a", "-m", "annotated", "v1")
+	r.git("branch", "topic")
+	r.git("symbolic-ref", "refs/heads/alias", "refs/heads/main")
+	r.write("README", "second\n", 0o644)
+	r.git("commit", "-q", "-am", "second")
+
+	state := func() string {
+		data, _ := os.ReadFile(filepath.Join(r.dir, ".git", "packed-refs"))
+		var loose []string
+		filepath.Walk(filepath.Join(r.dir, ".git", "refs"), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(r.dir, path)
+				loose = append(loose, rel)
+			}
+			return err
+		})
+		return string(data) + strings.Join(loose, "\n")
+	}
+	for _, args := range [][]string{{"pack-refs"}, {"pack-refs", "--all", "--no-prune"}, {"pack-refs", "--all"}} {
+		undo := r.snapshot()
+		r.same(args...)
+		want := state()
+		undo()
+		r.mygit(args...)
+		if got := state(); got != want {
+			t.Errorf("%s:\ngit:\n%s\nmygit:\n%s", strings.Join(args, " "), want, got)
+		}
+		undo()
+	}
+
+	r.git("pack-refs", "--all")
+	for _, args := range [][]string{
+		{"branch"},
+		{"rev-parse", "topic", "v1", "light", "v1^{commit}", "refs/heads/main"},
+		{"log"},
+		{"describe", "HEAD~1"},
+	} {
+		r.same(args...)
+	}
+	r.mygit("checkout", "topic")
+	if got := r.git("symbolic-ref", "HEAD"); got != "refs/heads/topic\n" {
+		t.Errorf("checkout of a packed branch left HEAD at %q", got)
+	}
+}
@@ -0,0 +1,876 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// writePktLine writes line in pkt-line framing.
+func writePktLine(w io.Writer, line string) error {
+	_, err := fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+	return err
+}
+
+// writeFlushPkt writes a flush-pkt.
+func writeFlushPkt(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+// errDelimPkt is returned by readPktLine for the delim-pkt that separates
+// sections in protocol v2.
+var errDelimPkt = errors.New("unexpected delim-pkt")
+
+// writeDelimPkt writes a protocol v2 delim-pkt.
+func writeDelimPkt(w io.Writer) error {
+	_, err := io.WriteString(w, "0001")
+	return err
+}
+
+// readPktLine reads one pkt-line payload. A flush-pkt is returned as nil.
+func readPktLine(r io.Reader) ([]byte, error) {
+	var lenHex [4]byte
+	if _, err := io.ReadFull(r, lenHex[:]); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(lenHex[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line length %q", lenHex)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n == 1 {
+		return nil, errDelimPkt
+	}
+	if n < 4 {
+		return nil, fmt.Errorf("invalid pkt-line length %d", n)
+	}
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// remoteRefs holds the refs and capabilities advertised by a server.
+// With protocol v2 the capabilities are the server's v2 ones, plus a
+// symref entry for HEAD as v0 would give.
+type remoteRefs struct {
+	names   []string
+	refs    map[string]string
+	caps    map[string]string
+	version int
+}
+
+// format returns the object format the server names its objects in, as
+// its object-format capability gives it; servers that do not send one
+// use SHA-1.
+func (adv *remoteRefs) format() (objectFormat, error) {
+	name, ok := adv.caps["object-format"]
+	if !ok {
+		return sha1Format, nil
+	}
+	return parseObjectFormat(name)
+}
+
+// discoverRefs performs smart HTTP ref discovery for service. Protocol
+// v2 is requested for upload-pack; servers that do not speak it answer
+// with a v0 advertisement, which is parsed instead.
+func discoverRefs(url, service string) (*remoteRefs, error) {
+	req, err := http.NewRequest("GET", url+"/info/refs?service="+service, nil)
+	if err != nil {
+		return nil, err
+	}
+	if service == "git-upload-pack" {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: ref discovery failed: %s", url, resp.Status)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	line, err := readPktLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: ref discovery: %w", url, err)
+	}
+	// A v2 server may skip the service announcement.
+	if string(line) == "# service="+service+"\n" {
+		if _, err := readPktLine(r); err != nil {
+			return nil, fmt.Errorf("%s: ref discovery: %w", url, err)
+		}
+		if line, err = readPktLine(r); err != nil {
+			return nil, fmt.Errorf("%s: ref discovery: %w", url, err)
+		}
+	} else if string(line) != "version 2\n" {
+		return nil, fmt.Errorf("%s: not a smart HTTP server", url)
+	}
+	if string(line) == "version 2\n" {
+		return discoverRefsV2(url, r)
+	}
+
+	adv := &remoteRefs{refs: map[string]string{}, caps: map[string]string{}}
+	for ; line != nil; line, err = readPktLine(r) {
+		text := strings.TrimSuffix(string(line), "\n")
+		if ref, caps, ok := strings.Cut(text, "\x00"); ok {
+			text = ref
+			for _, c := range strings.Fields(caps) {
+				key, value, _ := strings.Cut(c, "=")
+				adv.caps[key] = value
+			}
+		}
+		hash, name, ok := strings.Cut(text, " ")
+		format, ferr := adv.format()
+		if ferr != nil {
+			return nil, fmt.Errorf("%s: %w", url, ferr)
+		}
+		if !ok || len(hash) != format.hexLen() {
+			return nil, fmt.Errorf("%s: malformed ref advertisement %q", url, text)
+		}
+		if name == "capabilities^{}" {
+			continue // empty repository
+		}
+		adv.names = append(adv.names, name)
+		adv.refs[name] = hash
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: ref discovery: %w", url, err)
+	}
+	return adv, nil
+}
+
+// fetchRequest describes what to ask upload-pack for. haves are the
+// local commits to negotiate with, newest first.
+type fetchRequest struct {
+	wants  []string
+	haves  []*Commit
+	depth  int
+	quiet  bool
+	filter string
+}
+
+// fetchResponse is what upload-pack sent back. The caller reads the
+// packfile from pack and must Close the response.
+type fetchResponse struct {
+	pack      io.Reader
+	shallow   []string
+	unshallow []string
+	body      io.Closer
+}
+
+func (r *fetchResponse) Close() error { return r.body.Close() }
+
+// fetchPack asks the server for req.wants, advertising req.haves as
+// already present, and returns the packfile stream it sends back.
+func fetchPack(url string, adv *remoteRefs, req fetchRequest) (*fetchResponse, error) {
+	if adv.version == 2 {
+		return fetchPackV2(url, adv, req)
+	}
+	var caps []string
+	for _, c := range []string{"side-band-64k", "ofs-delta"} {
+		if _, ok := adv.caps[c]; ok {
+			caps = append(caps, c)
+		}
+	}
+	sideband := len(caps) > 0 && caps[0] == "side-band-64k"
+	if _, ok := adv.caps["no-progress"]; ok && req.quiet {
+		caps = append(caps, "no-progress")
+	}
+
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	if req.depth > 0 || len(shallow) > 0 {
+		if _, ok := adv.caps["shallow"]; !ok {
+			return nil, fmt.Errorf("%s: server does not support shallow clients", url)
+		}
+		caps = append(caps, "shallow")
+	}
+	if req.filter != "" {
+		if _, ok := adv.caps["filter"]; ok {
+			caps = append(caps, "filter")
+		} else {
+			fmt.Fprintln(os.Stderr, "warning: filtering not recognized by server, ignoring")
+			req.filter = ""
+		}
+	}
+	_, multiAck := adv.caps["multi_ack_detailed"]
+	if multiAck && len(req.haves) > 0 {
+		caps = append(caps, "multi_ack_detailed")
+	}
+
+	// Every request repeats the wants, since HTTP keeps no state between
+	// negotiation rounds.
+	var wantLines bytes.Buffer
+	for i, want := range req.wants {
+		line := "want " + want
+		if i == 0 && len(caps) > 0 {
+			line += " " + strings.Join(caps, " ")
+		}
+		writePktLine(&wantLines, line+"\n")
+	}
+	for _, hash := range sortedKeys(shallow) {
+		writePktLine(&wantLines, "shallow "+hash+"\n")
+	}
+	if req.depth > 0 {
+		writePktLine(&wantLines, fmt.Sprintf("deepen %d\n", req.depth))
+	}
+	if req.filter != "" {
+		writePktLine(&wantLines, "filter "+req.filter+"\n")
+	}
+	writeFlushPkt(&wantLines)
+
+	var common []string
+	if multiAck {
+		if common, err = negotiate(url, wantLines.Bytes(), req.haves); err != nil {
+			return nil, err
+		}
+	} else {
+		// Without multi_ack_detailed there is no way to learn what the
+		// server has, so offer the newest commits and hope.
+		for _, c := range req.haves[:min(len(req.haves), initialHaveRound)] {
+			common = append(common, c.Hash)
+		}
+	}
+
+	body := bytes.NewBuffer(wantLines.Bytes())
+	for _, have := range common {
+		writePktLine(body, "have "+have+"\n")
+	}
+	writePktLine(body, "done\n")
+	resp, err := postUploadPack(url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &fetchResponse{body: resp.Body}
+	r := bufio.NewReader(resp.Body)
+	for {
+		peek, err := r.Peek(4)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: upload-pack: %w", url, err)
+		}
+		if string(peek) == "PACK" {
+			result.pack = r
+			return result, nil
+		}
+		line, err := readPktLine(r)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: upload-pack: %w", url, err)
+		}
+		text := string(line)
+		switch {
+		case line == nil, strings.HasPrefix(text, "ACK "), text == "NAK\n":
+			continue
+		case strings.HasPrefix(text, "shallow "):
+			result.shallow = append(result.shallow, strings.TrimSpace(text[len("shallow "):]))
+			continue
+		case strings.HasPrefix(text, "unshallow "):
+			result.unshallow = append(result.unshallow, strings.TrimSpace(text[len("unshallow "):]))
+			continue
+		}
+		if !sideband {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: unexpected response %q", url, text)
+		}
+		// First side-band packet; put it back and demultiplex from here.
+		result.pack = &sideBandReader{
+			r:        io.MultiReader(bytes.NewReader(pktLine(line)), r),
+			progress: &remoteWriter{w: os.Stderr},
+		}
+		return result, nil
+	}
+}
+
+// postUploadPack sends one upload-pack request.
+func postUploadPack(url string, body io.Reader) (*http.Response, error) {
+	resp, err := http.Post(url+"/git-upload-pack", "application/x-git-upload-pack-request", body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: upload-pack failed: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// Haves are sent in rounds sized as git sizes them over stateless
+// HTTP: 16 at first, doubling up to 16384 and growing by a tenth after.
+const (
+	initialHaveRound = 16
+	largeHaveRound   = 16384
+	// maxHavesInVain ends negotiation when this many haves in a row have
+	// gone unacknowledged after something in common was found.
+	maxHavesInVain = 256
+)
+
+func nextHaveRound(n int) int {
+	if n < largeHaveRound {
+		return n * 2
+	}
+	return n * 11 / 10
+}
+
+// haveOffer chooses the haves to offer while negotiating: local commits
+// newest first, skipping ancestors of commits the server already
+// acknowledged as common.
+type haveOffer struct {
+	haves  []*Commit
+	byHash map[string]*Commit
+	known  map[string]bool // common, or an ancestor of something common
+	common []string
+	next   int
+	round  int
+	inVain int
+	done   bool
+}
+
+func newHaveOffer(haves []*Commit) *haveOffer {
+	o := &haveOffer{haves: haves, byHash: map[string]*Commit{}, known: map[string]bool{}, round: initialHaveRound}
+	for _, c := range haves {
+		o.byHash[c.Hash] = c
+	}
+	return o
+}
+
+// nextRound returns the haves for the next round, or nil once there are
+// none left or too many have gone unacknowledged.
+func (o *haveOffer) nextRound() []string {
+	var batch []string
+	for ; !o.done && o.next < len(o.haves) && len(batch) < o.round; o.next++ {
+		if hash := o.haves[o.next].Hash; !o.known[hash] {
+			batch = append(batch, hash)
+		}
+	}
+	o.round = nextHaveRound(o.round)
+	return batch
+}
+
+// ack records the server's acknowledgments of a round of sent haves.
+func (o *haveOffer) ack(acks []string, sent int) {
+	found := false
+	for _, hash := range acks {
+		if !o.known[hash] {
+			o.common = append(o.common, hash)
+			o.markCommon(hash)
+			found = true
+		}
+	}
+	switch {
+	case found:
+		o.inVain = 0
+	case len(o.common) > 0:
+		o.inVain += sent
+		o.done = o.inVain >= maxHavesInVain
+	}
+}
+
+// writeHaves writes have lines for the common commits found so far,
+// which a stateless server has forgotten, followed by batch.
+func (o *haveOffer) writeHaves(w io.Writer, batch []string) {
+	for _, hash := range o.common {
+		writePktLine(w, "have "+hash+"\n")
+	}
+	for _, hash := range batch {
+		writePktLine(w, "have "+hash+"\n")
+	}
+}
+
+func (o *haveOffer) markCommon(hash string) {
+	stack := []string{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o.known[h] {
+			continue
+		}
+		o.known[h] = true
+		if c, ok := o.byHash[h]; ok {
+			stack = append(stack, c.Parents...)
+		}
+	}
+}
+
+// negotiate offers haves in rounds until the server is ready to send a
+// pack, and returns the commits it acknowledged as common. Each round
+// is a separate request that repeats wantLines and the common commits
+// found so far.
+func negotiate(url string, wantLines []byte, haves []*Commit) ([]string, error) {
+	o := newHaveOffer(haves)
+	for {
+		batch := o.nextRound()
+		if batch == nil {
+			return o.common, nil
+		}
+		body := bytes.NewBuffer(append([]byte(nil), wantLines...))
+		o.writeHaves(body, batch)
+		writeFlushPkt(body)
+
+		acks, ready, err := negotiationRound(url, body)
+		if err != nil {
+			return nil, err
+		}
+		o.ack(acks, len(batch))
+		if ready {
+			return o.common, nil
+		}
+	}
+}
+
+// negotiationRound sends one round of haves and returns the commits the
+// server acknowledged and whether it said it is ready to send a pack.
+func negotiationRound(url string, body io.Reader) ([]string, bool, error) {
+	resp, err := postUploadPack(url, body)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	var acks []string
+	ready := false
+	for {
+		line, err := readPktLine(r)
+		if err == io.EOF {
+			return acks, ready, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: upload-pack: %w", url, err)
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		switch fields := strings.Fields(text); {
+		case line == nil, strings.HasPrefix(text, "shallow "), strings.HasPrefix(text, "unshallow "):
+		case text == "NAK":
+			return acks, ready, nil
+		case len(fields) >= 2 && fields[0] == "ACK":
+			acks = append(acks, fields[1])
+			if len(fields) == 2 || fields[2] == "ready" {
+				ready = true
+			}
+		case strings.HasPrefix(text, "ERR "):
+			return nil, false, fmt.Errorf("%s: remote error: %s", url, text[len("ERR "):])
+		default:
+			return nil, false, fmt.Errorf("%s: unexpected response %q", url, text)
+		}
+	}
+}
+
+// pktLine re-encodes payload as a pkt-line.
+func pktLine(payload []byte) []byte {
+	return append([]byte(fmt.Sprintf("%04x", len(payload)+4)), payload...)
+}
+
+// sideBandReader demultiplexes a side-band-64k stream, yielding the
+// packfile sent on band 1 and relaying band 2 progress to progress.
+type sideBandReader struct {
+	r        io.Reader
+	progress io.Writer
+	pending  []byte
+	eof      bool
+}
+
+func (s *sideBandReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		line, err := readPktLine(s.r)
+		if err == io.EOF || (err == nil && line == nil) {
+			s.eof = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case 1:
+			s.pending = line[1:]
+		case 2:
+			s.progress.Write(line[1:])
+		case 3:
+			return 0, fmt.Errorf("remote error: %s", strings.TrimSpace(string(line[1:])))
+		default:
+			return 0, fmt.Errorf("unknown side-band %d", line[0])
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// remoteWriter prefixes every line of server progress output with "remote: ".
+type remoteWriter struct {
+	w   io.Writer
+	mid bool
+}
+
+func (rw *remoteWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		if !rw.mid {
+			io.WriteString(rw.w, "remote: ")
+			rw.mid = true
+		}
+		n := bytes.IndexAny(p, "\r\n") + 1
+		if n == 0 {
+			n = len(p)
+		} else {
+			rw.mid = false
+		}
+		if _, err := rw.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// refspec maps remote refs onto local refs, e.g. +refs/heads/*:refs/remotes/origin/*.
+type refspec struct {
+	force bool
+	src   string
+	dst   string
+}
+
+// parseRefspec parses a fetch refspec. Bare branch names are expanded
+// to refs/heads/<name>:refs/remotes/<remote>/<name>.
+func parseRefspec(s, remote string) (refspec, error) {
+	var spec refspec
+	if strings.HasPrefix(s, "+") {
+		spec.force = true
+		s = s[1:]
+	}
+	src, dst, ok := strings.Cut(s, ":")
+	if !ok {
+		dst = src
+	}
+	if src == "" || dst == "" {
+		return refspec{}, fmt.Errorf("invalid refspec %q", s)
+	}
+	if !strings.HasPrefix(src, "refs/") {
+		src = "refs/heads/" + src
+	}
+	if !strings.HasPrefix(dst, "refs/") {
+		dst = "refs/remotes/" + remote + "/" + strings.TrimPrefix(dst, "refs/heads/")
+	}
+	if strings.Count(src, "*") != strings.Count(dst, "*") || strings.Count(src, "*") > 1 {
+		return refspec{}, fmt.Errorf("invalid refspec %q: mismatched wildcards", s)
+	}
+	spec.src, spec.dst = src, dst
+	return spec, nil
+}
+
+// match returns the local ref that the remote ref name maps to.
+func (r refspec) match(name string) (string, bool) {
+	prefix, suffix, glob := strings.Cut(r.src, "*")
+	if !glob {
+		return r.dst, name == r.src
+	}
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	middle := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(r.dst, "*", middle, 1), true
+}
+
+// reverse returns the remote ref that maps to the local ref name.
+func (r refspec) reverse(name string) (string, bool) {
+	return refspec{src: r.dst, dst: r.src}.match(name)
+}
+
+// defaultFetchRefspec is the refspec clone configures for a remote.
+func defaultFetchRefspec(remote string) string {
+	return "+refs/heads/*:refs/remotes/" + remote + "/*"
+}
+
+// lookupRemote resolves a remote name or URL. For a configured remote it
+// returns the remote's URL and fetch refspecs; a URL is used as-is and
+// tracked under origin.
+func lookupRemote(nameOrURL string) (name, url string, specs []string, err error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return "", "", nil, err
+	}
+	if u, ok := cfg.get("remote", nameOrURL, "url"); ok {
+		specs = cfg.getAll("remote", nameOrURL, "fetch")
+		return nameOrURL, strings.TrimSuffix(u, "/"), specs, nil
+	}
+	if !strings.Contains(nameOrURL, "/") {
+		return "", "", nil, fmt.Errorf("'%s' does not appear to be a git repository", nameOrURL)
+	}
+	return "origin", strings.TrimSuffix(nameOrURL, "/"), []string{defaultFetchRefspec("origin")}, nil
+}
+
+// lsRemote implements `mygit ls-remote <remote>|<url>`, listing the refs
+// the server advertises.
+func lsRemote(w io.Writer, remoteArg string) error {
+	_, url, _, err := lookupRemote(remoteArg)
+	if err != nil {
+		return err
+	}
+	local, err := openLocalRemote(url)
+	if err != nil {
+		return err
+	}
+	var adv *remoteRefs
+	if local != nil {
+		adv, err = local.refs()
+	} else {
+		adv, err = discoverRefs(url, "git-upload-pack")
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range adv.names {
+		fmt.Fprintf(w, "%s\t%s\n", adv.refs[name], name)
+	}
+	return nil
+}
+
+// matchRefspecs maps a remote ref through the first matching refspec.
+func matchRefspecs(specs []refspec, name string) (refspec, string, bool) {
+	for _, spec := range specs {
+		if local, ok := spec.match(name); ok {
+			return spec, local, true
+		}
+	}
+	return refspec{}, "", false
+}
+
+// localHaves returns the commits reachable from local refs, newest
+// first, in the order they are offered to the server as haves.
+func (ctx *cmdContext) localHaves() ([]*Commit, error) {
+	_, refs, err := listRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+	var tips []string
+	for _, hash := range refs {
+		if !hasObject(hash) {
+			continue
+		}
+		if commit, err := peelToCommit(hash); err == nil {
+			tips = append(tips, commit)
+		}
+	}
+	return ctx.revList(tips)
+}
+
+// fetchOptions are the knobs shared by fetch and clone.
+type fetchOptions struct {
+	depth  int
+	quiet  bool
+	filter string // object filter for a partial clone, such as "blob:none"
+	clone  bool   // fetching into a new repository, which takes the remote's object format
+}
+
+// refUpdate is a local ref to move after a fetch.
+type refUpdate struct {
+	remote string
+	local  string
+	hash   string
+	force  bool
+}
+
+// fetchObjects downloads whatever is missing for the remote refs that
+// match specs and returns the ref updates the caller should apply. A
+// repository on this machine is read directly, its objects copied.
+func fetchObjects(ctx *cmdContext, url string, specs []refspec, opts fetchOptions) (*remoteRefs, []refUpdate, error) {
+	local, err := openLocalRemote(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	var adv *remoteRefs
+	if local != nil {
+		if err = matchObjectFormat(local.format, opts.clone); err == nil {
+			adv, err = local.refs()
+		}
+	} else if adv, err = discoverRefs(url, "git-upload-pack"); err == nil {
+		var format objectFormat
+		if format, err = adv.format(); err == nil {
+			err = matchObjectFormat(format, opts.clone)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var updates []refUpdate
+	var wants []string
+	wanted := map[string]bool{}
+	for _, name := range adv.names {
+		if strings.HasSuffix(name, "^{}") {
+			continue
+		}
+		spec, local, ok := matchRefspecs(specs, name)
+		if !ok {
+			continue
+		}
+		hash := adv.refs[name]
+		updates = append(updates, refUpdate{name, local, hash, spec.force})
+		if !wanted[hash] && (opts.depth > 0 || !hasObject(hash)) {
+			wanted[hash] = true
+			wants = append(wants, hash)
+		}
+	}
+
+	if len(wants) > 0 && local != nil {
+		shallow, err := local.copyObjects(wants, opts.depth)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := updateShallow(shallow, nil); err != nil {
+			return nil, nil, err
+		}
+	} else if len(wants) > 0 {
+		haves, err := ctx.localHaves()
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := fetchPack(url, adv, fetchRequest{wants: wants, haves: haves, depth: opts.depth, quiet: opts.quiet, filter: opts.filter})
+		if err != nil {
+			return nil, nil, err
+		}
+		var prog *progress
+		if !opts.quiet {
+			prog = &progress{}
+		}
+		_, err = indexPack(resp.pack, prog, opts.filter != "")
+		resp.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("index-pack: %w", err)
+		}
+		if err := updateShallow(resp.shallow, resp.unshallow); err != nil {
+			return nil, nil, err
+		}
+	}
+	return adv, updates, nil
+}
+
+// matchObjectFormat checks that a remote names its objects in format,
+// as this repository does. A repository being cloned into takes the
+// remote's format instead, recorded in its config as git's clone does.
+func matchObjectFormat(format objectFormat, clone bool) error {
+	ours := repoFormat()
+	if format.name == ours.name {
+		return nil
+	}
+	if !clone {
+		return fmt.Errorf("mismatched algorithms: client %s; server %s", ours.name, format.name)
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.set("core", "", "repositoryformatversion", "1")
+	cfg.set("extensions", "", "objectformat", format.name)
+	if err := cfg.write(); err != nil {
+		return err
+	}
+	repoObjectFormat = &format
+	return nil
+}
+
+// fetch implements `mygit fetch [--quiet] [<remote>|<url>] [<refspec>...]`.
+func fetch(ctx *cmdContext, remoteArg string, specArgs []string, opts fetchOptions) error {
+	remote, url, specStrings, err := lookupRemote(remoteArg)
+	if err != nil {
+		return err
+	}
+	if len(specArgs) > 0 {
+		specStrings = specArgs
+	}
+	var specs []refspec
+	for _, s := range specStrings {
+		spec, err := parseRefspec(s, remote)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, spec)
+	}
+
+	if opts.filter == "" {
+		opts.filter = partialCloneFilter(remote)
+	}
+	_, updates, err := fetchObjects(ctx, url, specs, opts)
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 && len(specArgs) > 0 {
+		return fmt.Errorf("couldn't find remote ref %s", specArgs[0])
+	}
+
+	report := io.Writer(os.Stderr)
+	if opts.quiet {
+		report = io.Discard
+	}
+	header := false
+	rejected := false
+	for _, u := range updates {
+		old, err := readRef(u.local)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if old == u.hash {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(report, "From %s\n", url)
+			header = true
+		}
+		short := func(ref string) string {
+			for _, p := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+				ref = strings.TrimPrefix(ref, p)
+			}
+			return ref
+		}
+		note := "storing head"
+		if old == "" {
+			fmt.Fprintf(report, " * [new branch]      %-10s -> %s\n", short(u.remote), short(u.local))
+		} else {
+			ff, err := ctx.isAncestor(old, u.hash)
+			if err != nil {
+				return err
+			}
+			switch {
+			case ff:
+				note = "fast-forward"
+				fmt.Fprintf(report, "   %s..%s  %-10s -> %s\n", old[:7], u.hash[:7], short(u.remote), short(u.local))
+			case u.force:
+				note = "forced-update"
+				fmt.Fprintf(report, " + %s...%s %-10s -> %s  (forced update)\n", old[:7], u.hash[:7], short(u.remote), short(u.local))
+			default:
+				fmt.Fprintf(os.Stderr, " ! [rejected]        %-10s -> %s  (non-fast-forward)\n", short(u.remote), short(u.local))
+				rejected = true
+				continue
+			}
+		}
+		if err := updateRef(u.local, u.hash, "fetch "+remoteArg+": "+note); err != nil {
+			return err
+		}
+	}
+	if rejected {
+		return errors.New("some refs were not updated")
+	}
+	return nil
+}
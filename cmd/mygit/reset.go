@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resetPaths implements `mygit reset [-q] [--] <path>...`: the index
+// entries at or below each path go back to their HEAD versions, and
+// those HEAD does not have are dropped, leaving the working tree as it
+// is. Unless quiet, the changes still unstaged afterwards are listed.
+// Without separated, a path that nothing knows of is taken for a
+// mistyped revision, as git does.
+func resetPaths(w io.Writer, paths []string, quiet, separated bool) error {
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		head, err = "", nil
+	}
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(head)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	for _, spec := range paths {
+		spec = filepath.ToSlash(filepath.Clean(spec))
+		if spec != "." && !worktreeRelative(spec) {
+			return fmt.Errorf("'%s' is outside repository", spec)
+		}
+		under := func(path string) bool {
+			return spec == "." || path == spec || strings.HasPrefix(path, spec+"/")
+		}
+		// Entries whose HEAD version is already staged keep their stat data.
+		staged := map[string]*indexEntry{}
+		for _, e := range idx.entries {
+			if under(e.path) && e.stage() == 0 {
+				staged[e.path] = e
+			}
+		}
+		removed := idx.removeUnder(spec, nil)
+		found := removed
+		for _, path := range sortedPaths(files) {
+			if !under(path) {
+				continue
+			}
+			found = true
+			te := files[path]
+			mode := parseMode(te.Mode)
+			if e := staged[path]; e != nil && e.hash == te.Hash && e.mode == mode {
+				idx.add(e)
+				continue
+			}
+			idx.add(&indexEntry{path: path, hash: te.Hash, mode: mode})
+		}
+		if _, err := os.Lstat(filepath.FromSlash(spec)); !found && !separated && err != nil {
+			return ambiguousArgument(spec)
+		}
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	if quiet {
+		return nil
+	}
+	return printUnstaged(w)
+}
+
+// printUnstaged lists the changes left unstaged after a reset, as git
+// does, saying nothing when there are none.
+func printUnstaged(w io.Writer) error {
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	header := false
+	for _, e := range s.entries {
+		if e.staged == '?' || e.unstaged == ' ' {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "Unstaged changes after reset:")
+			header = true
+		}
+		fmt.Fprintf(w, "%c\t%s\n", e.unstaged, quotePath(e.path))
+	}
+	return nil
+}
+
+// resetCommit implements `mygit reset [--soft | --mixed | --hard] [-q]
+// [<commit>]`: the current branch, or HEAD when detached, moves to
+// commit, and where it was is saved in ORIG_HEAD. mode is "soft" to
+// stop there, "mixed" to also reset the index, keeping entries whose
+// content is unchanged, and "hard" to reset the working tree as well,
+// discarding local changes to tracked files. Any merge in progress is
+// forgotten.
+func resetCommit(w io.Writer, rev, mode string, quiet bool) error {
+	hashes, err := resolveRevs([]string{rev})
+	if err != nil {
+		return err
+	}
+	commit, err := peelToCommit(hashes[0])
+	if err != nil {
+		return err
+	}
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		head, err = "", nil
+	}
+	if err != nil {
+		return err
+	}
+	files, err := commitFiles(commit)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "soft":
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		heads, err := mergeHeads()
+		if err != nil {
+			return err
+		}
+		if heads != nil || len(idx.unmerged()) > 0 {
+			return errors.New("Cannot do a soft reset in the middle of a merge.")
+		}
+	case "mixed":
+		old, err := readIndex()
+		if err != nil {
+			return err
+		}
+		idx := &index{}
+		for _, path := range sortedPaths(files) {
+			te := files[path]
+			mode := parseMode(te.Mode)
+			if e := old.entry(path); e != nil && e.stage() == 0 && e.hash == te.Hash && e.mode == mode {
+				idx.entries = append(idx.entries, e)
+				continue
+			}
+			idx.entries = append(idx.entries, &indexEntry{path: path, hash: te.Hash, mode: mode})
+		}
+		if err := idx.applySparseCheckout(os.Stderr); err != nil {
+			return err
+		}
+		if err := idx.write(); err != nil {
+			return err
+		}
+	case "hard":
+		s, err := readStatus()
+		if err != nil {
+			return err
+		}
+		if err := s.checkoutFiles(files, true); err != nil {
+			return err
+		}
+	}
+
+	if head != "" {
+		if err := saveOrigHead(head); err != nil {
+			return err
+		}
+	}
+	if err := updateHead(commit, "reset: moving to "+rev); err != nil {
+		return err
+	}
+	if err := clearMergeState(); err != nil {
+		return err
+	}
+	switch {
+	case quiet:
+	case mode == "hard":
+		c, err := readCommit(commit)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "HEAD is now at %s %s\n", commit[:7], c.Subject())
+	case mode == "mixed":
+		return printUnstaged(w)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResetPaths unstages a modified file, a new file, a deletion and a
+// whole directory with reset <path>..., and checks that the index, the
+// untouched working tree and the list of changes left unstaged are
+// git's, in a repository with commits and on an unborn branch.
+func TestResetPaths(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("README", "readme\n", 0o644)
+	r.write("src/a.c", "a\n", 0o644)
+	r.write("src/b.c", "b\n", 0o644)
+	r.write("gone", "gone\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.write("README", "changed\n", 0o644)
+	r.write("src/a.c", "a changed\n", 0o644)
+	r.write("src/new.c", "new\n", 0o644)
+	r.write("new", "new\n", 0o644)
+	r.git("rm", "-q", "gone")
+	r.git("add", "-A")
+
+	state := func() string { return r.git("ls-files", "-s") + r.git("status", "--porcelain") }
+	compare := func(args ...string) {
+		t.Helper()
+		undo := r.snapshot()
+		want := r.git(args...)
+		wantState := state()
+		undo()
+		if got := r.mygit(args...); got != want {
+			t.Errorf("%s printed %q, git printed %q", strings.Join(args, " "), got, want)
+		}
+		if got := state(); got != wantState {
+			t.Errorf("after %s:\ngit:   %q\nmygit: %q", strings.Join(args, " "), wantState, got)
+		}
+		undo()
+	}
+	compare("reset", "README")
+	compare("reset", "new")
+	compare("reset", "--", "gone")
+	compare("reset", "src")
+	compare("reset", "-q", "README", "src/new.c")
+	compare("reset", ".")
+	r.sameFailure("reset", "nothing")
+
+	r = newGoldenRepo(t)
+	r.write("a", "a\n", 0o644)
+	r.write("b", "b\n", 0o644)
+	r.git("add", "-A")
+	compare("reset", "a")
+	compare("reset", "--", "a", "b")
+}
+
+// TestResetCommit compares reset --soft, --mixed and --hard to an older
+// commit with git's: the branch, ORIG_HEAD, the index, the working tree,
+// the reflog entry and what is printed. It then checks that merge
+// records ORIG_HEAD and reset --hard ORIG_HEAD undoes it.
+func TestResetCommit(t *testing.T) {
+	r := mergeRepo(t, true)
+	r.write("c", "local\n", 0o644)
+	r.write("untracked", "untracked\n", 0o644)
+	state := func() string {
+		data, _ := os.ReadFile(filepath.Join(r.dir, ".git", "ORIG_HEAD"))
+		worktree, _ := os.ReadFile(filepath.Join(r.dir, "c"))
+		return "ORIG_HEAD: " + string(data) + "c: " + string(worktree) + r.git("rev-parse", "HEAD") +
+			r.git("status", "--porcelain") + r.git("ls-files", "-s") + r.git("log", "-g", "-1", "--format=%gs")
+	}
+	for _, args := range [][]string{
+		{"reset", "--soft", "HEAD~1"},
+		{"reset", "HEAD~1"},
+		{"reset", "--mixed", "-q", "HEAD~1"},
+		{"reset", "--hard", "HEAD~1"},
+		{"reset", "--hard"},
+		{"reset", "main~1"},
+	} {
+		undo := r.snapshot()
+		want := r.git(args...)
+		wantState := state()
+		undo()
+		if got := r.mygit(args...); got != want {
+			t.Errorf("%s printed %q, git printed %q", strings.Join(args, " "), got, want)
+		}
+		if got := state(); got != wantState {
+			t.Errorf("after %s:\ngit:   %q\nmygit: %q", strings.Join(args, " "), wantState, got)
+		}
+		undo()
+	}
+
+	r.git("reset", "-q", "--hard")
+	head := r.git("rev-parse", "HEAD")
+	r.mygit("merge", "topic")
+	if got := r.git("rev-parse", "ORIG_HEAD"); got != head {
+		t.Errorf("ORIG_HEAD after merge is %q, want %q", got, head)
+	}
+	r.same("rev-parse", "ORIG_HEAD")
+	r.mygit("reset", "-q", "--hard", "ORIG_HEAD")
+	if got := r.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("reset --hard ORIG_HEAD left HEAD at %q, want %q", got, head)
+	}
+}
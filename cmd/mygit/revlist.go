@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// revListOptions are the flags of `mygit rev-list`.
+type revListOptions struct {
+	count   bool // print how many objects would be listed instead
+	objects bool // list the trees and blobs of the commits too
+}
+
+// listRevs implements `mygit rev-list [--count] [--objects] <rev>...`:
+// the commits reachable from the revisions but not from those given as
+// "^rev" or as the left side of "a..b", newest first. With objects,
+// every tree and blob of those commits follows, each once and after its
+// hash the path it was first met at, trees before what they hold, as
+// pack-objects wants them. Objects reachable from an excluded commit are
+// left out too.
+func listRevs(ctx *cmdContext, w io.Writer, args []string, opts revListOptions) error {
+	var includeArgs, excludeArgs []string
+	for _, arg := range args {
+		if from, to, ok := strings.Cut(arg, ".."); ok {
+			if from == "" {
+				from = "HEAD"
+			}
+			if to == "" {
+				to = "HEAD"
+			}
+			excludeArgs = append(excludeArgs, from)
+			includeArgs = append(includeArgs, to)
+		} else if negative, ok := strings.CutPrefix(arg, "^"); ok {
+			excludeArgs = append(excludeArgs, negative)
+		} else {
+			includeArgs = append(includeArgs, arg)
+		}
+	}
+	if len(includeArgs) == 0 && len(excludeArgs) > 0 {
+		return nil
+	}
+	include, err := resolveRevs(includeArgs)
+	if err != nil {
+		return err
+	}
+	hidden := map[string]bool{}
+	if len(excludeArgs) > 0 {
+		exclude, err := resolveRevs(excludeArgs)
+		if err != nil {
+			return err
+		}
+		excluded, err := ctx.reachableObjects(exclude, nil)
+		if err != nil {
+			return err
+		}
+		for _, hash := range excluded {
+			hidden[hash] = true
+		}
+	}
+
+	n := 0
+	show := func(hash, suffix string) {
+		n++
+		if !opts.count {
+			fmt.Fprintf(w, "%s%s\n", hash, suffix)
+		}
+	}
+	var trees []string
+	err = ctx.walkTopology(include, func(c *Commit) error {
+		if hidden[c.Hash] {
+			return nil
+		}
+		show(c.Hash, "")
+		trees = append(trees, c.Tree)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.objects {
+		var visitTree func(hash, path string) error
+		visitTree = func(hash, path string) error {
+			if hidden[hash] {
+				return nil
+			}
+			hidden[hash] = true
+			show(hash, " "+path)
+			entries, err := readTree(hash)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				name := e.Name
+				if path != "" {
+					name = path + "/" + e.Name
+				}
+				switch e.Mode {
+				case "40000":
+					if err := visitTree(e.Hash, name); err != nil {
+						return err
+					}
+				case "160000":
+					// Submodule commits live in another repository.
+				default:
+					if !hidden[e.Hash] {
+						hidden[e.Hash] = true
+						show(e.Hash, " "+name)
+					}
+				}
+			}
+			return nil
+		}
+		for _, tree := range trees {
+			if err := visitTree(tree, ""); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.count {
+		fmt.Fprintln(w, n)
+	}
+	return nil
+}
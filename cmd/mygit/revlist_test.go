@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+// TestRevListCountObjects compares rev-list --count and --objects with
+// git's over a history with a branch, a file that moves and content
+// that repeats, for whole histories and for ranges that exclude
+// commits, whose objects are then left out too.
+func TestRevListCountObjects(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("checkout", "-q", "-b", "topic")
+	r.write("src/copy.txt", "deep\n", 0o644)
+	r.git("mv", "README", "src/README")
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "move")
+	r.git("checkout", "-q", "main")
+	r.write("README", "changed\n", 0o644)
+	r.git("commit", "-q", "-am", "change")
+	r.write("new", "new\n", 0o644)
+	r.git("add", "new")
+	r.git("commit", "-q", "-m", "new")
+
+	for _, revs := range [][]string{
+		{"HEAD"},
+		{"main", "topic"},
+		{"main..topic"},
+		{"topic..main"},
+		{"main", "^topic"},
+		{"HEAD~1..HEAD"},
+	} {
+		r.same(append([]string{"rev-list", "--count"}, revs...)...)
+		r.same(append([]string{"rev-list", "--objects"}, revs...)...)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// shallowCommits caches the contents of .git/shallow for this run.
+var shallowCommits map[string]bool
+
+// readShallow returns the set of shallow boundary commits.
+func readShallow() (map[string]bool, error) {
+	if shallowCommits != nil {
+		return shallowCommits, nil
+	}
+	data, err := os.ReadFile(gitPath("shallow"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	shallowCommits = map[string]bool{}
+	for _, line := range strings.Fields(string(data)) {
+		shallowCommits[line] = true
+	}
+	return shallowCommits, nil
+}
+
+// updateShallow adds and removes boundary commits as reported by the
+// server and rewrites .git/shallow, deleting it when nothing is left.
+func updateShallow(add, remove []string) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	shallow, err := readShallow()
+	if err != nil {
+		return err
+	}
+	for _, hash := range add {
+		shallow[hash] = true
+	}
+	for _, hash := range remove {
+		delete(shallow, hash)
+	}
+
+	path := gitPath("shallow")
+	if len(shallow) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	for _, hash := range sortedKeys(shallow) {
+		b.WriteString(hash + "\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// sortedKeys returns the keys of a string set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
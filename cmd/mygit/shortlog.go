@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// shortlogOptions are the flags accepted by shortlog.
+type shortlogOptions struct {
+	summary  bool // -s: print only the commit counts
+	numbered bool // -n: sort authors by commit count
+}
+
+// shortlog groups the commits reachable from starts by author, after
+// mapping authors through .mailmap.
+func shortlog(ctx *cmdContext, w io.Writer, starts []string, opts shortlogOptions) error {
+	commits, err := ctx.revList(starts)
+	if err != nil {
+		return err
+	}
+	subjects := map[string][]string{}
+	var authors []string
+	mm := readMailmap()
+	// revList yields newest first; shortlog lists each author's commits oldest first.
+	for i := len(commits) - 1; i >= 0; i-- {
+		name := mm.lookup(commits[i].Author).Name
+		if _, ok := subjects[name]; !ok {
+			authors = append(authors, name)
+		}
+		subjects[name] = append(subjects[name], commits[i].Subject())
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		if opts.numbered && len(subjects[authors[i]]) != len(subjects[authors[j]]) {
+			return len(subjects[authors[i]]) > len(subjects[authors[j]])
+		}
+		return authors[i] < authors[j]
+	})
+	for _, name := range authors {
+		if opts.summary {
+			fmt.Fprintf(w, "%6d\t%s\n", len(subjects[name]), name)
+			continue
+		}
+		fmt.Fprintf(w, "%s (%d):\n", name, len(subjects[name]))
+		for _, s := range subjects[name] {
+			fmt.Fprintf(w, "      %s\n", s)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// show implements `mygit show [--stat] [--color[=<when>]] [<commit>]`:
+// the commit in log's format, then what it changed against its first
+// parent, or against the empty tree for a root commit, as a patch or with
+// stat as a diffstat, in git's colors with color.
+// Renames are found as git finds them. For a merge git shows a combined
+// diff, which says nothing of a clean merge; mygit only shows a merge's
+// diffstat.
+func show(ctx *cmdContext, w io.Writer, rev string, stat, color bool) error {
+	hashes, err := resolveRevs([]string{rev})
+	if err != nil {
+		return err
+	}
+	hash, err := peelToCommit(hashes[0])
+	if err != nil {
+		return err
+	}
+	c, err := ctx.getCommit(hash)
+	if err != nil {
+		return err
+	}
+	printCommit(w, readMailmap().mapCommit(c), "", color, false)
+
+	parentTree := ""
+	if len(c.Parents) > 0 {
+		parent, err := ctx.getCommit(c.Parents[0])
+		if err != nil {
+			return err
+		}
+		parentTree = parent.Tree
+	}
+	if len(c.Parents) > 1 && !stat {
+		fmt.Fprintln(w)
+		return nil
+	}
+	from, err := treeFiles(parentTree, true)
+	if err != nil {
+		return err
+	}
+	to, err := treeFiles(c.Tree, true)
+	if err != nil {
+		return err
+	}
+	changes := diffTrees(from, to)
+	if len(changes) == 0 {
+		return nil
+	}
+	// git finds renames unless told not to.
+	if changes, err = detectRenames(changes, defaultRenameThreshold); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	if stat {
+		return writeDiffStat(w, changes, color)
+	}
+	for _, ch := range changes {
+		old, err := blobSide(ch.old)
+		if err != nil {
+			return err
+		}
+		new, err := blobSide(ch.new)
+		if err != nil {
+			return err
+		}
+		switch ch.status {
+		case 'R':
+			writeRenamePatch(w, ch.oldPath, ch.path, ch.score, old, new, color)
+		case 'T':
+			// A file that became a symlink or back is shown removed and re-added.
+			writePatch(w, ch.path, old, diffSide{}, color)
+			writePatch(w, ch.path, diffSide{}, new, color)
+		default:
+			writePatch(w, ch.path, old, new, color)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// signer asks for an object to be signed. A nil *signer signs nothing;
+// an empty key means user.signingkey, or failing that, for OpenPGP and
+// X.509, the committer's identity.
+type signer struct {
+	key string
+}
+
+// signFormats are the values of gpg.format and the program each runs by
+// default.
+var signFormats = map[string]string{
+	"openpgp": "gpg",
+	"x509":    "gpgsm",
+	"ssh":     "ssh-keygen",
+}
+
+// sign returns the detached signature of payload made by the program
+// gpg.format selects, configured by gpg.<format>.program or, for
+// OpenPGP, gpg.program. OpenPGP and X.509 signatures come from
+// `<program> --status-fd=2 -bsau <key>`; SSH ones from `ssh-keygen -Y
+// sign`, with user.signingkey the path of a key file or, after "key::",
+// a public key whose private half is in ssh-agent.
+func (s *signer) sign(payload []byte) (string, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return "", err
+	}
+	format, _ := cfg.get("gpg", "", "format")
+	if format == "" {
+		format = "openpgp"
+	}
+	program, err := signProgram(cfg, format)
+	if err != nil {
+		return "", err
+	}
+	key := s.key
+	if key == "" {
+		key, _ = cfg.get("user", "", "signingkey")
+	}
+	if key == "" && format != "ssh" {
+		who, err := currentSignature("COMMITTER")
+		if err != nil {
+			return "", err
+		}
+		key = fmt.Sprintf("%s <%s>", who.Name, who.Email)
+	}
+
+	var sig []byte
+	if format == "ssh" {
+		sig, err = signSSH(program, key, payload)
+	} else {
+		sig, err = signGPG(program, key, payload)
+	}
+	if err != nil {
+		return "", err
+	}
+	// As git does, drop the CRs a signer on Windows might write.
+	return string(bytes.ReplaceAll(sig, []byte("\r\n"), []byte("\n"))), nil
+}
+
+// signProgram returns the program that signs and verifies in format:
+// gpg.<format>.program, or for OpenPGP gpg.program, or the default.
+func signProgram(cfg *config, format string) (string, error) {
+	program, ok := signFormats[format]
+	if !ok {
+		return "", fmt.Errorf("invalid value for 'gpg.format': '%s'", format)
+	}
+	if p, ok := cfg.get("gpg", "", "program"); ok && format == "openpgp" {
+		program = p
+	}
+	if p, ok := cfg.get("gpg", format, "program"); ok {
+		program = p
+	}
+	return program, nil
+}
+
+// signGPG signs payload with gpg or gpgsm, which must report on its
+// status output that it made the signature.
+func signGPG(program, key string, payload []byte) ([]byte, error) {
+	var sig, status bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", key)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(payload), &sig, &status
+	if err := cmd.Run(); err != nil || !strings.Contains(status.String(), "\n[GNUPG:] SIG_CREATED ") {
+		return nil, errors.New("gpg failed to sign the data")
+	}
+	return sig.Bytes(), nil
+}
+
+// signSSH signs payload with ssh-keygen, which reads it from a file and
+// writes the signature beside it.
+func signSSH(program, key string, payload []byte) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("either user.signingkey or gpg.ssh.defaultKeyCommand needs to be configured")
+	}
+	args := []string{"-Y", "sign", "-n", "git", "-f"}
+	literal, isLiteral := strings.CutPrefix(key, "key::")
+	if !isLiteral && strings.HasPrefix(key, "ssh-") {
+		literal, isLiteral = key, true
+	}
+	if isLiteral {
+		keyFile, err := writeTempFile(".git_signing_key_tmp", []byte(literal))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyFile)
+		args = append(args, keyFile, "-U")
+	} else {
+		if rest, ok := strings.CutPrefix(key, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				key = filepath.Join(home, rest)
+			}
+		}
+		args = append(args, key)
+	}
+	bufferFile, err := writeTempFile(".git_signing_buffer_tmp", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(bufferFile)
+	defer os.Remove(bufferFile + ".sig")
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(program, append(args, bufferFile)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "usage:") {
+			return nil, errors.New("ssh-keygen -Y sign is needed for ssh signing (available in openssh version 8.2p1+)")
+		}
+		return nil, fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(bufferFile + ".sig")
+}
+
+// writeTempFile writes data to a new temporary file whose name starts
+// with prefix and returns its path.
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// signCommitBody adds the signature of a serialized commit to it, as a
+// gpgsig header after the others with every line of the signature
+// indented by a space. SHA-256 repositories call it gpgsig-sha256.
+func (s *signer) signCommitBody(body []byte) ([]byte, error) {
+	sig, err := s.sign(body)
+	if err != nil {
+		return nil, err
+	}
+	header := "gpgsig"
+	if repoFormat().name == "sha256" {
+		header = "gpgsig-sha256"
+	}
+	var b strings.Builder
+	b.WriteString(header)
+	for _, line := range strings.SplitAfter(sig, "\n") {
+		if line != "" {
+			b.WriteString(" " + line)
+		}
+	}
+	if !strings.HasSuffix(sig, "\n") {
+		b.WriteString("\n")
+	}
+	end := bytes.Index(body, []byte("\n\n")) + 1
+	signed := append([]byte{}, body[:end]...)
+	signed = append(signed, b.String()...)
+	return append(signed, body[end:]...), nil
+}
+
+// parseSignFlag turns -S[<keyid>] or --gpg-sign[=<keyid>] into a signer.
+func parseSignFlag(arg string) *signer {
+	if key, ok := strings.CutPrefix(arg, "--gpg-sign="); ok {
+		return &signer{key: key}
+	}
+	return &signer{key: strings.TrimPrefix(strings.TrimPrefix(arg, "--gpg-sign"), "-S")}
+}
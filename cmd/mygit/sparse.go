@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sparseCheckoutFile holds the patterns of the paths sparse checkout
+// keeps in the working tree, one per line in gitignore syntax.
+const sparseCheckoutFile = "info/sparse-checkout"
+
+// sparseIncludes reports whether patterns keep path in the working tree.
+// As in a .gitignore, the last pattern matching the path or one of its
+// directories decides, and a negated pattern leaves the path out.
+func sparseIncludes(patterns []pathPattern, name string) bool {
+	included := false
+	for _, p := range patterns {
+		matched := p.match(name, false)
+		for dir := path.Dir(name); !matched && dir != "."; dir = path.Dir(dir) {
+			matched = p.match(dir, true)
+		}
+		if matched {
+			included = !p.negate
+		}
+	}
+	return included
+}
+
+// sparsePatterns returns the sparse-checkout patterns, or nil when
+// core.sparseCheckout is not on and every path is checked out.
+func sparsePatterns() ([]pathPattern, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if v, _ := cfg.get("core", "", "sparsecheckout"); strings.ToLower(v) != "true" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(gitPath(sparseCheckoutFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	patterns := []pathPattern{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := parsePathPattern(line, ""); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, nil
+}
+
+// applySparseCheckout brings the working tree in line with the
+// sparse-checkout patterns, if any: files they leave out are deleted and
+// their entries marked skip-worktree, and marked files they keep are
+// written back. Files with local changes are not deleted but reported.
+// The caller writes the index.
+func (idx *index) applySparseCheckout(w io.Writer) error {
+	patterns, err := sparsePatterns()
+	if err != nil || patterns == nil {
+		return err
+	}
+	return idx.sparsify(w, func(path string) bool { return sparseIncludes(patterns, path) })
+}
+
+// sparsify checks out the files include accepts and removes the rest,
+// as applySparseCheckout describes.
+func (idx *index) sparsify(w io.Writer, include func(path string) bool) error {
+	var dirty []string
+	for _, e := range idx.entries {
+		if e.stage() != 0 || e.mode == 0o160000 {
+			continue
+		}
+		keep := include(e.path)
+		switch {
+		case keep && e.skipWorktree():
+			te := TreeEntry{Mode: formatMode(e.mode), Hash: e.hash}
+			if err := writeWorktreeFile(filepath.FromSlash(e.path), te); err != nil {
+				return err
+			}
+			ne, err := newIndexEntry(e.path, e.hash, e.mode)
+			if err != nil {
+				return err
+			}
+			*e = *ne
+		case !keep && !e.skipWorktree():
+			side, err := worktreeSide(idx, e)
+			if err != nil {
+				return err
+			}
+			if side.hash != "" && (side.hash != e.hash || side.mode != e.mode) {
+				dirty = append(dirty, e.path)
+				continue
+			}
+			if err := removeWorktreeFile(e.path); err != nil {
+				return err
+			}
+			e.ext |= indexSkipWorktree
+		}
+	}
+	if len(dirty) > 0 {
+		fmt.Fprintf(w, "warning: The following paths are not up to date and were left despite sparse patterns:\n")
+		for _, path := range dirty {
+			fmt.Fprintf(w, "\t%s\n", path)
+		}
+	}
+	return nil
+}
+
+// sparseCheckoutSet implements `mygit sparse-checkout set <pattern>...`:
+// the patterns replace those in info/sparse-checkout, sparse checkout
+// is turned on, and the working tree is updated to match.
+func sparseCheckoutSet(w io.Writer, patterns []string) error {
+	if err := os.MkdirAll(filepath.Dir(gitPath(sparseCheckoutFile)), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, p := range patterns {
+		b.WriteString(p + "\n")
+	}
+	if err := os.WriteFile(gitPath(sparseCheckoutFile), []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.set("core", "", "sparseCheckout", "true")
+	// mygit only has git's pattern mode; say so for git's sake.
+	cfg.set("core", "", "sparseCheckoutCone", "false")
+	if err := cfg.write(); err != nil {
+		return err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if err := idx.applySparseCheckout(w); err != nil {
+		return err
+	}
+	return idx.write()
+}
+
+// sparseCheckoutDisable implements `mygit sparse-checkout disable`:
+// every file is checked out again and sparse checkout is turned off.
+// The patterns are kept for a later set.
+func sparseCheckoutDisable(w io.Writer) error {
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if err := idx.sparsify(w, func(string) bool { return true }); err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg.set("core", "", "sparseCheckout", "false")
+	return cfg.write()
+}
+
+// sparseCheckoutList implements `mygit sparse-checkout list`, printing
+// the patterns in use.
+func sparseCheckoutList(w io.Writer) error {
+	patterns, err := sparsePatterns()
+	if err != nil {
+		return err
+	}
+	if patterns == nil {
+		return errors.New("this worktree is not sparse")
+	}
+	data, err := os.ReadFile(gitPath(sparseCheckoutFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSparseCheckout narrows the working tree with sparse-checkout set,
+// lists the patterns, switches branches under them and disables sparse
+// checkout again, checking each step against git's non-cone mode: the
+// pattern file, the skip-worktree bits, the files left in the working
+// tree and what status makes of them.
+func TestSparseCheckout(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("top", "top\n", 0o644)
+	r.write("src/main.c", "main\n", 0o644)
+	r.write("src/lib/lib.c", "lib\n", 0o644)
+	r.write("docs/readme", "readme\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	r.git("checkout", "-q", "-b", "topic")
+	r.write("src/new.c", "new\n", 0o644)
+	r.write("docs/guide", "guide\n", 0o644)
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "topic")
+	r.git("checkout", "-q", "main")
+
+	state := func() string {
+		var b strings.Builder
+		data, _ := os.ReadFile(filepath.Join(r.dir, ".git", "info", "sparse-checkout"))
+		b.WriteString("patterns:\n" + string(data))
+		filepath.Walk(r.dir, func(path string, info os.FileInfo, err error) error {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() {
+				rel, _ := filepath.Rel(r.dir, path)
+				b.WriteString(rel + "\n")
+			}
+			return nil
+		})
+		b.WriteString(r.git("config", "core.sparseCheckout"))
+		return b.String() + r.git("ls-files", "-t") + r.git("status", "--porcelain")
+	}
+	steps := [][]string{
+		{"sparse-checkout", "set", "/*", "!/*/", "/src/"},
+		{"sparse-checkout", "list"},
+		{"checkout", "topic"},
+		{"sparse-checkout", "set", "/docs/"},
+		{"sparse-checkout", "disable"},
+	}
+	for _, args := range steps {
+		undo := r.snapshot()
+		gitArgs := args
+		if args[1] == "set" {
+			gitArgs = append([]string{"sparse-checkout", "set", "--no-cone"}, args[2:]...)
+		}
+		want := r.git(gitArgs...)
+		wantState := state()
+		undo()
+		if got := r.mygit(args...); got != want {
+			t.Errorf("%s printed %q, git printed %q", strings.Join(args, " "), got, want)
+		}
+		if got := state(); got != wantState {
+			t.Errorf("after %s:\ngit:\n%s\nmygit:\n%s", strings.Join(args, " "), wantState, got)
+		}
+	}
+	r.sameFailure("sparse-checkout", "list")
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const stashRef = "refs/stash"
+
+// stashSave implements `mygit stash [push]`. The index is recorded as a
+// commit on HEAD, and the tracked files of the working tree as a commit
+// whose parents are HEAD and the index commit, which refs/stash then
+// points at. The working tree and index are reset to HEAD; untracked
+// files stay where they are.
+func stashSave(w io.Writer) error {
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("You do not have the initial commit yet")
+	}
+	if err != nil {
+		return err
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, e := range s.entries {
+		changed = changed || e.staged != '?'
+	}
+	if !changed {
+		fmt.Fprintln(w, "No local changes to save")
+		return nil
+	}
+
+	headCommit, err := readCommit(head)
+	if err != nil {
+		return err
+	}
+	branch := "(no branch)"
+	if ref, _ := headRef(); ref != "" {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	on := fmt.Sprintf("on %s: %s %s", branch, head[:7], headCommit.Subject())
+
+	indexTree, err := writeIndexTree(s.idx.entries)
+	if err != nil {
+		return err
+	}
+	indexCommit, err := writeCommit(indexTree, []string{head}, "index "+on+"\n", nil)
+	if err != nil {
+		return err
+	}
+	// The working tree commit holds every tracked file as it is on disk.
+	files := map[string]TreeEntry{}
+	for _, e := range s.idx.entries {
+		if e.unexamined() {
+			files[e.path] = TreeEntry{Mode: formatMode(e.mode), Hash: e.hash}
+			continue
+		}
+		content, mode, err := readWorktreeBlob(e.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hash, err := writeObject(BlobObject, content)
+		if err != nil {
+			return err
+		}
+		files[e.path] = TreeEntry{Mode: formatMode(mode), Hash: hash}
+	}
+	worktreeTree, err := writeTreeFiles(files)
+	if err != nil {
+		return err
+	}
+	// Unlike the index commit's, git ends this message without a newline.
+	stash, err := writeCommit(worktreeTree, []string{head, indexCommit}, "WIP "+on, nil)
+	if err != nil {
+		return err
+	}
+
+	// Stashes live in the reflog of refs/stash, which git keeps whatever
+	// core.logAllRefUpdates says.
+	old, _ := readRef(stashRef)
+	if err := os.MkdirAll(filepath.Dir(reflogPath(stashRef)), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(reflogPath(stashRef), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := writeLooseRef(stashRef, stash); err != nil {
+		return err
+	}
+	if err := appendReflog(stashRef, old, stash, "WIP "+on); err != nil {
+		return err
+	}
+
+	headFiles, err := flattenTree(headCommit.Tree)
+	if err != nil {
+		return err
+	}
+	if err := s.checkoutFiles(headFiles, true); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Saved working directory and index state WIP %s\n", on)
+	return nil
+}
+
+// errStashKept ends a stash pop that left conflicts, and errNoStash one
+// with nothing to pop.
+var (
+	errStashKept = errors.New("The stash entry is kept in case you need it again.")
+	errNoStash   = errors.New("No stash entries found.")
+)
+
+// stashPop implements `mygit stash pop`: the newest stash's changes are
+// merged into the working tree, against the commit they were made on,
+// and the stash is dropped. Files the stash added are staged; its other
+// changes are left unstaged. When the merge conflicts, the conflicted
+// files keep markers and the stash is kept.
+func stashPop(ctx *cmdContext, w io.Writer) error {
+	stash, err := readRef(stashRef)
+	if errors.Is(err, os.ErrNotExist) {
+		return errNoStash
+	}
+	if err != nil {
+		return err
+	}
+	c, err := readCommit(stash)
+	if err != nil {
+		return err
+	}
+	if len(c.Parents) < 2 {
+		return fmt.Errorf("%s is not a stash-like commit", stash)
+	}
+	base, err := commitFiles(c.Parents[0])
+	if err != nil {
+		return err
+	}
+	theirs, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]TreeEntry, len(s.idx.entries))
+	for _, e := range s.idx.entries {
+		ours[e.path] = TreeEntry{Mode: formatMode(e.mode), Name: filepath.Base(e.path), Hash: e.hash}
+	}
+
+	// Local changes the stash would touch are in the way.
+	var changed, untracked []string
+	for _, e := range s.entries {
+		b, inBase := base[e.path]
+		t, inTheirs := theirs[e.path]
+		if inBase == inTheirs && b == t {
+			continue
+		}
+		switch {
+		case e.staged == '?':
+			untracked = append(untracked, e.path)
+		case e.unstaged != ' ':
+			changed = append(changed, e.path)
+		}
+	}
+	if len(changed) > 0 || len(untracked) > 0 {
+		return overwriteError("merge", changed, untracked)
+	}
+
+	_, conflicted, err := applyMerge(w, nil, base, ours, theirs, "Updated upstream", "Stashed changes")
+	if err != nil {
+		return err
+	}
+	if conflicted {
+		return errStashKept
+	}
+	// Put back the index as it was, keeping the files the stash added.
+	merged, err := readIndex()
+	if err != nil {
+		return err
+	}
+	for _, e := range merged.entries {
+		if s.idx.entry(e.path) == nil {
+			s.idx.add(e)
+		}
+	}
+	for _, e := range s.idx.entries {
+		if ne := merged.entry(e.path); ne == nil || ne.hash != e.hash || ne.mode != e.mode {
+			// Unstaged changes must not pass as clean on stat data alone.
+			*e = indexEntry{path: e.path, hash: e.hash, mode: e.mode}
+		}
+	}
+	if err := s.idx.write(); err != nil {
+		return err
+	}
+	if err := status(ctx, w); err != nil {
+		return err
+	}
+	return stashDrop(w)
+}
+
+// stashDrop removes the newest stash, pointing refs/stash at the one
+// before it or deleting the ref when none is left.
+func stashDrop(w io.Writer) error {
+	stash, err := readRef(stashRef)
+	if err != nil {
+		return err
+	}
+	entries, err := readReflog(stashRef)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) <= 1 {
+		if err := deleteRef(stashRef); err != nil {
+			return err
+		}
+		if err := os.Remove(reflogPath(stashRef)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		entries = entries[:len(entries)-1]
+		if err := writeReflog(stashRef, entries); err != nil {
+			return err
+		}
+		if err := writeLooseRef(stashRef, entries[len(entries)-1].new); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "Dropped %s@{0} (%s)\n", stashRef, stash)
+	return nil
+}
+
+// writeReflog replaces ref's reflog with entries, oldest first.
+func writeReflog(ref string, entries []reflogEntry) error {
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s %s %s\t%s\n", e.old, e.new, e.who, e.message)
+	}
+	return os.WriteFile(reflogPath(ref), []byte(b.String()), 0o644)
+}
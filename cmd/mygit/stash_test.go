@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStash stashes staged and unstaged changes, with an untracked file
+// left alone, and pops them back, cleanly and with a conflict that keeps
+// the stash, checking the stash commits, the reflog, the index and the
+// working tree against git's, as well as the refusals with nothing to
+// stash or pop.
+func TestStash(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+	state := func() string {
+		readme, _ := os.ReadFile(filepath.Join(r.dir, "README"))
+		stash := r.run("", "sh", []string{"-c", "for rev in refs/stash refs/stash^1 refs/stash^2; do git rev-parse -q --verify $rev; done; " +
+			"git log -g --format=%gs refs/stash 2>/dev/null; true"})
+		return stash + r.git("status", "--porcelain") + r.git("ls-files", "-s") + string(readme)
+	}
+	r.sameFailure("stash", "pop")
+	r.sameRun(r.snapshot(), state, "stash")
+
+	r.write("README", "staged\n", 0o644)
+	r.git("add", "README")
+	r.write("README", "staged and then changed\n", 0o644)
+	r.write("src/main.go", "package changed\n", 0o644)
+	r.write("new.txt", "staged new file\n", 0o644)
+	r.git("add", "new.txt")
+	r.write("untracked", "untracked\n", 0o644)
+	r.sameRun(r.snapshot(), state, "stash")
+	r.sameRun(r.snapshot(), state, "stash", "pop")
+
+	r.mygit("stash")
+	r.write("README", "committed over the stash\n", 0o644)
+	r.git("commit", "-q", "-am", "change README")
+	r.sameRun(r.snapshot(), state, "stash", "pop")
+}
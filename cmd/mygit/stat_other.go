@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fillStat is a no-op where there is no Unix stat data to record.
+func fillStat(e *indexEntry, info os.FileInfo) {}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillStat copies the device, inode and owner fields git records in the
+// index. The ctime stays at the mtime: its field name differs between
+// Unix flavours, and git only uses it as a hint.
+func fillStat(e *indexEntry, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.dev = uint32(st.Dev)
+	e.ino = uint32(st.Ino)
+	e.uid = st.Uid
+	e.gid = st.Gid
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// statusEntry is a path that differs between HEAD, the index and the
+// working tree. staged compares the index to HEAD and unstaged the
+// working tree to the index, each as 'A', 'M', 'D', 'T' for a file that
+// became a symlink or back, or ' ' for no change. Untracked files have
+// '?' in both. An unmerged path has the code from unmergedCodes for the
+// sides of the conflict instead.
+type statusEntry struct {
+	path             string
+	staged, unstaged byte
+	unmerged         bool
+}
+
+// unmergedCodes are the status codes of an unmerged path by which of
+// its stages, 1 for the base, 2 for ours and 3 for theirs, are present
+// in the index, as a bit for each.
+var unmergedCodes = map[int]string{
+	1<<1 | 1<<2 | 1<<3: "UU",
+	1<<2 | 1<<3:        "AA",
+	1<<1 | 1<<2:        "UD",
+	1<<1 | 1<<3:        "DU",
+	1 << 2:             "AU",
+	1 << 3:             "UA",
+	1 << 1:             "DD",
+}
+
+// worktreeStatus is a snapshot of HEAD, the index and how the working
+// tree differs from them.
+type worktreeStatus struct {
+	head    string // "" on an unborn branch
+	files   map[string]TreeEntry
+	idx     *index
+	entries []statusEntry // sorted by path; see readStatus
+}
+
+// readStatus compares the working tree to the index and the index to
+// HEAD. A tracked file whose stat data still matches its index entry is
+// taken to be unchanged, as in git; only the others are hashed. A path
+// deleted from the index but still in the working tree has two entries,
+// the deletion and then the untracked file.
+func readStatus() (*worktreeStatus, error) {
+	head, err := resolveRef("HEAD")
+	if errors.Is(err, os.ErrNotExist) {
+		head, err = "", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files, err := commitFiles(head)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	s := &worktreeStatus{head: head, files: files, idx: idx}
+	changes := map[string]*statusEntry{}
+	change := func(path string) *statusEntry {
+		if changes[path] == nil {
+			changes[path] = &statusEntry{path: path, staged: ' ', unstaged: ' '}
+		}
+		return changes[path]
+	}
+
+	tracked := make(map[string]bool, len(idx.entries))
+	stages := map[string]int{}
+	for _, e := range idx.entries {
+		tracked[e.path] = true
+		if e.stage() != 0 {
+			stages[e.path] |= 1 << e.stage()
+			continue
+		}
+		if te, ok := files[e.path]; !ok {
+			change(e.path).staged = 'A'
+		} else if te.Hash != e.hash || parseMode(te.Mode) != e.mode {
+			change(e.path).staged = modifiedCode(parseMode(te.Mode), e.mode)
+		}
+		if e.unexamined() {
+			continue
+		}
+		if info, err := os.Lstat(filepath.FromSlash(e.path)); err == nil && idx.upToDate(e, info) {
+			continue
+		}
+		content, mode, err := readWorktreeBlob(e.path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			change(e.path).unstaged = 'D'
+		case err != nil:
+			return nil, err
+		case mode != e.mode || hashObject(BlobObject, content) != e.hash:
+			change(e.path).unstaged = modifiedCode(e.mode, mode)
+		}
+	}
+	for path := range files {
+		if !tracked[path] {
+			change(path).staged = 'D'
+		}
+	}
+	for path, present := range stages {
+		code := unmergedCodes[present]
+		*change(path) = statusEntry{path: path, staged: code[0], unstaged: code[1], unmerged: true}
+	}
+
+	paths, err := listWorktreeFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range changes {
+		s.entries = append(s.entries, *e)
+	}
+	for _, path := range paths {
+		if !tracked[path] {
+			s.entries = append(s.entries, statusEntry{path: path, staged: '?', unstaged: '?'})
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool {
+		if s.entries[i].path != s.entries[j].path {
+			return s.entries[i].path < s.entries[j].path
+		}
+		return s.entries[j].staged == '?'
+	})
+	return s, nil
+}
+
+// modifiedCode is the status code of a file whose content or mode went
+// from that of from to that of to: 'T' if it became a symlink or stopped
+// being one, 'M' otherwise.
+func modifiedCode(from, to uint32) byte {
+	if (from == 0o120000) != (to == 0o120000) {
+		return 'T'
+	}
+	return 'M'
+}
+
+// entry returns the status of path, or nil if it is unchanged.
+func (s *worktreeStatus) entry(path string) *statusEntry {
+	i := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].path >= path })
+	if i < len(s.entries) && s.entries[i].path == path {
+		return &s.entries[i]
+	}
+	return nil
+}
+
+// statusLabels are the words status uses for each kind of change.
+var statusLabels = map[byte]string{
+	'A': "new file:",
+	'M': "modified:",
+	'D': "deleted:",
+	'T': "typechange:",
+}
+
+// unmergedLabels are the words status uses for each kind of conflict.
+var unmergedLabels = map[string]string{
+	"UU": "both modified:",
+	"AA": "both added:",
+	"UD": "deleted by them:",
+	"DU": "deleted by us:",
+	"AU": "added by us:",
+	"UA": "added by them:",
+	"DD": "both deleted:",
+}
+
+// status implements `mygit status`, describing the changes in git's
+// long format, with paths named from where mygit was started.
+func status(ctx *cmdContext, w io.Writer) error {
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	ref, err := headRef()
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		fmt.Fprintf(w, "%s\n", detachedLabel(s.head))
+	} else {
+		fmt.Fprintf(w, "On branch %s\n", strings.TrimPrefix(ref, "refs/heads/"))
+		if err := ctx.trackingStatus(w, ref, s.head); err != nil {
+			return err
+		}
+	}
+	if s.head == "" {
+		fmt.Fprintf(w, "\nNo commits yet\n\n")
+	}
+
+	renamedFrom, renamed, err := s.stagedRenames()
+	if err != nil {
+		return err
+	}
+	var staged, unmerged, unstaged, untracked []statusEntry
+	deletions, unmergedDeletions := false, false
+	for _, e := range s.entries {
+		switch {
+		case e.unmerged:
+			unmerged = append(unmerged, e)
+			unmergedDeletions = unmergedDeletions || e.staged == 'D' || e.unstaged == 'D'
+			continue
+		case e.staged == '?':
+			untracked = append(untracked, e)
+			continue
+		case e.staged == 'D' && renamed[e.path]:
+		case e.staged != ' ':
+			staged = append(staged, e)
+		}
+		if e.unstaged != ' ' {
+			unstaged = append(unstaged, e)
+			deletions = deletions || e.unstaged == 'D'
+		}
+	}
+
+	merging, err := mergeHeads()
+	if err != nil {
+		return err
+	}
+	switch {
+	case merging != nil && len(unmerged) > 0:
+		fmt.Fprintf(w, "You have unmerged paths.\n")
+		fmt.Fprintf(w, "  (fix conflicts and run \"git commit\")\n")
+		fmt.Fprintf(w, "  (use \"git merge --abort\" to abort the merge)\n\n")
+	case merging != nil:
+		fmt.Fprintf(w, "All conflicts fixed but you are still merging.\n")
+		fmt.Fprintf(w, "  (use \"git commit\" to conclude merge)\n\n")
+	}
+
+	if len(staged) > 0 {
+		fmt.Fprintf(w, "Changes to be committed:\n")
+		switch {
+		case merging != nil:
+			// Unstaging part of a merge has no simple remedy to suggest.
+		case s.head == "":
+			fmt.Fprintf(w, "  (use \"git rm --cached <file>...\" to unstage)\n")
+		default:
+			fmt.Fprintf(w, "  (use \"git restore --staged <file>...\" to unstage)\n")
+		}
+		for _, e := range staged {
+			if old, ok := renamedFrom[e.path]; ok {
+				fmt.Fprintf(w, "\t%-12s%s -> %s\n", "renamed:", quotePath(relPath(old)), quotePath(relPath(e.path)))
+				continue
+			}
+			fmt.Fprintf(w, "\t%-12s%s\n", statusLabels[e.staged], quotePath(relPath(e.path)))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(unmerged) > 0 {
+		fmt.Fprintf(w, "Unmerged paths:\n")
+		if merging == nil && s.head != "" {
+			fmt.Fprintf(w, "  (use \"git restore --staged <file>...\" to unstage)\n")
+		}
+		if unmergedDeletions {
+			fmt.Fprintf(w, "  (use \"git add/rm <file>...\" as appropriate to mark resolution)\n")
+		} else {
+			fmt.Fprintf(w, "  (use \"git add <file>...\" to mark resolution)\n")
+		}
+		for _, e := range unmerged {
+			fmt.Fprintf(w, "\t%-17s%s\n", unmergedLabels[string([]byte{e.staged, e.unstaged})], quotePath(relPath(e.path)))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(unstaged) > 0 {
+		verb := "add"
+		if deletions {
+			verb = "add/rm"
+		}
+		fmt.Fprintf(w, "Changes not staged for commit:\n")
+		fmt.Fprintf(w, "  (use \"git %s <file>...\" to update what will be committed)\n", verb)
+		fmt.Fprintf(w, "  (use \"git restore <file>...\" to discard changes in working directory)\n")
+		for _, e := range unstaged {
+			fmt.Fprintf(w, "\t%-12s%s\n", statusLabels[e.unstaged], quotePath(relPath(e.path)))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(untracked) > 0 {
+		fmt.Fprintf(w, "Untracked files:\n")
+		fmt.Fprintf(w, "  (use \"git add <file>...\" to include in what will be committed)\n")
+		for _, path := range untrackedDisplay(s.idx, untracked) {
+			fmt.Fprintf(w, "\t%s\n", quotePath(relPath(path)))
+		}
+		fmt.Fprintln(w)
+	}
+
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0 || len(unmerged) > 0:
+		fmt.Fprintf(w, "no changes added to commit (use \"git add\" and/or \"git commit -a\")\n")
+	case len(untracked) > 0:
+		fmt.Fprintf(w, "nothing added to commit but untracked files present (use \"git add\" to track)\n")
+	case s.head == "":
+		fmt.Fprintf(w, "nothing to commit (create/copy files and use \"git add\" to track)\n")
+	default:
+		fmt.Fprintf(w, "nothing to commit, working tree clean\n")
+	}
+	return nil
+}
+
+// stagedRenames finds the renames between HEAD and the index as git
+// finds them, returning the old name of each file renamed and the set of
+// old names.
+func (s *worktreeStatus) stagedRenames() (renamedFrom map[string]string, renamed map[string]bool, err error) {
+	var staged []treeChange
+	for _, e := range s.entries {
+		switch {
+		case e.unmerged:
+		case e.staged == 'D':
+			staged = append(staged, treeChange{status: 'D', path: e.path, old: s.files[e.path]})
+		case e.staged == 'A':
+			ie := s.idx.entry(e.path)
+			staged = append(staged, treeChange{status: 'A', path: e.path, new: TreeEntry{Mode: formatMode(ie.mode), Hash: ie.hash}})
+		}
+	}
+	if staged, err = detectRenames(staged, defaultRenameThreshold); err != nil {
+		return nil, nil, err
+	}
+	renamedFrom, renamed = map[string]string{}, map[string]bool{}
+	for _, c := range staged {
+		if c.status == 'R' {
+			renamedFrom[c.path], renamed[c.oldPath] = c.oldPath, true
+		}
+	}
+	return renamedFrom, renamed, nil
+}
+
+// porcelainStatus implements `mygit status --porcelain[=v1] [-z]`: one
+// "XY path" record per changed path, in git's porcelain format. X is the
+// staged change and Y the unstaged one. A staged rename, found between
+// HEAD and the index as git finds it, is "R  old -> new", or "R  new"
+// then "old" with nul. Untracked files follow as "?? path", shown as
+// status's long format shows them. Records end in a newline and paths
+// are quoted, or with nul, records and names end in a NUL unquoted.
+func porcelainStatus(w io.Writer, nul bool) error {
+	s, err := readStatus()
+	if err != nil {
+		return err
+	}
+	renamedFrom, renamed, err := s.stagedRenames()
+	if err != nil {
+		return err
+	}
+	quote, end := quoteSpacedPath, "\n"
+	if nul {
+		quote, end = func(name string) string { return name }, "\x00"
+	}
+	var untracked []statusEntry
+	for _, e := range s.entries {
+		switch old, ok := renamedFrom[e.path]; {
+		case e.staged == '?':
+			untracked = append(untracked, e)
+		case e.staged == 'D' && renamed[e.path]:
+		case ok && nul:
+			fmt.Fprintf(w, "R%c %s%s%s%s", e.unstaged, e.path, end, old, end)
+		case ok:
+			fmt.Fprintf(w, "R%c %s -> %s%s", e.unstaged, quote(old), quote(e.path), end)
+		default:
+			fmt.Fprintf(w, "%c%c %s%s", e.staged, e.unstaged, quote(e.path), end)
+		}
+	}
+	for _, path := range untrackedDisplay(s.idx, untracked) {
+		fmt.Fprintf(w, "?? %s%s", quote(path), end)
+	}
+	return nil
+}
+
+// detachedLabel is status's first line for a HEAD detached at head. It
+// names what the last checkout detached at, "at" it if HEAD has not
+// moved since and "from" it otherwise.
+func detachedLabel(head string) string {
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		return "Not currently on any branch."
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		moved, ok := strings.CutPrefix(entries[i].message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		target := moved[strings.LastIndex(moved, " to ")+len(" to "):]
+		hash := entries[i].new
+		target = detachedName(target, hash)
+		if hash == head {
+			return "HEAD detached at " + target
+		}
+		return "HEAD detached from " + target
+	}
+	return "Not currently on any branch."
+}
+
+// detachedName is how status names target, what a checkout detached
+// HEAD at hash was given: as git does, by the ref it names if that still
+// points at hash, without "refs/tags/" or "refs/remotes/", and otherwise
+// by hash abbreviated, as "HEAD" always is.
+func detachedName(target, hash string) string {
+	if target != "HEAD" {
+		if ref, ok := expandRef(target); ok {
+			if value, err := readRef(ref); err == nil && (value == hash || peelTags(value) == hash) {
+				if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+					return name
+				}
+				return strings.TrimPrefix(ref, "refs/remotes/")
+			}
+		}
+	}
+	return hash[:7]
+}
+
+// untrackedDisplay lists untracked files the way status shows them: a
+// directory holding no tracked files is shown once, as "dir/".
+func untrackedDisplay(idx *index, untracked []statusEntry) []string {
+	trackedDirs := map[string]bool{}
+	for _, e := range idx.entries {
+		for i := 0; i < len(e.path); i++ {
+			if e.path[i] == '/' {
+				trackedDirs[e.path[:i]] = true
+			}
+		}
+	}
+	var shown []string
+	for _, e := range untracked {
+		name := e.path
+		for i := 0; i < len(name); i++ {
+			if name[i] == '/' && !trackedDirs[name[:i]] {
+				name = name[:i+1]
+				break
+			}
+		}
+		if len(shown) == 0 || shown[len(shown)-1] != name {
+			shown = append(shown, name)
+		}
+	}
+	return shown
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestStatusPorcelain compares status --porcelain, --porcelain=v1 and
+// -z with git's for each pair of staged and unstaged states: modified,
+// added, deleted, renamed, a type change and untracked files, and the
+// unmerged states a conflicted merge leaves.
+func TestStatusPorcelain(t *testing.T) {
+	r := newGoldenRepo(t)
+	for _, name := range []string{"staged", "unstaged", "both", "deleted", "gone", "moved", "typed", "keep"} {
+		r.write(name, name+" line one\n"+name+" line two\n"+name+" line three\n", 0o644)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "root")
+
+	r.write("staged", "staged changed\n", 0o644)
+	r.write("both", "both staged\n", 0o644)
+	r.git("add", "staged", "both")
+	r.write("both", "both unstaged\n", 0o644)
+	r.write("unstaged", "unstaged changed\n", 0o644)
+	r.write("added", "added\n", 0o644)
+	r.git("add", "added")
+	r.write("added", "added changed\n", 0o644)
+	r.git("rm", "-q", "deleted")
+	os.Remove(filepath.Join(r.dir, "gone"))
+	r.git("mv", "moved", "renamed")
+	os.Remove(filepath.Join(r.dir, "typed"))
+	if err := os.Symlink("keep", filepath.Join(r.dir, "typed")); err != nil {
+		t.Fatal(err)
+	}
+	r.write("new.txt", "untracked\n", 0o644)
+	for _, args := range [][]string{
+		{"status", "--porcelain"},
+		{"status", "--porcelain=v1"},
+		{"status", "--porcelain", "-z"},
+		{"status", "-z"},
+	} {
+		r.same(args...)
+	}
+
+	c := conflictRepo(t)
+	c.stderrOf("git", "merge", "topic")
+	c.same("status", "--porcelain")
+	c.same("status", "--porcelain", "-z")
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ObjectStore holds git objects by name. readObject, writeObject and
+// hasObject go through objectStore(), so commands never work with object
+// files or packs directly.
+type ObjectStore interface {
+	// Read returns the type and body of an object, or errObjectNotFound
+	// if the store does not hold it.
+	Read(hash string) (ObjectType, []byte, error)
+	// Write stores an object and returns its name.
+	Write(t ObjectType, data []byte) (string, error)
+	Has(hash string) bool
+	// Stat returns the type and size of an object, reading as little of
+	// it as the store allows, or errObjectNotFound.
+	Stat(hash string) (ObjectType, int64, error)
+}
+
+// objectsDir returns the repository's object directory: .git/objects,
+// unless GIT_OBJECT_DIRECTORY names another.
+func objectsDir() string {
+	if dir := os.Getenv("GIT_OBJECT_DIRECTORY"); dir != "" {
+		return dir
+	}
+	return gitPath("objects")
+}
+
+// repoObjects caches objectStore. repoObjectsMu guards building it, as
+// commands hashing files in parallel ask for the store from many
+// goroutines at once.
+var (
+	repoObjects   ObjectStore
+	repoObjectsMu sync.Mutex
+)
+
+// maxAlternateDepth is how deep git follows alternates that list
+// alternates of their own.
+const maxAlternateDepth = 5
+
+// objectStore returns the store of the repository: its loose objects,
+// where new objects are written, then its packs, then the read-only
+// object directories named by GIT_ALTERNATE_OBJECT_DIRECTORIES and by
+// objects/info/alternates.
+func objectStore() ObjectStore {
+	repoObjectsMu.Lock()
+	defer repoObjectsMu.Unlock()
+	if repoObjects == nil {
+		dir := objectsDir()
+		stores := multiStore{looseStore{dir}, packStore{dir}}
+		seen := map[string]bool{}
+		if abs, err := filepath.Abs(dir); err == nil {
+			seen[abs] = true
+		}
+		if env := os.Getenv("GIT_ALTERNATE_OBJECT_DIRECTORIES"); env != "" {
+			stores = append(stores, linkAlternates(filepath.SplitList(env), ".", seen, 0)...)
+		}
+		repoObjects = append(stores, alternateStores(dir, seen, 0)...)
+	}
+	return repoObjects
+}
+
+// alternateStores returns the stores of the object directories listed
+// in dir/info/alternates, relative to dir when not absolute.
+func alternateStores(dir string, seen map[string]bool, depth int) []ObjectStore {
+	data, err := os.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			paths = append(paths, line)
+		}
+	}
+	return linkAlternates(paths, dir, seen, depth)
+}
+
+// linkAlternates returns the loose and pack stores of each object
+// directory in paths, followed by the alternates each lists in turn.
+// Relative paths are relative to base. Directories already in seen are
+// skipped, so a cycle of alternates ends.
+func linkAlternates(paths []string, base string, seen map[string]bool, depth int) []ObjectStore {
+	if depth >= maxAlternateDepth {
+		return nil
+	}
+	var stores []ObjectStore
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "error: object directory %s does not exist; check .git/objects/info/alternates\n", abs)
+			continue
+		}
+		stores = append(stores, looseStore{path}, packStore{path})
+		stores = append(stores, alternateStores(path, seen, depth+1)...)
+	}
+	return stores
+}
+
+// multiStore reads from each of its stores in turn and writes to the
+// first.
+type multiStore []ObjectStore
+
+func (m multiStore) Read(hash string) (ObjectType, []byte, error) {
+	for _, s := range m {
+		objType, body, err := s.Read(hash)
+		if !errors.Is(err, errObjectNotFound) {
+			return objType, body, err
+		}
+	}
+	return 0, nil, errObjectNotFound
+}
+
+func (m multiStore) Stat(hash string) (ObjectType, int64, error) {
+	for _, s := range m {
+		objType, size, err := s.Stat(hash)
+		if !errors.Is(err, errObjectNotFound) {
+			return objType, size, err
+		}
+	}
+	return 0, 0, errObjectNotFound
+}
+
+func (m multiStore) Write(t ObjectType, data []byte) (string, error) {
+	return m[0].Write(t, data)
+}
+
+func (m multiStore) Has(hash string) bool {
+	for _, s := range m {
+		if s.Has(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// looseStore keeps each object zlib-compressed in a file of its own,
+// dir/xx/yyyy... for the object named xxyyyy....
+type looseStore struct {
+	dir string
+}
+
+func (s looseStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:])
+}
+
+// list returns the names of the loose objects in the store, in order.
+// Files that are not named like objects, such as temporary ones, are
+// left out.
+func (s looseStore) list() ([]string, error) {
+	fanout, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, d := range fanout {
+		if !d.IsDir() || len(d.Name()) != 2 || !isHex(d.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(s.dir, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if hash := d.Name() + f.Name(); len(hash) == repoFormat().hexLen() && isHex(hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// remove deletes the loose object hash, and its fan-out directory when
+// that is left empty.
+func (s looseStore) remove(hash string) error {
+	if err := os.Remove(s.path(hash)); err != nil {
+		return err
+	}
+	os.Remove(filepath.Dir(s.path(hash))) // fails while other objects remain
+	return nil
+}
+
+// readRaw returns the decompressed object file, header included.
+func (s looseStore) readRaw(hash string) (string, error) {
+	if _, err := os.Stat(s.path(hash)); err != nil {
+		return "", errObjectNotFound
+	}
+	data, err := DecompressAndRead(s.path(hash))
+	if err != nil {
+		return "", fmt.Errorf("object %s: %w", hash, err)
+	}
+	return data, nil
+}
+
+func (s looseStore) Read(hash string) (ObjectType, []byte, error) {
+	data, err := s.readRaw(hash)
+	if err != nil {
+		return 0, nil, err
+	}
+	objType, body, err := parseGitObject(data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("object %s: %w", hash, err)
+	}
+	return objType, body, nil
+}
+
+func (s looseStore) Has(hash string) bool {
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+// looseHeaderPeek is how much of a loose object Stat inflates looking
+// for the end of the header, which is far longer than any real header.
+const looseHeaderPeek = 64
+
+// Stat inflates only the start of the object file, which holds the
+// header. Should the header not end within it, the whole object is read.
+func (s looseStore) Stat(hash string) (ObjectType, int64, error) {
+	f, err := os.Open(s.path(hash))
+	if err != nil {
+		return 0, 0, errObjectNotFound
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("object %s: %w", hash, inflateError(err))
+	}
+	defer zr.Close()
+	buf := make([]byte, looseHeaderPeek)
+	n, err := io.ReadFull(zr, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, 0, fmt.Errorf("object %s: %w", hash, inflateError(err))
+	}
+	nul := bytes.IndexByte(buf[:n], 0)
+	if nul < 0 {
+		// Either a header too long to peek at or a stream cut short;
+		// reading it whole tells them apart.
+		objType, body, err := s.Read(hash)
+		return objType, int64(len(body)), err
+	}
+	typeName, size, err := parseHeaderLine(string(buf[:nul]))
+	if err != nil {
+		return 0, 0, err
+	}
+	objType, err := ParseObjectType(typeName)
+	return objType, size, err
+}
+
+func (s looseStore) Write(objType ObjectType, body []byte) (string, error) {
+	hash := hashObject(objType, body)
+	if s.Has(hash) {
+		return hash, nil
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", objType, len(body))
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	path := s.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file and rename it into place, so a reader or
+	// a concurrent writer of the same object never sees it half written.
+	if err := writeFileSynced(path, buf.Bytes(), 0o444, "tmp_obj_", "loose-object"); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// packStore reads objects from the packfiles in dir/pack, whose indexes
+// and mappings are kept in packCache. Packs are only ever added whole,
+// by indexPack, so it cannot write single objects.
+type packStore struct {
+	dir string
+}
+
+func (s packStore) Read(hash string) (ObjectType, []byte, error) {
+	loc, ok, err := s.find(hash)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !ok {
+		return 0, nil, errObjectNotFound
+	}
+	f, err := cachedPackFile(loc.packPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	return readPackObjectAt(f, loc.offset)
+}
+
+func (s packStore) Write(ObjectType, []byte) (string, error) {
+	return "", errors.New("objects cannot be written to a pack one at a time")
+}
+
+func (s packStore) Stat(hash string) (ObjectType, int64, error) {
+	loc, ok, err := s.find(hash)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return 0, 0, errObjectNotFound
+	}
+	f, err := cachedPackFile(loc.packPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return statPackObjectAt(f, loc.offset)
+}
+
+func (s packStore) Has(hash string) bool {
+	_, ok, _ := s.find(hash)
+	return ok
+}
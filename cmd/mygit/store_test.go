@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTruncatedLooseObject cuts a loose object file short, inside its
+// zlib header and inside its data, and checks that reading it reports a
+// truncated stream for that object rather than failing silently or
+// panicking.
+func TestTruncatedLooseObject(t *testing.T) {
+	r := newGoldenRepo(t)
+	r.write("big.txt", strings.Repeat("some text that compresses\n", 2000), 0o644)
+	hash := strings.TrimSpace(r.git("hash-object", "-w", "big.txt"))
+	path := filepath.Join(r.dir, ".git", "objects", hash[:2], hash[2:])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, len(data) / 2, len(data) - 4} {
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data[:n], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want := "object " + hash + ": inflate: truncated zlib stream"
+		if got := r.mygitFails("cat-file", "-p", hash); !strings.Contains(got, want) {
+			t.Errorf("%d of %d bytes: cat-file -p printed %q, want %q", n, len(data), got, want)
+		}
+	}
+	// As in git, the size needs only the header, which is whole here.
+	if got := r.mygit("cat-file", "-s", hash); got != "52000\n" {
+		t.Errorf("cat-file -s printed %q", got)
+	}
+}
+
+// TestAlternates reads a packed commit and a loose blob through a
+// relative objects/info/alternates entry, with the lender listing the
+// borrower in turn so that the chain is a cycle, and checks that new
+// objects are still written to the borrower's own store.
+func TestAlternates(t *testing.T) {
+	lender := newGoldenRepo(t)
+	lender.fill()
+	lender.git("add", "-A")
+	lender.git("commit", "-q", "-m", "root")
+	lender.git("repack", "-adq")
+	lender.write("loose", "loose\n", 0o644)
+	blob := strings.TrimSpace(lender.git("hash-object", "-w", "loose"))
+
+	r := newGoldenRepo(t)
+	objects := filepath.Join(r.dir, ".git", "objects")
+	lent := filepath.Join(lender.dir, ".git", "objects")
+	rel, err := filepath.Rel(objects, lent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.write(".git/objects/info/alternates", rel+"\n", 0o644)
+	lender.write(".git/objects/info/alternates", objects+"\n", 0o644)
+
+	head := strings.TrimSpace(lender.git("rev-parse", "HEAD"))
+	r.same("cat-file", "-p", head)
+	r.same("cat-file", "-p", blob)
+	r.same("log", head)
+	r.same("ls-tree", "-r", head)
+
+	r.write("new", "new\n", 0o644)
+	hash := strings.TrimSpace(r.mygit("hash-object", "-w", "new"))
+	if _, err := os.Stat(filepath.Join(objects, hash[:2], hash[2:])); err != nil {
+		t.Errorf("hash-object -w did not write to the borrowing repository: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lent, hash[:2], hash[2:])); !os.IsNotExist(err) {
+		t.Errorf("hash-object -w wrote to the alternate: %v", err)
+	}
+}
+
+// TestObjectDirectoryEnv moves a repository's objects to the directory
+// GIT_OBJECT_DIRECTORY names, where hash-object -w then writes, and
+// reads another repository's objects through
+// GIT_ALTERNATE_OBJECT_DIRECTORIES, next to a stale entry that is
+// skipped.
+func TestObjectDirectoryEnv(t *testing.T) {
+	r := newGoldenRepo(t)
+	store := t.TempDir()
+	env := "GIT_OBJECT_DIRECTORY=" + store
+	r.write("f", "f\n", 0o644)
+	hash := strings.TrimSpace(r.run("", os.Args[0], []string{"hash-object", "-w", "f"}, "MYGIT_TEST_MAIN=1", env))
+	if _, err := os.Stat(filepath.Join(store, hash[:2], hash[2:])); err != nil {
+		t.Errorf("hash-object -w did not write to GIT_OBJECT_DIRECTORY: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, ".git", "objects", hash[:2], hash[2:])); !os.IsNotExist(err) {
+		t.Errorf("hash-object -w wrote to .git/objects: %v", err)
+	}
+	if got := r.run("", "git", []string{"cat-file", "-p", hash}, env); got != "f\n" {
+		t.Errorf("git read %q from GIT_OBJECT_DIRECTORY", got)
+	}
+
+	other := newGoldenRepo(t)
+	env = "GIT_ALTERNATE_OBJECT_DIRECTORIES=" + filepath.Join(t.TempDir(), "gone") + string(filepath.ListSeparator) + store
+	if got := other.run("", os.Args[0], []string{"cat-file", "-p", hash}, "MYGIT_TEST_MAIN=1", env); got != "f\n" {
+		t.Errorf("cat-file -p read %q through GIT_ALTERNATE_OBJECT_DIRECTORIES", got)
+	}
+}
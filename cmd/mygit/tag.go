@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Tag is a parsed annotated tag object.
+type Tag struct {
+	Hash    string
+	Object  string
+	Type    string
+	Name    string
+	Tagger  Signature
+	Message string
+}
+
+// parseTag parses the body of a tag object.
+func parseTag(hash string, body []byte) (*Tag, error) {
+	t := &Tag{Hash: hash}
+	headers, message, _ := strings.Cut(string(body), "\n\n")
+	t.Message = message
+	for _, line := range strings.Split(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "object":
+			t.Object = value
+		case "type":
+			t.Type = value
+		case "tag":
+			t.Name = value
+		case "tagger":
+			t.Tagger, err = parseSignature(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", hash, err)
+		}
+	}
+	if t.Object == "" || t.Type == "" {
+		return nil, fmt.Errorf("tag %s: missing object or type", hash)
+	}
+	return t, nil
+}
+
+// peelToCommit follows tags from hash until it reaches a commit.
+func peelToCommit(hash string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		objType, body, err := readObject(hash)
+		if err != nil {
+			return "", err
+		}
+		switch objType {
+		case CommitObject:
+			return hash, nil
+		case TagObject:
+			t, err := parseTag(hash, body)
+			if err != nil {
+				return "", err
+			}
+			hash = t.Object
+		default:
+			return "", fmt.Errorf("%s is a %s, not a commit", hash, objType)
+		}
+	}
+	return "", fmt.Errorf("%s: tag chain too deep", hash)
+}
+
+// peelToTree follows tags and commits from hash until it reaches a
+// tree. commit is the commit passed through on the way, or "" if hash
+// named a tree directly.
+func peelToTree(hash string) (tree, commit string, err error) {
+	for depth := 0; depth < 10; depth++ {
+		objType, body, err := readObject(hash)
+		if err != nil {
+			return "", "", err
+		}
+		switch objType {
+		case TreeObject:
+			return hash, commit, nil
+		case CommitObject:
+			c, err := parseCommit(hash, body)
+			if err != nil {
+				return "", "", err
+			}
+			return c.Tree, hash, nil
+		case TagObject:
+			t, err := parseTag(hash, body)
+			if err != nil {
+				return "", "", err
+			}
+			hash = t.Object
+		default:
+			return "", "", fmt.Errorf("%s is a %s, not a tree", hash, objType)
+		}
+	}
+	return "", "", fmt.Errorf("%s: tag chain too deep", hash)
+}
+
+// tagOptions are the flags of `mygit tag` when it creates a tag.
+type tagOptions struct {
+	annotate   bool // -a, or implied by -m, -s and -u
+	message    string
+	hasMessage bool
+	sign       *signer // -s or -u <key>
+	force      bool
+}
+
+// createTag implements `mygit tag [-a | -s | -u <key>] [-f] [-m <msg>]
+// <name> [<object>]`: refs/tags/<name> is pointed at the object, or for
+// an annotated tag at a new tag object naming it, with the committer as
+// tagger and, when signed, the signature after the message. As in git,
+// the message loses its comment lines, and replacing a tag with -f says
+// what it used to point at.
+func createTag(w io.Writer, name, rev string, opts tagOptions) error {
+	ref := "refs/tags/" + name
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+	if err := validShortRefName("refs/tags/", name); err != nil {
+		return fmt.Errorf("'%s' is not a valid tag name: %s", name, err)
+	}
+	target, err := resolveObjectName(rev)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve '%s' as a valid ref.", rev)
+	}
+	old, err := readRef(ref)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if old != "" && !opts.force {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+
+	hash := target
+	if opts.annotate {
+		if !opts.hasMessage {
+			return errors.New("no tag message?")
+		}
+		objType, _, err := statObject(target)
+		if err != nil {
+			return err
+		}
+		tagger, err := currentSignature("COMMITTER")
+		if err != nil {
+			return err
+		}
+		var lines []string
+		for _, line := range strings.Split(opts.message, "\n") {
+			if !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		body := fmt.Sprintf("object %s\ntype %s\ntag %s\ntagger %s\n\n%s",
+			target, objType, name, tagger, cleanupMessage(strings.Join(lines, "\n")))
+		if opts.sign != nil {
+			sig, err := opts.sign.sign([]byte(body))
+			if err != nil {
+				return err
+			}
+			body += sig
+		}
+		if hash, err = writeObject(TagObject, []byte(body)); err != nil {
+			return err
+		}
+	}
+	if err := updateRef(ref, hash, "tag: tagging "+target); err != nil {
+		return err
+	}
+	if old != "" && old != hash {
+		fmt.Fprintf(w, "Updated tag '%s' (was %s)\n", name, old[:7])
+	}
+	return nil
+}
+
+// listTags implements `mygit tag` with no arguments, printing the name
+// of every tag.
+func listTags(w io.Writer) error {
+	names, _, err := listRefs("refs/tags/")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, strings.TrimPrefix(name, "refs/tags/"))
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// getTermios is the ioctl that reads a terminal's settings.
+const getTermios = syscall.TCGETS
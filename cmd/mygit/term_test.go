@@ -0,0 +1,45 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestIsTerminal checks that /dev/null, a character device, is not taken
+// for a terminal, so that color.ui=auto adds no color and no pager
+// starts when output goes there, while a pseudo-terminal is one, which
+// is colored unless NO_COLOR is set.
+func TestIsTerminal(t *testing.T) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	if isTerminal(null) {
+		t.Errorf("%s is taken for a terminal", os.DevNull)
+	}
+	if useColor("auto", null) {
+		t.Errorf("color is used on %s", os.DevNull)
+	}
+
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	defer pty.Close()
+	if !isTerminal(pty) {
+		t.Error("a pseudo-terminal is not taken for a terminal")
+	}
+	if !useColor("auto", pty) {
+		t.Error("color is not used on a pseudo-terminal")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if useColor("auto", pty) {
+		t.Error("color is used on a pseudo-terminal with NO_COLOR set")
+	}
+	if !useColor("always", pty) {
+		t.Error("--color=always uses no color with NO_COLOR set")
+	}
+}
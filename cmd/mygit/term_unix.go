@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminalFile reports whether f is open on a terminal, as isatty
+// does: only a terminal has settings to read, while other character
+// devices such as /dev/null fail the ioctl.
+func isTerminalFile(f *os.File) bool {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var errno syscall.Errno
+	var termios syscall.Termios
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, getTermios, uintptr(unsafe.Pointer(&termios)))
+	})
+	return err == nil && errno == 0
+}
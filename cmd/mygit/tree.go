@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TreeEntry is a single entry of a tree object.
+type TreeEntry struct {
+	Mode string
+	Name string
+	Hash string
+}
+
+// parseTree decodes the body of a tree object.
+func parseTree(body []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	size := repoFormat().size
+	for len(body) > 0 {
+		sp := bytes.IndexByte(body, ' ')
+		if sp < 0 {
+			return nil, errors.New("malformed tree entry: missing mode")
+		}
+		nul := bytes.IndexByte(body[sp:], 0)
+		if nul < 0 {
+			return nil, errors.New("malformed tree entry: missing name terminator")
+		}
+		nul += sp
+		if len(body) < nul+1+size {
+			return nil, errors.New("malformed tree entry: truncated hash")
+		}
+		entries = append(entries, TreeEntry{
+			Mode: string(body[:sp]),
+			Name: string(body[sp+1 : nul]),
+			Hash: hex.EncodeToString(body[nul+1 : nul+1+size]),
+		})
+		body = body[nul+1+size:]
+	}
+	return entries, nil
+}
+
+// readTree reads and parses the tree named by hash.
+func readTree(hash string) ([]TreeEntry, error) {
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != TreeObject {
+		return nil, fmt.Errorf("%s is a %s, not a tree", hash, objType)
+	}
+	return parseTree(body)
+}
+
+// errStopWalk can be returned by a walkTree or walkHistory callback to
+// end the walk early; the walk then returns nil.
+var errStopWalk = errors.New("stop walking")
+
+// walkTree calls fn for every entry below the tree treeSha with its
+// slash-separated path, in tree order. A subtree is visited before the
+// entries inside it; submodules are not descended into.
+func walkTree(treeSha string, fn func(path string, entry TreeEntry) error) error {
+	var walk func(hash, prefix string) error
+	walk = func(hash, prefix string) error {
+		entries, err := readTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			path := prefix + e.Name
+			if err := fn(path, e); err != nil {
+				return err
+			}
+			if e.Mode == "40000" {
+				if err := walk(e.Hash, path+"/"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(treeSha, ""); !errors.Is(err, errStopWalk) {
+		return err
+	}
+	return nil
+}
+
+// writeWorktreeFile writes the blob entry e to path, replacing whatever
+// file or symlink was there and creating parent directories as needed.
+// It refuses to write through a symlink on the way to path.
+func writeWorktreeFile(path string, e TreeEntry) error {
+	if link := symlinkLeadingPath(path); link != "" {
+		return fmt.Errorf("cannot write '%s': '%s' is a symbolic link", filepath.ToSlash(path), filepath.ToSlash(link))
+	}
+	_, body, err := readObject(e.Hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if e.Mode == "120000" {
+		return os.Symlink(string(body), path)
+	}
+	perm := os.FileMode(0o644)
+	if e.Mode == "100755" {
+		perm = 0o755
+	}
+	if err := os.WriteFile(path, toWorktreeText(filepath.ToSlash(path), body), perm); err != nil {
+		return err
+	}
+	if filepath.Base(path) == ".gitattributes" {
+		// Files checked out after this one must see its rules.
+		forgetAttrFile(path)
+	}
+	return nil
+}
+
+// lookupPath finds the entry at a slash-separated path below a tree.
+func lookupPath(treeHash, path string) (TreeEntry, bool, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		entries, err := readTree(treeHash)
+		if err != nil {
+			return TreeEntry{}, false, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.Name != part {
+				continue
+			}
+			if i == len(parts)-1 {
+				return e, true, nil
+			}
+			if e.Mode != "40000" {
+				return TreeEntry{}, false, nil
+			}
+			treeHash, found = e.Hash, true
+			break
+		}
+		if !found {
+			return TreeEntry{}, false, nil
+		}
+	}
+	return TreeEntry{}, false, nil
+}
+
+// maxSymlinks is how many symlinks followPath passes through before it
+// calls the path a loop, as git does.
+const maxSymlinks = 40
+
+// symlinkError says why followPath could not follow a path, in the
+// words of cat-file --batch-check --follow-symlinks: "loop", "dangling"
+// for a symlink to nothing, "notdir" when the path goes through a file,
+// and "symlink" when it leads out of the tree, to target.
+type symlinkError struct {
+	kind   string
+	target string
+}
+
+func (e *symlinkError) Error() string {
+	switch e.kind {
+	case "loop":
+		return "too many levels of symbolic links"
+	case "notdir":
+		return "not a directory"
+	case "symlink":
+		return "symbolic link leads outside the tree, to " + e.target
+	}
+	return "dangling symbolic link"
+}
+
+// followPath is lookupPath for git's --follow-symlinks: a symlink met on
+// the way to path, or at its end, is replaced by its target, read from
+// the directory that holds the link. ok is false only when path names
+// nothing without passing through a symlink.
+func followPath(treeHash, path string) (TreeEntry, bool, error) {
+	trees := []string{treeHash} // the directories walked into, from the top
+	var last *TreeEntry         // where the path ends, if not a directory
+	rest := strings.Split(path, "/")
+	links := 0
+	for len(rest) > 0 {
+		part := rest[0]
+		rest = rest[1:]
+		if last != nil {
+			return TreeEntry{}, false, &symlinkError{kind: "notdir"}
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(trees) == 1 {
+				return TreeEntry{}, false, &symlinkError{kind: "symlink", target: strings.Join(append([]string{".."}, rest...), "/")}
+			}
+			trees = trees[:len(trees)-1]
+			continue
+		}
+		entries, err := readTree(trees[len(trees)-1])
+		if err != nil {
+			return TreeEntry{}, false, err
+		}
+		i := 0
+		for i < len(entries) && entries[i].Name != part {
+			i++
+		}
+		if i == len(entries) {
+			if links > 0 {
+				return TreeEntry{}, false, &symlinkError{kind: "dangling"}
+			}
+			return TreeEntry{}, false, nil
+		}
+		switch e := entries[i]; e.Mode {
+		case "40000":
+			trees = append(trees, e.Hash)
+		case "120000":
+			if links++; links > maxSymlinks {
+				return TreeEntry{}, false, &symlinkError{kind: "loop"}
+			}
+			_, body, err := readObject(e.Hash)
+			if err != nil {
+				return TreeEntry{}, false, err
+			}
+			target := strings.Split(string(body), "/")
+			if target[0] == "" {
+				return TreeEntry{}, false, &symlinkError{kind: "symlink", target: strings.Join(append(target, rest...), "/")}
+			}
+			rest = append(target, rest...)
+		default:
+			last = &e
+		}
+	}
+	if last != nil {
+		return *last, true, nil
+	}
+	return TreeEntry{Mode: "40000", Hash: trees[len(trees)-1]}, true, nil
+}
+
+// resolveTreePath resolves "<rev>:<path>" to the object at path in the
+// tree of rev, following symlinks within the tree when follow is set.
+// An error from following a symlink is a *symlinkError.
+func resolveTreePath(name string, follow bool) (string, error) {
+	rev, path, _ := cutTreePath(name)
+	hash, err := resolveObjectName(rev)
+	if err != nil {
+		return "", fmt.Errorf("invalid object name '%s'.", rev)
+	}
+	tree, _, err := peelToTree(hash)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return tree, nil
+	}
+	lookup := lookupPath
+	if follow {
+		lookup = followPath
+	}
+	e, ok, err := lookup(tree, path)
+	if err != nil {
+		return "", err
+	}
+	if !ok || (strings.HasSuffix(path, "/") && e.Mode != "40000") {
+		return "", fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+	}
+	return e.Hash, nil
+}
+
+// flattenTree maps the slash-separated path of every non-tree entry
+// below treeHash to its entry.
+func flattenTree(treeHash string) (map[string]TreeEntry, error) {
+	files := map[string]TreeEntry{}
+	err := walkTree(treeHash, func(path string, e TreeEntry) error {
+		if e.Mode != "40000" {
+			files[path] = e
+		}
+		return nil
+	})
+	return files, err
+}
+
+// sortedPaths returns the keys of files in sorted order.
+func sortedPaths(files map[string]TreeEntry) []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeTreeFiles writes the tree objects for a set of slash-separated
+// paths and returns the name of the root tree.
+func writeTreeFiles(files map[string]TreeEntry) (string, error) {
+	var entries []TreeEntry
+	subtrees := map[string]map[string]TreeEntry{}
+	for path, e := range files {
+		dir, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			e.Name = path
+			entries = append(entries, e)
+			continue
+		}
+		if subtrees[dir] == nil {
+			subtrees[dir] = map[string]TreeEntry{}
+		}
+		subtrees[dir][rest] = e
+	}
+	for name, sub := range subtrees {
+		hash, err := writeTreeFiles(sub)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, TreeEntry{Mode: "40000", Name: name, Hash: hash})
+	}
+
+	// Git orders entries as if directory names ended in a slash.
+	key := func(e TreeEntry) string {
+		if e.Mode == "40000" {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+
+	var body bytes.Buffer
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil {
+			return "", fmt.Errorf("invalid object name %q for %s", e.Hash, e.Name)
+		}
+		fmt.Fprintf(&body, "%s %s\x00", e.Mode, e.Name)
+		body.Write(raw)
+	}
+	return writeObject(TreeObject, body.Bytes())
+}
+
+// mkTree implements `mygit mktree [-z] [--missing]`: it writes the tree
+// whose entries r lists in ls-tree's format, one per line or, with nul,
+// ending in NUL, and returns its name. Entries may come in any order.
+// Unless missing is set each object must be present with the type its
+// mode says; a submodule's commit need never be.
+func mkTree(r io.Reader, nul, missing bool) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	sep := "\n"
+	if nul {
+		sep = "\x00"
+	}
+	files := map[string]TreeEntry{}
+	for _, line := range strings.SplitAfter(string(data), sep) {
+		if line == "" {
+			break
+		}
+		if line = strings.TrimSuffix(line, sep); line == "" {
+			return "", errors.New("input format error: (blank line only valid in batch mode)")
+		}
+		info, name, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || len(fields[2]) != repoFormat().hexLen() {
+			return "", fmt.Errorf("input format error: %s", line)
+		}
+		mode, typ, hash := strings.TrimLeft(fields[0], "0"), fields[1], fields[2]
+		if _, err := hex.DecodeString(hash); err != nil || !slices.Contains([]string{"100644", "100755", "120000", "40000", "160000"}, mode) {
+			return "", fmt.Errorf("input format error: %s", line)
+		}
+		if !nul && strings.HasPrefix(name, `"`) {
+			if name, err = strconv.Unquote(name); err != nil {
+				return "", fmt.Errorf("invalid quoting")
+			}
+		}
+		if strings.Contains(name, "/") {
+			return "", fmt.Errorf("path %s contains slash", name)
+		}
+		want := entryType(mode)
+		if typ != want {
+			return "", fmt.Errorf("entry '%s' object type (%s) doesn't match mode type (%s)", name, typ, want)
+		}
+		if got, _, err := objectStore().Stat(hash); err != nil {
+			if !missing && mode != "160000" {
+				return "", fmt.Errorf("entry '%s' object %s is unavailable", name, hash)
+			}
+		} else if got.String() != want {
+			return "", fmt.Errorf("entry '%s' object %s is a %s but specified type was (%s)", name, hash, got, want)
+		}
+		files[name] = TreeEntry{Mode: mode, Hash: hash}
+	}
+	return writeTreeFiles(files)
+}
+
+// lsTreeOptions are the flags of `mygit ls-tree`.
+type lsTreeOptions struct {
+	nameOnly  bool
+	recursive bool // -r
+	showTrees bool // -t: show trees while recursing
+	treesOnly bool // -d
+	fullName  bool // show paths from the top of the repository
+	fullTree  bool // list the whole tree, ignoring prefix
+	nul       bool // -z: end lines with NUL instead of newline
+	json      bool // --format=json
+	// prefix is the slash-separated directory, relative to the top of
+	// the working tree, that the command runs in; "" at the top. Only
+	// that part of the tree is listed, with paths relative to it.
+	prefix string
+}
+
+// entryType is the type of object a tree entry mode refers to.
+func entryType(mode string) string {
+	switch mode {
+	case "40000":
+		return "tree"
+	case "160000":
+		return "commit"
+	}
+	return "blob"
+}
+
+// treeEntryJSON is an ls-tree entry in --format=json output.
+type treeEntryJSON struct {
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+	Path string `json:"path"`
+}
+
+// lsTree implements `mygit ls-tree [-r] [-t] [-d] [--name-only]
+// [--full-name] [--full-tree] [-z | --format=json] <tree-ish>`. As JSON
+// the entries form an array of treeEntryJSON objects.
+func lsTree(w io.Writer, treeish string, opts lsTreeOptions) error {
+	hash, err := resolveObjectName(treeish)
+	if err != nil {
+		return fmt.Errorf("Not a valid object name %s", treeish)
+	}
+	tree, _, err := peelToTree(hash)
+	if err != nil {
+		return fmt.Errorf("not a tree object: %s", treeish)
+	}
+	prefix := strings.Trim(opts.prefix, "/")
+	if opts.fullTree {
+		prefix = ""
+	}
+	if prefix != "" {
+		e, ok, err := lookupPath(tree, prefix)
+		if err != nil {
+			return err
+		}
+		if !ok || e.Mode != "40000" {
+			return nil // nothing of the tree lies in this directory
+		}
+		tree = e.Hash
+	}
+	end := "\n"
+	if opts.nul {
+		end = "\x00"
+	}
+	out := &jsonArray{w: w}
+	show := func(path string, e TreeEntry) {
+		if opts.fullName && prefix != "" {
+			path = prefix + "/" + path
+		}
+		if opts.json {
+			out.add(treeEntryJSON{fmt.Sprintf("%06o", parseMode(e.Mode)), entryType(e.Mode), e.Hash, path})
+			return
+		}
+		if !opts.nul {
+			path = quotePath(path)
+		}
+		if opts.nameOnly {
+			fmt.Fprint(w, path, end)
+			return
+		}
+		fmt.Fprintf(w, "%06o %s %s\t%s%s", parseMode(e.Mode), entryType(e.Mode), e.Hash, path, end)
+	}
+	if !opts.recursive {
+		entries, err := readTree(tree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !opts.treesOnly || e.Mode == "40000" {
+				show(e.Name, e)
+			}
+		}
+	} else {
+		err := walkTree(tree, func(path string, e TreeEntry) error {
+			if e.Mode == "40000" {
+				if opts.showTrees || opts.treesOnly {
+					show(path, e)
+				}
+			} else if !opts.treesOnly {
+				show(path, e)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.json {
+		return out.close()
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errBadSignature is returned for a signature that does not verify; the
+// verifier has already said why.
+var errBadSignature = errors.New("bad signature")
+
+// verifyObjects implements `mygit verify-commit` and `mygit verify-tag`:
+// each named object must be of type want and well formed, as git's fsck
+// checks, with every object it links to present and of the type it is
+// linked as, and its signature must verify. An object without one is
+// reported as such. It reports whether every object passed.
+func verifyObjects(w io.Writer, want ObjectType, names []string) bool {
+	ok := true
+	for _, name := range names {
+		if err := verifyObject(w, want, name); err != nil {
+			if err != errBadSignature {
+				fmt.Fprintf(w, "error: %s\n", err)
+			}
+			ok = false
+		}
+	}
+	return ok
+}
+
+// verifyObject checks one object for verifyObjects.
+func verifyObject(w io.Writer, want ObjectType, name string) error {
+	hash, err := resolveObjectName(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	objType, body, err := readObject(hash)
+	if err != nil {
+		return err
+	}
+	if objType != want {
+		return fmt.Errorf("%s: cannot verify a non-%s object of type %s.", name, want, objType)
+	}
+	headers, _, err := splitObjectHeaders(body)
+	if err == nil {
+		if want == CommitObject {
+			err = checkCommitHeaders(headers)
+		} else {
+			err = checkTagHeaders(headers)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", objType, hash, err)
+	}
+
+	payload, sig := objectSignature(objType, body)
+	if sig == "" {
+		fmt.Fprintf(w, "%s: no signature\n", name)
+		return nil
+	}
+	return verifySignature(w, payload, sig)
+}
+
+// signatureArmors are the first lines of the signatures git makes, with
+// the gpg.format of each.
+var signatureArmors = []struct{ armor, format string }{
+	{"-----BEGIN PGP SIGNATURE-----", "openpgp"},
+	{"-----BEGIN PGP MESSAGE-----", "openpgp"},
+	{"-----BEGIN SIGNED MESSAGE-----", "x509"},
+	{"-----BEGIN SSH SIGNATURE-----", "ssh"},
+}
+
+// signatureFormat returns the gpg.format of the signature sig, or "" if
+// it is none git makes.
+func signatureFormat(sig string) string {
+	for _, a := range signatureArmors {
+		if strings.HasPrefix(sig, a.armor) {
+			return a.format
+		}
+	}
+	return ""
+}
+
+// objectSignature splits a commit or tag into what was signed and its
+// signature, "" if it has none. A commit carries the signature in its
+// gpgsig header, gpgsig-sha256 in a SHA-256 repository, and what was
+// signed is the commit without that header. A tag carries it at the end
+// of its message, from the first line that starts one.
+func objectSignature(objType ObjectType, body []byte) ([]byte, string) {
+	text := string(body)
+	if objType == TagObject {
+		for start := 0; start < len(text); {
+			if signatureFormat(text[start:]) != "" {
+				return body[:start], text[start:]
+			}
+			next := strings.IndexByte(text[start:], '\n')
+			if next < 0 {
+				break
+			}
+			start += next + 1
+		}
+		return body, ""
+	}
+
+	header := "gpgsig "
+	if repoFormat().name == "sha256" {
+		header = "gpgsig-sha256 "
+	}
+	end := strings.Index(text, "\n\n") + 1
+	if end == 0 {
+		end = len(text)
+	}
+	var payload, sig strings.Builder
+	inSig := false
+	for _, line := range strings.SplitAfter(text[:end], "\n") {
+		switch {
+		case strings.HasPrefix(line, header) && sig.Len() == 0:
+			sig.WriteString(strings.TrimPrefix(line, header))
+			inSig = true
+		case inSig && strings.HasPrefix(line, " "):
+			sig.WriteString(line[1:])
+		default:
+			inSig = false
+			payload.WriteString(line)
+		}
+	}
+	payload.WriteString(text[end:])
+	return []byte(payload.String()), sig.String()
+}
+
+// verifySignature checks sig, a signature of payload, with the program
+// of the format it is in, as git does, and writes what that program
+// says of it. It returns errBadSignature if the signature does not
+// verify.
+func verifySignature(w io.Writer, payload []byte, sig string) error {
+	format := signatureFormat(sig)
+	if format == "" {
+		return errors.New("unknown signature format")
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	program, err := signProgram(cfg, format)
+	if err != nil {
+		return err
+	}
+	sigFile, err := writeTempFile(".git_vtag_tmp", []byte(sig))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sigFile)
+	if format == "ssh" {
+		allowed, _ := cfg.get("gpg", "ssh", "allowedsignersfile")
+		return verifySSH(w, program, allowed, sigFile, payload)
+	}
+	return verifyGPG(w, program, sigFile, payload)
+}
+
+// verifyGPG checks a signature with gpg or gpgsm, passing it on what
+// they say. The signature is good if they report it so on their status
+// output.
+func verifyGPG(w io.Writer, program, sigFile string, payload []byte) error {
+	var status bytes.Buffer
+	cmd := exec.Command(program, "--keyid-format=long", "--status-fd=1", "--verify", sigFile, "-")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(payload), &status, w
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return fmt.Errorf("could not run %s: %w", program, err)
+	}
+	good := false
+	for _, line := range strings.Split(status.String(), "\n") {
+		switch key, _, _ := strings.Cut(strings.TrimPrefix(line, "[GNUPG:] "), " "); key {
+		case "GOODSIG":
+			good = true
+		case "BADSIG", "ERRSIG", "EXPKEYSIG", "REVKEYSIG":
+			return errBadSignature
+		}
+	}
+	if !good || err != nil {
+		return errBadSignature
+	}
+	return nil
+}
+
+// verifySSH checks an SSH signature with ssh-keygen against the allowed
+// signers file: the principals it allows for the signing key are looked
+// up, and the signature is good if it verifies for one of them. As in
+// git, with no principal allowed the signature is only checked for
+// being made by its key, and still fails.
+func verifySSH(w io.Writer, program, allowed, sigFile string, payload []byte) error {
+	if rest, ok := strings.CutPrefix(allowed, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			allowed = filepath.Join(home, rest)
+		}
+	}
+	if _, err := os.Stat(allowed); allowed == "" || err != nil {
+		return errors.New("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		cmd := exec.Command(program, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(payload), &out, &out
+		err := cmd.Run()
+		var exit *exec.ExitError
+		if err != nil && !errors.As(err, &exit) {
+			return "", fmt.Errorf("could not run %s: %w", program, err)
+		}
+		return out.String(), err
+	}
+
+	var principals []string
+	if out, err := run("-Y", "find-principals", "-f", allowed, "-s", sigFile); err == nil {
+		principals = strings.Fields(out)
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		return err
+	}
+	if len(principals) == 0 {
+		out, err := run("-Y", "check-novalidate", "-n", "git", "-s", sigFile)
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			return err
+		}
+		fmt.Fprint(w, out)
+		fmt.Fprintln(w, "No principal matched.")
+		return errBadSignature
+	}
+	var out string
+	for _, principal := range principals {
+		var err error
+		out, err = run("-Y", "verify", "-n", "git", "-f", allowed, "-I", principal, "-s", sigFile)
+		if err == nil {
+			fmt.Fprint(w, out)
+			return nil
+		}
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+	}
+	fmt.Fprint(w, out)
+	return errBadSignature
+}
+
+// splitObjectHeaders splits a commit or tag body into its header lines,
+// each with its continuation lines, and its message. As in git, the
+// headers may not hold a NUL and must end in a newline.
+func splitObjectHeaders(body []byte) (headers []string, message string, err error) {
+	end := bytes.Index(body, []byte("\n\n"))
+	if end < 0 {
+		end = len(body)
+		if end == 0 || body[end-1] != '\n' {
+			return nil, "", errors.New("unterminatedHeader: unterminated header")
+		}
+		end--
+	} else {
+		message = string(body[end+2:])
+	}
+	if i := bytes.IndexByte(body[:end], 0); i >= 0 {
+		return nil, "", fmt.Errorf("nulInHeader: unterminated header: NUL at offset %d", i)
+	}
+	for _, line := range strings.Split(string(body[:end]), "\n") {
+		if strings.HasPrefix(line, " ") && len(headers) > 0 {
+			headers[len(headers)-1] += "\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, message, nil
+}
+
+// headerCursor walks the header lines of an object whose headers must
+// come in a fixed order.
+type headerCursor struct {
+	headers []string
+}
+
+// next returns the value of the next header if it is key, and moves
+// past it.
+func (c *headerCursor) next(key string) (string, bool) {
+	if len(c.headers) == 0 {
+		return "", false
+	}
+	value, ok := strings.CutPrefix(c.headers[0], key+" ")
+	if ok {
+		c.headers = c.headers[1:]
+	}
+	return value, ok
+}
+
+// checkCommitHeaders checks that a commit starts with its tree, then its
+// parents, its author and its committer, each well formed and linking to
+// an object of the right type. Later headers, such as encoding or a
+// signature, are not examined.
+func checkCommitHeaders(headers []string) error {
+	c := &headerCursor{headers}
+	tree, ok := c.next("tree")
+	if !ok {
+		return errors.New("missingTree: invalid format - expected 'tree' line")
+	}
+	if err := checkLink("tree", tree, TreeObject); err != nil {
+		return err
+	}
+	for {
+		parent, ok := c.next("parent")
+		if !ok {
+			break
+		}
+		if err := checkLink("parent", parent, CommitObject); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"author", "committer"} {
+		ident, ok := c.next(key)
+		if !ok {
+			return fmt.Errorf("missing%s: invalid format - expected '%s' line", capitalize(key), key)
+		}
+		if _, err := parseSignature(ident); err != nil {
+			return fmt.Errorf("badIdent: invalid %s line - %w", key, err)
+		}
+	}
+	return nil
+}
+
+// checkTagHeaders checks that a tag starts with the object it tags, that
+// object's type, its name and, if present, its tagger, and that the
+// object exists with the type the tag gives it.
+func checkTagHeaders(headers []string) error {
+	c := &headerCursor{headers}
+	object, ok := c.next("object")
+	if !ok {
+		return errors.New("missingObject: invalid format - expected 'object' line")
+	}
+	typeName, ok := c.next("type")
+	if !ok {
+		return errors.New("missingTypeEntry: invalid format - expected 'type' line")
+	}
+	objType, err := ParseObjectType(typeName)
+	if err != nil {
+		return errors.New("badType: invalid 'type' value")
+	}
+	if err := checkLink("object", object, objType); err != nil {
+		return err
+	}
+	name, ok := c.next("tag")
+	if !ok {
+		return errors.New("missingTagEntry: invalid format - expected 'tag' line")
+	}
+	if name == "" {
+		return errors.New("badTagName: invalid 'tag' name")
+	}
+	// Old tags may lack a tagger; git only warns about them.
+	if ident, ok := c.next("tagger"); ok {
+		if _, err := parseSignature(ident); err != nil {
+			return fmt.Errorf("badIdent: invalid tagger line - %w", err)
+		}
+	}
+	return nil
+}
+
+// checkLink checks that the value of the header key names an object of
+// type want that is present in the repository.
+func checkLink(key, hash string, want ObjectType) error {
+	if len(hash) != repoFormat().hexLen() || !isHex(hash) {
+		return fmt.Errorf("bad%sSha1: invalid '%s' line format - bad sha1", capitalize(key), key)
+	}
+	objType, _, err := statObject(hash)
+	if err != nil {
+		return fmt.Errorf("broken link to %s %s", want, hash)
+	}
+	if objType != want {
+		return fmt.Errorf("'%s' line names %s %s, not a %s", key, objType, hash, want)
+	}
+	return nil
+}
+
+// capitalize upper-cases the first letter of a header name, as git's fsck
+// message ids spell it.
+func capitalize(key string) string {
+	return strings.ToUpper(key[:1]) + key[1:]
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifySSHSignature signs a commit and a tag with an SSH key through
+// git and checks that mygit verifies them as git does, and that it
+// rejects a signature by a key no principal may sign with and a signed
+// commit whose content was changed.
+func TestVerifySSHSignature(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	r := newGoldenRepo(t)
+	keys := t.TempDir()
+	key := filepath.Join(keys, "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(keys, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte("committer@example.com "+string(pub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.git("config", "gpg.format", "ssh")
+	r.git("config", "user.signingkey", key)
+	r.git("config", "gpg.ssh.allowedSignersFile", allowed)
+
+	r.fill()
+	r.git("add", "-A")
+	r.git("commit", "-q", "-S", "-m", "signed")
+	r.git("tag", "-s", "-m", "signed tag", "v1")
+	for _, args := range [][]string{{"verify-commit", "HEAD"}, {"verify-tag", "v1"}} {
+		want, wantOK := r.stderrOf("git", args...)
+		got, gotOK := r.stderrOf("", args...)
+		if got != want || gotOK != wantOK || !gotOK {
+			t.Errorf("%s:\ngit:   %q %v\nmygit: %q %v", strings.Join(args, " "), want, wantOK, got, gotOK)
+		}
+	}
+
+	body := r.git("cat-file", "commit", "HEAD")
+	forged := strings.TrimSpace(r.run(strings.Replace(body, "\nsigned\n", "\nforged\n", 1), "git", []string{"hash-object", "-t", "commit", "-w", "--stdin"}))
+	if got, ok := r.stderrOf("", "verify-commit", forged); ok {
+		t.Errorf("verify-commit of a changed commit succeeded: %q", got)
+	}
+
+	other := filepath.Join(keys, "other")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", other).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	otherPub, err := os.ReadFile(other + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(allowed, []byte("committer@example.com "+string(otherPub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := r.stderrOf("git", "verify-commit", "HEAD")
+	got, ok := r.stderrOf("", "verify-commit", "HEAD")
+	if ok || got != want {
+		t.Errorf("verify-commit with no allowed principal:\ngit:   %q\nmygit: %q %v", want, got, ok)
+	}
+
+	r.git("config", "--unset", "gpg.ssh.allowedSignersFile")
+	got, ok = r.stderrOf("", "verify-commit", "HEAD")
+	if ok || !strings.Contains(got, "gpg.ssh.allowedSignersFile needs to be configured") {
+		t.Errorf("verify-commit with no allowed signers file: %q %v", got, ok)
+	}
+}
+
+// TestSignSSH signs a commit, a commit-tree and a tag with an SSH key
+// through mygit and checks that the objects are the ones git writes,
+// ed25519 signatures being deterministic, and that git verifies them.
+func TestSignSSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	r := newGoldenRepo(t)
+	keys := t.TempDir()
+	key := filepath.Join(keys, "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(keys, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte("committer@example.com "+string(pub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.git("config", "gpg.format", "ssh")
+	r.git("config", "user.signingkey", key)
+	r.git("config", "gpg.ssh.allowedSignersFile", allowed)
+	r.fill()
+	r.git("add", "-A")
+
+	state := func() string { return r.git("cat-file", "commit", "HEAD") + r.git("rev-parse", "HEAD") }
+	r.sameRun(func() { r.git("update-ref", "-d", "HEAD") }, state, "commit", "-S", "-m", "signed")
+	r.git("verify-commit", "HEAD")
+
+	tree := strings.TrimSpace(r.git("write-tree"))
+	r.same("commit-tree", "-S", "-p", "HEAD", "-m", "signed tree", tree)
+
+	tagState := func() string { return r.git("cat-file", "tag", "v1") }
+	r.sameRun(func() { r.git("tag", "-d", "v1") }, tagState, "tag", "-s", "-m", "signed tag", "v1")
+	r.git("verify-tag", "v1")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updateWorktree moves the working tree from one flattened tree to
+// another, touching only the paths that differ between them.
+func updateWorktree(from, to map[string]TreeEntry) error {
+	if err := checkTreePaths(to); err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(from) {
+		if _, ok := to[path]; ok {
+			continue
+		}
+		if err := removeWorktreeFile(path); err != nil {
+			return err
+		}
+	}
+	for _, path := range sortedPaths(to) {
+		e := to[path]
+		if old, ok := from[path]; ok && old == e {
+			continue
+		}
+		if e.Mode == "160000" {
+			if err := os.MkdirAll(filepath.FromSlash(path), 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeWorktreeFile(filepath.FromSlash(path), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeWorktreeFile deletes a tracked file and any directories that
+// become empty as a result.
+func removeWorktreeFile(path string) error {
+	if err := os.Remove(filepath.FromSlash(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if os.Remove(filepath.FromSlash(dir)) != nil {
+			break // not empty, or already gone
+		}
+	}
+	return nil
+}
+
+// worktreeRelative reports whether path stays inside the working tree,
+// guarding against trees with entries such as "../x".
+func worktreeRelative(path string) bool {
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." || part == ".." || part == ".git" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTreePaths refuses files to check out that no tree git writes
+// would hold, as a hostile one might to write through a symlink: a path
+// outside the working tree, one that is also the directory of another,
+// as "d" and "d/x" from two entries named "d", and two that differ only
+// in case, which are one file on a case-insensitive file system.
+func checkTreePaths(files map[string]TreeEntry) error {
+	seen := map[string]string{}
+	dirs := map[string]bool{}
+	for _, path := range sortedPaths(files) {
+		if !worktreeRelative(path) {
+			return fmt.Errorf("invalid path '%s'", path)
+		}
+		key := strings.ToLower(path)
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("invalid path '%s': it collides with '%s'", path, other)
+		}
+		seen[key] = path
+		for i := strings.LastIndexByte(key, '/'); i > 0; i = strings.LastIndexByte(key[:i], '/') {
+			dirs[key[:i]] = true
+		}
+	}
+	for _, path := range sortedPaths(files) {
+		if dirs[strings.ToLower(path)] {
+			return fmt.Errorf("invalid path '%s': it is also a directory", path)
+		}
+	}
+	return nil
+}
+
+// symlinkLeadingPath returns the first directory leading to path that
+// is a symlink, or "" if there is none: writing path would then follow
+// it, perhaps out of the working tree, as git's has_symlink_leading_path
+// guards against.
+func symlinkLeadingPath(path string) string {
+	dir := filepath.Dir(path)
+	var leading []string
+	for ; dir != "." && dir != string(filepath.Separator) && dir != filepath.VolumeName(dir); dir = filepath.Dir(dir) {
+		leading = append(leading, dir)
+	}
+	for i := len(leading) - 1; i >= 0; i-- {
+		info, err := os.Lstat(leading[i])
+		if err != nil {
+			return ""
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return leading[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// linkedWorktree is one working tree of the repository: the main one,
+// or one added by `worktree add`.
+type linkedWorktree struct {
+	path   string // top of the working tree, absolute
+	gitDir string // its own git directory, holding HEAD and the index
+}
+
+// listWorktrees returns the main working tree and then the linked ones,
+// in order of their directories under .git/worktrees.
+func listWorktrees() ([]linkedWorktree, error) {
+	common, err := filepath.Abs(commonDir())
+	if err != nil {
+		return nil, err
+	}
+	trees := []linkedWorktree{{filepath.Dir(common), common}}
+	entries, err := os.ReadDir(filepath.Join(common, "worktrees"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		dir := filepath.Join(common, "worktrees", e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "gitdir"))
+		if err != nil {
+			continue // not the directory of a working tree
+		}
+		trees = append(trees, linkedWorktree{filepath.Dir(strings.TrimSpace(string(data))), dir})
+	}
+	return trees, nil
+}
+
+// head returns the branch the working tree has checked out, "" when its
+// HEAD is detached, and the commit HEAD is at, "" on an unborn branch.
+func (t linkedWorktree) head() (ref, hash string, err error) {
+	data, err := os.ReadFile(filepath.Join(t.gitDir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	value := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(value, "ref: ")
+	if !ok {
+		return "", value, nil
+	}
+	hash, err = readRef(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return ref, hash, err
+}
+
+// worktreeAdd implements `mygit worktree add <path> <commit-ish>`: it
+// creates a working tree at path, linked to this repository through a
+// .git file naming its directory under .git/worktrees, and checks out a
+// branch there, or detaches at a commit. A branch can be checked out in
+// only one working tree at a time.
+func worktreeAdd(dir, rev string) error {
+	t, err := resolveCheckoutTarget(rev)
+	if err != nil {
+		return fmt.Errorf("invalid reference: %s", rev)
+	}
+	if t.branch != "" {
+		fmt.Fprintf(os.Stderr, "Preparing worktree (checking out '%s')\n", rev)
+	} else {
+		fmt.Fprintf(os.Stderr, "Preparing worktree (detached HEAD %s)\n", t.commit[:7])
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("'%s' already exists", dir)
+	}
+	trees, err := listWorktrees()
+	if err != nil {
+		return err
+	}
+	for _, wt := range trees {
+		if ref, _, err := wt.head(); err == nil && t.branch != "" && ref == t.branch {
+			return fmt.Errorf("'%s' is already checked out at '%s'", rev, wt.path)
+		}
+	}
+	c, err := readCommit(t.commit)
+	if err != nil {
+		return err
+	}
+
+	top, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	common, err := filepath.Abs(commonDir())
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(top)
+	admin := filepath.Join(common, "worktrees", name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(admin); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		admin = filepath.Join(common, "worktrees", name+strconv.Itoa(i))
+	}
+	head := t.commit + "\n"
+	if t.branch != "" {
+		head = "ref: " + t.branch + "\n"
+	}
+	if err := os.MkdirAll(admin, 0o755); err != nil {
+		return err
+	}
+	for file, content := range map[string]string{
+		"gitdir":    filepath.Join(top, ".git") + "\n",
+		"commondir": filepath.Join("..", "..") + "\n",
+		"HEAD":      head,
+	} {
+		if err := os.WriteFile(filepath.Join(admin, file), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(top, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(top, ".git"), []byte("gitdir: "+admin+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	// Check out from inside the new working tree, so that its .git file
+	// is what every path resolves through.
+	back, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := enterWorktree(top); err != nil {
+		return err
+	}
+	defer enterWorktree(back)
+	files, err := flattenTree(c.Tree)
+	if err != nil {
+		return err
+	}
+	if err := updateWorktree(map[string]TreeEntry{}, files); err != nil {
+		return err
+	}
+	idx, err := indexFromTree(c.Tree, &index{})
+	if err != nil {
+		return err
+	}
+	if err := idx.write(); err != nil {
+		return err
+	}
+	if err := appendReflog("HEAD", "", t.commit, "reset: moving to HEAD"); err != nil {
+		return err
+	}
+	fmt.Printf("HEAD is now at %s %s\n", t.commit[:7], c.Subject())
+	return nil
+}
+
+// worktreeList implements `mygit worktree list`, printing each working
+// tree's path, commit and branch in aligned columns.
+func worktreeList(w io.Writer) error {
+	trees, err := listWorktrees()
+	if err != nil {
+		return err
+	}
+	width := 0
+	for _, t := range trees {
+		width = max(width, len(t.path))
+	}
+	for _, t := range trees {
+		ref, hash, err := t.head()
+		if err != nil {
+			return err
+		}
+		label := "(detached HEAD)"
+		if ref != "" {
+			label = "[" + strings.TrimPrefix(ref, "refs/heads/") + "]"
+		}
+		short := strings.Repeat("0", 7)
+		if hash != "" {
+			short = hash[:7]
+		}
+		fmt.Fprintf(w, "%-*s %s %s\n", width+1, t.path, short, label)
+	}
+	return nil
+}